@@ -482,6 +482,9 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	// remove the pins added with an expiry once they expire
+	pinExpiryErrc := runPinExpiry(req, node)
+
 	// Add any files downloaded by migration.
 	if cacheMigrations || pinMigrations {
 		err = addMigrations(cctx.Context(), node, fetcher, pinMigrations)
@@ -574,7 +577,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesn't follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, gcErrc) {
+	for err := range merge(apiErrc, gwErrc, gcErrc, pinExpiryErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -734,6 +737,15 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 		return nil, fmt.Errorf("serveHTTPGateway: GetConfig() failed: %s", err)
 	}
 
+	if len(cfg.Gateway.APICommands) > 0 {
+		if _, err := commands.RootWithAllowlist(cfg.Gateway.APICommands); err != nil {
+			return nil, fmt.Errorf("serveHTTPGateway: invalid Gateway.APICommands: %w", err)
+		}
+	}
+	if _, err := corehttp.ParseTrustedProxies(cfg.Gateway.RateLimit.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("serveHTTPGateway: invalid Gateway.RateLimit.TrustedProxies: %w", err)
+	}
+
 	writable, writableOptionFound := req.Options[writableKwd].(bool)
 	if !writableOptionFound {
 		writable = cfg.Gateway.Writable
@@ -876,6 +888,15 @@ func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 	return errc, nil
 }
 
+func runPinExpiry(req *cmds.Request, node *core.IpfsNode) <-chan error {
+	errc := make(chan error)
+	go func() {
+		errc <- corerepo.PeriodicPinExpiry(req.Context, node)
+		close(errc)
+	}()
+	return errc
+}
+
 // merge does fan-in of multiple read-only error channels
 // taken from http://blog.golang.org/pipelines
 func merge(cs ...<-chan error) <-chan error {
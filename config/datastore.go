@@ -23,6 +23,23 @@ type Datastore struct {
 
 	HashOnRead      bool
 	BloomFilterSize int
+
+	// GCPolicies are the retention policies of garbage collection, by
+	// datastore mountpoint (such as "/blocks"). A block gets the policy of
+	// the longest mountpoint containing it.
+	GCPolicies map[string]GCPolicy `json:",omitempty"`
+}
+
+// GCPolicy selects the unpinned blocks removed by garbage collection
+type GCPolicy struct {
+	// KeepAccessedWithin keeps the blocks read or written within the duration
+	KeepAccessedWithin *OptionalDuration `json:",omitempty"`
+
+	// Eviction is "all" to remove all the blocks which are not kept, "lru" to
+	// remove the least recently used blocks first, or "lfu" to remove the
+	// least frequently used blocks first. With "lru" and "lfu", automatic
+	// garbage collection stops once usage is under StorageGCWatermark.
+	Eviction *OptionalString `json:",omitempty"`
 }
 
 // DataStorePath returns the default data store path given a configuration root
@@ -18,6 +18,12 @@ type GatewaySpec struct {
 	// NoDNSLink configures this gateway to _not_ resolve DNSLink for the FQDN
 	// provided in `Host` HTTP header.
 	NoDNSLink bool
+
+	// TrustlessOnly configures this gateway to only serve responses that
+	// can be verified by the client (raw blocks, CARs and IPNS records).
+	// Requests for deserialized responses, such as UnixFS files or HTML
+	// directory listings, return HTTP 406 Not Acceptable.
+	TrustlessOnly bool
 }
 
 // Gateway contains options for the HTTP gateway server.
@@ -61,7 +67,9 @@ type Gateway struct {
 	// Setting to 0 will enable fast listings for all directories.
 	FastDirIndexThreshold *OptionalInteger `json:",omitempty"`
 
-	// FIXME: Not yet implemented: https://github.com/ipfs/kubo/issues/8059
+	// APICommands is a list of RPC API commands (e.g. "dag/get") exposed
+	// under /api/v0 on the gateway port. When empty, the default set of
+	// read-only commands is exposed.
 	APICommands []string
 
 	// NoFetch configures the gateway to _not_ fetch blocks in response to
@@ -76,4 +84,32 @@ type Gateway struct {
 	// PublicGateways configures behavior of known public gateways.
 	// Each key is a fully qualified domain name (FQDN).
 	PublicGateways map[string]*GatewaySpec
+
+	// RateLimit configures per-client limits of requests to the gateway.
+	RateLimit GatewayRateLimit
+}
+
+// GatewayRateLimit configures per-client token buckets of the gateway.
+// Requests which can be answered with data from the local repository use the
+// Cached budget, requests which need to fetch data from the network
+// additionally use the Network budget. Limits are disabled when unset.
+type GatewayRateLimit struct {
+	// CachedRequestsPerMinute is the rate at which every client can make
+	// requests to the gateway.
+	CachedRequestsPerMinute *OptionalInteger `json:",omitempty"`
+	// CachedBurst is the number of requests a client can make at once before
+	// it is limited to CachedRequestsPerMinute.
+	CachedBurst *OptionalInteger `json:",omitempty"`
+
+	// NetworkRequestsPerMinute is the rate at which every client can make
+	// requests for data which is not available in the local repository.
+	NetworkRequestsPerMinute *OptionalInteger `json:",omitempty"`
+	// NetworkBurst is the number of requests for data from the network a
+	// client can make at once before it is limited to NetworkRequestsPerMinute.
+	NetworkBurst *OptionalInteger `json:",omitempty"`
+
+	// TrustedProxies is a list of IP addresses or CIDR ranges of reverse
+	// proxies in front of the gateway. For requests from these addresses,
+	// the client is identified by the X-Forwarded-For header.
+	TrustedProxies []string
 }
@@ -111,7 +111,8 @@ Inverse profile of the test profile.`,
 
 Read the "flatfs" profile description for more information on this datastore.
 
-This profile may only be applied when first initializing the node.
+This profile may only be applied when first initializing the node, use
+'ipfs repo convert' to convert an existing repo.
 `,
 
 		InitOnly: true,
@@ -134,7 +135,8 @@ You should use this datastore if:
 * You want to minimize memory usage.
 * You are ok with the default speed of data import, or prefer to use --nocopy.
 
-This profile may only be applied when first initializing the node.
+This profile may only be applied when first initializing the node, use
+'ipfs repo convert' to convert an existing repo.
 `,
 
 		InitOnly: true,
@@ -160,7 +162,8 @@ However, be aware that:
 * The current implementation is based on old badger 1.x
   which is no longer supported by the upstream team.
 
-This profile may only be applied when first initializing the node.`,
+This profile may only be applied when first initializing the node, use
+'ipfs repo convert' to convert an existing repo.`,
 
 		InitOnly: true,
 		Transform: func(c *Config) error {
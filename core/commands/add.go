@@ -9,6 +9,8 @@ import (
 	"strings"
 
 	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/core/coreunix"
 
 	"github.com/cheggaaa/pb"
 	cmds "github.com/ipfs/go-ipfs-cmds"
@@ -29,26 +31,55 @@ type AddEvent struct {
 }
 
 const (
-	quietOptionName       = "quiet"
-	quieterOptionName     = "quieter"
-	silentOptionName      = "silent"
-	progressOptionName    = "progress"
-	trickleOptionName     = "trickle"
-	wrapOptionName        = "wrap-with-directory"
-	onlyHashOptionName    = "only-hash"
-	chunkerOptionName     = "chunker"
-	pinOptionName         = "pin"
-	rawLeavesOptionName   = "raw-leaves"
-	noCopyOptionName      = "nocopy"
-	fstoreCacheOptionName = "fscache"
-	cidVersionOptionName  = "cid-version"
-	hashOptionName        = "hash"
-	inlineOptionName      = "inline"
-	inlineLimitOptionName = "inline-limit"
+	quietOptionName         = "quiet"
+	quieterOptionName       = "quieter"
+	silentOptionName        = "silent"
+	progressOptionName      = "progress"
+	trickleOptionName       = "trickle"
+	wrapOptionName          = "wrap-with-directory"
+	onlyHashOptionName      = "only-hash"
+	chunkerOptionName       = "chunker"
+	pinOptionName           = "pin"
+	rawLeavesOptionName     = "raw-leaves"
+	noCopyOptionName        = "nocopy"
+	fstoreCacheOptionName   = "fscache"
+	cidVersionOptionName    = "cid-version"
+	hashOptionName          = "hash"
+	inlineOptionName        = "inline"
+	inlineLimitOptionName   = "inline-limit"
+	preserveModeOptionName  = "preserve-mode"
+	preserveMtimeOptionName = "preserve-mtime"
+	resumeOptionName        = "resume"
+	concurrencyOptionName   = "concurrency"
+	profileOptionName       = "profile"
 )
 
 const adderOutChanSize = 8
 
+// addProfile is a preset of the options changing how the files are imported
+type addProfile struct {
+	Chunker    string
+	RawLeaves  bool
+	CidVersion int
+	Trickle    bool
+}
+
+// addProfiles are the presets of '--profile', the options passed explicitly
+// override them
+var addProfiles = map[string]addProfile{
+	"large-files": {
+		Chunker:    "fastcdc",
+		RawLeaves:  true,
+		CidVersion: 1,
+	},
+	"small-web": {
+		Chunker:    "fastcdc-16384-65536-262144",
+		RawLeaves:  true,
+		CidVersion: 1,
+		Trickle:    true,
+	},
+}
+
 var AddCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Add a file or directory to IPFS.",
@@ -87,6 +118,11 @@ hashes for the same file. The default is a fixed block size of
 Buzhash or Rabin fingerprint chunker for content defined chunking by
 specifying buzhash or rabin-[min]-[avg]-[max] (where min/avg/max refer
 to the desired chunk sizes in bytes), e.g. 'rabin-262144-524288-1048576'.
+The FastCDC chunker, 'fastcdc' or 'fastcdc-[min]-[avg]-[max]', is a faster
+content defined chunker. The tar chunker, 'tar' or 'tar-[chunker]', splits
+tar archives on the boundaries of their members and splits the content of
+the members with the other chunker, e.g. 'tar-fastcdc', so the same files
+are deduplicated across archives.
 
 The following examples use very small byte sizes to demonstrate the
 properties of the different chunkers on a small file. You'll likely
@@ -107,6 +143,33 @@ You can now check what blocks have been created by:
   QmerURi9k4XzKCaaPbsK6BL5pMEjF7PGphjDvkkjDtsVf3 868
   QmQB28iwSriSUSMqG2nXDTLtdPHgWb4rebBrU7Q1j4vxPv 338
 
+The '--preserve-mode' and '--preserve-mtime' options store the permissions
+and the modification time of the files and directories in their UnixFS
+nodes, 'ipfs get' applies them. The client sends them with the files, the
+daemon does not read the files from its own disk. Symlinks are added without
+them.
+
+The '--resume' option journals the files as they are added, in the repo. When
+an add of large directories is interrupted, running it again with '--resume'
+and the same options skips the files which did not change since they were
+added, by size, mode and modification time, and whose blocks are all still
+in the repo. The root hash is the same as without '--resume'. The entries of
+the files of an add are removed from the journal once it completes.
+
+The '--profile' option sets the chunker, raw-leaves, cid-version and trickle
+options together, the options passed explicitly override it:
+
+  large-files: --chunker=fastcdc --raw-leaves --cid-version=1
+  small-web:   --chunker=fastcdc-16384-65536-262144 --raw-leaves
+               --cid-version=1 --trickle
+
+The '--concurrency' option chunks and hashes that many files in parallel,
+which speeds up adding directories of many small files. Only the files of up
+to 1 MiB are hashed in parallel: the larger files are still chunked and
+hashed one at a time, so adding a single large file is not faster. The files
+are read one at a time and added to their directories in the same order, so
+the hashes and the output are the same as without it.
+
 Finally, a note on hash determinism. While not guaranteed, adding the same
 file/directory with the same flags will almost always result in the same output
 hash. However, almost all of the flags provided by this command (other than pin,
@@ -131,7 +194,7 @@ only-hash, and progress/status related flags) will change the final hash.
 		cmds.BoolOption(trickleOptionName, "t", "Use trickle-dag format for dag generation."),
 		cmds.BoolOption(onlyHashOptionName, "n", "Only chunk and hash - do not write to disk."),
 		cmds.BoolOption(wrapOptionName, "w", "Wrap files with a directory object."),
-		cmds.StringOption(chunkerOptionName, "s", "Chunking algorithm, size-[bytes], rabin-[min]-[avg]-[max] or buzhash").WithDefault("size-262144"),
+		cmds.StringOption(chunkerOptionName, "s", "Chunking algorithm, size-[bytes], rabin-[min]-[avg]-[max], buzhash, fastcdc-[min]-[avg]-[max] or tar-[chunker]. Default: size-262144."),
 		cmds.BoolOption(pinOptionName, "Pin this object when adding.").WithDefault(true),
 		cmds.BoolOption(rawLeavesOptionName, "Use raw blocks for leaf nodes."),
 		cmds.BoolOption(noCopyOptionName, "Add the file using filestore. Implies raw-leaves. (experimental)"),
@@ -140,8 +203,19 @@ only-hash, and progress/status related flags) will change the final hash.
 		cmds.StringOption(hashOptionName, "Hash function to use. Implies CIDv1 if not sha2-256. (experimental)").WithDefault("sha2-256"),
 		cmds.BoolOption(inlineOptionName, "Inline small blocks into CIDs. (experimental)"),
 		cmds.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
+		cmds.BoolOption(preserveModeOptionName, "Store the permissions of the files."),
+		cmds.BoolOption(preserveMtimeOptionName, "Store the modification time of the files."),
+		cmds.BoolOption(resumeOptionName, "Resume an interrupted add, skipping the files it completed."),
+		cmds.IntOption(concurrencyOptionName, "Number of files of up to 1 MiB chunked and hashed in parallel.").WithDefault(1),
+		cmds.StringOption(profileOptionName, "Preset of the import options, large-files or small-web."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// the client sends the info of its files, PreRun runs twice when
+		// the command falls back to run offline
+		if _, sent := req.Files.(sendInfoDirectory); req.Files != nil && !sent && sendsAddInfo(req) {
+			req.Files = sendInfoDirectory{req.Files}
+		}
+
 		quiet, _ := req.Options[quietOptionName].(bool)
 		quieter, _ := req.Options[quieterOptionName].(bool)
 		quiet = quiet || quieter
@@ -180,6 +254,30 @@ only-hash, and progress/status related flags) will change the final hash.
 		hashFunStr, _ := req.Options[hashOptionName].(string)
 		inline, _ := req.Options[inlineOptionName].(bool)
 		inlineLimit, _ := req.Options[inlineLimitOptionName].(int)
+		preserveMode, _ := req.Options[preserveModeOptionName].(bool)
+		preserveMtime, _ := req.Options[preserveMtimeOptionName].(bool)
+		resume, _ := req.Options[resumeOptionName].(bool)
+		concurrency, _ := req.Options[concurrencyOptionName].(int)
+		profileName, _ := req.Options[profileOptionName].(string)
+
+		if profileName != "" {
+			profile, ok := addProfiles[profileName]
+			if !ok {
+				return fmt.Errorf("unrecognized add profile: %s", profileName)
+			}
+			if _, set := req.Options[chunkerOptionName]; !set {
+				chunker = profile.Chunker
+			}
+			if !rbset {
+				rawblks, rbset = profile.RawLeaves, true
+			}
+			if !cidVerSet {
+				cidVer, cidVerSet = profile.CidVersion, true
+			}
+			if _, set := req.Options[trickleOptionName]; !set {
+				trickle = profile.Trickle
+			}
+		}
 
 		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
 		if !ok {
@@ -192,9 +290,12 @@ only-hash, and progress/status related flags) will change the final hash.
 		}
 
 		toadd := req.Files
+		if sendsAddInfo(req) {
+			toadd = recvInfoDirectory{toadd}
+		}
 		if wrap {
 			toadd = files.NewSliceDirectory([]files.DirEntry{
-				files.FileEntry("", req.Files),
+				files.FileEntry("", toadd),
 			})
 		}
 
@@ -204,8 +305,6 @@ only-hash, and progress/status related flags) will change the final hash.
 			options.Unixfs.Inline(inline),
 			options.Unixfs.InlineLimit(inlineLimit),
 
-			options.Unixfs.Chunker(chunker),
-
 			options.Unixfs.Pin(dopin),
 			options.Unixfs.HashOnly(hash),
 			options.Unixfs.FsCache(fscache),
@@ -215,6 +314,10 @@ only-hash, and progress/status related flags) will change the final hash.
 			options.Unixfs.Silent(silent),
 		}
 
+		if chunker != "" {
+			opts = append(opts, options.Unixfs.Chunker(chunker))
+		}
+
 		if cidVerSet {
 			opts = append(opts, options.Unixfs.CidVersion(cidVer))
 		}
@@ -229,6 +332,17 @@ only-hash, and progress/status related flags) will change the final hash.
 
 		opts = append(opts, nil) // events option placeholder
 
+		addOpts := []coreunix.AddOption{
+			coreunix.AddOpts.PreserveMode(preserveMode),
+			coreunix.AddOpts.PreserveMtime(preserveMtime),
+			coreunix.AddOpts.Resume(resume),
+			coreunix.AddOpts.Concurrency(concurrency),
+		}
+		adder, ok := api.Unixfs().(coreapi.AdderAPI)
+		if !ok {
+			return errors.New("the API of the node can't add files with the options of the command")
+		}
+
 		var added int
 		addit := toadd.Entries()
 		for addit.Next() {
@@ -240,7 +354,7 @@ only-hash, and progress/status related flags) will change the final hash.
 			go func() {
 				var err error
 				defer close(events)
-				_, err = api.Unixfs().Add(req.Context, addit.Node(), opts...)
+				_, err = adder.AddWith(req.Context, addit.Node(), addOpts, opts...)
 				errCh <- err
 			}()
 
@@ -267,6 +381,11 @@ only-hash, and progress/status related flags) will change the final hash.
 					Bytes: output.Bytes,
 					Size:  output.Size,
 				}); err != nil {
+					// wait for the adder to stop, it writes the journal
+					// of the completed files when it is interrupted
+					for range events {
+					}
+					<-errCh
 					return err
 				}
 			}
@@ -429,3 +548,13 @@ only-hash, and progress/status related flags) will change the final hash.
 	},
 	Type: AddEvent{},
 }
+
+// sendsAddInfo reports whether the client sends the mode, the modification
+// time and the size of its files with them, they are stored in the nodes or
+// compare the files with the journal of an interrupted add
+func sendsAddInfo(req *cmds.Request) bool {
+	preserveMode, _ := req.Options[preserveModeOptionName].(bool)
+	preserveMtime, _ := req.Options[preserveMtimeOptionName].(bool)
+	resume, _ := req.Options[resumeOptionName].(bool)
+	return preserveMode || preserveMtime || resume
+}
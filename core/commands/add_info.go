@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipfs/kubo/core/coreunix"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// addInfoPrefix starts the names of the entries holding the info of the next
+// entry of a directory. The info of the files is read by the client, the
+// daemon does not read the files of the client from its own disk. No file
+// name on the disk contains a NUL byte.
+const addInfoPrefix = "\x00info:"
+
+// maxAddInfoSize limits the size of the info entries read by the daemon
+const maxAddInfoSize = 1024
+
+// addInfo is the info of a file or a directory sent before its entry
+type addInfo struct {
+	Mode  uint32 // unix permission bits
+	Mtime int64  // in nanoseconds since the epoch
+	Size  int64  `json:",omitempty"`
+	Dir   bool   `json:",omitempty"`
+}
+
+// sendInfoDirectory precedes the entries of a directory with their info, when
+// the client knows it.
+type sendInfoDirectory struct {
+	files.Directory
+}
+
+func (d sendInfoDirectory) Entries() files.DirIterator {
+	return &sendInfoIterator{it: d.Directory.Entries()}
+}
+
+type sendInfoIterator struct {
+	it      files.DirIterator
+	pending bool // the info of the current entry was sent
+	name    string
+	node    files.Node
+}
+
+func (it *sendInfoIterator) Next() bool {
+	if it.pending {
+		it.pending = false
+		it.name, it.node = it.it.Name(), sendInfoNode(it.it.Node())
+		return true
+	}
+	if !it.it.Next() {
+		return false
+	}
+	s, ok := it.it.Node().(interface{ Stat() os.FileInfo })
+	if !ok || s.Stat() == nil {
+		it.name, it.node = it.it.Name(), sendInfoNode(it.it.Node())
+		return true
+	}
+	fi := s.Stat()
+	if fi.Mode()&os.ModeSymlink != 0 {
+		it.name, it.node = it.it.Name(), it.it.Node()
+		return true
+	}
+	b, err := json.Marshal(addInfo{
+		Mode:  coreunix.UnixMode(fi.Mode()),
+		Mtime: fi.ModTime().UnixNano(),
+		Size:  fi.Size(),
+		Dir:   fi.IsDir(),
+	})
+	if err != nil {
+		// an info which can't be encoded is not sent
+		it.name, it.node = it.it.Name(), sendInfoNode(it.it.Node())
+		return true
+	}
+	it.pending = true
+	it.name, it.node = addInfoPrefix+it.it.Name(), files.NewBytesFile(b)
+	return true
+}
+
+func (it *sendInfoIterator) Name() string     { return it.name }
+func (it *sendInfoIterator) Node() files.Node { return it.node }
+func (it *sendInfoIterator) Err() error       { return it.it.Err() }
+
+func sendInfoNode(nd files.Node) files.Node {
+	if dir, ok := nd.(files.Directory); ok {
+		return sendInfoDirectory{dir}
+	}
+	return nd
+}
+
+// recvInfoDirectory reads the info entries sent by sendInfoDirectory, and
+// gives their info to the next entries.
+type recvInfoDirectory struct {
+	files.Directory
+}
+
+func (d recvInfoDirectory) Entries() files.DirIterator {
+	return &recvInfoIterator{it: d.Directory.Entries()}
+}
+
+type recvInfoIterator struct {
+	it   files.DirIterator
+	node files.Node
+	err  error
+}
+
+func (it *recvInfoIterator) Next() bool {
+	var info *addInfoFile
+	for it.it.Next() {
+		name := it.it.Name()
+		if !strings.HasPrefix(name, addInfoPrefix) {
+			var fi os.FileInfo
+			if info != nil && info.name == name {
+				fi = info
+			}
+			it.node = recvInfoNode(it.it.Node(), fi)
+			return true
+		}
+		if info, it.err = readAddInfo(strings.TrimPrefix(name, addInfoPrefix), it.it.Node()); it.err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+func (it *recvInfoIterator) Name() string     { return it.it.Name() }
+func (it *recvInfoIterator) Node() files.Node { return it.node }
+
+func (it *recvInfoIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
+
+func readAddInfo(name string, nd files.Node) (*addInfoFile, error) {
+	f, ok := nd.(files.File)
+	if !ok {
+		return nil, fmt.Errorf("the info of %s is not a file", name)
+	}
+	b, err := io.ReadAll(io.LimitReader(f, maxAddInfoSize))
+	if err != nil {
+		return nil, err
+	}
+	info := &addInfoFile{name: name}
+	if err := json.Unmarshal(b, &info.info); err != nil {
+		return nil, fmt.Errorf("invalid info of %s: %w", name, err)
+	}
+	return info, nil
+}
+
+// recvInfoNode returns the node with the info fi, which may be nil
+func recvInfoNode(nd files.Node, fi os.FileInfo) files.Node {
+	switch nd := nd.(type) {
+	case files.Directory:
+		d := recvInfoDirectory{nd}
+		if fi == nil || !fi.IsDir() {
+			return d
+		}
+		return &statDirectory{recvInfoDirectory: d, fi: fi}
+	case *files.Symlink:
+		return nd
+	case files.File:
+		if fi == nil || fi.IsDir() {
+			return nd
+		}
+		if p, ok := nd.(files.FileInfo); ok && p.AbsPath() != "" {
+			return &statPathFile{File: nd, abspath: p.AbsPath(), fi: fi}
+		}
+		return &statFile{File: nd, fi: fi}
+	}
+	return nd
+}
+
+type statDirectory struct {
+	recvInfoDirectory
+	fi os.FileInfo
+}
+
+func (d *statDirectory) Stat() os.FileInfo { return d.fi }
+
+type statFile struct {
+	files.File
+	fi os.FileInfo
+}
+
+func (f *statFile) Stat() os.FileInfo { return f.fi }
+
+type statPathFile struct {
+	files.File
+	abspath string
+	fi      os.FileInfo
+}
+
+func (f *statPathFile) AbsPath() string   { return f.abspath }
+func (f *statPathFile) Stat() os.FileInfo { return f.fi }
+
+// addInfoFile is the os.FileInfo of an info entry
+type addInfoFile struct {
+	name string
+	info addInfo
+}
+
+func (fi *addInfoFile) Name() string       { return fi.name }
+func (fi *addInfoFile) Size() int64        { return fi.info.Size }
+func (fi *addInfoFile) ModTime() time.Time { return time.Unix(0, fi.info.Mtime) }
+func (fi *addInfoFile) IsDir() bool        { return fi.info.Dir }
+func (fi *addInfoFile) Sys() interface{}   { return nil }
+
+func (fi *addInfoFile) Mode() os.FileMode {
+	mode := coreunix.FileMode(fi.info.Mode)
+	if fi.info.Dir {
+		mode |= os.ModeDir
+	}
+	return mode
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+func TestAddInfo(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "tree")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "run.sh"), []byte("run"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("run.sh", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1577934245, 5)
+	for _, p := range []string{"run.sh", "sub", ""} {
+		if err := os.Chtimes(filepath.Join(root, p), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stat, err := os.Lstat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := files.NewSerialFile(root, false, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := sendInfoDirectory{files.NewSliceDirectory([]files.DirEntry{files.FileEntry("tree", sf)})}
+
+	// the info goes through the multipart body like the files
+	mfr := files.NewMultiFileReader(sent, true)
+	received, err := files.NewFileFromPartReader(multipart.NewReader(mfr, mfr.Boundary()), "multipart/form-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	err = files.Walk(recvInfoDirectory{received}, func(fpath string, nd files.Node) error {
+		names = append(names, fpath)
+		s, ok := nd.(interface{ Stat() os.FileInfo })
+		switch fpath {
+		case "tree/link", "":
+			if ok && s.Stat() != nil {
+				t.Errorf("unexpected info of %q", fpath)
+			}
+			return nil
+		}
+		if !ok {
+			t.Fatalf("no info for %s", fpath)
+		}
+		fi := s.Stat()
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("unexpected mtime %s of %s", fi.ModTime(), fpath)
+		}
+		if fpath == "tree/run.sh" {
+			if fi.Mode() != 0750 || fi.Size() != 3 {
+				t.Errorf("unexpected mode %s and size %d of %s", fi.Mode(), fi.Size(), fpath)
+			}
+			if _, ok := nd.(files.File); !ok {
+				t.Errorf("%s is not a file", fpath)
+			}
+		} else if !fi.IsDir() {
+			t.Errorf("%s is not a directory", fpath)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != " tree tree/link tree/run.sh tree/sub" {
+		t.Errorf("unexpected entries %q", got)
+	}
+}
@@ -34,6 +34,7 @@ var CatCmd = &cmds.Command{
 		cmds.Int64Option(offsetOptionName, "o", "Byte offset to begin reading from."),
 		cmds.Int64Option(lengthOptionName, "l", "Maximum number of bytes to read."),
 		cmds.BoolOption(progressOptionName, "p", "Stream progress data.").WithDefault(true),
+		cmds.BoolOption(enforceDenylistOptionName, "Refuse to output content blocked by the denylists of the node."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -60,6 +61,10 @@ var CatCmd = &cmds.Command{
 			return err
 		}
 
+		if err := enforceDenylist(req, env, api, req.Arguments); err != nil {
+			return err
+		}
+
 		readers, length, err := cat(req.Context, api, req.Arguments, int64(offset), int64(max))
 		if err != nil {
 			return err
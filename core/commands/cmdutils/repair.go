@@ -0,0 +1,40 @@
+package cmdutils
+
+import (
+	"errors"
+	"fmt"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	files "github.com/ipfs/go-ipfs-files"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/corerepo"
+)
+
+// RepairBlocks replaces the blocks, from the CAR files of the request and
+// from the network, see corerepo.Repair
+func RepairBlocks(req *cmds.Request, nd *core.IpfsNode, keys []cid.Cid, importMissing bool) ([]corerepo.RepairResult, error) {
+	r := corerepo.NewRepair(nd, keys)
+	r.ImportMissing = importMissing
+
+	if req.Files != nil {
+		it := req.Files.Entries()
+		for it.Next() {
+			file := files.FileFromEntry(it)
+			if file == nil {
+				return nil, errors.New("expected a file handle")
+			}
+			err := r.ReadCar(req.Context, file)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", it.Name(), err)
+			}
+		}
+		if it.Err() != nil {
+			return nil, it.Err()
+		}
+	}
+
+	return r.Fetch(req.Context), nil
+}
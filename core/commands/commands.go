@@ -184,7 +184,10 @@ func streamResult(procVal func(interface{}, io.Writer) nonFatalError) func(cmds.
 			if r := recover(); r != nil {
 				err = fmt.Errorf("internal error: %v", r)
 			}
-			re.Close()
+			// the executor closes the emitter with the error
+			if err == nil {
+				re.Close()
+			}
 		}()
 
 		var errors bool
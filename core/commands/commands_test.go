@@ -112,6 +112,11 @@ func TestCommands(t *testing.T) {
 		"/dag/put",
 		"/dag/resolve",
 		"/dag/stat",
+		"/denylist",
+		"/denylist/check",
+		"/denylist/hash",
+		"/denylist/ls",
+		"/denylist/reload",
 		"/dht",
 		"/dht/findpeer",
 		"/dht/findprovs",
@@ -136,6 +141,7 @@ func TestCommands(t *testing.T) {
 		"/file/ls",
 		"/files",
 		"/files/chcid",
+		"/files/chmod",
 		"/files/cp",
 		"/files/flush",
 		"/files/ls",
@@ -144,8 +150,10 @@ func TestCommands(t *testing.T) {
 		"/files/read",
 		"/files/rm",
 		"/files/stat",
+		"/files/touch",
 		"/files/write",
 		"/filestore",
+		"/filestore/clean",
 		"/filestore/dups",
 		"/filestore/ls",
 		"/filestore/verify",
@@ -210,6 +218,7 @@ func TestCommands(t *testing.T) {
 		"/pin/remote/service/ls",
 		"/pin/remote/service/rm",
 		"/pin/rm",
+		"/pin/stat",
 		"/pin/update",
 		"/pin/verify",
 		"/ping",
@@ -221,8 +230,12 @@ func TestCommands(t *testing.T) {
 		"/refs",
 		"/refs/local",
 		"/repo",
+		"/repo/convert",
+		"/repo/du",
+		"/repo/export",
 		"/repo/fsck",
 		"/repo/gc",
+		"/repo/import",
 		"/repo/migrate",
 		"/repo/stat",
 		"/repo/verify",
@@ -287,3 +300,56 @@ func TestCommands(t *testing.T) {
 		}
 	}
 }
+
+func TestRootWithAllowlist(t *testing.T) {
+	root, err := RootWithAllowlist([]string{"dag/get", "/name/resolve", "block", "block/get", "object links"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmdSet := make(map[string]struct{})
+	collectPaths("", root, cmdSet)
+	expected := []string{
+		"/block",
+		"/block/get",
+		"/block/stat",
+		"/dag",
+		"/dag/get",
+		"/name",
+		"/name/resolve",
+		"/object",
+		"/object/links",
+	}
+	for _, path := range expected {
+		if _, ok := cmdSet[path]; !ok {
+			t.Errorf("%q not in result", path)
+		} else {
+			delete(cmdSet, path)
+		}
+	}
+	for path := range cmdSet {
+		t.Errorf("%q in result but shouldn't be", path)
+	}
+
+	// the mutating subcommands of a listed command are not included
+	for _, path := range [][]string{{"block", "put"}, {"block", "rm"}} {
+		if _, err := root.Get(path); err == nil {
+			t.Errorf("%q should not be in the result", strings.Join(path, "/"))
+		}
+	}
+
+	// parents of listed commands can't be called
+	if dagCmd, _ := root.Get([]string{"dag"}); dagCmd.Run != nil {
+		t.Error("dag command should not be callable")
+	}
+	// global options are preserved
+	if len(root.Options) != len(Root.Options) {
+		t.Error("root options were not preserved")
+	}
+
+	for _, invalid := range []string{"", "/", "foo", "dag/foo", "block/put", "pin", "pin/ls", "name/publish"} {
+		if _, err := RootWithAllowlist([]string{invalid}); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/denylist"
+)
+
+const enforceDenylistOptionName = "enforce-denylist"
+
+var DenylistCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect and test content denylists.",
+		ShortDescription: `
+Denylists are lists of content which is not served by the gateway of the
+node, such as the "bad bits" list of https://badbits.dwebops.pub/.
+`,
+		LongDescription: `
+Denylists are lists of content which is not served by the gateway of the
+node, such as the "bad bits" list of https://badbits.dwebops.pub/.
+
+Lists are files with the .deny extension in the 'denylists' directory of the
+repo ($IPFS_PATH/denylists). They are reloaded when the files change. Each
+line is a hashed entry or an /ipfs/ path:
+
+  # comment
+  //8347c0662b24db0a8a0afb7e3a8798d7da2ce38478aa564282e9724f3e1b53d7
+  /ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/secret
+
+Hashed entries are produced by 'ipfs denylist hash', so a list can be shared
+without spreading the content it blocks. An entry for a path blocks the path
+and everything below it. Files with the .json extension are read in the
+format of the bad bits list.
+
+Content is only checked by 'ipfs cat' and 'ipfs get' when they are called
+with --enforce-denylist.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"ls":     denylistLsCmd,
+		"check":  denylistCheckCmd,
+		"hash":   denylistHashCmd,
+		"reload": denylistReloadCmd,
+	},
+}
+
+var denylistLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List loaded denylists.",
+		ShortDescription: `
+Lists the denylists loaded from the repo, with the number of entries in each
+list.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.Denylist == nil {
+			return nil
+		}
+		for _, f := range nd.Denylist.Files() {
+			f := f
+			if err := res.Emit(&f); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, f *denylist.File) error {
+			if f.Error != "" {
+				_, err := fmt.Fprintf(w, "%s error: %s\n", f.Name, f.Error)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "%s %d\n", f.Name, f.Entries)
+			return err
+		}),
+	},
+	Type: denylist.File{},
+}
+
+var denylistReloadCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Reload denylists from the repo.",
+		ShortDescription: `
+Lists are reloaded automatically when the files change, this command reloads
+them immediately.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.Denylist == nil {
+			return nil
+		}
+		_, reloadErr := nd.Denylist.Reload(true)
+		for _, f := range nd.Denylist.Files() {
+			f := f
+			if err := res.Emit(&f); err != nil {
+				return err
+			}
+		}
+		return reloadErr
+	},
+	Encoders: denylistLsCmd.Encoders,
+	Type:     denylist.File{},
+}
+
+// DenylistCheckOutput is the output of 'ipfs denylist check'
+type DenylistCheckOutput struct {
+	Path    string
+	Blocked bool
+	Match   *denylist.Match `json:",omitempty"`
+}
+
+var denylistCheckCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check if content is blocked by the denylists.",
+		ShortDescription: `
+Checks if the paths, or the content they resolve to, are blocked by the
+denylists of the node.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, true, "The paths to check.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		if err := req.ParseBodyArgs(); err != nil {
+			return err
+		}
+		for _, p := range req.Arguments {
+			match, err := checkDenylist(req.Context, api, nd.Denylist, path.New(p))
+			if err != nil {
+				return err
+			}
+			if err := res.Emit(&DenylistCheckOutput{Path: p, Blocked: match != nil, Match: match}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *DenylistCheckOutput) error {
+			if !out.Blocked {
+				_, err := fmt.Fprintf(w, "%s allowed\n", out.Path)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "%s blocked by %s in %s (%s)\n", out.Path, out.Match.Entry, out.Match.File, out.Match.Path)
+			return err
+		}),
+	},
+	Type: DenylistCheckOutput{},
+}
+
+// DenylistHashOutput is the output of 'ipfs denylist hash'
+type DenylistHashOutput struct {
+	Path  string
+	Entry string
+}
+
+var denylistHashCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Print the hashed denylist entry of a path.",
+		ShortDescription: `
+Prints the hashed denylist entry which blocks /ipfs/<cid>[/path]. The entry
+can be added to a list in the denylists directory of the repo.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, true, "The /ipfs/ paths to hash.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if err := req.ParseBodyArgs(); err != nil {
+			return err
+		}
+		for _, p := range req.Arguments {
+			entry, err := denylist.HashPath(p)
+			if err != nil {
+				return err
+			}
+			if err := res.Emit(&DenylistHashOutput{Path: p, Entry: entry}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *DenylistHashOutput) error {
+			_, err := fmt.Fprintln(w, out.Entry)
+			return err
+		}),
+	},
+	Type: DenylistHashOutput{},
+}
+
+// checkDenylist returns the match if the path, or the content it resolves
+// to, is blocked by the denylist
+func checkDenylist(ctx context.Context, api coreiface.CoreAPI, dl *denylist.Denylist, p path.Path) (*denylist.Match, error) {
+	if dl == nil {
+		return nil, nil
+	}
+	if err := p.IsValid(); err != nil {
+		return nil, err
+	}
+	match, err := dl.CheckPath(p.String())
+	if match != nil || err != nil {
+		return match, err
+	}
+	resolved, err := api.ResolvePath(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return dl.CheckCid(resolved.Cid()), nil
+}
+
+// enforceDenylist returns an error if any of the paths is blocked by the
+// denylists of the node, and --enforce-denylist is set
+func enforceDenylist(req *cmds.Request, env cmds.Environment, api coreiface.CoreAPI, paths []string) error {
+	if enforce, _ := req.Options[enforceDenylistOptionName].(bool); !enforce {
+		return nil
+	}
+	nd, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		match, err := checkDenylist(req.Context, api, nd.Denylist, path.New(p))
+		if err != nil {
+			return err
+		}
+		if match != nil {
+			return fmt.Errorf("%s: %w (%s in %s)", p, denylist.ErrDenied, match.Entry, match.File)
+		}
+	}
+	return nil
+}
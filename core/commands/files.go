@@ -8,11 +8,14 @@ import (
 	"os"
 	gopath "path"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/coreunix"
 
 	bservice "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
@@ -80,6 +83,8 @@ operations.
 		"rm":    filesRmCmd,
 		"flush": filesFlushCmd,
 		"chcid": filesChcidCmd,
+		"chmod": filesChmodCmd,
+		"touch": filesTouchCmd,
 	},
 }
 
@@ -102,6 +107,10 @@ type statOutput struct {
 	WithLocality   bool   `json:",omitempty"`
 	Local          bool   `json:",omitempty"`
 	SizeLocal      uint64 `json:",omitempty"`
+	// Mode is the octal permissions of the file, Mtime its modification time
+	// in RFC 3339 format, both empty when they are not stored
+	Mode  string `json:",omitempty"`
+	Mtime string `json:",omitempty"`
 }
 
 const (
@@ -125,7 +134,7 @@ var filesStatCmd = &cmds.Command{
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(filesFormatOptionName, "Print statistics in given format. Allowed tokens: "+
-			"<hash> <size> <cumulsize> <type> <childs>. Conflicts with other format options.").WithDefault(defaultStatFormat),
+			"<hash> <size> <cumulsize> <type> <childs> <mode> <mtime>. Conflicts with other format options.").WithDefault(defaultStatFormat),
 		cmds.BoolOption(filesHashOptionName, "Print only hash. Implies '--format=<hash>'. Conflicts with other format options."),
 		cmds.BoolOption(filesSizeOptionName, "Print only size. Implies '--format=<cumulsize>'. Conflicts with other format options."),
 		cmds.BoolOption(filesWithLocalOptionName, "Compute the amount of the dag that is local, and if possible the total size"),
@@ -198,11 +207,21 @@ var filesStatCmd = &cmds.Command{
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *statOutput) error {
 			s, _ := statGetFormatOptions(req)
+			if s == defaultStatFormat {
+				if out.Mode != "" {
+					s += "\nMode: <mode>"
+				}
+				if out.Mtime != "" {
+					s += "\nMtime: <mtime>"
+				}
+			}
 			s = strings.Replace(s, "<hash>", out.Hash, -1)
 			s = strings.Replace(s, "<size>", fmt.Sprintf("%d", out.Size), -1)
 			s = strings.Replace(s, "<cumulsize>", fmt.Sprintf("%d", out.CumulativeSize), -1)
 			s = strings.Replace(s, "<childs>", fmt.Sprintf("%d", out.Blocks), -1)
 			s = strings.Replace(s, "<type>", out.Type, -1)
+			s = strings.Replace(s, "<mode>", out.Mode, -1)
+			s = strings.Replace(s, "<mtime>", out.Mtime, -1)
 
 			fmt.Fprintln(w, s)
 
@@ -264,17 +283,34 @@ func statNode(nd ipld.Node, enc cidenc.Encoder) (*statOutput, error) {
 			ndtype = "directory"
 		case ft.TFile, ft.TMetadata, ft.TRaw:
 			ndtype = "file"
+		case ft.TSymlink:
+			ndtype = "symlink"
 		default:
 			return nil, fmt.Errorf("unrecognized node type: %s", d.Type())
 		}
 
-		return &statOutput{
+		out := &statOutput{
 			Hash:           enc.Encode(c),
 			Blocks:         len(nd.Links()),
 			Size:           d.FileSize(),
 			CumulativeSize: cumulsize,
 			Type:           ndtype,
-		}, nil
+		}
+		mode, err := coreunix.Mode(n)
+		if err != nil {
+			return nil, err
+		}
+		if mode != 0 {
+			out.Mode = fmt.Sprintf("%04o", coreunix.UnixMode(mode))
+		}
+		mtime, err := coreunix.ModTime(n)
+		if err != nil {
+			return nil, err
+		}
+		if !mtime.IsZero() {
+			out.Mtime = mtime.UTC().Format(time.RFC3339Nano)
+		}
+		return out, nil
 	case *dag.RawNode:
 		return &statOutput{
 			Hash:           enc.Encode(c),
@@ -1017,6 +1053,133 @@ func updatePath(rt *mfs.Root, pth string, builder cid.Builder) error {
 	return nil
 }
 
+var filesChmodCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Change the permissions of a file or directory.",
+		ShortDescription: `
+Store the permissions of a file or directory, given in octal. 'ipfs get'
+applies them to the files it writes. A mode of 0 removes the permissions.
+
+    $ ipfs files chmod 0755 /bin/run.sh
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("mode", true, false, "Octal permissions."),
+		cmds.StringArg("path", true, false, "Path to the file or directory."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		m, err := strconv.ParseUint(req.Arguments[0], 8, 32)
+		if err != nil || m > 07777 {
+			return fmt.Errorf("invalid mode %q, expected octal permissions", req.Arguments[0])
+		}
+		mode := coreunix.FileMode(uint32(m))
+
+		path, err := checkPath(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+
+		flush, _ := req.Options[filesFlushOptionName].(bool)
+
+		err = updateNodeInfo(nd.FilesRoot, path, func(pn *dag.ProtoNode) error {
+			return coreunix.SetMode(pn, mode)
+		})
+		if err == nil && flush {
+			_, err = mfs.FlushPath(req.Context, nd.FilesRoot, path)
+		}
+		return err
+	},
+}
+
+const filesMtimeOptionName = "mtime"
+
+var filesTouchCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Change the modification time of a file or directory.",
+		ShortDescription: `
+Store the modification time of an existing file or directory, the current
+time unless --mtime is given in seconds since the Unix epoch. 'ipfs get'
+applies it to the files it writes.
+
+    $ ipfs files touch --mtime=1577836800 /docs/index.html
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "Path to the file or directory."),
+	},
+	Options: []cmds.Option{
+		cmds.Int64Option(filesMtimeOptionName, "Modification time in seconds since the Unix epoch. Default: now."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		mtime := time.Now()
+		if secs, ok := req.Options[filesMtimeOptionName].(int64); ok {
+			mtime = time.Unix(secs, 0)
+		}
+
+		path, err := checkPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		flush, _ := req.Options[filesFlushOptionName].(bool)
+
+		err = updateNodeInfo(nd.FilesRoot, path, func(pn *dag.ProtoNode) error {
+			return coreunix.SetModTime(pn, mtime)
+		})
+		if err == nil && flush {
+			_, err = mfs.FlushPath(req.Context, nd.FilesRoot, path)
+		}
+		return err
+	},
+}
+
+// updateNodeInfo replaces the node at the path with a copy changed by update
+func updateNodeInfo(root *mfs.Root, path string, update func(*dag.ProtoNode) error) error {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return errors.New("can't change the root directory")
+	}
+	dir, name := gopath.Split(path)
+
+	pdir, err := getParentDir(root, dir)
+	if err != nil {
+		return err
+	}
+	child, err := pdir.Child(name)
+	if err != nil {
+		return err
+	}
+	nd, err := child.GetNode()
+	if err != nil {
+		return err
+	}
+	pn, err := coreunix.MetadataNode(nd, pdir.GetCidBuilder())
+	if err != nil {
+		return err
+	}
+	if err := update(pn); err != nil {
+		return err
+	}
+
+	if err := pdir.Unlink(name); err != nil {
+		return err
+	}
+	if err := pdir.AddChild(name, pn); err != nil {
+		return err
+	}
+	return pdir.Flush()
+}
+
 var filesRmCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Remove a file.",
@@ -2,15 +2,23 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	filestore "github.com/ipfs/go-filestore"
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
 	core "github.com/ipfs/kubo/core"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	e "github.com/ipfs/kubo/core/commands/e"
+	corerepo "github.com/ipfs/kubo/core/corerepo"
 
 	"github.com/ipfs/go-cid"
 )
@@ -23,11 +31,15 @@ var FileStoreCmd = &cmds.Command{
 		"ls":     lsFileStore,
 		"verify": verifyFileStore,
 		"dups":   dupsFileStore,
+		"clean":  cleanFileStore,
 	},
 }
 
 const (
-	fileOrderOptionName = "file-order"
+	fileOrderOptionName  = "file-order"
+	pathPrefixOptionName = "path-prefix"
+	unpinnedOptionName   = "unpinned"
+	readdOptionName      = "readd"
 )
 
 var lsFileStore = &cmds.Command{
@@ -39,6 +51,10 @@ List objects in the filestore.
 If one or more <obj> is specified only list those specific objects,
 otherwise list all objects.
 
+With --path-prefix, only the objects backed by the files under the path are
+listed, the path is compared on whole path segments. A relative prefix is
+compared to the paths as listed, relative to the filestore root.
+
 The output is:
 
 <hash> <size> <path> <offset>
@@ -49,15 +65,21 @@ The output is:
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(fileOrderOptionName, "sort the results based on the path of the backing file"),
+		cmds.StringOption(pathPrefixOptionName, "only list the objects backed by the files under this path"),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
-		_, fs, err := getFilestore(env)
+		n, fs, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+		prefix, _ := req.Options[pathPrefixOptionName].(string)
+		prefix, err = filestorePathPrefix(n, prefix)
 		if err != nil {
 			return err
 		}
 		args := req.Arguments
 		if len(args) > 0 {
-			return listByArgs(req.Context, res, fs, args)
+			return listByArgs(req.Context, res, fs, args, prefix)
 		}
 
 		fileOrder, _ := req.Options[fileOrderOptionName].(bool)
@@ -71,6 +93,9 @@ The output is:
 			if r == nil {
 				break
 			}
+			if !hasPathPrefix(r.FilePath, prefix) {
+				continue
+			}
 			if err := res.Emit(r); err != nil {
 				return err
 			}
@@ -134,7 +159,7 @@ For ERROR entries the error will also be printed to stderr.
 		}
 		args := req.Arguments
 		if len(args) > 0 {
-			return listByArgs(req.Context, res, fs, args)
+			return listByArgs(req.Context, res, fs, args, "")
 		}
 
 		fileOrder, _ := req.Options[fileOrderOptionName].(bool)
@@ -224,6 +249,254 @@ var dupsFileStore = &cmds.Command{
 	Type:     RefWrapper{},
 }
 
+// filestoreCleanOutput is an entry removed by filestore clean, or a file
+// added again
+type filestoreCleanOutput struct {
+	Action string
+	filestore.ListRes
+}
+
+const (
+	cleanRemoved = "removed"
+	cleanAdded   = "added"
+	cleanError   = "error"
+)
+
+var cleanFileStore = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove the filestore objects of changed or removed files.",
+		ShortDescription: `
+Removes the objects of the filestore whose backing file changed, was removed
+or can't be read, as reported by 'ipfs filestore verify'.
+`,
+		LongDescription: `
+Removes the objects of the filestore whose backing file changed, was removed
+or can't be read, as reported by 'ipfs filestore verify'. Their blocks can't
+be read anyway, removing them lets a node providing live directories from
+the filestore stay consistent.
+
+<what> is one or more of:
+changed:  the contents of the backing file have changed
+no-file:  the backing file could not be found
+error:    there was some other problem reading the file
+
+With --unpinned, only the objects which are not pinned, and not in the
+files of 'ipfs files', are removed. With --path-prefix, only the objects
+backed by the files under the path are removed.
+
+With --readd, the changed files are added again with --nocopy, and pinned,
+so the filestore references their current contents in place. The hashes of
+the files change, the pins and the directories of the previous hashes are
+not updated.
+
+The output is:
+
+removed <status> <hash> <size> <path> <offset>
+added <hash> <path>
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("what", true, true, "The objects to remove: changed, no-file or error."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(unpinnedOptionName, "only remove the objects which are not pinned"),
+		cmds.StringOption(pathPrefixOptionName, "only remove the objects backed by the files under this path"),
+		cmds.BoolOption(readdOptionName, "add the changed files again"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, fs, err := getFilestore(env)
+		if err != nil {
+			return err
+		}
+
+		what := make(map[filestore.Status]bool)
+		for _, arg := range req.Arguments {
+			switch arg {
+			case "changed":
+				what[filestore.StatusFileChanged] = true
+			case "no-file":
+				what[filestore.StatusFileNotFound] = true
+			case "error":
+				what[filestore.StatusFileError] = true
+			default:
+				return fmt.Errorf("invalid objects to remove %q, expected changed, no-file or error", arg)
+			}
+		}
+		unpinned, _ := req.Options[unpinnedOptionName].(bool)
+		readd, _ := req.Options[readdOptionName].(bool)
+		if readd && !what[filestore.StatusFileChanged] {
+			return errors.New("--readd adds the changed files again, it needs changed")
+		}
+		prefix, _ := req.Options[pathPrefixOptionName].(string)
+		prefix, err = filestorePathPrefix(n, prefix)
+		if err != nil {
+			return err
+		}
+
+		removed, err := cleanFilestore(req.Context, n, fs, what, unpinned, prefix)
+		if err != nil {
+			return err
+		}
+		var changed []string
+		seen := make(map[string]bool)
+		for _, r := range removed {
+			if err := res.Emit(&filestoreCleanOutput{Action: cleanRemoved, ListRes: *r}); err != nil {
+				return err
+			}
+			if r.Status == filestore.StatusFileChanged && !seen[r.FilePath] {
+				seen[r.FilePath] = true
+				changed = append(changed, r.FilePath)
+			}
+		}
+		if !readd {
+			return nil
+		}
+
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		root := filestoreRoot(n)
+		for _, fpath := range changed {
+			out := &filestoreCleanOutput{Action: cleanAdded, ListRes: filestore.ListRes{FilePath: fpath}}
+			c, err := cid.Undef, errors.New("not a file")
+			if !filestore.IsURL(fpath) {
+				c, err = readdFile(req.Context, api.Unixfs(), filepath.Join(root, fpath))
+			}
+			if err != nil {
+				out.Action = cleanError
+				out.Status = filestore.StatusFileError
+				out.ErrorMsg = fmt.Sprintf("adding %s again: %s", fpath, err)
+			}
+			out.Key = c
+			if err := res.Emit(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *filestoreCleanOutput) error {
+			enc, err := cmdenv.GetCidEncoder(req)
+			if err != nil {
+				return err
+			}
+			switch out.Action {
+			case cleanRemoved:
+				fmt.Fprintf(w, "%s %s %s\n", out.Action, out.Status.Format(), out.FormatLong(enc.Encode))
+			case cleanAdded:
+				fmt.Fprintf(w, "%s %s %s\n", out.Action, enc.Encode(out.Key), out.FilePath)
+			default:
+				fmt.Fprintf(os.Stderr, "%s\n", out.ErrorMsg)
+			}
+			return nil
+		}),
+	},
+	Type: filestoreCleanOutput{},
+}
+
+// cleanFilestore removes the entries of the filestore with the given status,
+// and returns them in the order of their files. The files are verified
+// without holding the GC lock, the entries to remove are verified again once
+// it is taken.
+func cleanFilestore(ctx context.Context, n *core.IpfsNode, fs *filestore.Filestore, what map[filestore.Status]bool, unpinned bool, prefix string) ([]*filestore.ListRes, error) {
+	next, err := filestore.VerifyAll(ctx, fs, true)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []*filestore.ListRes
+	for r := next(ctx); r != nil; r = next(ctx) {
+		if what[r.Status] && hasPathPrefix(r.FilePath, prefix) {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// the pinned blocks can't be added while the entries are removed
+	unlocker := n.Blockstore.GCLock(ctx)
+	defer unlocker.Unlock(ctx)
+
+	var kept *cid.Set
+	if unpinned {
+		if kept, err = corerepo.KeptSet(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+
+	var removed []*filestore.ListRes
+	for _, r := range candidates {
+		if kept != nil && kept.Has(r.Key) {
+			continue
+		}
+		// the entry may have been added again since it was verified
+		if !what[filestore.Verify(ctx, fs, r.Key).Status] {
+			continue
+		}
+		if err := fs.FileManager().DeleteBlock(ctx, r.Key); err != nil && !ipld.IsNotFound(err) {
+			return nil, err
+		}
+		removed = append(removed, r)
+	}
+	return removed, nil
+}
+
+// readdFile adds the file at the path with --nocopy
+func readdFile(ctx context.Context, api coreiface.UnixfsAPI, fpath string) (cid.Cid, error) {
+	st, err := os.Stat(fpath)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if !st.Mode().IsRegular() {
+		return cid.Undef, errors.New("not a regular file")
+	}
+	f, err := files.NewSerialFile(fpath, false, st)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer f.Close()
+	p, err := api.Add(ctx, f, options.Unixfs.Nocopy(true), options.Unixfs.Pin(true))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return p.Cid(), nil
+}
+
+// filestoreRoot returns the directory the paths of the filestore are
+// relative to, the parent of the repo like in fsrepo
+func filestoreRoot(n *core.IpfsNode) string {
+	return filepath.Dir(n.Repo.Path())
+}
+
+// filestorePathPrefix returns a prefix of the paths of the filestore, which
+// are relative to the filestore root
+func filestorePathPrefix(n *core.IpfsNode, prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	rel := filepath.Clean(prefix)
+	if filepath.IsAbs(prefix) {
+		root := filestoreRoot(n)
+		var err error
+		rel, err = filepath.Rel(root, prefix)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("%s is not under the filestore root %s", prefix, root)
+		}
+	}
+	if rel == "." {
+		return "", nil
+	}
+	// the paths of the filestore use forward slashes
+	return filepath.ToSlash(rel), nil
+}
+
+// hasPathPrefix tells if the path is the prefix or under it, comparing
+// whole path segments
+func hasPathPrefix(fpath, prefix string) bool {
+	return prefix == "" || fpath == prefix || strings.HasPrefix(fpath, prefix+"/")
+}
+
 func getFilestore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, error) {
 	n, err := cmdenv.GetNode(env)
 	if err != nil {
@@ -236,7 +509,7 @@ func getFilestore(env cmds.Environment) (*core.IpfsNode, *filestore.Filestore, e
 	return n, fs, err
 }
 
-func listByArgs(ctx context.Context, res cmds.ResponseEmitter, fs *filestore.Filestore, args []string) error {
+func listByArgs(ctx context.Context, res cmds.ResponseEmitter, fs *filestore.Filestore, args []string, prefix string) error {
 	for _, arg := range args {
 		c, err := cid.Decode(arg)
 		if err != nil {
@@ -250,6 +523,9 @@ func listByArgs(ctx context.Context, res cmds.ResponseEmitter, fs *filestore.Fil
 			continue
 		}
 		r := filestore.Verify(ctx, fs, c)
+		if r.Status != filestore.StatusOtherError && !hasPathPrefix(r.FilePath, prefix) {
+			continue
+		}
 		if err := res.Emit(r); err != nil {
 			return err
 		}
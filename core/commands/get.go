@@ -1,8 +1,10 @@
 package commands
 
 import (
+	"archive/tar"
 	"bufio"
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,15 +12,18 @@ import (
 	gopath "path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ipfs/kubo/core/commands/cmdenv"
 	"github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/core/coreunix"
 
 	"github.com/cheggaaa/pb"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/interface-go-ipfs-core/path"
-	"github.com/ipfs/tar-utils"
+	tarutil "github.com/ipfs/tar-utils"
 )
 
 var ErrInvalidCompressionLevel = errors.New("compression level must be between 1 and 9")
@@ -28,6 +33,7 @@ const (
 	archiveOptionName          = "archive"
 	compressOptionName         = "compress"
 	compressionLevelOptionName = "compression-level"
+	specialBitsOptionName      = "special-bits"
 )
 
 var GetCmd = &cmds.Command{
@@ -43,6 +49,10 @@ To output a TAR archive instead of unpacked files, use '--archive' or '-a'.
 
 To compress the output with GZIP compression, use '--compress' or '-C'. You
 may also specify the level of compression by specifying '-l=<1-9>'.
+
+The mode and the modification time stored with the files are restored. Only
+the permission bits of the mode are restored, unless '--special-bits' is
+given to restore the setuid, setgid and sticky bits too.
 `,
 	},
 
@@ -55,6 +65,8 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		cmds.BoolOption(compressOptionName, "C", "Compress the output with GZIP compression."),
 		cmds.IntOption(compressionLevelOptionName, "l", "The level of compression (1-9)."),
 		cmds.BoolOption(progressOptionName, "p", "Stream progress data.").WithDefault(true),
+		cmds.BoolOption(specialBitsOptionName, "Also restore the setuid, setgid and sticky bits of the files."),
+		cmds.BoolOption(enforceDenylistOptionName, "Refuse to output content blocked by the denylists of the node."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
 		_, err := getCompressOptions(req)
@@ -72,9 +84,21 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 			return err
 		}
 
+		if err := enforceDenylist(req, env, api, req.Arguments[:1]); err != nil {
+			return err
+		}
+
 		p := path.New(req.Arguments[0])
 
-		file, err := api.Unixfs().Get(ctx, p)
+		rp, err := api.ResolvePath(ctx, p)
+		if err != nil {
+			return err
+		}
+		nd, err := api.Dag().Get(ctx, rp.Cid())
+		if err != nil {
+			return err
+		}
+		file, err := api.Unixfs().Get(ctx, rp)
 		if err != nil {
 			return err
 		}
@@ -87,7 +111,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 		res.SetLength(uint64(size))
 
 		archive, _ := req.Options[archiveOptionName].(bool)
-		reader, err := fileArchive(file, p.String(), archive, cmplvl)
+		reader, err := fileArchive(ctx, api.Dag(), nd, file, p.String(), archive, cmplvl)
 		if err != nil {
 			return err
 		}
@@ -124,6 +148,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 
 			archive, _ := req.Options[archiveOptionName].(bool)
 			progress, _ := req.Options[progressOptionName].(bool)
+			specialBits, _ := req.Options[specialBitsOptionName].(bool)
 
 			gw := getWriter{
 				Out:         os.Stdout,
@@ -132,6 +157,7 @@ may also specify the level of compression by specifying '-l=<1-9>'.
 				Compression: cmplvl,
 				Size:        int64(res.Length()),
 				Progress:    progress,
+				SpecialBits: specialBits,
 			}
 
 			return gw.Write(outReader, outPath)
@@ -193,6 +219,7 @@ type getWriter struct {
 	Compression int
 	Size        int64
 	Progress    bool
+	SpecialBits bool // restore the setuid, setgid and sticky bits
 }
 
 func (gw *getWriter) Write(r io.Reader, fpath string) error {
@@ -247,8 +274,191 @@ func (gw *getWriter) writeExtracted(r io.Reader, fpath string) error {
 		progressCb = bar.Add64
 	}
 
-	extractor := &tar.Extractor{Path: fpath, Progress: progressCb}
-	return extractor.Extract(r)
+	// the extractor ignores the mode and mtime of the entries, they are read
+	// from a copy of the stream and applied to the extracted files
+	info := newExtractedInfo(fpath, gw.SpecialBits)
+	extractor := &tarutil.Extractor{Path: fpath, Progress: progressCb}
+	err := extractor.Extract(io.TeeReader(r, info.pw))
+	if ierr := info.wait(); err == nil {
+		err = ierr
+	}
+	if err != nil {
+		return err
+	}
+	return info.apply()
+}
+
+// extractedInfo reads the mode and the mtime of the entries of a tar stream
+// written by coreunix.TarWriter, and the paths they are extracted to
+type extractedInfo struct {
+	path      string
+	rootIsDir bool
+	modeMask  os.FileMode
+
+	pw      *io.PipeWriter
+	done    chan struct{}
+	entries []extractedEntry
+	err     error
+}
+
+type extractedEntry struct {
+	path  string
+	dir   bool
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func newExtractedInfo(fpath string, specialBits bool) *extractedInfo {
+	info := &extractedInfo{path: filepath.Clean(fpath), modeMask: os.ModePerm, done: make(chan struct{})}
+	if specialBits {
+		info.modeMask |= os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	}
+	// like the extractor, a file root goes in the output path when it is an
+	// existing directory
+	if st, err := os.Lstat(info.path); err == nil && st.IsDir() {
+		info.rootIsDir = true
+	}
+
+	pr, pw := io.Pipe()
+	info.pw = pw
+	go func() {
+		defer close(info.done)
+		info.err = info.read(pr)
+		// keep the extraction going when the stream can't be read
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+	return info
+}
+
+func (info *extractedInfo) read(r io.Reader) error {
+	tr := tar.NewReader(r)
+	var root string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// the names are checked like the extractor does, the entries it
+		// refuses are never changed
+		var out string
+		switch {
+		case root == "":
+			if !validExtractedName(hdr.Name) || strings.Contains(hdr.Name, "/") {
+				return fmt.Errorf("invalid root name %q", hdr.Name)
+			}
+			root = hdr.Name
+			out = info.path
+			if hdr.Typeflag != tar.TypeDir && info.rootIsDir {
+				out = filepath.Join(info.path, root)
+			}
+		case validExtractedName(hdr.Name) && strings.HasPrefix(hdr.Name, root+"/"):
+			out = filepath.Join(info.path, filepath.FromSlash(strings.TrimPrefix(hdr.Name, root+"/")))
+		default:
+			return fmt.Errorf("invalid entry name %q", hdr.Name)
+		}
+
+		// symlinks keep the mode and mtime they are created with
+		if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		mode, mtime, err := coreunix.TarHeaderInfo(hdr)
+		if err != nil {
+			return err
+		}
+		mode &= info.modeMask
+		if mode == 0 && mtime.IsZero() {
+			continue
+		}
+		info.entries = append(info.entries, extractedEntry{
+			path:  out,
+			dir:   hdr.Typeflag == tar.TypeDir,
+			mode:  mode,
+			mtime: mtime,
+		})
+	}
+}
+
+// validExtractedName reports whether a tar entry name is relative and has no
+// empty, '.' or '..' element
+func validExtractedName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, e := range strings.Split(name, "/") {
+		switch e {
+		case "", ".", "..":
+			return false
+		}
+	}
+	return true
+}
+
+// wait returns once the whole stream was read
+func (info *extractedInfo) wait() error {
+	info.pw.Close()
+	<-info.done
+	return info.err
+}
+
+// apply sets the mode and the mtime of the extracted files. Directories come
+// before their children in the stream, they are changed after them.
+func (info *extractedInfo) apply() error {
+	for i := len(info.entries) - 1; i >= 0; i-- {
+		e := info.entries[i]
+		if err := info.checkExtracted(e); err != nil {
+			return err
+		}
+		if e.mode != 0 {
+			if err := os.Chmod(e.path, e.mode); err != nil {
+				return err
+			}
+		}
+		if !e.mtime.IsZero() {
+			if err := os.Chtimes(e.path, e.mtime, e.mtime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkExtracted returns an error unless the path of an entry and its parents
+// below the output path are not symlinks, and the entry has the type it was
+// extracted with. Chmod and Chtimes follow symlinks, they must not lead out of
+// the extracted files.
+func (info *extractedInfo) checkExtracted(e extractedEntry) error {
+	rel, err := filepath.Rel(info.path, e.path)
+	if err != nil {
+		return err
+	}
+	var elems []string
+	if rel != "." {
+		elems = strings.Split(rel, string(filepath.Separator))
+	}
+	p := info.path
+	for i := 0; ; i++ {
+		st, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("can't restore the mode and mtime of %s, %s is a symlink", e.path, p)
+		}
+		if i == len(elems) {
+			if st.IsDir() != e.dir {
+				return fmt.Errorf("can't restore the mode and mtime of %s, it was replaced", e.path)
+			}
+			return nil
+		}
+		if !st.IsDir() || elems[i] == ".." {
+			return fmt.Errorf("can't restore the mode and mtime of %s, %s is not an extracted directory", e.path, p)
+		}
+		p = filepath.Join(p, elems[i])
+	}
 }
 
 func getCompressOptions(req *cmds.Request) (int, error) {
@@ -281,7 +491,7 @@ func (i *identityWriteCloser) Close() error {
 	return nil
 }
 
-func fileArchive(f files.Node, name string, archive bool, compression int) (io.ReadCloser, error) {
+func fileArchive(ctx context.Context, dag ipld.DAGService, nd ipld.Node, f files.Node, name string, archive bool, compression int) (io.ReadCloser, error) {
 	cleaned := gopath.Clean(name)
 	_, filename := gopath.Split(cleaned)
 
@@ -330,15 +540,14 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 	} else {
 		// the case for 1. archive, and 2. not archived and not compressed, in which tar is used anyway as a transport format
 
-		// construct the tar writer
-		w, err := files.NewTarWriter(maybeGzw)
-		if checkErrAndClosePipe(err) {
-			return nil, err
-		}
+		// construct the tar writer, the mode and mtime of the nodes are
+		// extracted from its records by the client
+		w := coreunix.NewTarWriter(ctx, dag, maybeGzw)
+		w.InfoRecords = !archive
 
 		go func() {
 			// write all the nodes recursively
-			if err := w.WriteFile(f, filename); checkErrAndClosePipe(err) {
+			if err := w.WriteNode(nd, filename); checkErrAndClosePipe(err) {
 				return
 			}
 			w.Close()         // close tar writer
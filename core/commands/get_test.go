@@ -1,11 +1,19 @@
 package commands
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/ipfs/kubo/core/coreunix"
+
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	tarutil "github.com/ipfs/tar-utils"
 )
 
 func TestGetOutputPath(t *testing.T) {
@@ -66,3 +74,103 @@ func TestGetOutputPath(t *testing.T) {
 		})
 	}
 }
+
+// extractedTar returns a tar of a directory with a setuid file, like the ones
+// of coreunix.TarWriter
+func extractedTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		name string
+		typ  byte
+		mode string
+	}{
+		{"root", tar.TypeDir, "755"},
+		{"root/sub", tar.TypeDir, "700"},
+		{"root/sub/run", tar.TypeReg, "4750"},
+	}
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typ,
+			Mode:     0644,
+			Format:   tar.FormatPAX,
+			PAXRecords: map[string]string{
+				coreunix.TarModeRecord:  e.mode,
+				coreunix.TarMtimeRecord: "1000000000",
+			},
+		}
+		if e.typ == tar.TypeReg {
+			hdr.Size = 3
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.typ == tar.TypeReg {
+			if _, err := tw.Write([]byte("run")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetExtractedInfo(t *testing.T) {
+	dir := t.TempDir()
+	archive := extractedTar(t)
+
+	for _, specialBits := range []bool{false, true} {
+		out := filepath.Join(dir, fmt.Sprint("out-", specialBits))
+		gw := getWriter{Out: io.Discard, Err: io.Discard, SpecialBits: specialBits}
+		if err := gw.Write(bytes.NewReader(archive), out); err != nil {
+			t.Fatal(err)
+		}
+		st, err := os.Lstat(filepath.Join(out, "sub", "run"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := os.FileMode(0750)
+		if specialBits {
+			want |= os.ModeSetuid
+		}
+		if st.Mode()&(os.ModePerm|os.ModeSetuid) != want {
+			t.Errorf("expected the mode %s, got %s", want, st.Mode())
+		}
+		if st.ModTime().Unix() != 1000000000 {
+			t.Errorf("unexpected mtime %s", st.ModTime())
+		}
+	}
+
+	// a directory replaced by a symlink after the extraction is not followed
+	out := filepath.Join(dir, "out-replaced")
+	outside := filepath.Join(dir, "outside")
+	info := newExtractedInfo(out, false)
+	extractor := &tarutil.Extractor{Path: out}
+	if err := extractor.Extract(io.TeeReader(bytes.NewReader(archive), info.pw)); err != nil {
+		t.Fatal(err)
+	}
+	if err := info.wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(outside, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "run"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(out, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(out, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if err := info.apply(); err == nil {
+		t.Fatal("expected the symlink to be refused")
+	}
+	if st, err := os.Stat(filepath.Join(outside, "run")); err != nil || st.Mode().Perm() != 0600 {
+		t.Fatalf("the file behind the symlink was changed: %v", err)
+	}
+}
@@ -3,16 +3,20 @@ package pin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	bserv "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
 	verifcid "github.com/ipfs/go-verifcid"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
@@ -21,7 +25,12 @@ import (
 
 	core "github.com/ipfs/kubo/core"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/commands/cmdutils"
 	e "github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/core/corerepo"
+	"github.com/ipfs/kubo/gc"
+	"github.com/ipfs/kubo/pinmeta"
 )
 
 var PinCmd = &cmds.Command{
@@ -34,6 +43,7 @@ var PinCmd = &cmds.Command{
 		"rm":     rmPinCmd,
 		"ls":     listPinCmd,
 		"verify": verifyPinCmd,
+		"stat":   statPinCmd,
 		"update": updatePinCmd,
 		"remote": remotePinCmd,
 	},
@@ -51,12 +61,55 @@ type AddPinOutput struct {
 const (
 	pinRecursiveOptionName = "recursive"
 	pinProgressOptionName  = "progress"
+	pinMetaOptionName      = "meta"
+	pinExpiresInOptionName = "expires-in"
+	pinExpiresAtOptionName = "expires-at"
 )
 
+// pinInfoAPI is implemented by the pin API of the node, which stores the
+// names and metadata of the local pins
+type pinInfoAPI interface {
+	AddWithInfo(ctx context.Context, p path.Path, info pinmeta.Info, opts ...options.PinAddOption) error
+	Query(ctx context.Context, q pinmeta.Query, opts ...options.PinLsOption) (<-chan coreiface.Pin, error)
+	Info(ctx context.Context, p path.Path) (pinmeta.Info, error)
+}
+
+func getPinInfoAPI(api coreiface.CoreAPI) (pinInfoAPI, error) {
+	papi, ok := api.Pin().(pinInfoAPI)
+	if !ok {
+		return nil, errors.New("pin names and metadata are not supported by this node")
+	}
+	return papi, nil
+}
+
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+Pins can be given a name with --name, and metadata with --meta key=value,
+which can be repeated. Both are listed and queried with 'ipfs pin ls', and
+are kept when the pin is updated with 'ipfs pin update'. Pinning again with a
+name or metadata replaces the ones of the pin, otherwise they are kept.
+
+With --expires-in or --expires-at, the daemon removes the pin once it
+expires, so that its blocks can be garbage collected. The expiry is shown by
+'ipfs pin ls', and kept by 'ipfs pin update'. Pinning again always replaces
+the expiry: a pin added again without --expires-in or --expires-at does not
+expire anymore.
+
+Example:
+	$ ipfs pin add --name=photos --meta owner=alice --meta year=2022 QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
+	pinned QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN recursively
+	$ ipfs pin ls --meta owner=alice
+	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN recursive photos
+	$ ipfs pin add --name=build --expires-in=72h QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
+	pinned QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN recursively
+	$ ipfs pin ls --name=build
+	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN recursive build (expires 2022-10-21T10:00:00Z)
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -65,6 +118,10 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.StringOption(pinNameOptionName, "An optional name for the pin."),
+		cmds.StringsOption(pinMetaOptionName, "Metadata of the pin, as key=value. Can be repeated."),
+		cmds.StringOption(pinExpiresInOptionName, "Remove the pin after the duration, e.g. 72h."),
+		cmds.StringOption(pinExpiresAtOptionName, "Remove the pin at the time, in RFC 3339 format, e.g. 2022-10-21T10:00:00Z."),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -77,6 +134,14 @@ var addPinCmd = &cmds.Command{
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
 
+		info, err := pinInfoOptions(req)
+		if err != nil {
+			return err
+		}
+		if info.Expires, err = pinExpiryOptions(req); err != nil {
+			return err
+		}
+
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
 		}
@@ -87,7 +152,7 @@ var addPinCmd = &cmds.Command{
 		}
 
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, info)
 			if err != nil {
 				return err
 			}
@@ -105,7 +170,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, info)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -181,7 +246,60 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+// pinInfoOptions returns the name and metadata given in the options
+func pinInfoOptions(req *cmds.Request) (pinmeta.Info, error) {
+	var info pinmeta.Info
+	info.Name, _ = req.Options[pinNameOptionName].(string)
+	pairs, _ := req.Options[pinMetaOptionName].([]string)
+	meta, err := pinmeta.ParseMeta(pairs)
+	if err != nil {
+		return info, err
+	}
+	info.Meta = meta
+	return info, info.Validate()
+}
+
+// pinExpiryOptions returns the expiry given in the options, or zero
+func pinExpiryOptions(req *cmds.Request) (time.Time, error) {
+	expiresIn, inFound := req.Options[pinExpiresInOptionName].(string)
+	expiresAt, atFound := req.Options[pinExpiresAtOptionName].(string)
+	switch {
+	case inFound && atFound:
+		return time.Time{}, fmt.Errorf("--%s and --%s are mutually exclusive", pinExpiresInOptionName, pinExpiresAtOptionName)
+	case inFound:
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --%s: %s", pinExpiresInOptionName, err)
+		}
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("--%s must be positive", pinExpiresInOptionName)
+		}
+		return time.Now().Add(d).UTC().Truncate(time.Second), nil
+	case atFound:
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --%s: %s", pinExpiresAtOptionName, err)
+		}
+		if !t.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("--%s must be in the future", pinExpiresAtOptionName)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, info pinmeta.Info) ([]string, error) {
+	add := api.Pin().Add
+	if !info.IsEmpty() {
+		papi, err := getPinInfoAPI(api)
+		if err != nil {
+			return nil, err
+		}
+		add = func(ctx context.Context, p path.Path, opts ...options.PinAddOption) error {
+			return papi.AddWithInfo(ctx, p, info, opts...)
+		}
+	}
+
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -189,7 +307,7 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 			return nil, err
 		}
 
-		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
+		if err := add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
 			return nil, err
 		}
 		added[i] = enc.Encode(rp.Cid())
@@ -300,6 +418,11 @@ With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
 
+Use --name=<substring> to only list the pins whose name contains the
+substring, and --meta key=value to only list the pins with the metadata. With
+--meta key, any value of the key matches. Indirect pins have no name nor
+metadata, they are not listed with these options.
+
 Example:
 	$ echo "hello" | ipfs add -q
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
@@ -324,6 +447,8 @@ Example:
 		cmds.StringOption(pinTypeOptionName, "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
+		cmds.StringOption(pinNameOptionName, "Only list the pins with names containing the value."),
+		cmds.StringsOption(pinMetaOptionName, "Only list the pins with the metadata, as key=value or key. Can be repeated."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -341,21 +466,32 @@ Example:
 			return err
 		}
 
+		info, err := pinInfoOptions(req)
+		if err != nil {
+			return err
+		}
+		q := pinmeta.Query{Name: info.Name, Meta: info.Meta}
+
 		// For backward compatibility, we accumulate the pins in the same output type as before.
 		emit := res.Emit
 		lgcList := map[string]PinLsType{}
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = PinLsType{
+					Type:    obj.PinLsObject.Type,
+					Name:    obj.PinLsObject.Name,
+					Meta:    obj.PinLsObject.Meta,
+					Expires: obj.PinLsObject.Expires,
+				}
 				return nil
 			}
 		}
 
 		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, api, emit)
+			err = pinLsKeys(req, typeStr, q, api, emit)
 		} else {
-			err = pinLsAll(req, typeStr, api, emit)
+			err = pinLsAll(req, typeStr, q, api, emit)
 		}
 		if err != nil {
 			return err
@@ -390,7 +526,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					fmt.Fprintf(w, "%s %s%s\n", out.PinLsObject.Cid, out.PinLsObject.Type, formatPinInfo(out.PinLsObject.Name, out.PinLsObject.Expires))
 				}
 				return nil
 			}
@@ -399,7 +535,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					fmt.Fprintf(w, "%s %s%s\n", k, v.Type, formatPinInfo(v.Name, v.Expires))
 				}
 			}
 
@@ -421,23 +557,52 @@ type PinLsList struct {
 	Keys map[string]PinLsType
 }
 
-// PinLsType contains the type of a pin
+// PinLsType contains the type of a pin, and its name, metadata and expiry
 type PinLsType struct {
-	Type string
+	Type    string
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid     string            `json:",omitempty"`
+	Type    string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+func formatPinInfo(name string, expires *time.Time) string {
+	var s string
+	if name != "" {
+		s += " " + cmdenv.EscNonPrint(name)
+	}
+	if expires != nil {
+		s += " (expires " + expires.UTC().Format(time.RFC3339) + ")"
+	}
+	return s
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinExpiry(info pinmeta.Info) *time.Time {
+	if info.Expires.IsZero() {
+		return nil
+	}
+	return &info.Expires
+}
+
+func pinLsKeys(req *cmds.Request, typeStr string, q pinmeta.Query, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
 	}
 
+	papi, err := getPinInfoAPI(api)
+	if err != nil && !q.IsEmpty() {
+		return err
+	}
+
 	switch typeStr {
 	case "all", "direct", "indirect", "recursive":
 	default:
@@ -464,16 +629,29 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 			return fmt.Errorf("path '%s' is not pinned", p)
 		}
 
+		var info pinmeta.Info
 		switch pinType {
-		case "direct", "indirect", "recursive", "internal":
+		case "direct", "recursive":
+			if papi != nil {
+				if info, err = papi.Info(req.Context, rp); err != nil {
+					return err
+				}
+			}
+		case "indirect", "internal":
 		default:
 			pinType = "indirect through " + pinType
 		}
+		if !q.Matches(info) {
+			continue
+		}
 
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(rp.Cid()),
+				Type:    pinType,
+				Cid:     enc.Encode(rp.Cid()),
+				Name:    info.Name,
+				Meta:    info.Meta,
+				Expires: pinExpiry(info),
 			},
 		})
 		if err != nil {
@@ -484,7 +662,7 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 	return nil
 }
 
-func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsAll(req *cmds.Request, typeStr string, q pinmeta.Query, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -502,7 +680,14 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		panic("unhandled pin type")
 	}
 
-	pins, err := api.Pin().Ls(req.Context, opt)
+	var pins <-chan coreiface.Pin
+	if papi, perr := getPinInfoAPI(api); perr == nil {
+		pins, err = papi.Query(req.Context, q, opt)
+	} else if q.IsEmpty() {
+		pins, err = api.Pin().Ls(req.Context, opt)
+	} else {
+		err = perr
+	}
 	if err != nil {
 		return err
 	}
@@ -511,10 +696,17 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		if err := p.Err(); err != nil {
 			return err
 		}
+		var info pinmeta.Info
+		if ip, ok := p.(coreapi.PinWithInfo); ok {
+			info = ip.Info()
+		}
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: p.Type(),
-				Cid:  enc.Encode(p.Path().Cid()),
+				Type:    p.Type(),
+				Cid:     enc.Encode(p.Path().Cid()),
+				Name:    info.Name,
+				Meta:    info.Meta,
+				Expires: pinExpiry(info),
 			},
 		})
 		if err != nil {
@@ -592,15 +784,30 @@ pin.
 
 const (
 	pinVerboseOptionName = "verbose"
+	pinRepairOptionName  = "repair"
 )
 
 var verifyPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Verify that recursive pins are complete.",
+		ShortDescription: `
+'ipfs pin verify' checks that all the blocks of the recursive pins are
+stored locally, and reports the broken pins with their missing blocks.
+
+With --repair, the hashes of the blocks are checked too. The corrupt and
+missing blocks are written again from the given CAR files or, when they are
+not found there, fetched from the network. The blocks of the CAR files which
+are not stored locally are imported. Broken pins are reported before and
+after the repair.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("car", false, true, "CAR files with the blocks to repair, with --repair."),
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(pinVerboseOptionName, "Also write the hashes of non-broken pins."),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of broken pins."),
+		cmds.BoolOption(pinRepairOptionName, "Replace the missing and corrupt blocks of the pins."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -624,6 +831,16 @@ var verifyPinCmd = &cmds.Command{
 			explain:   !quiet,
 			includeOk: verbose,
 		}
+		if repair, _ := req.Options[pinRepairOptionName].(bool); repair {
+			opts.repair = func(keys []cid.Cid) ([]corerepo.RepairResult, error) {
+				results, err := cmdutils.RepairBlocks(req, n, keys, true)
+				// the CAR files are read once
+				req.Files = nil
+				return results, err
+			}
+		} else if req.Files != nil {
+			return fmt.Errorf("CAR files are only read with --%s", pinRepairOptionName)
+		}
 		out, err := pinVerify(req.Context, n, opts, enc)
 		if err != nil {
 			return err
@@ -635,7 +852,7 @@ var verifyPinCmd = &cmds.Command{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinVerifyRes) error {
 			quiet, _ := req.Options[pinQuietOptionName].(bool)
 
-			if quiet && !out.Ok {
+			if quiet && !out.final().Ok {
 				fmt.Fprintf(w, "%s\n", out.Cid)
 			} else if !quiet {
 				out.Format(w)
@@ -646,10 +863,127 @@ var verifyPinCmd = &cmds.Command{
 	},
 }
 
+const (
+	pinHumanOptionName = "human"
+)
+
+var statPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the space used by recursive pins.",
+		ShortDescription: `
+'ipfs pin stat' reports, for each given recursive pin, the size of the
+blocks it keeps in the local repo:
+
+Size       Size of all the local blocks of the pin.
+Exclusive  Size of the blocks only kept by this pin, which 'ipfs repo gc'
+           reclaims once it is unpinned.
+Shared     Size of the blocks also kept by other pins or by MFS.
+
+Use 'ipfs repo du' to report all the recursive pins.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, true, "Path to the recursively pinned object(s).").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(pinHumanOptionName, "H", "Print sizes in human readable format (e.g., 1K 234M 2G)"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		if err := req.ParseBodyArgs(); err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		pins := make([]cid.Cid, 0, len(req.Arguments))
+		for _, p := range req.Arguments {
+			rp, err := api.ResolvePath(req.Context, path.New(p))
+			if err != nil {
+				return err
+			}
+			pins = append(pins, rp.Cid())
+		}
+
+		stats, err := corerepo.PinStats(n, req.Context, pins...)
+		if err != nil {
+			return err
+		}
+		for _, stat := range stats {
+			if err := res.Emit(NewPinStatOutput(stat, enc)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: PinStatOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinStatOutput) error {
+			human, _ := req.Options[pinHumanOptionName].(bool)
+			return out.Format(w, human)
+		}),
+	},
+}
+
+// PinStatOutput is the space used by a recursive pin, returned by
+// "pin stat" and "repo du"
+type PinStatOutput struct {
+	Cid       string
+	Blocks    int
+	Size      uint64
+	Exclusive uint64
+	Shared    uint64
+}
+
+// NewPinStatOutput returns the output of the given stat
+func NewPinStatOutput(stat gc.PinStat, enc cidenc.Encoder) *PinStatOutput {
+	return &PinStatOutput{
+		Cid:       enc.Encode(stat.Cid),
+		Blocks:    stat.Blocks,
+		Size:      stat.Size,
+		Exclusive: stat.Exclusive,
+		Shared:    stat.Shared,
+	}
+}
+
+// Format writes a line of text describing the stat
+func (out *PinStatOutput) Format(w io.Writer, human bool) error {
+	size := func(n uint64) string {
+		if human {
+			return humanize.Bytes(n)
+		}
+		return fmt.Sprintf("%d", n)
+	}
+	_, err := fmt.Fprintf(w, "%s %d blocks, size %s, exclusive %s, shared %s\n",
+		out.Cid, out.Blocks, size(out.Size), size(out.Exclusive), size(out.Shared))
+	return err
+}
+
 // PinVerifyRes is the result returned for each pin checked in "pin verify"
 type PinVerifyRes struct {
 	Cid string
 	PinStatus
+	// Repaired is the status after the repair, with --repair
+	Repaired *PinStatus `json:",omitempty"`
+}
+
+// final returns the status of the pin after the command
+func (r PinVerifyRes) final() PinStatus {
+	if r.Repaired != nil {
+		return *r.Repaired
+	}
+	return r.PinStatus
 }
 
 // PinStatus is part of PinVerifyRes, do not use directly
@@ -667,14 +1001,38 @@ type BadNode struct {
 type pinVerifyOpts struct {
 	explain   bool
 	includeOk bool
+	// repair replaces the given blocks, the hashes of the blocks are
+	// verified when it is set
+	repair func(keys []cid.Cid) ([]corerepo.RepairResult, error)
 }
 
 func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc cidenc.Encoder) (<-chan interface{}, error) {
 	visited := make(map[cid.Cid]PinStatus)
+	// blocks which are missing or corrupt
+	broken := cid.NewSet()
+	// errors of the blocks which could not be repaired
+	repairErrs := make(map[cid.Cid]error)
 
 	bs := n.Blocks.Blockstore()
 	DAG := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
 	getLinks := dag.GetLinksWithDAG(DAG)
+	if opts.repair != nil {
+		dagGetLinks := getLinks
+		getLinks = func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+			block, err := bs.Get(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			sum, err := c.Prefix().Sum(block.RawData())
+			if err != nil {
+				return nil, err
+			}
+			if !sum.Equals(c) {
+				return nil, bstore.ErrHashMismatch
+			}
+			return dagGetLinks(ctx, c)
+		}
+	}
 	recPins, err := n.Pinning.RecursiveKeys(ctx)
 	if err != nil {
 		return nil, err
@@ -698,11 +1056,15 @@ func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc ci
 
 		links, err := getLinks(ctx, root)
 		if err != nil {
+			if repairErr, ok := repairErrs[key]; ok {
+				err = repairErr
+			}
 			status := PinStatus{Ok: false}
 			if opts.explain {
 				status.BadNodes = []BadNode{{Cid: enc.Encode(key), Err: err.Error()}}
 			}
 			visited[key] = status
+			broken.Add(key)
 			return status
 		}
 
@@ -722,11 +1084,67 @@ func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc ci
 	out := make(chan interface{})
 	go func() {
 		defer close(out)
-		for _, cid := range recPins {
-			pinStatus := checkPin(cid)
-			if !pinStatus.Ok || opts.includeOk {
+		if opts.repair == nil {
+			for _, cid := range recPins {
+				pinStatus := checkPin(cid)
+				if !pinStatus.Ok || opts.includeOk {
+					select {
+					case out <- &PinVerifyRes{Cid: enc.Encode(cid), PinStatus: pinStatus}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			return
+		}
+
+		before := make([]PinStatus, len(recPins))
+		for i, c := range recPins {
+			before[i] = checkPin(c)
+		}
+		// blocks below repaired blocks can be missing too
+		repaired := false
+		attempted := cid.NewSet()
+		for {
+			var keys []cid.Cid
+			for _, c := range broken.Keys() {
+				if attempted.Visit(c) {
+					keys = append(keys, c)
+				}
+			}
+			if len(keys) == 0 {
+				break
+			}
+			results, err := opts.repair(keys)
+			if err != nil {
+				select {
+				case out <- err:
+				case <-ctx.Done():
+				}
+				return
+			}
+			for _, r := range results {
+				if r.Err != nil {
+					repairErrs[r.Cid] = r.Err
+				}
+			}
+			repaired = true
+			visited = make(map[cid.Cid]PinStatus)
+			broken = cid.NewSet()
+			for _, c := range recPins {
+				checkPin(c)
+			}
+		}
+
+		for i, c := range recPins {
+			res := &PinVerifyRes{Cid: enc.Encode(c), PinStatus: before[i]}
+			if repaired && !before[i].Ok {
+				after := checkPin(c)
+				res.Repaired = &after
+			}
+			if !res.Ok || opts.includeOk {
 				select {
-				case out <- &PinVerifyRes{enc.Encode(cid), pinStatus}:
+				case out <- res:
 				case <-ctx.Done():
 					return
 				}
@@ -747,4 +1165,15 @@ func (r PinVerifyRes) Format(out io.Writer) {
 			fmt.Fprintf(out, "  %s: %s\n", e.Cid, e.Err)
 		}
 	}
+	if r.Repaired == nil {
+		return
+	}
+	if r.Repaired.Ok {
+		fmt.Fprintf(out, "%s repaired\n", r.Cid)
+	} else {
+		fmt.Fprintf(out, "%s still broken\n", r.Cid)
+		for _, e := range r.Repaired.BadNodes {
+			fmt.Fprintf(out, "  %s: %s\n", e.Cid, e.Err)
+		}
+	}
 }
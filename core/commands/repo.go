@@ -7,13 +7,19 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"text/tabwriter"
 
 	oldcmds "github.com/ipfs/kubo/commands"
+	"github.com/ipfs/kubo/config"
+	serialize "github.com/ipfs/kubo/config/serialize"
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/commands/cmdutils"
+	"github.com/ipfs/kubo/core/commands/pin"
 	corerepo "github.com/ipfs/kubo/core/corerepo"
+	"github.com/ipfs/kubo/gc"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations"
 	"github.com/ipfs/kubo/repo/fsrepo/migrations/ipfsfetcher"
@@ -22,6 +28,7 @@ import (
 	cid "github.com/ipfs/go-cid"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	files "github.com/ipfs/go-ipfs-files"
 )
 
 type RepoVersion struct {
@@ -40,8 +47,12 @@ var RepoCmd = &cmds.Command{
 		"stat":    repoStatCmd,
 		"gc":      repoGcCmd,
 		"fsck":    repoFsckCmd,
+		"convert": repoConvertCmd,
+		"export":  repoExportCmd,
+		"import":  repoImportCmd,
 		"version": repoVersionCmd,
 		"verify":  repoVerifyCmd,
+		"du":      repoDuCmd,
 		"migrate": repoMigrateCmd,
 	},
 }
@@ -50,12 +61,17 @@ var RepoCmd = &cmds.Command{
 type GcResult struct {
 	Key   cid.Cid
 	Error string `json:",omitempty"`
+	// Size of the block, and Total size of the blocks, reported with --dry-run
+	Size  uint64 `json:",omitempty"`
+	Total uint64 `json:",omitempty"`
 }
 
 const (
 	repoStreamErrorsOptionName   = "stream-errors"
 	repoQuietOptionName          = "quiet"
 	repoSilentOptionName         = "silent"
+	repoConcurrentOptionName     = "concurrent"
+	repoDryRunOptionName         = "dry-run"
 	repoAllowDowngradeOptionName = "allow-downgrade"
 )
 
@@ -66,12 +82,33 @@ var repoGcCmd = &cmds.Command{
 'ipfs repo gc' is a plumbing command that will sweep the local
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
+`,
+		LongDescription: `
+'ipfs repo gc' is a plumbing command that will sweep the local
+set of stored objects and remove ones that are not pinned in
+order to reclaim hard disk space.
+
+The blocks reachable from the pins and the MFS root are kept in an index in
+the datastore, so only the pins and files changed since the previous
+collection are walked.
+
+By default, adds and pins wait until the garbage collection is done. With
+--concurrent, they only wait for short periods: while the collection starts,
+while it looks for pins added since it started, and while each batch of
+blocks is deleted. Blocks read or written during a concurrent collection are
+kept, with everything they link to.
+
+With --dry-run, nothing is removed: the blocks which would be removed are
+listed with their size, followed by the total size which would be reclaimed.
+Adds and pins wait until the listing is done.
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoStreamErrorsOptionName, "Stream errors."),
 		cmds.BoolOption(repoQuietOptionName, "q", "Write minimal output."),
 		cmds.BoolOption(repoSilentOptionName, "Write no output."),
+		cmds.BoolOption(repoConcurrentOptionName, "Do not block adds and pins while collecting garbage."),
+		cmds.BoolOption(repoDryRunOptionName, "List the blocks which would be removed, without removing them."),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -82,7 +119,55 @@ order to reclaim hard disk space.
 		silent, _ := req.Options[repoSilentOptionName].(bool)
 		streamErrors, _ := req.Options[repoStreamErrorsOptionName].(bool)
 
-		gcOutChan := corerepo.GarbageCollectAsync(n, req.Context)
+		concurrent, _ := req.Options[repoConcurrentOptionName].(bool)
+		dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+
+		var gcOutChan <-chan gc.Result
+		switch {
+		case dryRun && concurrent:
+			return fmt.Errorf("--%s and --%s are mutually exclusive", repoDryRunOptionName, repoConcurrentOptionName)
+		case dryRun:
+			gcOutChan = corerepo.DryRunGarbageCollectAsync(n, req.Context)
+		case concurrent:
+			gcOutChan = corerepo.ConcurrentGarbageCollectAsync(n, req.Context)
+		default:
+			gcOutChan = corerepo.GarbageCollectAsync(n, req.Context)
+		}
+
+		if dryRun {
+			var total uint64
+			var errs []error
+			for res := range gcOutChan {
+				if res.Error != nil {
+					if !streamErrors {
+						errs = append(errs, res.Error)
+						continue
+					}
+					if err := re.Emit(&GcResult{Error: res.Error.Error()}); err != nil {
+						return err
+					}
+					errs = append(errs, res.Error)
+					continue
+				}
+				total += uint64(res.Size)
+				if err := re.Emit(&GcResult{Key: res.KeyRemoved, Size: uint64(res.Size)}); err != nil {
+					return err
+				}
+			}
+			if err := req.Context.Err(); err != nil {
+				return err
+			}
+			switch {
+			case len(errs) == 0:
+			case streamErrors:
+				return errors.New("encountered errors during gc dry run")
+			case len(errs) == 1:
+				return errs[0]
+			default:
+				return corerepo.NewMultiError(errs...)
+			}
+			return re.Emit(&GcResult{Total: total})
+		}
 
 		if streamErrors {
 			errs := false
@@ -133,6 +218,22 @@ order to reclaim hard disk space.
 				return err
 			}
 
+			if dryRun, _ := req.Options[repoDryRunOptionName].(bool); dryRun {
+				switch {
+				case !gcr.Key.Defined() && quiet:
+					return nil
+				case !gcr.Key.Defined():
+					_, err := fmt.Fprintf(w, "%d bytes would be reclaimed\n", gcr.Total)
+					return err
+				case quiet:
+					_, err := fmt.Fprintf(w, "%s\n", gcr.Key)
+					return err
+				default:
+					_, err := fmt.Fprintf(w, "would remove %s (%d bytes)\n", gcr.Key, gcr.Size)
+					return err
+				}
+			}
+
 			prefix := "removed "
 			if quiet {
 				prefix = ""
@@ -226,22 +327,371 @@ Version         string The repo version.
 	},
 }
 
+var repoDuCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the space used by each recursive pin.",
+		ShortDescription: `
+'ipfs repo du' reports the size of the blocks kept in the local repo by each
+recursive pin, largest exclusive size first:
+
+Size       Size of all the local blocks of the pin.
+Exclusive  Size of the blocks only kept by this pin, which 'ipfs repo gc'
+           reclaims once it is unpinned.
+Shared     Size of the blocks also kept by other pins or by MFS.
+
+Use 'ipfs pin stat' to report specific pins.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoHumanOptionName, "H", "Print sizes in human readable format (e.g., 1K 234M 2G)"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		stats, err := corerepo.PinStats(n, req.Context)
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(stats, func(i, j int) bool {
+			return stats[i].Exclusive > stats[j].Exclusive
+		})
+		for _, stat := range stats {
+			if err := res.Emit(pin.NewPinStatOutput(stat, enc)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: pin.PinStatOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *pin.PinStatOutput) error {
+			human, _ := req.Options[repoHumanOptionName].(bool)
+			return out.Format(w, human)
+		}),
+	},
+}
+
+const repoFixOptionName = "fix"
+
 var repoFsckCmd = &cmds.Command{
-	Status: cmds.Deprecated, // https://github.com/ipfs/kubo/issues/6435
 	Helptext: cmds.HelpText{
-		Tagline: "Remove repo lockfiles.",
+		Tagline: "Check the consistency of the repo.",
+		ShortDescription: `
+'ipfs repo fsck' checks the repo offline, it can't run while the daemon uses
+the repo. The checks are:
+
+  datastore-spec  the datastore_spec file matches the configured datastore
+  blocks          the blocks match their hashes
+  pins            the pinned blocks are stored, and the pin names, metadata
+                  and expiry belong to existing pins
+  filestore       the files referenced by the filestore did not change
+  files-root      the root of the files API is a stored directory
+  provider-queue  the queued CIDs to provide are valid
+
+With --fix, the corrupt blocks, the info of removed pins, the filestore
+entries of changed or removed files and the invalid entries of the provider
+queue are deleted. A broken files root is replaced with an empty directory,
+and a missing datastore_spec file is written for the configured datastore.
+
+Missing pinned blocks are not fixed, use 'ipfs pin verify --repair' with the
+daemon. A datastore which does not match the config can't be opened, the
+other checks are not run.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoFixOptionName, "Fix the problems found."),
+	},
+	NoRemote: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cctx := env.(*oldcmds.Context)
+		fix, _ := req.Options[repoFixOptionName].(bool)
+		configFileOpt, _ := req.Options[ConfigFileOption].(string)
+
+		fsck, spec, err := corerepo.OpenFsck(cctx.ConfigRoot, configFileOpt, fix)
+		if err != nil {
+			return err
+		}
+		if err := res.Emit(&spec); err != nil {
+			return err
+		}
+		if fsck == nil {
+			return errors.New("fsck aborted, the datastore can't be opened")
+		}
+		defer fsck.Close()
+
+		problems, unfixed := len(spec.Problems), spec.Unfixed()
+		for _, run := range []func(context.Context) (corerepo.FsckCheck, error){
+			fsck.CheckBlocks,
+			fsck.CheckPins,
+			fsck.CheckFilestore,
+			fsck.CheckFilesRoot,
+			fsck.CheckProviderQueue,
+		} {
+			check, err := run(req.Context)
+			if err != nil {
+				return fmt.Errorf("%s check failed: %w", check.Name, err)
+			}
+			if err := res.Emit(&check); err != nil {
+				return err
+			}
+			problems += len(check.Problems)
+			unfixed += check.Unfixed()
+		}
+
+		switch {
+		case unfixed == 0:
+			return nil
+		case fix:
+			return fmt.Errorf("fsck found %d problems, %d could not be fixed", problems, unfixed)
+		default:
+			return fmt.Errorf("fsck found %d problems", problems)
+		}
+	},
+	Type: corerepo.FsckCheck{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, check *corerepo.FsckCheck) error {
+			switch {
+			case check.Skipped != "":
+				fmt.Fprintf(w, "%s: skipped, %s\n", check.Name, check.Skipped)
+			case len(check.Problems) == 0:
+				fmt.Fprintf(w, "%s: %d checked, ok\n", check.Name, check.Checked)
+			default:
+				fmt.Fprintf(w, "%s: %d checked, %d problems\n", check.Name, check.Checked, len(check.Problems))
+			}
+			for _, p := range check.Problems {
+				fixed := ""
+				if p.Fixed {
+					fixed = " (fixed)"
+				}
+				fmt.Fprintf(w, "  %s: %s%s\n", p.Key, p.Err, fixed)
+			}
+			return nil
+		}),
+	},
+}
+
+const (
+	repoRedactSecretsOptionName = "redact-secrets"
+	repoSkipConfigOptionName    = "skip-config"
+)
+
+var repoExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Export the node to an archive.",
+		ShortDescription: `
+'ipfs repo export' writes a backup of the node to stdout, which is restored
+with 'ipfs repo import', for example on a new machine or in a repo with
+another datastore. It can't run while the daemon uses the repo.
+
+The archive is a tar file with the config, the keystore, the pins with their
+names, metadata and expiry, the root of the files API, the IPNS records and
+the blocks of the pins and of the files as a CARv2 file.
+
+With --redact-secrets, the private key of the identity, the keys of the
+remote pinning services and the keystore are left out of the archive.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoRedactSecretsOptionName, "Leave the private keys and the secrets of the config out of the archive."),
+	},
+	NoRemote: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		redact, _ := req.Options[repoRedactSecretsOptionName].(bool)
+
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			return err
+		}
+		cfgMap, err := config.ToMap(cfg)
+		if err != nil {
+			return err
+		}
+		if redact {
+			cfgMap, err = scrubValue(cfgMap, []string{config.IdentityTag, config.PrivKeyTag})
+			if err != nil {
+				return err
+			}
+			cfgMap, err = scrubOptionalValue(cfgMap, config.PinningConcealSelector)
+			if err != nil {
+				return err
+			}
+		}
+
+		opts := corerepo.ExportOptions{Config: cfgMap, Redacted: redact}
+		pipeR, pipeW := io.Pipe()
+		go func() {
+			pipeW.CloseWithError(corerepo.Export(req.Context, n, pipeW, opts))
+		}()
+		if err := res.Emit(pipeR); err != nil {
+			pipeR.Close()
+			return err
+		}
+		return nil
+	},
+}
+
+var repoImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Restore the node from an archive of 'ipfs repo export'.",
 		ShortDescription: `
-'ipfs repo fsck' is now a no-op.
+'ipfs repo import' adds the content of an archive written by 'ipfs repo
+export' to the node. It can't run while the daemon uses the repo.
+
+The blocks, keys and IPNS records are added, and the pins are pinned again
+with their names, metadata and expiry. The entries of the exported files root
+are added to the files root of the node. Pins and files with missing blocks
+are reported, and are not restored.
+
+The config of the archive replaces the config of the node, unless
+--skip-config is set. The datastore of the node is kept, and its identity is
+kept too when the archive was exported with --redact-secrets.
 `,
 	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("archive", true, false, "The archive to import.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoSkipConfigOptionName, "Keep the config of the node."),
+	},
 	NoRemote: true,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
-		return cmds.EmitOnce(res, &MessageOutput{"`ipfs repo fsck` is deprecated and does nothing.\n"})
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		skipConfig, _ := req.Options[repoSkipConfigOptionName].(bool)
+
+		it := req.Files.Entries()
+		if !it.Next() {
+			if it.Err() != nil {
+				return it.Err()
+			}
+			return errors.New("no archive given")
+		}
+		file := files.FileFromEntry(it)
+		if file == nil {
+			return errors.New("expected a file handle")
+		}
+		defer file.Close()
+
+		out, err := corerepo.Import(req.Context, n, file, corerepo.ImportOptions{Config: !skipConfig})
+		if err != nil {
+			return err
+		}
+		if err := res.Emit(out); err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("import incomplete, %d errors", len(out.Errors))
+		}
+		return nil
 	},
-	Type: MessageOutput{},
+	Type: corerepo.ImportResult{},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MessageOutput) error {
-			fmt.Fprintf(w, out.Message)
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *corerepo.ImportResult) error {
+			fmt.Fprintf(w, "imported %d blocks, %d pins, %d keys and %d IPNS records\n", out.Blocks, out.Pins, out.Keys, out.IpnsRecords)
+			if out.FilesRoot != "" {
+				fmt.Fprintf(w, "restored the files of %s\n", out.FilesRoot)
+			}
+			if out.Config {
+				fmt.Fprintln(w, "restored the config")
+			}
+			for _, e := range out.Errors {
+				fmt.Fprintf(w, "error: %s\n", e)
+			}
+			return nil
+		}),
+	},
+}
+
+const repoProfileOptionName = "profile"
+
+// ConvertProgress is the output of 'ipfs repo convert'
+type ConvertProgress struct {
+	Msg    string `json:",omitempty"`
+	Copied int
+}
+
+var repoConvertCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Convert the repo to another datastore.",
+		ShortDescription: `
+'ipfs repo convert' copies all the keys of the datastore to the datastore of
+a profile, and then replaces the datastore, its datastore_spec file and the
+Datastore.Spec of the config.
+
+  > ipfs repo convert --profile=badgerds
+
+The conversion only runs offline: it locks the repo, so the daemon must be
+stopped first and can't serve the repo during the copy.
+
+The new datastore is written in the datastore_convert directory of the repo,
+which needs enough free space for a copy of the datastore. Every key of the
+old datastore is compared with its value in the new one before the swap. An interrupted conversion
+is resumed by running the command again with the same profile, or canceled by
+removing the datastore_convert directory.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(repoProfileOptionName, "The profile of the new datastore, e.g. 'flatfs' or 'badgerds'."),
+	},
+	NoRemote: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cctx := env.(*oldcmds.Context)
+		configFileOpt, _ := req.Options[ConfigFileOption].(string)
+
+		name, _ := req.Options[repoProfileOptionName].(string)
+		if name == "" {
+			return fmt.Errorf("--%s is required", repoProfileOptionName)
+		}
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return fmt.Errorf("%s is not a profile", name)
+		}
+
+		filename, err := config.Filename(cctx.ConfigRoot, configFileOpt)
+		if err != nil {
+			return err
+		}
+		cfg, err := serialize.Load(filename)
+		if err != nil {
+			return err
+		}
+		if err := profile.Transform(cfg); err != nil {
+			return err
+		}
+
+		copied, err := fsrepo.ConvertDatastore(req.Context, cctx.ConfigRoot, configFileOpt, cfg.Datastore.Spec, func(copied int) {
+			// a failed emit ends the command with the next one
+			_ = res.Emit(&ConvertProgress{Copied: copied})
+		})
+		if err == fsrepo.ErrSameDatastore {
+			return fmt.Errorf("the repo already uses the datastore of the %s profile", name)
+		} else if err != nil {
+			return err
+		}
+		return res.Emit(&ConvertProgress{Msg: "converted the repo to the " + name + " datastore", Copied: copied})
+	},
+	Type: ConvertProgress{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *ConvertProgress) error {
+			if out.Msg != "" {
+				fmt.Fprintf(w, "%d keys copied, %s\n", out.Copied, out.Msg)
+				return nil
+			}
+			fmt.Fprintf(w, "%d keys copied\r", out.Copied)
 			return nil
 		}),
 	},
@@ -252,31 +702,28 @@ type VerifyProgress struct {
 	Progress int
 }
 
-func verifyWorkerRun(ctx context.Context, wg *sync.WaitGroup, keys <-chan cid.Cid, results chan<- string, bs bstore.Blockstore) {
+// verifyResult is the result of the verification of a block, Err is set
+// when it is corrupt
+type verifyResult struct {
+	Key cid.Cid
+	Err error
+}
+
+func verifyWorkerRun(ctx context.Context, wg *sync.WaitGroup, keys <-chan cid.Cid, results chan<- verifyResult, bs bstore.Blockstore) {
 	defer wg.Done()
 
 	for k := range keys {
 		_, err := bs.Get(ctx, k)
-		if err != nil {
-			select {
-			case results <- fmt.Sprintf("block %s was corrupt (%s)", k, err):
-			case <-ctx.Done():
-				return
-			}
-
-			continue
-		}
-
 		select {
-		case results <- "":
+		case results <- verifyResult{Key: k, Err: err}:
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func verifyResultChan(ctx context.Context, keys <-chan cid.Cid, bs bstore.Blockstore) <-chan string {
-	results := make(chan string)
+func verifyResultChan(ctx context.Context, keys <-chan cid.Cid, bs bstore.Blockstore) <-chan verifyResult {
+	results := make(chan verifyResult)
 
 	go func() {
 		defer close(results)
@@ -294,9 +741,27 @@ func verifyResultChan(ctx context.Context, keys <-chan cid.Cid, bs bstore.Blocks
 	return results
 }
 
+const (
+	repoRepairOptionName = "repair"
+)
+
 var repoVerifyCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Verify all blocks in repo are not corrupted.",
+		ShortDescription: `
+'ipfs repo verify' checks the hash of every block stored in the repo, and
+reports the blocks which are corrupt.
+
+With --repair, the corrupt blocks are replaced by the blocks of the given CAR
+files or, when they are not found there, by the blocks fetched from the
+network. A corrupt block is only deleted once its replacement is verified.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("car", false, true, "CAR files with the blocks to repair, with --repair."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(repoRepairOptionName, "Replace the corrupt blocks."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
@@ -304,6 +769,11 @@ var repoVerifyCmd = &cmds.Command{
 			return err
 		}
 
+		repair, _ := req.Options[repoRepairOptionName].(bool)
+		if !repair && req.Files != nil {
+			return fmt.Errorf("CAR files are only read with --%s", repoRepairOptionName)
+		}
+
 		bs := bstore.NewBlockstore(nd.Repo.Datastore())
 		bs.HashOnRead(true)
 
@@ -315,14 +785,15 @@ var repoVerifyCmd = &cmds.Command{
 
 		results := verifyResultChan(req.Context, keys, bs)
 
-		var fails int
+		var corrupt []cid.Cid
 		var i int
-		for msg := range results {
-			if msg != "" {
+		for r := range results {
+			if r.Err != nil {
+				msg := fmt.Sprintf("block %s was corrupt (%s)", r.Key, r.Err)
 				if err := res.Emit(&VerifyProgress{Msg: msg}); err != nil {
 					return err
 				}
-				fails++
+				corrupt = append(corrupt, r.Key)
 			}
 			i++
 			if err := res.Emit(&VerifyProgress{Progress: i}); err != nil {
@@ -334,9 +805,30 @@ var repoVerifyCmd = &cmds.Command{
 			return err
 		}
 
-		if fails != 0 {
+		if len(corrupt) != 0 && !repair {
 			return errors.New("verify complete, some blocks were corrupt")
 		}
+		if len(corrupt) != 0 {
+			results, err := cmdutils.RepairBlocks(req, nd, corrupt, false)
+			if err != nil {
+				return err
+			}
+			var fails int
+			for _, r := range results {
+				msg := fmt.Sprintf("block %s was repaired from the %s", r.Cid, r.Source)
+				if r.Err != nil {
+					msg = fmt.Sprintf("block %s could not be repaired (%s)", r.Cid, r.Err)
+					fails++
+				}
+				if err := res.Emit(&VerifyProgress{Msg: msg}); err != nil {
+					return err
+				}
+			}
+			if fails != 0 {
+				return fmt.Errorf("verify complete, %d of %d corrupt blocks could not be repaired", fails, len(corrupt))
+			}
+			return res.Emit(&VerifyProgress{Msg: fmt.Sprintf("verify complete, %d corrupt blocks were repaired.", len(corrupt))})
+		}
 
 		return res.Emit(&VerifyProgress{Msg: "verify complete, all blocks validated."})
 	},
@@ -2,6 +2,8 @@ package commands
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	dag "github.com/ipfs/kubo/core/commands/dag"
@@ -136,6 +138,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"bootstrap": BootstrapCmd,
 	"config":    ConfigCmd,
 	"dag":       dag.DagCmd,
+	"denylist":  DenylistCmd,
 	"dht":       DhtCmd,
 	"routing":   RoutingCmd,
 	"diag":      DiagCmd,
@@ -232,6 +235,54 @@ func init() {
 	RootRO.Subcommands = rootROSubcommands
 }
 
+// RootWithAllowlist returns a version of RootRO which only includes the
+// commands at the given paths (e.g. "dag/get" or "name/resolve"). Listing a
+// command includes its read-only subcommands. Paths are resolved against
+// RootRO and not Root on purpose: the gateway port has no authorization, so
+// commands of Root which are not in RootRO (even ones that only read, like
+// "pin/ls") are rejected. Parents of listed commands are only used for
+// grouping, they can't be called on their own.
+func RootWithAllowlist(paths []string) (*cmds.Command, error) {
+	root := &cmds.Command{}
+	*root = *RootRO
+	root.Subcommands = map[string]*cmds.Command{}
+
+	for _, p := range paths {
+		segs := strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == ' ' })
+		if len(segs) == 0 {
+			return nil, fmt.Errorf("invalid command path %q", p)
+		}
+
+		src, dst := RootRO, root
+		for idx, seg := range segs {
+			sub, ok := src.Subcommands[seg]
+			if !ok {
+				if c, _ := Root.Get(segs[:idx+1]); c != nil {
+					return nil, fmt.Errorf("command %q is not read-only, only the commands of the read-only RPC API can be exposed on the gateway", strings.Join(segs[:idx+1], "/"))
+				}
+				return nil, fmt.Errorf("unknown command %q", strings.Join(segs[:idx+1], "/"))
+			}
+			if idx == len(segs)-1 {
+				dst.Subcommands[seg] = sub
+				break
+			}
+			next, ok := dst.Subcommands[seg]
+			if !ok {
+				next = &cmds.Command{
+					Helptext:    sub.Helptext,
+					Subcommands: map[string]*cmds.Command{},
+				}
+				dst.Subcommands[seg] = next
+			} else if next == sub {
+				// the parent is already allowed with all of its subcommands
+				break
+			}
+			src, dst = sub, next
+		}
+	}
+	return root, nil
+}
+
 type MessageOutput struct {
 	Message string
 }
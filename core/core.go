@@ -48,9 +48,12 @@ import (
 	"github.com/ipfs/kubo/core/bootstrap"
 	"github.com/ipfs/kubo/core/node"
 	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/denylist"
 	"github.com/ipfs/kubo/fuse/mount"
+	"github.com/ipfs/kubo/gc"
 	"github.com/ipfs/kubo/p2p"
 	"github.com/ipfs/kubo/peering"
+	"github.com/ipfs/kubo/pinmeta"
 	"github.com/ipfs/kubo/repo"
 	irouting "github.com/ipfs/kubo/routing"
 )
@@ -67,6 +70,7 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	PinInfo         *pinmeta.Store         `optional:"true"` // names and metadata of the pins
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -85,6 +89,8 @@ type IpfsNode struct {
 	Discovery            mdns.Service              `optional:"true"`
 	FilesRoot            *mfs.Root
 	RecordValidator      record.Validator
+	Denylist             *denylist.Denylist `optional:"true"` // content which must not be served
+	GCAccess             *gc.AccessTracker  `optional:"true"` // use of blocks, for GC retention policies
 
 	// Online
 	PeerHost        p2phost.Host            `optional:"true"` // the network host (server+client)
@@ -42,6 +42,8 @@ import (
 	"github.com/ipfs/go-namesys"
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/node"
+	"github.com/ipfs/kubo/gc"
+	"github.com/ipfs/kubo/pinmeta"
 	"github.com/ipfs/kubo/repo"
 )
 
@@ -55,6 +57,8 @@ type CoreAPI struct {
 	blockstore blockstore.GCBlockstore
 	baseBlocks blockstore.Blockstore
 	pinning    pin.Pinner
+	gcAccess   *gc.AccessTracker
+	pinInfo    *pinmeta.Store
 
 	blocks               bserv.BlockService
 	dag                  ipld.DAGService
@@ -134,6 +138,11 @@ func (api *CoreAPI) Dht() coreiface.DhtAPI {
 	return (*DhtAPI)(api)
 }
 
+// Routing returns the RoutingAPI implementation backed by the go-ipfs node
+func (api *CoreAPI) Routing() *RoutingAPI {
+	return (*RoutingAPI)(api)
+}
+
 // Swarm returns the SwarmAPI interface implementation backed by the go-ipfs node
 func (api *CoreAPI) Swarm() coreiface.SwarmAPI {
 	return (*SwarmAPI)(api)
@@ -168,6 +177,8 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		blockstore: n.Blockstore,
 		baseBlocks: n.BaseBlocks,
 		pinning:    n.Pinning,
+		gcAccess:   n.GCAccess,
+		pinInfo:    n.PinInfo,
 
 		blocks:               n.Blocks,
 		dag:                  n.DAG,
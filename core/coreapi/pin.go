@@ -2,6 +2,7 @@ package coreapi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	bserv "github.com/ipfs/go-blockservice"
@@ -12,6 +13,8 @@ import (
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/gc"
+	"github.com/ipfs/kubo/pinmeta"
 	"github.com/ipfs/kubo/tracing"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -19,10 +22,34 @@ import (
 
 type PinAPI CoreAPI
 
+// PinWithInfo is a pin listed by PinAPI.Ls and PinAPI.Query. Direct and
+// recursive pins have the name and metadata they were added with.
+type PinWithInfo interface {
+	coreiface.Pin
+	Info() pinmeta.Info
+}
+
 func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOption) error {
-	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Add", trace.WithAttributes(attribute.String("path", p.String())))
+	return api.AddWithInfo(ctx, p, pinmeta.Info{}, opts...)
+}
+
+// AddWithInfo pins the path like Add, and stores the name, metadata and
+// expiry of the pin. The stored name and metadata are kept when the given
+// ones are empty, the stored expiry is always replaced (see Info.Repin).
+func (api *PinAPI) AddWithInfo(ctx context.Context, p path.Path, info pinmeta.Info, opts ...caopts.PinAddOption) error {
+	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Add", trace.WithAttributes(
+		attribute.String("path", p.String()),
+		attribute.String("name", info.Name),
+	))
 	defer span.End()
 
+	if err := info.Validate(); err != nil {
+		return err
+	}
+	if !info.IsEmpty() && api.pinInfo == nil {
+		return errors.New("pin: names and metadata are not supported by the node")
+	}
+
 	dagNode, err := api.core().ResolveNode(ctx, p)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
@@ -42,6 +69,19 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 		return fmt.Errorf("pin: %s", err)
 	}
 
+	if api.pinInfo != nil {
+		stored, err := api.pinInfo.Get(ctx, dagNode.Cid())
+		if err != nil {
+			return fmt.Errorf("pin: %s", err)
+		}
+		// a pin added again without an expiry doesn't expire anymore
+		if !info.IsEmpty() || !stored.Expires.IsZero() {
+			if err := api.pinInfo.Put(ctx, dagNode.Cid(), info.Repin(stored)); err != nil {
+				return fmt.Errorf("pin: %s", err)
+			}
+		}
+	}
+
 	if err := api.provider.Provide(dagNode.Cid()); err != nil {
 		return err
 	}
@@ -50,7 +90,13 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 }
 
 func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error) {
-	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Ls")
+	return api.Query(ctx, pinmeta.Query{}, opts...)
+}
+
+// Query lists the pins like Ls. Unless the query is empty, only the direct
+// and recursive pins whose name and metadata match it are listed.
+func (api *PinAPI) Query(ctx context.Context, q pinmeta.Query, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error) {
+	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Ls", trace.WithAttributes(attribute.String("name", q.Name)))
 	defer span.End()
 
 	settings, err := caopts.PinLsOptions(opts...)
@@ -66,7 +112,22 @@ func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) (<-chan c
 		return nil, fmt.Errorf("invalid type '%s', must be one of {direct, indirect, recursive, all}", settings.Type)
 	}
 
-	return api.pinLsAll(ctx, settings.Type), nil
+	return api.pinLsAll(ctx, settings.Type, q), nil
+}
+
+// Info returns the name and metadata of the pin of the path
+func (api *PinAPI) Info(ctx context.Context, p path.Path) (pinmeta.Info, error) {
+	ctx, span := tracing.Span(ctx, "CoreAPI.PinAPI", "Info", trace.WithAttributes(attribute.String("path", p.String())))
+	defer span.End()
+
+	rp, err := api.core().ResolvePath(ctx, p)
+	if err != nil {
+		return pinmeta.Info{}, err
+	}
+	if api.pinInfo == nil {
+		return pinmeta.Info{}, nil
+	}
+	return api.pinInfo.Get(ctx, rp.Cid())
 }
 
 func (api *PinAPI) IsPinned(ctx context.Context, p path.Path, opts ...caopts.PinIsPinnedOption) (string, bool, error) {
@@ -240,6 +301,7 @@ func (api *PinAPI) Verify(ctx context.Context) (<-chan coreiface.PinStatus, erro
 type pinInfo struct {
 	pinType string
 	path    path.Resolved
+	meta    pinmeta.Info
 	err     error
 }
 
@@ -255,11 +317,15 @@ func (p *pinInfo) Err() error {
 	return p.err
 }
 
+func (p *pinInfo) Info() pinmeta.Info {
+	return p.meta
+}
+
 // pinLsAll is an internal function for returning a list of pins
 //
 // The caller must keep reading results until the channel is closed to prevent
 // leaking the goroutine that is fetching pins.
-func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreiface.Pin {
+func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string, q pinmeta.Query) <-chan coreiface.Pin {
 	out := make(chan coreiface.Pin, 1)
 
 	keys := cid.NewSet()
@@ -267,10 +333,21 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 	AddToResultKeys := func(keyList []cid.Cid, typeStr string) error {
 		for _, c := range keyList {
 			if keys.Visit(c) {
+				var meta pinmeta.Info
+				if typeStr != "indirect" && api.pinInfo != nil {
+					var err error
+					if meta, err = api.pinInfo.Get(ctx, c); err != nil {
+						return err
+					}
+				}
+				if !q.Matches(meta) {
+					continue
+				}
 				select {
 				case out <- &pinInfo{
 					pinType: typeStr,
 					path:    path.IpldPath(c),
+					meta:    meta,
 				}:
 				case <-ctx.Done():
 					return ctx.Err()
@@ -313,25 +390,19 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 				return
 			}
 		}
-		if typeStr == "all" {
-			set := cid.NewSet()
-			for _, k := range rkeys {
-				err = merkledag.Walk(
-					ctx, merkledag.GetLinksWithDAG(api.dag), k,
-					set.Visit,
-					merkledag.SkipRoot(), merkledag.Concurrent(),
-				)
-				if err != nil {
-					out <- &pinInfo{err: err}
-					return
-				}
+		// indirect pins have no name nor metadata
+		if typeStr == "all" && q.IsEmpty() {
+			set, err := api.indirectKeys(ctx, rkeys)
+			if err != nil {
+				out <- &pinInfo{err: err}
+				return
 			}
 			if err = AddToResultKeys(set.Keys(), "indirect"); err != nil {
 				out <- &pinInfo{err: err}
 				return
 			}
 		}
-		if typeStr == "indirect" {
+		if typeStr == "indirect" && q.IsEmpty() {
 			// We need to first visit the direct pins that have priority
 			// without emitting them
 
@@ -349,17 +420,10 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 			}
 			VisitKeys(rkeys)
 
-			set := cid.NewSet()
-			for _, k := range rkeys {
-				err = merkledag.Walk(
-					ctx, merkledag.GetLinksWithDAG(api.dag), k,
-					set.Visit,
-					merkledag.SkipRoot(), merkledag.Concurrent(),
-				)
-				if err != nil {
-					out <- &pinInfo{err: err}
-					return
-				}
+			set, err := api.indirectKeys(ctx, rkeys)
+			if err != nil {
+				out <- &pinInfo{err: err}
+				return
 			}
 			if err = AddToResultKeys(set.Keys(), "indirect"); err != nil {
 				out <- &pinInfo{err: err}
@@ -371,6 +435,52 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 	return out
 }
 
+// indirectKeys returns the blocks reachable from the recursive pins. The
+// result includes the pins themselves when they are found from the index.
+func (api *PinAPI) indirectKeys(ctx context.Context, rkeys []cid.Cid) (*cid.Set, error) {
+	set := cid.NewSet()
+	idx := gc.PinnerIndex(api.pinning)
+	if idx == nil {
+		for _, k := range rkeys {
+			err := merkledag.Walk(
+				ctx, merkledag.GetLinksWithDAG(api.dag), k,
+				set.Visit,
+				merkledag.SkipRoot(), merkledag.Concurrent(),
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return set, nil
+	}
+
+	indexed, missing, err := idx.Pinned(ctx, rkeys)
+	if err != nil {
+		return nil, err
+	}
+	missingSet := cid.NewSet()
+	for _, c := range missing {
+		missingSet.Add(c)
+	}
+	for _, c := range indexed {
+		if !missingSet.Has(c) {
+			set.Add(c)
+		}
+	}
+	// blocks which were not stored locally when they were indexed are walked
+	for _, k := range missing {
+		err := merkledag.Walk(
+			ctx, merkledag.GetLinksWithDAG(api.dag), k,
+			set.Visit,
+			merkledag.Concurrent(),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
 func (api *PinAPI) core() coreiface.CoreAPI {
 	return (*CoreAPI)(api)
 }
@@ -0,0 +1,42 @@
+package coreapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	ipns "github.com/ipfs/go-ipns"
+	"github.com/ipfs/kubo/tracing"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RoutingAPI reads values, such as IPNS records, from the routing system
+type RoutingAPI CoreAPI
+
+// Get returns the value stored under the key, which is validated by the
+// routing system before it is returned. Keys of IPNS records can use any
+// text form of the peer ID, e.g. /ipns/k51.. or /ipns/12D3Koo..
+func (api *RoutingAPI) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, span := tracing.Span(ctx, "CoreAPI.RoutingAPI", "Get", trace.WithAttributes(attribute.String("key", key)))
+	defer span.End()
+
+	dhtKey, err := normalizeRoutingKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return api.routing.GetValue(ctx, dhtKey)
+}
+
+func normalizeRoutingKey(key string) (string, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] != "ipns" {
+		return "", errors.New("only /ipns/{peer-id} keys are supported")
+	}
+	pid, err := peer.Decode(parts[2])
+	if err != nil {
+		return "", err
+	}
+	return ipns.RecordKey(pid), nil
+}
@@ -11,6 +11,7 @@ import (
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/ipfs/kubo/core/coreunix"
+	"github.com/ipfs/kubo/gc"
 
 	blockservice "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
@@ -58,6 +59,20 @@ func getOrCreateNilNode() (*core.IpfsNode, error) {
 // Add builds a merkledag node from a reader, adds it to the blockstore,
 // and returns the key representing that node.
 func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options.UnixfsAddOption) (path.Resolved, error) {
+	return api.AddWith(ctx, files, nil, opts...)
+}
+
+// AdderAPI is implemented by the UnixfsAPI of the node, it adds with the
+// settings which the add options of the CoreAPI do not have.
+type AdderAPI interface {
+	AddWith(ctx context.Context, files files.Node, addOpts []coreunix.AddOption, opts ...options.UnixfsAddOption) (path.Resolved, error)
+}
+
+var _ AdderAPI = (*UnixfsAPI)(nil)
+
+// AddWith adds the node like Add, with the settings of the adder set by
+// addOpts.
+func (api *UnixfsAPI) AddWith(ctx context.Context, files files.Node, addOpts []coreunix.AddOption, opts ...options.UnixfsAddOption) (path.Resolved, error) {
 	ctx, span := tracing.Span(ctx, "CoreAPI.UnixfsAPI", "Add")
 	defer span.End()
 
@@ -65,6 +80,10 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	if err != nil {
 		return nil, err
 	}
+	adderSettings, err := coreunix.AddOptionsFrom(addOpts...)
+	if err != nil {
+		return nil, err
+	}
 
 	span.SetAttributes(
 		attribute.String("chunker", settings.Chunker),
@@ -102,6 +121,13 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	addblockstore := api.blockstore
 	if !(settings.FsCache || settings.NoCopy) {
 		addblockstore = bstore.NewGCBlockstore(api.baseBlocks, api.blockstore)
+		if api.gcAccess != nil {
+			addblockstore = gc.NewTrackingBlockstore(addblockstore, api.gcAccess)
+		}
+		// blocks added during a concurrent GC must be seen by it
+		if barrier, ok := api.blockstore.(*gc.BarrierBlockstore); ok {
+			addblockstore = barrier.Wrap(addblockstore)
+		}
 	}
 	exch := api.exchange
 	pinning := api.pinning
@@ -157,6 +183,16 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 	fileAdder.NoCopy = settings.NoCopy
 	fileAdder.CidBuilder = prefix
 
+	fileAdder.PreserveMode = adderSettings.PreserveMode
+	fileAdder.PreserveMtime = adderSettings.PreserveMtime
+	fileAdder.Concurrency = adderSettings.Concurrency
+	if adderSettings.Resume {
+		if settings.OnlyHash {
+			return nil, fmt.Errorf("an add which only hashes the files can't be resumed")
+		}
+		fileAdder.Journal = coreunix.NewAddJournal(api.repo.Datastore(), addblockstore)
+	}
+
 	switch settings.Layout {
 	case options.BalancedLayout:
 		// Default
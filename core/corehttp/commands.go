@@ -159,8 +159,24 @@ func CommandsOption(cctx oldcmds.Context) ServeOption {
 
 // CommandsROOption constructs a ServerOption for hooking the read-only commands
 // into the HTTP server. It will allow GET requests.
+//
+// If Gateway.APICommands is set, only the listed commands are exposed instead.
 func CommandsROOption(cctx oldcmds.Context) ServeOption {
-	return commandsOption(cctx, corecommands.RootRO, true)
+	return func(n *core.IpfsNode, l net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		rcfg, err := n.Repo.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		root := corecommands.RootRO
+		if len(rcfg.Gateway.APICommands) > 0 {
+			root, err = corecommands.RootWithAllowlist(rcfg.Gateway.APICommands)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Gateway.APICommands: %w", err)
+			}
+		}
+		return commandsOption(cctx, root, true)(n, l, mux)
+	}
 }
 
 // CheckVersionOption returns a ServeOption that checks whether the client ipfs version matches. Does nothing when the user agent string does not contain `/kubo/` or `/go-ipfs/`
@@ -13,6 +13,7 @@ import (
 	version "github.com/ipfs/kubo"
 	core "github.com/ipfs/kubo/core"
 	coreapi "github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/denylist"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
@@ -22,6 +23,8 @@ type GatewayConfig struct {
 	Writable              bool
 	PathPrefixes          []string
 	FastDirIndexThreshold int
+	RateLimit             GatewayRateLimitConfig
+	Denylist              *denylist.Denylist
 }
 
 // NodeAPI defines the minimal set of API services required by a gateway handler
@@ -83,11 +86,25 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			return nil, err
 		}
 
+		trustedProxies, err := ParseTrustedProxies(cfg.Gateway.RateLimit.TrustedProxies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Gateway.RateLimit.TrustedProxies: %w", err)
+		}
+
+		rl := cfg.Gateway.RateLimit
 		gateway := NewGatewayHandler(GatewayConfig{
 			Headers:               headers,
 			Writable:              writable,
 			PathPrefixes:          cfg.Gateway.PathPrefixes,
 			FastDirIndexThreshold: int(cfg.Gateway.FastDirIndexThreshold.WithDefault(100)),
+			Denylist:              n.Denylist,
+			RateLimit: GatewayRateLimitConfig{
+				CachedRequestsPerMinute:  int(rl.CachedRequestsPerMinute.WithDefault(0)),
+				CachedBurst:              int(rl.CachedBurst.WithDefault(0)),
+				NetworkRequestsPerMinute: int(rl.NetworkRequestsPerMinute.WithDefault(0)),
+				NetworkBurst:             int(rl.NetworkBurst.WithDefault(0)),
+				TrustedProxies:           trustedProxies,
+			},
 		}, api, offlineApi)
 
 		gateway = otelhttp.NewHandler(gateway, "Gateway.Request")
@@ -26,6 +26,8 @@ import (
 	"github.com/ipfs/go-path/resolver"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/denylist"
 	routing "github.com/libp2p/go-libp2p-core/routing"
 	prometheus "github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel/attribute"
@@ -80,6 +82,15 @@ type gatewayHandler struct {
 	unixfsGenDirGetMetric *prometheus.HistogramVec
 	carStreamGetMetric    *prometheus.HistogramVec
 	rawBlockGetMetric     *prometheus.HistogramVec
+	tarStreamGetMetric    *prometheus.HistogramVec
+	codecGetMetric        *prometheus.HistogramVec
+	ipnsRecordGetMetric   *prometheus.HistogramVec
+
+	// rate limiting
+	cachedLimiter           *rateLimiter
+	networkLimiter          *rateLimiter
+	rateLimitRequestsMetric *prometheus.CounterVec
+	rateLimitedMetric       *prometheus.CounterVec
 }
 
 // StatusResponseWriter enables us to override HTTP Status Code passed to
@@ -213,6 +224,26 @@ func newGatewayHistogramMetric(name string, help string) *prometheus.HistogramVe
 	return histogramMetric
 }
 
+func newGatewayCounterMetric(name string, help string, label string) *prometheus.CounterVec {
+	counterMetric := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ipfs",
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+		},
+		[]string{label},
+	)
+	if err := prometheus.Register(counterMetric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			counterMetric = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			log.Errorf("failed to register ipfs_http_%s: %v", name, err)
+		}
+	}
+	return counterMetric
+}
+
 // NewGatewayHandler returns an http.Handler that can act as a gateway to IPFS content
 // offlineApi is a version of the API that should not make network requests for missing data
 func NewGatewayHandler(c GatewayConfig, api NodeAPI, offlineApi NodeAPI) http.Handler {
@@ -221,9 +252,11 @@ func NewGatewayHandler(c GatewayConfig, api NodeAPI, offlineApi NodeAPI) http.Ha
 
 func newGatewayHandler(c GatewayConfig, api NodeAPI, offlineApi NodeAPI) *gatewayHandler {
 	i := &gatewayHandler{
-		config:     c,
-		api:        api,
-		offlineApi: offlineApi,
+		config:         c,
+		api:            api,
+		offlineApi:     offlineApi,
+		cachedLimiter:  newRateLimiter(c.RateLimit.CachedRequestsPerMinute, c.RateLimit.CachedBurst),
+		networkLimiter: newRateLimiter(c.RateLimit.NetworkRequestsPerMinute, c.RateLimit.NetworkBurst),
 		// Improved Metrics
 		// ----------------------------
 		// Time till the first content block (bar in /ipfs/cid/foo/bar)
@@ -255,6 +288,33 @@ func newGatewayHandler(c GatewayConfig, api NodeAPI, offlineApi NodeAPI) *gatewa
 			"gw_raw_block_get_duration_seconds",
 			"The time to GET an entire raw Block from the gateway.",
 		),
+		// TAR: time it takes to return requested TAR stream
+		tarStreamGetMetric: newGatewayHistogramMetric(
+			"gw_tar_stream_get_duration_seconds",
+			"The time to GET an entire TAR stream from the gateway.",
+		),
+		// Codec: time it takes to return a dag-json/dag-cbor node or its HTML view
+		codecGetMetric: newGatewayHistogramMetric(
+			"gw_codec_get_duration_seconds",
+			"The time to GET an entire IPLD node encoded with a codec from the gateway.",
+		),
+		// IPNS Record: time it takes to return a signed IPNS record
+		ipnsRecordGetMetric: newGatewayHistogramMetric(
+			"gw_ipns_record_get_duration_seconds",
+			"The time to GET a signed IPNS record from the gateway.",
+		),
+
+		// Rate Limiting: requests checked against, and rejected by per-client budgets
+		rateLimitRequestsMetric: newGatewayCounterMetric(
+			"gw_rate_limit_requests_total",
+			"The number of requests checked against a per-client rate limit budget of the gateway.",
+			"budget",
+		),
+		rateLimitedMetric: newGatewayCounterMetric(
+			"gw_rate_limited_requests_total",
+			"The number of requests rejected with 429 Too Many Requests by the gateway.",
+			"budget",
+		),
 
 		// Legacy Metrics
 		// ----------------------------
@@ -301,6 +361,11 @@ func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	r, ok := i.allowRequest(w, r)
+	if !ok {
+		return
+	}
+
 	if i.config.Writable {
 		switch r.Method {
 		case http.MethodPost:
@@ -378,14 +443,53 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Detect when explicit Accept header or ?format parameter are present
+	responseFormat, formatParams, err := customResponseFormat(r)
+	if err != nil {
+		webError(w, "error while processing the Accept header", err, http.StatusBadRequest)
+		return
+	}
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResponseFormat", responseFormat))
+
+	// Trustless gateways only return responses which can be verified by the client
+	if isTrustlessOnly(r) && !trustlessResponseFormats[responseFormat] {
+		err := fmt.Errorf("only application/vnd.ipld.raw, application/vnd.ipld.car and application/vnd.ipfs.ipns-record responses are served by this gateway")
+		webError(w, "deserialized responses are disabled", err, http.StatusNotAcceptable)
+		return
+	}
+
+	// IPNS record is returned as-is, without resolving the content path
+	if responseFormat == "application/vnd.ipfs.ipns-record" {
+		// the record is always looked up with the routing system
+		if !i.allowNetwork(w, r) {
+			return
+		}
+		logger.Debugw("serving ipns record", "path", contentPath)
+		i.addUserHeaders(w)
+		i.serveIpnsRecord(r.Context(), w, r, contentPath, begin)
+		return
+	}
+
+	// Blocked content is not fetched
+	if requestHandled := i.handleDenylisted(w, contentPath, nil, logger); requestHandled {
+		return
+	}
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(r.Context(), contentPath)
+	resolvedPath, err := i.resolvePath(w, r, contentPath)
 	switch err {
 	case nil:
+	case errRateLimited:
+		return
 	case coreiface.ErrOffline:
 		webError(w, "ipfs resolve -r "+debugStr(contentPath.String()), err, http.StatusServiceUnavailable)
 		return
 	default:
+		// if the website has a _redirects file, see if any of the rules match
+		if i.serveRedirectsIfPresent(w, r, contentPath, begin, logger) {
+			logger.Debugw("served using _redirects")
+			return
+		}
 		// if Accept is text/html, see if ipfs-404.html is present
 		if i.servePretty404IfPresent(w, r, contentPath) {
 			logger.Debugw("serve pretty 404 if present")
@@ -395,17 +499,17 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Detect when explicit Accept header or ?format parameter are present
-	responseFormat, formatParams, err := customResponseFormat(r)
-	if err != nil {
-		webError(w, "error while processing the Accept header", err, http.StatusBadRequest)
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResolvedPath", resolvedPath.String()))
+
+	// Blocked content is not served, even when reached through other paths
+	if requestHandled := i.handleDenylisted(w, contentPath, resolvedPath, logger); requestHandled {
 		return
 	}
-	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResponseFormat", responseFormat))
-	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("ResolvedPath", resolvedPath.String()))
 
 	// Detect when If-None-Match HTTP header allows returning HTTP 304 Not Modified
-	if inm := r.Header.Get("If-None-Match"); inm != "" {
+	// (paths traversing fields within a block have Etag set by serveCodec, and
+	// CARs including the blocks of the path by serveCAR)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && resolvedPath.Remainder() == "" && !carIncludesPath(r, contentPath) {
 		pathCid := resolvedPath.Cid()
 		// need to check against both File and Dir Etag variants
 		// because this inexpensive check happens before we do any I/O
@@ -430,7 +534,12 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 
 	// Support custom response formats passed via ?format or Accept HTTP header
 	switch responseFormat {
-	case "": // The implicit response format is UnixFS
+	case "": // The implicit response format is UnixFS, unless the CID uses a different codec
+		if !isUnixFSCodec(resolvedPath.Cid()) {
+			logger.Debugw("serving codec", "path", contentPath)
+			i.serveCodec(r.Context(), w, r, resolvedPath, contentPath, responseFormat, begin)
+			return
+		}
 		logger.Debugw("serving unixfs", "path", contentPath)
 		i.serveUnixFS(r.Context(), w, r, resolvedPath, contentPath, begin, logger)
 		return
@@ -440,8 +549,15 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	case "application/vnd.ipld.car":
 		logger.Debugw("serving car stream", "path", contentPath)
-		carVersion := formatParams["version"]
-		i.serveCAR(r.Context(), w, r, resolvedPath, contentPath, carVersion, begin)
+		i.serveCAR(r.Context(), w, r, resolvedPath, contentPath, formatParams, begin)
+		return
+	case "application/x-tar":
+		logger.Debugw("serving tar archive", "path", contentPath)
+		i.serveTAR(r.Context(), w, r, resolvedPath, contentPath, begin)
+		return
+	case "application/vnd.ipld.dag-json", "application/vnd.ipld.dag-cbor":
+		logger.Debugw("serving codec", "path", contentPath)
+		i.serveCodec(r.Context(), w, r, resolvedPath, contentPath, responseFormat, begin)
 		return
 	default: // catch-all for unsuported application/vnd.*
 		err := fmt.Errorf("unsupported format %q", responseFormat)
@@ -882,17 +998,51 @@ func etagWeakMatch(a, b string) bool {
 func getEtag(r *http.Request, cid cid.Cid) string {
 	prefix := `"`
 	suffix := `"`
-	responseFormat, _, err := customResponseFormat(r)
+	responseFormat, formatParams, err := customResponseFormat(r)
 	if err == nil && responseFormat != "" {
-		// application/vnd.ipld.foo → foo
-		f := responseFormat[strings.LastIndex(responseFormat, ".")+1:]
+		// application/vnd.ipld.foo → foo, application/x-tar → x-tar
+		f := responseFormat[strings.LastIndexAny(responseFormat, "./")+1:]
+		// Etag: "cid.car.entity.0-1023.dfs" (CAR responses scoped to a subset of the DAG or in explicit order)
+		if responseFormat == "application/vnd.ipld.car" {
+			if params, err := getCarParams(r, formatParams); err == nil {
+				f += params.etagSuffix()
+			}
+		}
 		// Etag: "cid.foo" (gives us nice compression together with Content-Disposition in block (raw) and car responses)
 		suffix = `.` + f + suffix
 	}
-	// TODO: include selector suffix when https://github.com/ipfs/kubo/issues/8769 lands
 	return prefix + cid.String() + suffix
 }
 
+// carIncludesPath tells if the response is a CAR including the blocks of the
+// content path, which does not only depend on the resolved CID
+func carIncludesPath(r *http.Request, contentPath ipath.Path) bool {
+	responseFormat, formatParams, err := customResponseFormat(r)
+	if err != nil || responseFormat != "application/vnd.ipld.car" {
+		return false
+	}
+	params, err := getCarParams(r, formatParams)
+	if err != nil || params.scope == "" {
+		return false
+	}
+	return contentPath.Mutable() || strings.Count(strings.Trim(contentPath.String(), "/"), "/") > 1
+}
+
+// trustlessResponseFormats are response formats which can be verified by
+// the client, without trusting the gateway
+var trustlessResponseFormats = map[string]bool{
+	"application/vnd.ipld.raw":         true,
+	"application/vnd.ipld.car":         true,
+	"application/vnd.ipfs.ipns-record": true,
+}
+
+// isTrustlessOnly returns true if the request was made to a known gateway
+// with Gateway.PublicGateways.TrustlessOnly enabled
+func isTrustlessOnly(r *http.Request) bool {
+	gw, ok := r.Context().Value("gw-spec").(*config.GatewaySpec)
+	return ok && gw.TrustlessOnly
+}
+
 // return explicit response format if specified in request as query parameter or via Accept HTTP header
 func customResponseFormat(r *http.Request) (mediaType string, params map[string]string, err error) {
 	if formatParam := r.URL.Query().Get("format"); formatParam != "" {
@@ -902,14 +1052,24 @@ func customResponseFormat(r *http.Request) (mediaType string, params map[string]
 			return "application/vnd.ipld.raw", nil, nil
 		case "car":
 			return "application/vnd.ipld.car", nil, nil
+		case "tar":
+			return "application/x-tar", nil, nil
+		case "dag-json":
+			return "application/vnd.ipld.dag-json", nil, nil
+		case "dag-cbor":
+			return "application/vnd.ipld.dag-cbor", nil, nil
+		case "ipns-record":
+			return "application/vnd.ipfs.ipns-record", nil, nil
 		}
 	}
 	// Browsers and other user agents will send Accept header with generic types like:
 	// Accept:text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8
-	// We only care about explciit, vendor-specific content-types.
+	// We only care about explciit, vendor-specific content-types (and application/x-tar).
 	for _, accept := range r.Header.Values("Accept") {
 		// respond to the very first ipld content type
-		if strings.HasPrefix(accept, "application/vnd.ipld") {
+		if strings.HasPrefix(accept, "application/vnd.ipld") ||
+			strings.HasPrefix(accept, "application/vnd.ipfs.ipns-record") ||
+			strings.HasPrefix(accept, "application/x-tar") {
 			mediatype, params, err := mime.ParseMediaType(accept)
 			if err != nil {
 				return "", nil, err
@@ -993,6 +1153,27 @@ func (i *gatewayHandler) handleOnlyIfCached(w http.ResponseWriter, r *http.Reque
 	return false
 }
 
+// handleDenylisted returns 410 Gone for content blocked by the denylists of
+// the node. Without resolvedPath, the content path and its parent paths are
+// checked, otherwise the resolved CID is checked.
+func (i *gatewayHandler) handleDenylisted(w http.ResponseWriter, contentPath ipath.Path, resolvedPath ipath.Resolved, logger *zap.SugaredLogger) (requestHandled bool) {
+	if i.config.Denylist == nil {
+		return false
+	}
+	var match *denylist.Match
+	if resolvedPath != nil {
+		match = i.config.Denylist.CheckCid(resolvedPath.Cid())
+	} else {
+		match, _ = i.config.Denylist.CheckPath(contentPath.String())
+	}
+	if match == nil {
+		return false
+	}
+	logger.Debugw("blocked by denylist", "path", contentPath, "match", match.Path, "entry", match.Entry, "file", match.File)
+	webError(w, debugStr(contentPath.String()), denylist.ErrDenied, http.StatusGone)
+	return true
+}
+
 func handleUnsupportedHeaders(r *http.Request) (err *requestError) {
 	// X-Ipfs-Gateway-Prefix was removed (https://github.com/ipfs/kubo/issues/7702)
 	// TODO: remove this after  go-ipfs 0.13 ships
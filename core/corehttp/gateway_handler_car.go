@@ -2,8 +2,10 @@ package corehttp
 
 import (
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"strings"
 	"time"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -18,40 +20,48 @@ import (
 )
 
 // serveCAR returns a CAR stream for specific DAG+selector
-func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, carVersion string, begin time.Time) {
+func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, formatParams map[string]string, begin time.Time) {
 	ctx, span := tracing.Span(ctx, "Gateway", "ServeCAR", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
 	defer span.End()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	switch carVersion {
-	case "": // noop, client does not care about version
-	case "1": // noop, we support this
-	default:
-		err := fmt.Errorf("only version=1 is supported")
-		webError(w, "unsupported CAR version", err, http.StatusBadRequest)
+	params, err := getCarParams(r, formatParams)
+	if err != nil {
+		webError(w, "invalid CAR request", err, http.StatusBadRequest)
+		return
+	}
+	pathCids, err := i.carPathCids(ctx, contentPath, resolvedPath, params)
+	if err != nil {
+		webError(w, "failed to resolve CAR path", err, http.StatusInternalServerError)
 		return
 	}
-	rootCid := resolvedPath.Cid()
 
 	// Set Content-Disposition
 	var name string
 	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
 		name = urlFilename
 	} else {
-		name = rootCid.String() + ".car"
+		name = pathCids[0].String() + ".car"
 	}
 	setContentDispositionHeader(w, name, "attachment")
 
 	// Set Cache-Control (same logic as for a regular files)
-	addCacheControlHeaders(w, r, contentPath, rootCid)
+	modtime := addCacheControlHeaders(w, r, contentPath, pathCids[0])
+
+	if params.ordered {
+		i.serveOrderedCAR(ctx, w, r, contentPath, pathCids, params, name, modtime, begin)
+		return
+	}
 
 	// Weak Etag W/ because we can't guarantee byte-for-byte identical
 	// responses, but still want to benefit from HTTP Caching. Two CAR
 	// responses for the same CID and selector will be logically equivalent,
 	// but when CAR is streamed, then in theory, blocks may arrive from
 	// datastore in non-deterministic order.
-	etag := `W/` + getEtag(r, rootCid)
+	// The Etag includes dag-scope and entity-bytes, if present, and the
+	// blocks of the content path included in a scoped CAR.
+	etag := `W/` + carEtag(r, pathCids)
 	w.Header().Set("Etag", etag)
 
 	// Finish early if Etag match
@@ -60,22 +70,30 @@ func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
+	if !i.allowDagNetwork(w, r, pathCids, params) {
+		return
+	}
+
 	// Make it clear we don't support range-requests over a car stream
 	// Partial downloads and resumes should be handled using requests for
-	// sub-DAGs and IPLD selectors: https://github.com/ipfs/go-ipfs/issues/8769
+	// sub-DAGs via ?dag-scope and ?entity-bytes, or an ordered CAR (order=dfs)
 	w.Header().Set("Accept-Ranges", "none")
 
 	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
 	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
 
-	// Same go-car settings as dag.export command
-	store := dagStore{dag: i.api.Dag(), ctx: ctx}
-
-	// TODO: support selectors passed as request param: https://github.com/ipfs/kubo/issues/8769
-	dag := gocar.Dag{Root: rootCid, Selector: selectorparse.CommonSelector_ExploreAllRecursively}
-	car := gocar.NewSelectiveCar(ctx, store, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
+	if params.scope != "" {
+		// Scoped CAR: blocks of the content path followed by blocks from dag-scope
+		err = i.writeCarStream(ctx, w, pathCids, params)
+	} else {
+		// Same go-car settings as dag.export command
+		store := dagStore{dag: i.api.Dag(), ctx: ctx}
+		dag := gocar.Dag{Root: pathCids[0], Selector: selectorparse.CommonSelector_ExploreAllRecursively}
+		car := gocar.NewSelectiveCar(ctx, store, []gocar.Dag{dag}, gocar.TraverseLinksOnlyOnce())
+		err = car.Write(w)
+	}
 
-	if err := car.Write(w); err != nil {
+	if err != nil {
 		// We return error as a trailer, however it is not something browsers can access
 		// (https://github.com/mdn/browser-compat-data/issues/14703)
 		// Due to this, we suggest client always verify that
@@ -88,6 +106,21 @@ func (i *gatewayHandler) serveCAR(ctx context.Context, w http.ResponseWriter, r
 	i.carStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
 }
 
+// carEtag returns the Etag of a CAR starting at the root of the content
+// path: the Etag of the root, with a digest of the CIDs of the other blocks
+// of the path when there are any
+func carEtag(r *http.Request, pathCids []cid.Cid) string {
+	etag := getEtag(r, pathCids[0])
+	if len(pathCids) == 1 {
+		return etag
+	}
+	h := sha256.New()
+	for _, c := range pathCids[1:] {
+		h.Write(c.Bytes())
+	}
+	return strings.TrimSuffix(etag, `"`) + "." + hex.EncodeToString(h.Sum(nil)[:8]) + `"`
+}
+
 // FIXME(@Jorropo): https://github.com/ipld/go-car/issues/315
 type dagStore struct {
 	dag coreiface.APIDagService
@@ -0,0 +1,247 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// orderedCarMaxBlocks is the maximum number of blocks of an ordered CAR
+// which has its layout computed before the response is sent. Larger DAGs are
+// streamed in the same order, without support for range requests.
+var orderedCarMaxBlocks = 1 << 16
+
+// errOrderedCarTooLarge is returned when the DAG of an ordered CAR has more
+// than orderedCarMaxBlocks blocks
+var errOrderedCarTooLarge = errors.New("DAG is too large for an ordered CAR with a known layout")
+
+// serveOrderedCAR returns a CAR with blocks in deterministic, depth-first
+// order without duplicates. The bytes of such response only depend on the
+// request, so unlike a regular CAR stream it has a strong Etag and supports
+// range requests, which allows resuming interrupted downloads.
+func (i *gatewayHandler) serveOrderedCAR(ctx context.Context, w http.ResponseWriter, r *http.Request, contentPath ipath.Path, pathCids []cid.Cid, params carParams, name string, modtime time.Time, begin time.Time) {
+	// The CAR starts at the root of the content path, its Etag depends on the
+	// blocks of the path and not only on the terminal CID
+	etag := carEtag(r, pathCids)
+	w.Header().Set("Etag", etag)
+
+	// Finish early if Etag match, before traversing the DAG
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, etag, "") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if !i.allowDagNetwork(w, r, pathCids, params) {
+		return
+	}
+
+	w.Header().Set("Content-Type", fmt.Sprintf("application/vnd.ipld.car; version=%d; order=dfs", params.version))
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+
+	// The layout of the CAR has to be known before the first byte is sent,
+	// so we traverse the DAG once to learn the size of every block.
+	car, err := i.newOrderedCar(ctx, pathCids, params)
+	if errors.Is(err, errOrderedCarTooLarge) {
+		i.serveLargeOrderedCAR(ctx, w, contentPath, pathCids, params, begin)
+		return
+	}
+	if err != nil {
+		webError(w, "failed to traverse DAG for ordered CAR", err, http.StatusInternalServerError)
+		return
+	}
+
+	// ServeContent will take care of
+	// If-None-Match+Etag, Content-Length and range requests
+	_, dataSent, _ := ServeContent(w, r, name, modtime, car)
+
+	if dataSent {
+		// Update metrics
+		i.carStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
+
+// serveLargeOrderedCAR streams an ordered CARv1 of a DAG with more than
+// orderedCarMaxBlocks blocks. The bytes are the same as the ones of an ordered
+// CAR with a known layout, but range requests are ignored.
+// A CARv2 can't be streamed as its header has the size of the data payload.
+func (i *gatewayHandler) serveLargeOrderedCAR(ctx context.Context, w http.ResponseWriter, contentPath ipath.Path, pathCids []cid.Cid, params carParams, begin time.Time) {
+	if params.version != 1 {
+		webError(w, "failed to create ordered CAR", fmt.Errorf("%w, request version=1 instead", errOrderedCarTooLarge), http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "none")
+	if err := i.writeCarStream(ctx, w, pathCids, params); err != nil {
+		// see serveCAR
+		w.Header().Set("X-Stream-Error", err.Error())
+		return
+	}
+
+	// Update metrics
+	i.carStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+}
+
+// orderedCarSection is a single block of an ordered CAR
+type orderedCarSection struct {
+	cid    cid.Cid
+	offset int64 // position of the section within the CAR
+	size   int64 // varint length prefix, CID and block data
+}
+
+// orderedCar is an io.ReadSeeker over a CAR with a known layout.
+// Header and index are kept in memory, while block data is read from the
+// blockstore when a section is read.
+type orderedCar struct {
+	ctx      context.Context
+	api      NodeAPI
+	header   []byte // everything before the first block (CARv2 pragma and header, CARv1 header)
+	sections []orderedCarSection
+	footer   []byte // CARv2 index
+	size     int64
+	offset   int64
+
+	// the most recently read section
+	cached    int
+	cachedBuf []byte
+}
+
+func (i *gatewayHandler) newOrderedCar(ctx context.Context, pathCids []cid.Cid, params carParams) (*orderedCar, error) {
+	var v1Header bytes.Buffer
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: pathCids[:1], Version: 1}, &v1Header); err != nil {
+		return nil, err
+	}
+
+	// offsets within the CARv1 data payload
+	var sections []orderedCarSection
+	var records []index.Record
+	dataSize := int64(v1Header.Len())
+	err := i.walkCar(ctx, pathCids, params, func(nd ipld.Node) error {
+		if len(sections) >= orderedCarMaxBlocks {
+			return errOrderedCarTooLarge
+		}
+		size := int64(carutil.LdSize(nd.Cid().Bytes(), nd.RawData()))
+		sections = append(sections, orderedCarSection{cid: nd.Cid(), offset: dataSize, size: size})
+		// same as go-car/v2 default: identity CIDs are not indexed
+		if nd.Cid().Prefix().MhType != mh.IDENTITY {
+			records = append(records, index.Record{Cid: nd.Cid(), Offset: uint64(dataSize)})
+		}
+		dataSize += size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var header, footer bytes.Buffer
+	if params.version == 2 {
+		if _, err := header.Write(carv2.Pragma); err != nil {
+			return nil, err
+		}
+		if _, err := carv2.NewHeader(uint64(dataSize)).WriteTo(&header); err != nil {
+			return nil, err
+		}
+		idx := index.NewMultihashSorted()
+		if err := idx.Load(records); err != nil {
+			return nil, err
+		}
+		if _, err := index.WriteTo(idx, &footer); err != nil {
+			return nil, err
+		}
+	}
+	v1Offset := int64(header.Len())
+	header.Write(v1Header.Bytes())
+
+	for idx := range sections {
+		sections[idx].offset += v1Offset
+	}
+
+	return &orderedCar{
+		ctx:      ctx,
+		api:      i.api,
+		header:   header.Bytes(),
+		sections: sections,
+		footer:   footer.Bytes(),
+		size:     v1Offset + dataSize + int64(footer.Len()),
+		cached:   -1,
+	}, nil
+}
+
+func (c *orderedCar) Read(p []byte) (int, error) {
+	if c.offset >= c.size {
+		return 0, io.EOF
+	}
+	region, start, err := c.regionAt(c.offset)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, region[c.offset-start:])
+	c.offset += int64(n)
+	return n, nil
+}
+
+func (c *orderedCar) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+// regionAt returns the bytes of the header, section or footer that contains
+// the offset, along with the offset at which the returned bytes start
+func (c *orderedCar) regionAt(offset int64) ([]byte, int64, error) {
+	if offset < int64(len(c.header)) {
+		return c.header, 0, nil
+	}
+	footerStart := c.size - int64(len(c.footer))
+	if offset >= footerStart {
+		return c.footer, footerStart, nil
+	}
+
+	idx := sort.Search(len(c.sections), func(i int) bool {
+		return c.sections[i].offset+c.sections[i].size > offset
+	})
+	section := c.sections[idx]
+	if c.cached != idx {
+		blk, err := c.api.Block().Get(c.ctx, ipath.IpfsPath(section.cid))
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := io.ReadAll(blk)
+		if err != nil {
+			return nil, 0, err
+		}
+		var buf bytes.Buffer
+		if err := carutil.LdWrite(&buf, section.cid.Bytes(), data); err != nil {
+			return nil, 0, err
+		}
+		if int64(buf.Len()) != section.size {
+			return nil, 0, fmt.Errorf("unexpected size of block %s", section.cid)
+		}
+		c.cached, c.cachedBuf = idx, buf.Bytes()
+	}
+	return c.cachedBuf, section.offset, nil
+}
@@ -0,0 +1,469 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfs/hamt"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+// dagScope describes which blocks of the DAG behind the requested content
+// path are included in a CAR response (?dag-scope parameter)
+type dagScope string
+
+const (
+	// dagScopeBlock only includes the block of the terminal element of the path
+	dagScopeBlock dagScope = "block"
+	// dagScopeEntity includes the blocks needed to deserialize the terminal
+	// element: all blocks of a UnixFS file, the shards of a HAMT directory,
+	// or a single block for everything else
+	dagScopeEntity dagScope = "entity"
+	// dagScopeAll includes the entire DAG behind the terminal element
+	dagScopeAll dagScope = "all"
+)
+
+// byteRange is an inclusive range of bytes requested via ?entity-bytes=from:to.
+// Negative offsets are counted from the end of the entity, and a nil to
+// means "until the end of the entity".
+type byteRange struct {
+	from int64
+	to   *int64
+}
+
+// carParams are the optional parameters of a CAR response
+type carParams struct {
+	version     int      // CAR version, 1 or 2
+	ordered     bool     // deterministic, depth-first order without duplicates
+	scope       dagScope // empty if the client did not ask for a scope
+	entityBytes *byteRange
+}
+
+// getCarParams parses and validates the version and order parameters of the
+// requested media type, and the dag-scope and entity-bytes query parameters
+func getCarParams(r *http.Request, formatParams map[string]string) (carParams, error) {
+	params := carParams{version: 1}
+	query := r.URL.Query()
+
+	switch v := formatParams["version"]; v {
+	case "", "1": // noop, client does not care about version or asked for default one
+	case "2":
+		// CARv2 header includes the size of the data payload, so we need to
+		// know all blocks upfront, which is only possible in ordered mode
+		params.version = 2
+		params.ordered = true
+	default:
+		return params, fmt.Errorf("unsupported CAR version %q, only version=1 and version=2 are supported", v)
+	}
+
+	switch o := formatParams["order"]; o {
+	case "", "unk":
+	case "dfs":
+		params.ordered = true
+	default:
+		return params, fmt.Errorf("unsupported CAR order %q, only order=dfs and order=unk are supported", o)
+	}
+
+	switch s := dagScope(query.Get("dag-scope")); s {
+	case "":
+	case dagScopeBlock, dagScopeEntity, dagScopeAll:
+		params.scope = s
+	default:
+		return params, fmt.Errorf("unsupported dag-scope %q, expected one of %q, %q or %q", s, dagScopeBlock, dagScopeEntity, dagScopeAll)
+	}
+
+	if rangeStr := query.Get("entity-bytes"); rangeStr != "" {
+		switch params.scope {
+		case "": // entity-bytes implies dag-scope=entity
+			params.scope = dagScopeEntity
+		case dagScopeEntity:
+		default:
+			return params, fmt.Errorf("entity-bytes can only be used with dag-scope=%s", dagScopeEntity)
+		}
+		br, err := parseByteRange(rangeStr)
+		if err != nil {
+			return params, err
+		}
+		params.entityBytes = br
+	}
+
+	return params, nil
+}
+
+// parseByteRange parses a from:to range where both sides are integers
+// (negative ones are relative to the end) and to can be '*'
+func parseByteRange(s string) (*byteRange, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid entity-bytes %q, expected from:to", s)
+	}
+	from, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid entity-bytes start %q: %w", parts[0], err)
+	}
+	br := &byteRange{from: from}
+	if parts[1] != "*" {
+		to, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entity-bytes end %q: %w", parts[1], err)
+		}
+		if from >= 0 && to >= 0 && to < from {
+			return nil, fmt.Errorf("invalid entity-bytes %q, end is before start", s)
+		}
+		br.to = &to
+	}
+	return br, nil
+}
+
+// resolve returns absolute, inclusive offsets of the range within an entity
+// of the given size. ok is false when the range does not overlap the entity.
+func (br *byteRange) resolve(size int64) (from, to int64, ok bool) {
+	from = br.from
+	if from < 0 {
+		from += size
+		if from < 0 {
+			from = 0
+		}
+	}
+	to = size - 1
+	if br.to != nil {
+		to = *br.to
+		if to < 0 {
+			to += size
+		}
+		if to > size-1 {
+			to = size - 1
+		}
+	}
+	return from, to, from <= to && from < size
+}
+
+// etagSuffix returns a string that makes the Etag of a CAR response
+// distinct from the Etag of responses with a different version, scope or order
+func (p carParams) etagSuffix() string {
+	var suffix string
+	if p.version != 1 {
+		suffix += ".v" + strconv.Itoa(p.version)
+	}
+	if p.scope != "" {
+		suffix += "." + string(p.scope)
+	}
+	if p.entityBytes != nil {
+		to := "*"
+		if p.entityBytes.to != nil {
+			to = strconv.FormatInt(*p.entityBytes.to, 10)
+		}
+		suffix += "." + strconv.FormatInt(p.entityBytes.from, 10) + "-" + to
+	}
+	if p.ordered {
+		suffix += ".dfs"
+	}
+	return suffix
+}
+
+// carWalker visits the blocks needed to verify the content path followed by
+// the blocks included by the requested dag-scope. Blocks are visited in
+// depth-first order and never more than once, so the result is deterministic.
+type carWalker struct {
+	api    NodeAPI
+	params carParams
+	visit  func(ipld.Node) error
+	seen   map[cid.Cid]struct{}
+}
+
+// carPathCids returns the CIDs of the content path that are part of a CAR
+// response: the full path for scoped responses, or only the terminal element
+// for the implicit, full DAG export. The first CID is the root of the CAR.
+func (i *gatewayHandler) carPathCids(ctx context.Context, contentPath ipath.Path, resolvedPath ipath.Resolved, params carParams) ([]cid.Cid, error) {
+	if params.scope == "" {
+		return []cid.Cid{resolvedPath.Cid()}, nil
+	}
+	// The root of the CAR is the root of the content path, so the client can
+	// verify every step of the path resolution on its own.
+	return i.walkContentPath(ctx, contentPath)
+}
+
+// walkCar calls visit for every block of the CAR response described by
+// pathCids and params, in the order they are written to the CAR
+func (i *gatewayHandler) walkCar(ctx context.Context, pathCids []cid.Cid, params carParams, visit func(ipld.Node) error) error {
+	return walkCarWith(ctx, i.api, pathCids, params, visit)
+}
+
+// walkCarWith is walkCar reading the blocks with the given API
+func walkCarWith(ctx context.Context, api NodeAPI, pathCids []cid.Cid, params carParams, visit func(ipld.Node) error) error {
+	cw := &carWalker{
+		api:    api,
+		params: params,
+		visit:  visit,
+		seen:   make(map[cid.Cid]struct{}),
+	}
+
+	for _, c := range pathCids[:len(pathCids)-1] {
+		if err := cw.walkCid(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	terminal, err := api.Dag().Get(ctx, pathCids[len(pathCids)-1])
+	if err != nil {
+		return err
+	}
+	switch params.scope {
+	case dagScopeBlock:
+		return cw.emit(terminal)
+	case dagScopeEntity:
+		return cw.walkEntity(ctx, terminal)
+	default:
+		return cw.walkAll(ctx, terminal)
+	}
+}
+
+// writeCarStream streams a CARv1 with blocks written as soon as they are traversed
+func (i *gatewayHandler) writeCarStream(ctx context.Context, w io.Writer, pathCids []cid.Cid, params carParams) error {
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: pathCids[:1], Version: 1}, w); err != nil {
+		return err
+	}
+	return i.walkCar(ctx, pathCids, params, func(nd ipld.Node) error {
+		return carutil.LdWrite(w, nd.Cid().Bytes(), nd.RawData())
+	})
+}
+
+// walkContentPath returns the CIDs of all blocks traversed when resolving
+// contentPath, starting with the root of the path and ending with the
+// terminal element. Intermediate HAMT shards are included.
+func (i *gatewayHandler) walkContentPath(ctx context.Context, contentPath ipath.Path) ([]cid.Cid, error) {
+	segments := strings.Split(strings.Trim(contentPath.String(), "/"), "/")
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("invalid content path %q", contentPath)
+	}
+
+	subPath := "/" + segments[0] + "/" + segments[1]
+	resolved, err := i.api.ResolvePath(ctx, ipath.New(subPath))
+	if err != nil {
+		return nil, err
+	}
+	pathCids := []cid.Cid{resolved.Cid()}
+
+	for _, segment := range segments[2:] {
+		if segment == "" {
+			continue
+		}
+		parent := pathCids[len(pathCids)-1]
+
+		shardCids, err := i.hamtShardsForLookup(ctx, parent, segment)
+		if err != nil {
+			return nil, err
+		}
+		pathCids = append(pathCids, shardCids...)
+
+		subPath += "/" + segment
+		resolved, err := i.api.ResolvePath(ctx, ipath.New(subPath))
+		if err != nil {
+			return nil, err
+		}
+		// path segments within a single dag-cbor/dag-json block resolve to the same CID
+		if !resolved.Cid().Equals(parent) {
+			pathCids = append(pathCids, resolved.Cid())
+		}
+	}
+
+	return pathCids, nil
+}
+
+// hamtShardsForLookup returns the child shards of a HAMT-sharded directory
+// that are loaded when looking up name. It returns nothing if c is not a HAMT.
+func (i *gatewayHandler) hamtShardsForLookup(ctx context.Context, c cid.Cid, name string) ([]cid.Cid, error) {
+	if c.Type() != cid.DagProtobuf {
+		return nil, nil
+	}
+	nd, err := i.api.Dag().Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return nil, nil
+	}
+	fsn, err := ft.FSNodeFromBytes(pn.Data())
+	if err != nil || fsn.Type() != ft.THAMTShard {
+		return nil, nil
+	}
+
+	rec := &recordingDAGService{DAGService: i.api.Dag()}
+	shard, err := hamt.NewHamtFromDag(rec, pn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := shard.Find(ctx, name); err != nil {
+		return nil, err
+	}
+	return rec.fetched, nil
+}
+
+// recordingDAGService records the CIDs of every node fetched through it
+type recordingDAGService struct {
+	ipld.DAGService
+	fetched []cid.Cid
+}
+
+func (rd *recordingDAGService) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, err := rd.DAGService.Get(ctx, c)
+	if err == nil {
+		rd.fetched = append(rd.fetched, c)
+	}
+	return nd, err
+}
+
+func (cw *carWalker) walkCid(ctx context.Context, c cid.Cid) error {
+	if _, ok := cw.seen[c]; ok {
+		return nil
+	}
+	nd, err := cw.api.Dag().Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	return cw.emit(nd)
+}
+
+func (cw *carWalker) emit(nd ipld.Node) error {
+	if _, ok := cw.seen[nd.Cid()]; ok {
+		return nil
+	}
+	cw.seen[nd.Cid()] = struct{}{}
+	return cw.visit(nd)
+}
+
+// walkAll visits the entire DAG under nd, depth-first
+func (cw *carWalker) walkAll(ctx context.Context, nd ipld.Node) error {
+	if _, ok := cw.seen[nd.Cid()]; ok {
+		return nil
+	}
+	if err := cw.emit(nd); err != nil {
+		return err
+	}
+	for _, l := range nd.Links() {
+		if _, ok := cw.seen[l.Cid]; ok {
+			continue
+		}
+		child, err := cw.api.Dag().Get(ctx, l.Cid)
+		if err != nil {
+			return err
+		}
+		if err := cw.walkAll(ctx, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkEntity visits the blocks needed to deserialize nd
+func (cw *carWalker) walkEntity(ctx context.Context, nd ipld.Node) error {
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		// raw blocks and non-UnixFS codecs: the entity is the block itself
+		return cw.emit(nd)
+	}
+	fsn, err := ft.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		// dag-pb without UnixFS data
+		return cw.emit(nd)
+	}
+
+	switch fsn.Type() {
+	case ft.TFile, ft.TRaw:
+		if cw.params.entityBytes == nil {
+			return cw.walkAll(ctx, nd)
+		}
+		if err := cw.emit(nd); err != nil {
+			return err
+		}
+		from, to, ok := cw.params.entityBytes.resolve(int64(fsn.FileSize()))
+		if !ok {
+			return nil
+		}
+		return cw.walkFileRange(ctx, pn, fsn, 0, from, to)
+	case ft.THAMTShard:
+		return cw.walkHAMTShards(ctx, pn, fsn)
+	default: // TDirectory, TSymlink, TMetadata
+		return cw.emit(nd)
+	}
+}
+
+// walkFileRange visits the children of a UnixFS file node that overlap with
+// the inclusive [from, to] range. offset is the position of the node in the file.
+func (cw *carWalker) walkFileRange(ctx context.Context, pn *dag.ProtoNode, fsn *ft.FSNode, offset, from, to int64) error {
+	// inline data of the node comes before the data of its children
+	offset += int64(len(fsn.Data()))
+	links := pn.Links()
+	for idx, blockSize := range fsn.BlockSizes() {
+		start, end := offset, offset+int64(blockSize)-1
+		offset += int64(blockSize)
+		if end < from || idx >= len(links) {
+			continue
+		}
+		if start > to {
+			break
+		}
+
+		child, err := cw.api.Dag().Get(ctx, links[idx].Cid)
+		if err != nil {
+			return err
+		}
+		if err := cw.emit(child); err != nil {
+			return err
+		}
+		childPn, ok := child.(*dag.ProtoNode)
+		if !ok {
+			continue // raw leaf
+		}
+		childFsn, err := ft.FSNodeFromBytes(childPn.Data())
+		if err != nil {
+			return err
+		}
+		if err := cw.walkFileRange(ctx, childPn, childFsn, start, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkHAMTShards visits all shards of a HAMT directory, without the entries
+func (cw *carWalker) walkHAMTShards(ctx context.Context, pn *dag.ProtoNode, fsn *ft.FSNode) error {
+	if err := cw.emit(pn); err != nil {
+		return err
+	}
+	// links to child shards are named with the hex prefix only, while links
+	// to directory entries have the entry name appended to the prefix
+	prefixLen := len(fmt.Sprintf("%X", fsn.Fanout()-1))
+	for _, l := range pn.Links() {
+		if len(l.Name) != prefixLen {
+			continue
+		}
+		child, err := cw.api.Dag().Get(ctx, l.Cid)
+		if err != nil {
+			return err
+		}
+		childPn, ok := child.(*dag.ProtoNode)
+		if !ok {
+			return fmt.Errorf("HAMT shard %s is not a dag-pb node", l.Cid)
+		}
+		childFsn, err := ft.FSNodeFromBytes(childPn.Data())
+		if err != nil {
+			return err
+		}
+		if err := cw.walkHAMTShards(ctx, childPn, childFsn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	iface "github.com/ipfs/interface-go-ipfs-core"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+)
+
+// addScopeTestDir adds /sub/file.txt with a file made of four distinct 16-byte raw leaves
+func addScopeTestDir(t *testing.T, api iface.CoreAPI, ctx context.Context) (root cid.Cid, sub cid.Cid, file cid.Cid) {
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"file.txt": files.NewBytesFile([]byte("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_")),
+		}),
+	})
+	p, err := api.Unixfs().Add(ctx, dir, options.Unixfs.Chunker("size-16"), options.Unixfs.RawLeaves(true), options.Unixfs.CidVersion(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(s string) cid.Cid {
+		rp, err := api.ResolvePath(ctx, ipath.New(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp.Cid()
+	}
+	return p.Cid(), resolve(p.String() + "/sub"), resolve(p.String() + "/sub/file.txt")
+}
+
+func readCarResponse(t *testing.T, url string) (*http.Response, []cid.Cid, []cid.Cid) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return res, nil, nil
+	}
+	cr, err := gocar.NewCarReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blks []cid.Cid
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			break
+		}
+		blks = append(blks, blk.Cid())
+	}
+	return res, cr.Header.Roots, blks
+}
+
+func TestCarDagScope(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, mockNamesys{})
+	root, sub, file := addScopeTestDir(t, api, ctx)
+	filePath := "/ipfs/" + root.String() + "/sub/file.txt"
+
+	for _, test := range []struct {
+		query  string
+		path   string
+		blocks int
+	}{
+		{"dag-scope=block", filePath, 3},
+		{"dag-scope=entity", filePath, 7},
+		{"dag-scope=entity&entity-bytes=0:15", filePath, 4},
+		{"entity-bytes=0:15", filePath, 4},
+		{"dag-scope=entity&entity-bytes=16:40", filePath, 5},
+		{"dag-scope=entity&entity-bytes=-16:*", filePath, 4},
+		{"dag-scope=entity&entity-bytes=100:*", filePath, 3},
+		{"dag-scope=entity", "/ipfs/" + root.String() + "/sub", 2},
+		{"dag-scope=all", "/ipfs/" + root.String(), 7},
+	} {
+		res, roots, blks := readCarResponse(t, ts.URL+test.path+"?format=car&"+test.query)
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s?%s: got status %d", test.path, test.query, res.StatusCode)
+			continue
+		}
+		if len(roots) != 1 || !roots[0].Equals(root) {
+			t.Errorf("%s?%s: expected root %s, got %v", test.path, test.query, root, roots)
+		}
+		if len(blks) != test.blocks {
+			t.Errorf("%s?%s: expected %d blocks, got %d", test.path, test.query, test.blocks, len(blks))
+			continue
+		}
+		// blocks of the path are always sent first
+		if !blks[0].Equals(root) || !blks[1].Equals(sub) || (strings.HasSuffix(test.path, "file.txt") && !blks[2].Equals(file)) {
+			t.Errorf("%s?%s: unexpected order of path blocks: %v", test.path, test.query, blks)
+		}
+	}
+
+	res, _, _ := readCarResponse(t, ts.URL+"/ipfs/"+file.String()+"?format=car&dag-scope=entity&entity-bytes=0:15")
+	directEtag := res.Header.Get("Etag")
+	if directEtag != `W/"`+file.String()+`.car.entity.0-15"` {
+		t.Errorf("unexpected Etag %s", directEtag)
+	}
+
+	// the same terminal CID reached through a path is another CAR
+	res, _, _ = readCarResponse(t, ts.URL+filePath+"?format=car&dag-scope=entity&entity-bytes=0:15")
+	pathEtag := res.Header.Get("Etag")
+	if !strings.HasPrefix(pathEtag, `W/"`+root.String()+`.car.entity.0-15.`) {
+		t.Errorf("expected an Etag of the path, got %s", pathEtag)
+	}
+	if cd := res.Header.Get("Content-Disposition"); !strings.Contains(cd, root.String()+".car") {
+		t.Errorf("expected the root of the path in the filename, got %s", cd)
+	}
+	res, _ = getWithAccept(t, ts.URL+filePath+"?dag-scope=entity&entity-bytes=0:15", "application/vnd.ipld.car", "If-None-Match", directEtag)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d for the Etag of another CAR, got %d", http.StatusOK, res.StatusCode)
+	}
+	res, _ = getWithAccept(t, ts.URL+filePath+"?dag-scope=entity&entity-bytes=0:15", "application/vnd.ipld.car", "If-None-Match", pathEtag)
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status %d, got %d", http.StatusNotModified, res.StatusCode)
+	}
+
+	for _, query := range []string{"dag-scope=foo", "dag-scope=block&entity-bytes=0:1", "entity-bytes=10:1", "entity-bytes=1", "entity-bytes=a:*"} {
+		res, _, _ := readCarResponse(t, ts.URL+filePath+"?format=car&"+query)
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, res.StatusCode)
+		}
+	}
+}
+
+func TestByteRangeResolve(t *testing.T) {
+	for _, test := range []struct {
+		in       string
+		size     int64
+		from, to int64
+		ok       bool
+	}{
+		{"0:*", 100, 0, 99, true},
+		{"10:20", 100, 10, 20, true},
+		{"10:200", 100, 10, 99, true},
+		{"-10:*", 100, 90, 99, true},
+		{"-200:*", 100, 0, 99, true},
+		{"0:-11", 100, 0, 89, true},
+		{"100:*", 100, 100, 99, false},
+		{"-10:-20", 100, 90, 80, false},
+	} {
+		br, err := parseByteRange(test.in)
+		if err != nil {
+			t.Fatalf("%s: %s", test.in, err)
+		}
+		from, to, ok := br.resolve(test.size)
+		if from != test.from || to != test.to || ok != test.ok {
+			t.Errorf("%s: got (%d, %d, %t), expected (%d, %d, %t)", test.in, from, to, ok, test.from, test.to, test.ok)
+		}
+	}
+}
+
+func getWithAccept(t *testing.T, url string, accept string, header ...string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", accept)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, body
+}
+
+func TestCarOrdered(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, mockNamesys{})
+	root, sub, _ := addScopeTestDir(t, api, ctx)
+	url := ts.URL + "/ipfs/" + root.String()
+
+	res, full := getWithAccept(t, url, "application/vnd.ipld.car; version=1; order=dfs")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if etag := res.Header.Get("Etag"); etag != `"`+root.String()+`.car.dfs"` {
+		t.Errorf("expected strong Etag, got %s", etag)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.car; version=1; order=dfs" {
+		t.Errorf("unexpected Content-Type %s", ct)
+	}
+	if res.Header.Get("Content-Length") != strconv.Itoa(len(full)) {
+		t.Errorf("unexpected Content-Length %s", res.Header.Get("Content-Length"))
+	}
+
+	// the same request produces the same bytes
+	_, again := getWithAccept(t, url, "application/vnd.ipld.car; order=dfs")
+	if !bytes.Equal(full, again) {
+		t.Error("ordered CAR responses are not byte-identical")
+	}
+
+	// and it is the same as a full, scoped CAR stream for a root CID
+	_, stream := readBody(t, url+"?format=car&dag-scope=all")
+	if !bytes.Equal(full, stream) {
+		t.Error("ordered CAR does not match the scoped stream")
+	}
+
+	// resume download
+	res, part := getWithAccept(t, url, "application/vnd.ipld.car; order=dfs", "Range", "bytes=100-")
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected status %d, got %d", http.StatusPartialContent, res.StatusCode)
+	}
+	if !bytes.Equal(full[100:], part) {
+		t.Error("range response does not match the full response")
+	}
+
+	// CARv2 with index
+	res, v2 := getWithAccept(t, url, "application/vnd.ipld.car; version=2")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if etag := res.Header.Get("Etag"); etag != `"`+root.String()+`.car.v2.dfs"` {
+		t.Errorf("unexpected Etag %s", etag)
+	}
+	cr, err := carv2.NewReader(bytes.NewReader(v2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := cr.DataReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(full, data) {
+		t.Error("CARv2 data payload does not match the CARv1 response")
+	}
+	ir, err := cr.IndexReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.ReadFrom(ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.GetFirst(idx, root); err != nil {
+		t.Errorf("root is missing from the CARv2 index: %s", err)
+	}
+
+	// the same terminal CID reached through a path is another CAR
+	res, direct := getWithAccept(t, ts.URL+"/ipfs/"+sub.String()+"?dag-scope=all", "application/vnd.ipld.car; order=dfs")
+	directEtag := res.Header.Get("Etag")
+	if directEtag != `"`+sub.String()+`.car.all.dfs"` {
+		t.Errorf("unexpected Etag %s", directEtag)
+	}
+	res, throughPath := getWithAccept(t, url+"/sub?dag-scope=all", "application/vnd.ipld.car; order=dfs")
+	if bytes.Equal(direct, throughPath) {
+		t.Fatal("expected the CAR of the path to include the root block")
+	}
+	pathEtag := res.Header.Get("Etag")
+	if pathEtag == directEtag || !strings.HasPrefix(pathEtag, `"`+root.String()+`.car.all.dfs.`) {
+		t.Errorf("expected an Etag of the path, got %s", pathEtag)
+	}
+	res, _ = getWithAccept(t, url+"/sub?dag-scope=all", "application/vnd.ipld.car; order=dfs", "If-None-Match", directEtag)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d for the Etag of another CAR, got %d", http.StatusOK, res.StatusCode)
+	}
+	res, _ = getWithAccept(t, url+"/sub?dag-scope=all", "application/vnd.ipld.car; order=dfs", "If-None-Match", pathEtag)
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status %d, got %d", http.StatusNotModified, res.StatusCode)
+	}
+
+	res, _ = getWithAccept(t, url, "application/vnd.ipld.car; version=3")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for unsupported version, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+}
+
+func TestCarOrderedLarge(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, mockNamesys{})
+	root, _, _ := addScopeTestDir(t, api, ctx)
+	url := ts.URL + "/ipfs/" + root.String()
+
+	res, full := getWithAccept(t, url, "application/vnd.ipld.car; order=dfs")
+	etag := res.Header.Get("Etag")
+
+	defer func(max int) { orderedCarMaxBlocks = max }(orderedCarMaxBlocks)
+	orderedCarMaxBlocks = 2
+
+	// the Etag is checked before the DAG is traversed
+	res, _ = getWithAccept(t, url, "application/vnd.ipld.car; order=dfs", "If-None-Match", etag)
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status %d, got %d", http.StatusNotModified, res.StatusCode)
+	}
+
+	// larger DAGs are streamed in the same order, without range requests
+	res, stream := getWithAccept(t, url, "application/vnd.ipld.car; order=dfs", "Range", "bytes=100-")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if !bytes.Equal(full, stream) {
+		t.Error("streamed ordered CAR does not match the ordered CAR")
+	}
+	if ar := res.Header.Get("Accept-Ranges"); ar != "none" {
+		t.Errorf("unexpected Accept-Ranges %s", ar)
+	}
+	if res.Header.Get("Etag") != etag {
+		t.Errorf("unexpected Etag %s", res.Header.Get("Etag"))
+	}
+
+	res, _ = getWithAccept(t, url, "application/vnd.ipld.car; version=2")
+	if res.StatusCode != http.StatusNotImplemented {
+		t.Errorf("expected status %d for a large CARv2, got %d", http.StatusNotImplemented, res.StatusCode)
+	}
+}
+
+func readBody(t *testing.T, url string) (*http.Response, []byte) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, body
+}
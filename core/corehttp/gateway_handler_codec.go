@@ -0,0 +1,358 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/tracing"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/traversal"
+	mc "github.com/multiformats/go-multicodec"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// contentTypeToCodec maps the content types that can be requested explicitly
+// to the codec used for encoding the response
+var contentTypeToCodec = map[string]mc.Code{
+	"application/vnd.ipld.dag-json": mc.DagJson,
+	"application/vnd.ipld.dag-cbor": mc.DagCbor,
+}
+
+// codecToExtension is used for the default filename of a response
+var codecToExtension = map[mc.Code]string{
+	mc.DagJson: ".json",
+	mc.DagCbor: ".cbor",
+}
+
+// isUnixFSCodec returns true for codecs handled by serveUnixFS
+func isUnixFSCodec(c cid.Cid) bool {
+	codec := mc.Code(c.Prefix().Codec)
+	return codec == mc.DagPb || codec == mc.Raw
+}
+
+// serveCodec returns the IPLD node behind the path encoded with the codec of
+// the requested content type. When no content type was requested, the node
+// is returned in its own codec, or as a HTML page when a browser asks for one.
+func (i *gatewayHandler) serveCodec(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, responseFormat string, begin time.Time) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeCodec", trace.WithAttributes(attribute.String("path", resolvedPath.String()), attribute.String("responseFormat", responseFormat)))
+	defer span.End()
+
+	blockCid := resolvedPath.Cid()
+	blockCodec := mc.Code(blockCid.Prefix().Codec)
+
+	if responseFormat == "" && acceptsHTML(r) {
+		i.serveCodecHTML(ctx, w, r, resolvedPath, contentPath, begin)
+		return
+	}
+
+	codec := blockCodec
+	if responseFormat != "" {
+		codec = contentTypeToCodec[responseFormat]
+	}
+
+	node, err := i.getCodecNode(ctx, resolvedPath)
+	if err != nil {
+		webError(w, "ipfs dag get "+html.EscapeString(contentPath.String()), err, http.StatusInternalServerError)
+		return
+	}
+
+	var data []byte
+	if codec == blockCodec && resolvedPath.Remainder() == "" {
+		// the block is already in the requested codec, return it as-is so
+		// the client can verify it against the CID
+		data = node.(ipldlegacy.UniversalNode).RawData()
+	} else {
+		encoder, err := multicodec.LookupEncoder(uint64(codec))
+		if err != nil {
+			webError(w, "failed to find encoder for "+codec.String(), err, http.StatusNotAcceptable)
+			return
+		}
+		var buf bytes.Buffer
+		if err := encoder(node, &buf); err != nil {
+			webError(w, "failed to encode node as "+codec.String(), err, http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+	}
+
+	// Set Content-Disposition
+	var name string
+	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
+		name = urlFilename
+	} else {
+		ext, ok := codecToExtension[codec]
+		if !ok {
+			ext = ".bin"
+		}
+		name = blockCid.String() + ext
+	}
+	disposition := "attachment"
+	if codec == mc.DagJson {
+		disposition = "inline"
+	}
+	setContentDispositionHeader(w, name, disposition)
+
+	// Set remaining headers
+	modtime := addCacheControlHeaders(w, r, contentPath, blockCid)
+	if rem := resolvedPath.Remainder(); rem != "" {
+		// the same block can be reached with different paths,
+		// so the Etag has to include the part resolved within the block
+		w.Header().Set("Etag", getCodecEtag(r, blockCid, rem))
+	}
+	w.Header().Set("Content-Type", "application/vnd.ipld."+codec.String())
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+
+	// ServeContent will take care of
+	// If-None-Match+Etag, Content-Length and range requests
+	_, dataSent, _ := ServeContent(w, r, name, modtime, bytes.NewReader(data))
+
+	if dataSent {
+		// Update metrics
+		i.codecGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
+
+// getCodecNode returns the IPLD node at the resolved path, including
+// the fields traversed within the block (the path remainder)
+func (i *gatewayHandler) getCodecNode(ctx context.Context, resolvedPath ipath.Resolved) (ipld.Node, error) {
+	obj, err := i.api.Dag().Get(ctx, resolvedPath.Cid())
+	if err != nil {
+		return nil, err
+	}
+	universal, ok := obj.(ipldlegacy.UniversalNode)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a valid IPLD node", obj)
+	}
+	var node ipld.Node = universal
+	if rem := resolvedPath.Remainder(); rem != "" {
+		node, err = traversal.Get(node, ipld.ParsePath(rem))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// getCodecEtag returns Etag for a node reached by traversing fields of a block
+func getCodecEtag(r *http.Request, blockCid cid.Cid, remainder string) string {
+	etag := getEtag(r, blockCid)
+	return etag[:len(etag)-1] + "/" + url.PathEscape(strings.Trim(remainder, "/")) + `"`
+}
+
+// acceptsHTML returns true if text/html is explicitly listed in the Accept
+// header, which is the case for requests made by web browsers
+func acceptsHTML(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if strings.TrimSpace(strings.SplitN(mediaRange, ";", 2)[0]) == "text/html" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var dagTemplate = template.Must(template.New("dag").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8">
+		<title>{{.Path}}</title>
+	</head>
+	<body>
+		<p><strong>{{.Path}}</strong></p>
+		<p>CID: <code>{{.CID}}</code> ({{.Codec}})</p>
+		<p>Download as <a href="{{.URL}}?format=dag-json">dag-json</a>, <a href="{{.URL}}?format=dag-cbor">dag-cbor</a> or <a href="/ipfs/{{.CID}}?format=raw">raw block</a></p>
+		<pre>{{.Node}}</pre>
+	</body>
+</html>`))
+
+type dagTemplateData struct {
+	Path  string
+	URL   string
+	CID   string
+	Codec string
+	Node  template.HTML
+}
+
+// serveCodecHTML returns a HTML page with the node rendered as dag-json,
+// where links point at the linked CIDs and fields at the path of the field
+func (i *gatewayHandler) serveCodecHTML(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time) {
+	node, err := i.getCodecNode(ctx, resolvedPath)
+	if err != nil {
+		webError(w, "ipfs dag get "+html.EscapeString(contentPath.String()), err, http.StatusInternalServerError)
+		return
+	}
+
+	// HostnameOption might have constructed an IPNS/IPFS path using the Host header,
+	// links have to match the requested URL (same as in directory listing)
+	requestURI, err := url.ParseRequestURI(r.RequestURI)
+	if err != nil {
+		webError(w, "failed to parse request path", err, http.StatusInternalServerError)
+		return
+	}
+	originalUrlPath := strings.TrimSuffix(requestURI.Path, "/")
+
+	var buf bytes.Buffer
+	if err := writeDagHTML(&buf, node, originalUrlPath, ""); err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	blockCid := resolvedPath.Cid()
+	modtime := addCacheControlHeaders(w, r, contentPath, blockCid)
+	// Generated HTML requires custom Etag (output may change between kubo versions)
+	w.Header().Set("Etag", `"DagView_CID-`+strings.Trim(getCodecEtag(r, blockCid, resolvedPath.Remainder()), `"`)+`"`)
+	w.Header().Set("Content-Type", "text/html")
+
+	var page bytes.Buffer
+	if err := dagTemplate.Execute(&page, dagTemplateData{
+		Path:  contentPath.String(),
+		URL:   originalUrlPath,
+		CID:   blockCid.String(),
+		Codec: mc.Code(blockCid.Prefix().Codec).String(),
+		Node:  template.HTML(buf.String()),
+	}); err != nil {
+		internalWebError(w, err)
+		return
+	}
+
+	_, dataSent, _ := ServeContent(w, r, "", modtime, bytes.NewReader(page.Bytes()))
+
+	if dataSent {
+		// Update metrics
+		i.codecGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
+
+// writeDagHTML writes the node in dag-json notation with HTML escaping, links
+// to linked CIDs, and links to the fields that can be used in a path
+func writeDagHTML(w io.Writer, node ipld.Node, urlPath string, indent string) error {
+	var err error
+	write := func(s string) {
+		if err == nil {
+			_, err = io.WriteString(w, s)
+		}
+	}
+	// field names including a slash can't be part of a path
+	fieldLink := func(name, label string) string {
+		if name == "" || strings.Contains(name, "/") {
+			return html.EscapeString(label)
+		}
+		return `<a href="` + html.EscapeString(urlPath+"/"+url.PathEscape(name)) + `">` + html.EscapeString(label) + `</a>`
+	}
+
+	switch node.Kind() {
+	case ipld.Kind_Map:
+		write("{")
+		it := node.MapIterator()
+		first := true
+		for !it.Done() {
+			k, v, e := it.Next()
+			if e != nil {
+				return e
+			}
+			key, e := k.AsString()
+			if e != nil {
+				return e
+			}
+			if !first {
+				write(",")
+			}
+			first = false
+			write("\n" + indent + "  " + fieldLink(key, strconv.Quote(key)) + ": ")
+			if e := writeDagHTML(w, v, urlPath+"/"+url.PathEscape(key), indent+"  "); e != nil {
+				return e
+			}
+		}
+		if !first {
+			write("\n" + indent)
+		}
+		write("}")
+	case ipld.Kind_List:
+		write("[")
+		it := node.ListIterator()
+		first := true
+		for !it.Done() {
+			idx, v, e := it.Next()
+			if e != nil {
+				return e
+			}
+			if !first {
+				write(",")
+			}
+			first = false
+			name := strconv.FormatInt(idx, 10)
+			write("\n" + indent + "  " + fieldLink(name, "/* "+name+" */") + " ")
+			if e := writeDagHTML(w, v, urlPath+"/"+name, indent+"  "); e != nil {
+				return e
+			}
+		}
+		if !first {
+			write("\n" + indent)
+		}
+		write("]")
+	case ipld.Kind_Link:
+		lnk, e := node.AsLink()
+		if e != nil {
+			return e
+		}
+		s := lnk.String()
+		if cl, ok := lnk.(cidlink.Link); ok {
+			s = cl.Cid.String()
+		}
+		write(`{"/": "<a href="/ipfs/` + html.EscapeString(s) + `">` + html.EscapeString(s) + `</a>"}`)
+	case ipld.Kind_Bytes:
+		b, e := node.AsBytes()
+		if e != nil {
+			return e
+		}
+		write(`{"/": {"bytes": "` + base64.RawStdEncoding.EncodeToString(b) + `"}}`)
+	case ipld.Kind_String:
+		s, e := node.AsString()
+		if e != nil {
+			return e
+		}
+		write(html.EscapeString(strconv.Quote(s)))
+	case ipld.Kind_Int:
+		n, e := node.AsInt()
+		if e != nil {
+			return e
+		}
+		write(strconv.FormatInt(n, 10))
+	case ipld.Kind_Float:
+		f, e := node.AsFloat()
+		if e != nil {
+			return e
+		}
+		write(strconv.FormatFloat(f, 'g', -1, 64))
+	case ipld.Kind_Bool:
+		b, e := node.AsBool()
+		if e != nil {
+			return e
+		}
+		write(strconv.FormatBool(b))
+	case ipld.Kind_Null:
+		write("null")
+	default:
+		return fmt.Errorf("unsupported IPLD kind %s", node.Kind())
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package corehttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/interface-go-ipfs-core/options"
+)
+
+func TestGatewayCodec(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, mockNamesys{})
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonBlock := []byte(`{"file":{"/":"` + file.Cid().String() + `"},"list":[1,"two"],"name":"foo"}`)
+	p, err := api.Block().Put(ctx, bytes.NewReader(jsonBlock), options.Block.CidCodec("dag-json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := p.Path().Cid().String()
+
+	get := func(t *testing.T, urlPath string, accept string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, res.StatusCode, body)
+		}
+		return res, string(body)
+	}
+
+	t.Run("implicit request returns the block as-is", func(t *testing.T) {
+		res, body := get(t, "/ipfs/"+root, "")
+		if body != string(jsonBlock) {
+			t.Errorf("unexpected body %s", body)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.dag-json" {
+			t.Errorf("unexpected Content-Type %s", ct)
+		}
+		if etag := res.Header.Get("Etag"); etag != `"`+root+`"` {
+			t.Errorf("unexpected Etag %s", etag)
+		}
+	})
+
+	t.Run("dag-cbor round-trips to the same dag-json", func(t *testing.T) {
+		res, cborBody := get(t, "/ipfs/"+root+"?format=dag-cbor", "")
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.dag-cbor" {
+			t.Errorf("unexpected Content-Type %s", ct)
+		}
+		if etag := res.Header.Get("Etag"); etag != `"`+root+`.dag-cbor"` {
+			t.Errorf("unexpected Etag %s", etag)
+		}
+		cborCid, err := api.Block().Put(ctx, strings.NewReader(cborBody), options.Block.CidCodec("dag-cbor"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, body := get(t, "/ipfs/"+cborCid.Path().Cid().String(), "application/vnd.ipld.dag-json")
+		if body != string(jsonBlock) {
+			t.Errorf("unexpected body %s", body)
+		}
+	})
+
+	t.Run("path traversal into fields", func(t *testing.T) {
+		res, body := get(t, "/ipfs/"+root+"/list/1", "application/vnd.ipld.dag-json")
+		if body != `"two"` {
+			t.Errorf("unexpected body %s", body)
+		}
+		if etag := res.Header.Get("Etag"); etag != `"`+root+`.dag-json/list%2F1"` {
+			t.Errorf("unexpected Etag %s", etag)
+		}
+		_, body = get(t, "/ipfs/"+root+"/name?format=dag-json", "")
+		if body != `"foo"` {
+			t.Errorf("unexpected body %s", body)
+		}
+	})
+
+	t.Run("path traversal through links", func(t *testing.T) {
+		_, body := get(t, "/ipfs/"+root+"/file", "")
+		if body != "hello" {
+			t.Errorf("unexpected body %s", body)
+		}
+	})
+
+	t.Run("HTML view for browsers", func(t *testing.T) {
+		res, body := get(t, "/ipfs/"+root, "text/html,application/xhtml+xml,*/*;q=0.8")
+		if ct := res.Header.Get("Content-Type"); ct != "text/html" {
+			t.Errorf("unexpected Content-Type %s", ct)
+		}
+		for _, s := range []string{
+			`<a href="/ipfs/` + file.Cid().String() + `">`,
+			`<a href="/ipfs/` + root + `/list/1">`,
+			`<a href="/ipfs/` + root + `/name">&#34;name&#34;</a>: &#34;foo&#34;`,
+		} {
+			if !strings.Contains(body, s) {
+				t.Errorf("expected HTML to include %s, got %s", s, body)
+			}
+		}
+	})
+}
+
+func TestAcceptsHTML(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"application/vnd.ipld.dag-json":     false,
+		"text/html":                         true,
+		"text/html;q=0.9":                   true,
+		"application/xhtml+xml, text/html ": true,
+	} {
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		if acceptsHTML(r) != expected {
+			t.Errorf("expected acceptsHTML(%q) to be %t", accept, expected)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/tracing"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	mc "github.com/multiformats/go-multicodec"
+	mh "github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// routingNodeAPI is implemented by NodeAPIs which can read records from the
+// routing system, such as the CoreAPI of the node
+type routingNodeAPI interface {
+	Routing() *coreapi.RoutingAPI
+}
+
+// serveIpnsRecord returns the signed IPNS record of /ipns/{key}, so clients
+// can verify the signature themselves instead of trusting the resolution
+// done by the gateway
+func (i *gatewayHandler) serveIpnsRecord(ctx context.Context, w http.ResponseWriter, r *http.Request, contentPath ipath.Path, begin time.Time) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeIpnsRecord", trace.WithAttributes(attribute.String("path", contentPath.String())))
+	defer span.End()
+
+	key, err := ipnsRecordKey(contentPath)
+	if err != nil {
+		webError(w, "invalid IPNS record request", err, http.StatusBadRequest)
+		return
+	}
+
+	rapi, ok := i.api.(routingNodeAPI)
+	if !ok {
+		err := errors.New("gateway is not backed by a routing system")
+		webError(w, "failed to fetch IPNS record", err, http.StatusNotImplemented)
+		return
+	}
+	record, err := rapi.Routing().Get(ctx, "/ipns/"+key.String())
+	if err != nil {
+		webError(w, "ipfs routing get /ipns/"+key.String(), err, http.StatusNotFound)
+		return
+	}
+
+	var entry ipns_pb.IpnsEntry
+	if err := entry.Unmarshal(record); err != nil {
+		webError(w, "failed to parse IPNS record", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Set Content-Disposition
+	var name string
+	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
+		name = urlFilename
+	} else {
+		name = key.String() + ".ipns-record"
+	}
+	setContentDispositionHeader(w, name, "attachment")
+
+	// The record is mutable, it can be cached only for its TTL
+	if ttl := entry.GetTtl(); ttl > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(time.Duration(ttl).Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	// Etag is based on the record itself, so it changes on every update
+	recordCid, err := cid.NewPrefixV1(uint64(mc.Raw), mh.SHA2_256).Sum(record)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	w.Header().Set("Etag", `"`+recordCid.String()+`.ipns-record"`)
+	w.Header().Set("Content-Type", "application/vnd.ipfs.ipns-record")
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+
+	// ServeContent will take care of
+	// If-None-Match+Etag, Content-Length and range requests
+	_, dataSent, _ := ServeContent(w, r, name, noModtime, bytes.NewReader(record))
+
+	if dataSent {
+		// Update metrics
+		i.ipnsRecordGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+	}
+}
+
+// ipnsRecordKey returns the key of /ipns/{key}. IPNS records only exist for
+// keys, so paths with DNSLink names or with a subpath are rejected.
+func ipnsRecordKey(contentPath ipath.Path) (peer.ID, error) {
+	if contentPath.Namespace() != "ipns" {
+		return "", errors.New("IPNS records are only available for /ipns/ paths")
+	}
+	segs := strings.Split(strings.TrimSuffix(contentPath.String(), "/"), "/")
+	if len(segs) != 3 {
+		return "", errors.New("IPNS record path can't include a subpath")
+	}
+	key, err := peer.Decode(segs[2])
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid IPNS key: %w", segs[2], err)
+	}
+	return key, nil
+}
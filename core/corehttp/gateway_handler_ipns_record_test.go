@@ -0,0 +1,127 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ipns "github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestGatewayIpnsRecord(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sk, pk, err := ci.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ipns.Create(sk, []byte(emptyDir), 1, time.Now().Add(time.Hour), 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := entry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Routing.PutValue(context.Background(), ipns.RecordKey(pid), record); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(t *testing.T, urlPath string, accept string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	for _, test := range []struct {
+		name, path, accept string
+	}{
+		{"format param", "/ipns/" + pid.String() + "?format=ipns-record", ""},
+		{"accept header", "/ipns/" + pid.String(), "application/vnd.ipfs.ipns-record"},
+		{"CIDv1 key", "/ipns/" + peer.ToCid(pid).String() + "?format=ipns-record", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, body := get(t, test.path, test.accept)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusOK, res.StatusCode, body)
+			}
+			if !bytes.Equal(body, record) {
+				t.Fatal("response is not the published record")
+			}
+			var got ipns_pb.IpnsEntry
+			if err := got.Unmarshal(body); err != nil {
+				t.Fatal(err)
+			}
+			if err := ipns.Validate(pk, &got); err != nil {
+				t.Fatalf("record signature can't be verified: %s", err)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipfs.ipns-record" {
+				t.Errorf("unexpected Content-Type %s", ct)
+			}
+			if cc := res.Header.Get("Cache-Control"); cc != "public, max-age=300" {
+				t.Errorf("unexpected Cache-Control %s", cc)
+			}
+		})
+	}
+
+	t.Run("unknown key", func(t *testing.T) {
+		_, otherPk, err := ci.GenerateEd25519Key(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := peer.IDFromPublicKey(otherPk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, _ := get(t, "/ipns/"+other.String()+"?format=ipns-record", "")
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status %d, got %d", http.StatusNotFound, res.StatusCode)
+		}
+	})
+
+	for _, invalid := range []string{
+		"/ipns/example.net?format=ipns-record",
+		"/ipns/" + pid.String() + "/foo?format=ipns-record",
+		emptyDir + "?format=ipns-record",
+	} {
+		res, _ := get(t, invalid, "")
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, invalid, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package corehttp
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"go.uber.org/zap"
+)
+
+const (
+	// redirectsFileName is the name of the file with redirect rules at the
+	// root of a website hosted on a subdomain or DNSLink gateway
+	redirectsFileName = "_redirects"
+	// maxRedirectsFileSize caps the size of the _redirects file, as the file
+	// is read and parsed for every request for a path that does not exist
+	maxRedirectsFileSize = 64 << 10
+)
+
+var redirectPlaceholder = regexp.MustCompile(`^:[A-Za-z_][A-Za-z0-9_]*$`)
+
+// redirectRule is a single line of a Netlify-style _redirects file:
+//
+//	/from/:placeholder/*  /to/:placeholder/:splat  [status]
+type redirectRule struct {
+	from   string
+	to     string
+	status int
+}
+
+// parseRedirectsFile parses rules from a _redirects file.
+// Empty lines and lines starting with # are ignored.
+func parseRedirectsFile(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRedirectRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseRedirectRule(line string) (redirectRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return redirectRule{}, fmt.Errorf("missing 'to' path")
+	}
+	if len(fields) > 3 {
+		return redirectRule{}, fmt.Errorf("too many fields")
+	}
+	rule := redirectRule{from: fields[0], to: fields[1], status: http.StatusMovedPermanently}
+
+	if !strings.HasPrefix(rule.from, "/") {
+		return redirectRule{}, fmt.Errorf("'from' path must begin with '/'")
+	}
+	segs := strings.Split(strings.TrimPrefix(rule.from, "/"), "/")
+	for idx, seg := range segs {
+		if strings.Contains(seg, "*") && (seg != "*" || idx != len(segs)-1) {
+			return redirectRule{}, fmt.Errorf("splat '*' is only allowed as the last segment of 'from' path")
+		}
+		if strings.HasPrefix(seg, ":") && !redirectPlaceholder.MatchString(seg) {
+			return redirectRule{}, fmt.Errorf("invalid placeholder %q", seg)
+		}
+	}
+
+	if len(fields) == 3 {
+		status, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return redirectRule{}, fmt.Errorf("invalid status code %q", fields[2])
+		}
+		rule.status = status
+	}
+	switch rule.status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		if !strings.HasPrefix(rule.to, "/") && !strings.HasPrefix(rule.to, "http://") && !strings.HasPrefix(rule.to, "https://") {
+			return redirectRule{}, fmt.Errorf("'to' must be a path beginning with '/' or a http(s) URL")
+		}
+	case http.StatusOK, http.StatusNotFound, http.StatusGone, http.StatusUnavailableForLegalReasons:
+		// the content is served from within the website
+		if !strings.HasPrefix(rule.to, "/") {
+			return redirectRule{}, fmt.Errorf("'to' must be a path beginning with '/' for status %d", rule.status)
+		}
+	default:
+		return redirectRule{}, fmt.Errorf("unsupported status code %d", rule.status)
+	}
+	return rule, nil
+}
+
+// match returns 'to' of the rule with placeholders and splat replaced with
+// the matching segments of the path
+func (rule redirectRule) match(urlPath string) (string, bool) {
+	fromSegs := strings.Split(strings.Trim(rule.from, "/"), "/")
+	pathSegs := strings.Split(strings.Trim(urlPath, "/"), "/")
+	params := make(map[string]string)
+	for idx, seg := range fromSegs {
+		if seg == "*" {
+			// (/foo/* also matches /foo)
+			if idx < len(pathSegs) {
+				params["splat"] = strings.Join(pathSegs[idx:], "/")
+			} else {
+				params["splat"] = ""
+			}
+			return rule.expand(params), true
+		}
+		if idx >= len(pathSegs) {
+			return "", false
+		}
+		if strings.HasPrefix(seg, ":") {
+			params[seg[1:]] = pathSegs[idx]
+			continue
+		}
+		if seg != pathSegs[idx] {
+			return "", false
+		}
+	}
+	if len(fromSegs) != len(pathSegs) {
+		return "", false
+	}
+	return rule.expand(params), true
+}
+
+func (rule redirectRule) expand(params map[string]string) string {
+	// replace longer names first, so :splat is not replaced as :s + plat
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	to := rule.to
+	for _, name := range names {
+		to = strings.ReplaceAll(to, ":"+name, params[name])
+	}
+	return to
+}
+
+// hasOriginIsolation returns true for requests to subdomain and DNSLink
+// gateways, where the website root is the root of the content path
+func hasOriginIsolation(r *http.Request) bool {
+	_, ok := r.Context().Value("gw-hostname").(string)
+	return ok
+}
+
+// splitWebsitePath splits /ipfs/cid/foo/bar or /ipns/name/foo/bar into
+// the website root (/ipfs/cid) and the path within the website (/foo/bar)
+func splitWebsitePath(contentPath ipath.Path) (string, string) {
+	segs := strings.SplitN(contentPath.String(), "/", 4)
+	if len(segs) < 4 {
+		return contentPath.String(), "/"
+	}
+	return strings.Join(segs[:3], "/"), "/" + segs[3]
+}
+
+// serveRedirectsIfPresent applies the first matching rule from the _redirects
+// file at the root of the website. It returns true if the request was handled.
+func (i *gatewayHandler) serveRedirectsIfPresent(w http.ResponseWriter, r *http.Request, contentPath ipath.Path, begin time.Time, logger *zap.SugaredLogger) bool {
+	if !hasOriginIsolation(r) {
+		return false
+	}
+	if responseFormat, _, err := customResponseFormat(r); err != nil || responseFormat != "" {
+		return false
+	}
+
+	rootPath, urlPath := splitWebsitePath(contentPath)
+	redirectsPath := ipath.New(rootPath + "/" + redirectsFileName)
+	node, err := i.api.Unixfs().Get(r.Context(), redirectsPath)
+	if err != nil {
+		return false
+	}
+	defer node.Close()
+	f, ok := node.(files.File)
+	if !ok {
+		return false
+	}
+
+	size, err := f.Size()
+	if err != nil {
+		internalWebError(w, err)
+		return true
+	}
+	if size > maxRedirectsFileSize {
+		err := fmt.Errorf("%s is %d bytes, the maximum size is %d bytes", redirectsFileName, size, maxRedirectsFileSize)
+		webError(w, "could not read "+redirectsFileName, err, http.StatusInternalServerError)
+		return true
+	}
+	rules, err := parseRedirectsFile(io.LimitReader(f, maxRedirectsFileSize))
+	if err != nil {
+		webError(w, "could not parse "+html.EscapeString(redirectsPath.String()), err, http.StatusInternalServerError)
+		return true
+	}
+
+	for _, rule := range rules {
+		to, ok := rule.match(urlPath)
+		if !ok {
+			continue
+		}
+		logger.Debugw("applying redirect rule", "from", rule.from, "to", to, "status", rule.status)
+		switch rule.status {
+		case http.StatusOK, http.StatusNotFound, http.StatusGone, http.StatusUnavailableForLegalReasons:
+			return i.serveRedirectTarget(w, r, ipath.New(rootPath+to), rule.status, begin, logger)
+		default:
+			i.addUserHeaders(w)
+			http.Redirect(w, r, to, rule.status)
+			return true
+		}
+	}
+	return false
+}
+
+// serveRedirectTarget serves a file from within the website with the status
+// code of the rule (rewrite or custom error page). The target is checked
+// against the denylists like a requested path.
+func (i *gatewayHandler) serveRedirectTarget(w http.ResponseWriter, r *http.Request, targetPath ipath.Path, status int, begin time.Time, logger *zap.SugaredLogger) bool {
+	if i.handleDenylisted(w, targetPath, nil, logger) {
+		return true
+	}
+	resolvedPath, err := i.api.ResolvePath(r.Context(), targetPath)
+	if err != nil {
+		return false
+	}
+	if i.handleDenylisted(w, targetPath, resolvedPath, logger) {
+		return true
+	}
+	node, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
+	if err != nil {
+		return false
+	}
+	defer node.Close()
+
+	// a directory is served using its index.html
+	if _, ok := node.(files.Directory); ok {
+		targetPath = ipath.Join(targetPath, "index.html")
+		if i.handleDenylisted(w, targetPath, nil, logger) {
+			return true
+		}
+		if resolvedPath, err = i.api.ResolvePath(r.Context(), targetPath); err != nil {
+			return false
+		}
+		if i.handleDenylisted(w, targetPath, resolvedPath, logger) {
+			return true
+		}
+		if node, err = i.api.Unixfs().Get(r.Context(), resolvedPath); err != nil {
+			return false
+		}
+		defer node.Close()
+	}
+	f, ok := node.(files.File)
+	if !ok {
+		return false
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-Ipfs-Path", targetPath.String())
+
+	if status == http.StatusOK {
+		// rewrite is served as if the target was requested directly
+		i.serveFile(r.Context(), w, r, resolvedPath, targetPath, f, begin)
+		return true
+	}
+
+	size, err := f.Size()
+	if err != nil {
+		return false
+	}
+	ctype := mime.TypeByExtension(gopath.Ext(targetPath.String()))
+	if ctype == "" {
+		ctype = "text/html"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(status)
+	_, _ = io.CopyN(w, f, size)
+	return true
+}
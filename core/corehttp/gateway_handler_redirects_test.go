@@ -0,0 +1,269 @@
+package corehttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	files "github.com/ipfs/go-ipfs-files"
+	path "github.com/ipfs/go-path"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/denylist"
+)
+
+func TestParseRedirectsFile(t *testing.T) {
+	rules, err := parseRedirectsFile(strings.NewReader(`
+# comment
+/old        /new
+/temp       https://example.com/   302
+/app/*      /app/index.html        200
+/missing    /404.html              404
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []redirectRule{
+		{"/old", "/new", 301},
+		{"/temp", "https://example.com/", 302},
+		{"/app/*", "/app/index.html", 200},
+		{"/missing", "/404.html", 404},
+	}
+	if len(rules) != len(expected) {
+		t.Fatalf("expected %d rules, got %v", len(expected), rules)
+	}
+	for idx, rule := range rules {
+		if rule != expected[idx] {
+			t.Errorf("expected rule %v, got %v", expected[idx], rule)
+		}
+	}
+
+	for _, malformed := range []string{
+		"/from",
+		"/from /to 301 extra",
+		"from /to",
+		"/from /to abc",
+		"/from /to 418",
+		"/from example.com",
+		"/from https://example.com/ 200",
+		"/*/from /to",
+		"/from* /to",
+		"/:1from /to",
+	} {
+		if _, err := parseRedirectsFile(strings.NewReader(malformed)); err == nil {
+			t.Errorf("expected error for %q", malformed)
+		} else if !strings.HasPrefix(err.Error(), "line 1:") {
+			t.Errorf("expected error with line number, got %s", err)
+		}
+	}
+}
+
+func TestRedirectRuleMatch(t *testing.T) {
+	for _, test := range []struct {
+		from, to, path string
+		expected       string
+		ok             bool
+	}{
+		{"/old", "/new", "/old", "/new", true},
+		{"/old", "/new", "/old/", "/new", true},
+		{"/old", "/new", "/older", "", false},
+		{"/old", "/new", "/old/foo", "", false},
+		{"/posts/:year/:slug", "/blog/:year/:slug.html", "/posts/2022/hello", "/blog/2022/hello.html", true},
+		{"/posts/:year/:slug", "/blog/:year/:slug.html", "/posts/2022", "", false},
+		{"/docs/*", "/v2/:splat", "/docs/a/b/c", "/v2/a/b/c", true},
+		{"/docs/*", "/v2/:splat", "/docs", "/v2/", true},
+		{"/:s/*", "/:splat/:s", "/x/y", "/y/x", true},
+		{"/*", "/index.html", "/anything/at/all", "/index.html", true},
+	} {
+		to, ok := redirectRule{from: test.from, to: test.to}.match(test.path)
+		if ok != test.ok || to != test.expected {
+			t.Errorf("%s -> %s for %s: expected (%q, %t), got (%q, %t)", test.from, test.to, test.path, test.expected, test.ok, to, ok)
+		}
+	}
+}
+
+func TestGatewayRedirects(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(`
+/old/:name   /new/:name         301
+/app/*       /app/index.html    200
+/*           /404.html          404
+`)),
+		"404.html": files.NewBytesFile([]byte("not found")),
+		"new": files.NewMapDirectory(map[string]files.Node{
+			"page": files.NewBytesFile([]byte("page")),
+		}),
+		"app": files.NewMapDirectory(map[string]files.Node{
+			"index.html": files.NewBytesFile([]byte("app")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(k.String())
+
+	get := func(t *testing.T, host, urlPath string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		// (body of a redirect is already closed by doWithoutRedirect)
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	t.Run("redirect with placeholder", func(t *testing.T) {
+		res, _ := get(t, "example.net", "/old/page")
+		if res.StatusCode != http.StatusMovedPermanently {
+			t.Fatalf("expected status %d, got %d", http.StatusMovedPermanently, res.StatusCode)
+		}
+		if loc := res.Header.Get("Location"); loc != "/new/page" {
+			t.Errorf("unexpected Location %s", loc)
+		}
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		res, body := get(t, "example.net", "/app/some/route")
+		if res.StatusCode != http.StatusOK || body != "app" {
+			t.Errorf("expected 200 with app, got %d with %q", res.StatusCode, body)
+		}
+	})
+
+	t.Run("existing content is not affected", func(t *testing.T) {
+		res, body := get(t, "example.net", "/new/page")
+		if res.StatusCode != http.StatusOK || body != "page" {
+			t.Errorf("expected 200 with page, got %d with %q", res.StatusCode, body)
+		}
+	})
+
+	t.Run("custom 404", func(t *testing.T) {
+		res, body := get(t, "example.net", "/missing")
+		if res.StatusCode != http.StatusNotFound || body != "not found" {
+			t.Errorf("expected 404 with custom page, got %d with %q", res.StatusCode, body)
+		}
+		if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("unexpected Content-Type %s", ct)
+		}
+	})
+
+	t.Run("ignored on path gateway", func(t *testing.T) {
+		res, _ := get(t, "127.0.0.1", "/ipfs/"+k.Cid().String()+"/old/page")
+		if res.StatusCode == http.StatusMovedPermanently {
+			t.Errorf("_redirects should only be applied with origin isolation")
+		}
+	})
+
+	t.Run("malformed file", func(t *testing.T) {
+		bad, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+			"_redirects": files.NewBytesFile([]byte("/from /to 418")),
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns["/ipns/bad.example.net"] = path.FromString(bad.String())
+		res, body := get(t, "bad.example.net", "/from")
+		if res.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, res.StatusCode)
+		}
+		if !strings.Contains(body, "could not parse") || !strings.Contains(body, "line 1") {
+			t.Errorf("expected parse error, got %q", body)
+		}
+	})
+
+	t.Run("size cap", func(t *testing.T) {
+		big, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+			"_redirects": files.NewBytesFile([]byte(strings.Repeat("# padding\n", maxRedirectsFileSize/10+1))),
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ns["/ipns/big.example.net"] = path.FromString(big.String())
+		res, _ := get(t, "big.example.net", "/from")
+		if res.StatusCode != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, res.StatusCode)
+		}
+	})
+}
+
+func TestGatewayRedirectsDenylist(t *testing.T) {
+	ns := mockNamesys{}
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := api.Unixfs().Add(n.Context(), files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(`
+/app/*      /blocked.html   200
+/*          /errors         404
+`)),
+		"blocked.html": files.NewBytesFile([]byte("blocked")),
+		"errors": files.NewMapDirectory(map[string]files.Node{
+			"index.html": files.NewBytesFile([]byte("blocked error page")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(root.String())
+
+	var deny []string
+	for _, p := range []string{"blocked.html", "errors/index.html"} {
+		resolved, err := api.ResolvePath(n.Context(), ipath.Join(root, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deny = append(deny, "/ipfs/"+resolved.Cid().String())
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.deny"), []byte(strings.Join(deny, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n.Denylist = denylist.New(dir)
+	if _, err := n.Denylist.Reload(true); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewritten targets and the index.html of a custom error page are
+	// checked against the denylist
+	for _, urlPath := range []string{"/app/route", "/missing"} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "example.net"
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusGone || !strings.Contains(string(body), denylist.ErrDenied.Error()) {
+			t.Errorf("%s: expected status %d, got %d: %s", urlPath, http.StatusGone, res.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package corehttp
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/ipfs/kubo/core/coreunix"
+	"github.com/ipfs/kubo/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serveTAR returns a TAR archive of a UnixFS file or directory,
+// the same as 'ipfs get --archive' would produce
+func (i *gatewayHandler) serveTAR(ctx context.Context, w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, contentPath ipath.Path, begin time.Time) {
+	ctx, span := tracing.Span(ctx, "Gateway", "ServeTAR", trace.WithAttributes(attribute.String("path", resolvedPath.String())))
+	defer span.End()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	file, err := i.api.Unixfs().Get(ctx, resolvedPath)
+	if err != nil {
+		webError(w, "ipfs get "+html.EscapeString(contentPath.String()), err, http.StatusBadRequest)
+		return
+	}
+	file.Close()
+
+	rootCid := resolvedPath.Cid()
+	nd, err := i.api.Dag().Get(ctx, rootCid)
+	if err != nil {
+		webError(w, "ipfs get "+html.EscapeString(contentPath.String()), err, http.StatusInternalServerError)
+		return
+	}
+
+	// Set Content-Disposition
+	var name string
+	if urlFilename := r.URL.Query().Get("filename"); urlFilename != "" {
+		name = urlFilename
+	} else {
+		name = rootCid.String() + ".tar"
+	}
+	setContentDispositionHeader(w, name, "attachment")
+
+	// Set Cache-Control (same logic as for a regular files)
+	addCacheControlHeaders(w, r, contentPath, rootCid)
+
+	// Weak Etag W/ because the archive is streamed and we don't want to
+	// promise byte-for-byte identical responses, same as with CAR
+	etag := `W/` + getEtag(r, rootCid)
+	w.Header().Set("Etag", etag)
+
+	// Finish early if Etag match
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if !i.allowDagNetwork(w, r, []cid.Cid{rootCid}, carParams{}) {
+		return
+	}
+
+	// Make it clear we don't support range-requests over a tar stream
+	w.Header().Set("Accept-Ranges", "none")
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("X-Content-Type-Options", "nosniff") // no funny business in the browsers :^)
+
+	// Same writer as 'ipfs get', with the mode and mtime stored in the nodes
+	// and a fixed mtime for the other entries
+	tw := coreunix.NewTarWriter(ctx, i.api.Dag(), w)
+	tw.ModTime = noModtime
+	if err := tw.WriteNode(nd, rootCid.String()); err != nil {
+		// Same as with CAR streams, we can only return the error as a trailer.
+		// The end-of-archive marker is not written, so tar tools will report
+		// the archive as truncated instead of extracting partial contents.
+		w.Header().Set("X-Stream-Error", err.Error())
+		return
+	}
+	if err := tw.Close(); err != nil {
+		w.Header().Set("X-Stream-Error", err.Error())
+		return
+	}
+
+	// Update metrics
+	i.tarStreamGetMetric.WithLabelValues(contentPath.Namespace()).Observe(time.Since(begin).Seconds())
+}
@@ -0,0 +1,182 @@
+package corehttp
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/core/coreunix"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+func TestGatewayTar(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, mockNamesys{})
+
+	p, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("hello")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("world")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := p.Cid().String()
+
+	res, err := http.Get(ts.URL + p.String() + "?format=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-tar" {
+		t.Errorf("unexpected Content-Type %s", ct)
+	}
+	if etag := res.Header.Get("Etag"); etag != `W/"`+root+`.x-tar"` {
+		t.Errorf("unexpected Etag %s", etag)
+	}
+	if cd := res.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="`+root+`.tar"`) {
+		t.Errorf("unexpected Content-Disposition %s", cd)
+	}
+
+	entries := make(map[string]string)
+	tr := tar.NewReader(res.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = string(data)
+	}
+	for name, content := range map[string]string{
+		root:                "",
+		root + "/a.txt":     "hello",
+		root + "/sub":       "",
+		root + "/sub/b.txt": "world",
+	} {
+		if got, ok := entries[name]; !ok || got != content {
+			t.Errorf("expected entry %q with %q, got %q (present: %t)", name, content, got, ok)
+		}
+	}
+	if len(entries) != 4 {
+		t.Errorf("expected 4 entries, got %v", entries)
+	}
+}
+
+func TestGatewayTarModeMtime(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, mockNamesys{})
+
+	mtime := time.Unix(1577934245, 0)
+	file := dag.NodeWithData(ft.FilePBData([]byte("run"), 3))
+	dir := dag.NodeWithData(ft.FolderPBData())
+	for nd, mode := range map[*dag.ProtoNode]os.FileMode{file: 0750, dir: 0700} {
+		if err := coreunix.SetMode(nd, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := coreunix.SetModTime(nd, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dir.AddNodeLink("run.sh", file); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().AddMany(ctx, []ipld.Node{file, dir}); err != nil {
+		t.Fatal(err)
+	}
+	root := dir.Cid().String()
+
+	res, err := http.Get(ts.URL + "/ipfs/" + root + "?format=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// the headers have the mode and mtime of the nodes
+	want := map[string]os.FileMode{root: 0700, root + "/run.sh": 0750}
+	tr := tar.NewReader(res.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		mode, ok := want[hdr.Name]
+		if !ok {
+			t.Fatalf("unexpected entry %s", hdr.Name)
+		}
+		delete(want, hdr.Name)
+		if os.FileMode(hdr.Mode).Perm() != mode || !hdr.ModTime.Equal(mtime) {
+			t.Errorf("expected the mode %s and mtime %s of %s, got %s and %s", mode, mtime, hdr.Name, os.FileMode(hdr.Mode).Perm(), hdr.ModTime)
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("missing entries %v", want)
+	}
+}
+
+func TestGatewayTarPathTraversal(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, mockNamesys{})
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("evil")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNode, err := api.Dag().Get(ctx, file.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid dag-pb directory can have links with arbitrary names
+	dir := dag.NodeWithData(ft.FolderPBData())
+	if err := dir.AddRawLink("../../evil.txt", &ipld.Link{Cid: file.Cid(), Size: uint64(len(fileNode.RawData()))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Get(ts.URL + "/ipfs/" + dir.Cid().String() + "?format=tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// the archive is cut short before the end-of-archive marker, so all we
+	// can check on the client side is that nothing outside the root is there
+	var names []string
+	tr := tar.NewReader(res.Body)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	for _, name := range names {
+		if strings.Contains(name, "..") {
+			t.Fatalf("archive includes entry outside of the root: %s", name)
+		}
+	}
+	if len(names) != 1 || names[0] != dir.Cid().String() {
+		t.Fatalf("expected only the root directory entry, got %v", names)
+	}
+}
@@ -0,0 +1,299 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	rateLimitBudgetCached  = "cached"
+	rateLimitBudgetNetwork = "network"
+)
+
+// GatewayRateLimitConfig configures per-client limits of the gateway.
+// A limit with zero requests per minute is disabled.
+type GatewayRateLimitConfig struct {
+	CachedRequestsPerMinute  int
+	CachedBurst              int
+	NetworkRequestsPerMinute int
+	NetworkBurst             int
+	TrustedProxies           []*net.IPNet
+}
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q: %w", proxy, err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// rateLimiter keeps a token bucket for every client
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns nil if the limit is disabled. When burst is not
+// set, clients can make a minute worth of requests at once.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// take removes a token from the bucket of the client. When the bucket is
+// empty, it returns the time until the next token is available.
+func (l *rateLimiter) take(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// refund gives a token back to the bucket of the client
+func (l *rateLimiter) refund(client string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[client]; ok {
+		b.tokens = math.Min(l.burst, b.tokens+1)
+	}
+}
+
+// sweep forgets clients with full buckets, so the memory used by the limiter
+// is bound by the number of recently active clients
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientAddr returns the IP address identifying the client of the request.
+// X-Forwarded-For is only used for requests from trusted proxies, the client
+// is the right-most address in the header which is not a trusted proxy.
+func clientAddr(r *http.Request, trustedProxies []*net.IPNet) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	if !isTrustedProxy(addr, trustedProxies) {
+		return addr
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for idx := len(hops) - 1; idx >= 0; idx-- {
+		ip := net.ParseIP(hops[idx])
+		if ip == nil {
+			// everything to the left of a malformed entry is untrusted
+			break
+		}
+		addr = ip.String()
+		if !isTrustedProxy(addr, trustedProxies) {
+			break
+		}
+	}
+	return addr
+}
+
+func isTrustedProxy(addr string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBudget records the tokens taken by a request, so a request rejected
+// by the network budget gives back its token of the cached budget
+type requestBudget struct {
+	client  string
+	cached  bool
+	network bool
+}
+
+type requestBudgetKey struct{}
+
+// errRateLimited is returned when a request was rejected with 429 Too Many
+// Requests while it was handled
+var errRateLimited = errors.New("request budget of the client is exhausted")
+
+// allowRequest applies the per-client cached budget of the gateway, which is
+// used by every request. It returns false if the request was rejected with
+// 429 Too Many Requests, otherwise the request to handle, which keeps track
+// of the tokens taken for allowNetwork.
+func (i *gatewayHandler) allowRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if (i.cachedLimiter == nil && i.networkLimiter == nil) || r.Method == http.MethodOptions {
+		return r, true
+	}
+	budget := &requestBudget{client: clientAddr(r, i.config.RateLimit.TrustedProxies)}
+
+	if i.cachedLimiter != nil {
+		i.rateLimitRequestsMetric.WithLabelValues(rateLimitBudgetCached).Inc()
+		ok, wait := i.cachedLimiter.take(budget.client)
+		if !ok {
+			i.tooManyRequests(w, rateLimitBudgetCached, wait)
+			return r, false
+		}
+		budget.cached = true
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestBudgetKey{}, budget)), true
+}
+
+// allowNetwork applies the per-client network budget to a request which
+// fetches data from the network. Only the first call for a request takes a
+// token. It returns false if the request was rejected with 429 Too Many
+// Requests, in which case the token of the cached budget is given back.
+func (i *gatewayHandler) allowNetwork(w http.ResponseWriter, r *http.Request) bool {
+	budget, _ := r.Context().Value(requestBudgetKey{}).(*requestBudget)
+	if i.networkLimiter == nil || budget == nil || budget.network {
+		return true
+	}
+
+	i.rateLimitRequestsMetric.WithLabelValues(rateLimitBudgetNetwork).Inc()
+	if ok, wait := i.networkLimiter.take(budget.client); !ok {
+		if budget.cached {
+			i.cachedLimiter.refund(budget.client)
+			budget.cached = false
+		}
+		i.tooManyRequests(w, rateLimitBudgetNetwork, wait)
+		return false
+	}
+	budget.network = true
+	return true
+}
+
+// usedNetwork tells if the network budget doesn't have to be checked for the
+// request anymore, either because it is disabled or because it was used
+func (i *gatewayHandler) usedNetwork(r *http.Request) bool {
+	budget, _ := r.Context().Value(requestBudgetKey{}).(*requestBudget)
+	return i.networkLimiter == nil || budget == nil || budget.network
+}
+
+// resolvePath resolves the content path of the request. With a network budget,
+// the path is first resolved with the data in the local repository, and the
+// budget is only used when that fails. errRateLimited is returned when the
+// request was rejected.
+func (i *gatewayHandler) resolvePath(w http.ResponseWriter, r *http.Request, contentPath ipath.Path) (ipath.Resolved, error) {
+	if !i.usedNetwork(r) {
+		resolvedPath, err := i.offlineApi.ResolvePath(r.Context(), contentPath)
+		// mutable paths are resolved again, as records of the local
+		// repository can be older than the ones found online
+		if err == nil && !contentPath.Mutable() {
+			return resolvedPath, nil
+		}
+		if err != nil && !i.allowNetwork(w, r) {
+			return nil, errRateLimited
+		}
+	}
+	return i.api.ResolvePath(r.Context(), contentPath)
+}
+
+// allowDagNetwork applies the network budget to a response including the DAG
+// behind the path (CAR or TAR), when some blocks of the DAG are not in the
+// local repository. It returns false if the request was rejected.
+func (i *gatewayHandler) allowDagNetwork(w http.ResponseWriter, r *http.Request, pathCids []cid.Cid, params carParams) bool {
+	if i.usedNetwork(r) {
+		return true
+	}
+	if err := walkCarWith(r.Context(), i.offlineApi, pathCids, params, func(ipld.Node) error { return nil }); err == nil {
+		return true
+	}
+	return i.allowNetwork(w, r)
+}
+
+func (i *gatewayHandler) tooManyRequests(w http.ResponseWriter, budget string, wait time.Duration) {
+	i.rateLimitedMetric.WithLabelValues(budget).Inc()
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "429 Too Many Requests: "+budget+" request budget of the client is exhausted", http.StatusTooManyRequests)
+}
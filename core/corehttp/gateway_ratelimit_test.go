@@ -0,0 +1,203 @@
+package corehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	options "github.com/ipfs/interface-go-ipfs-core/options"
+	"github.com/ipfs/kubo/core/coreapi"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0, 10) != nil {
+		t.Fatal("limiter without rate should be disabled")
+	}
+
+	now := time.Unix(0, 0)
+	l := newRateLimiter(60, 2)
+	l.now = func() time.Time { return now }
+
+	for idx := 0; idx < 2; idx++ {
+		if ok, _ := l.take("a"); !ok {
+			t.Fatalf("request %d within burst was limited", idx)
+		}
+	}
+	ok, wait := l.take("a")
+	if ok {
+		t.Fatal("request over burst was not limited")
+	}
+	if wait != time.Second {
+		t.Errorf("expected to wait 1s, got %s", wait)
+	}
+	if ok, _ := l.take("b"); !ok {
+		t.Fatal("clients should have separate buckets")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.take("a"); !ok {
+		t.Fatal("bucket was not refilled")
+	}
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	l.sweep(now)
+	if len(l.buckets) != 0 {
+		t.Errorf("expected idle clients to be removed, got %d", len(l.buckets))
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("expected error for invalid proxy")
+	}
+
+	for _, test := range []struct {
+		remoteAddr, xff, expected string
+	}{
+		{"1.2.3.4:1234", "", "1.2.3.4"},
+		{"1.2.3.4:1234", "5.6.7.8", "1.2.3.4"},
+		{"192.168.1.1:1234", "5.6.7.8", "5.6.7.8"},
+		{"192.168.1.1:1234", "", "192.168.1.1"},
+		{"192.168.1.1:1234", "6.6.6.6, 5.6.7.8, 10.1.1.1", "5.6.7.8"},
+		{"192.168.1.1:1234", "6.6.6.6, garbage, 10.1.1.1", "10.1.1.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/ipfs/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.xff != "" {
+			r.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if addr := clientAddr(r, trusted); addr != test.expected {
+			t.Errorf("%s with X-Forwarded-For %q: expected %s, got %s", test.remoteAddr, test.xff, test.expected, addr)
+		}
+	}
+}
+
+func TestGatewayRateLimit(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"CachedRequestsPerMinute": 1, "CachedBurst": 2}`), &cfg.Gateway.RateLimit); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		res, err := http.Get(ts.URL + emptyDir + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if idx < 2 {
+			if res.StatusCode == http.StatusTooManyRequests {
+				t.Fatalf("request %d within burst was limited", idx)
+			}
+			continue
+		}
+		if res.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, res.StatusCode)
+		}
+		if ra := res.Header.Get("Retry-After"); ra != "60" {
+			t.Errorf("expected Retry-After 60, got %q", ra)
+		}
+	}
+}
+
+func TestGatewayRateLimitNetwork(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offlineApi, err := api.WithOptions(options.Api.Offline(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := n.Context()
+	local, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("local")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := cid.Decode("bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy") // (not stored in the repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a local directory with a child which is not stored in the repo
+	dir := dag.NodeWithData(ft.FolderPBData())
+	if err := dir.AddRawLink("missing", &ipld.Link{Cid: missing, Size: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Dag().Add(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	partial := "/ipfs/" + dir.Cid().String()
+
+	gw := newGatewayHandler(GatewayConfig{
+		RateLimit: GatewayRateLimitConfig{
+			CachedRequestsPerMinute:  1,
+			CachedBurst:              4,
+			NetworkRequestsPerMinute: 1,
+			NetworkBurst:             1,
+		},
+	}, api, offlineApi)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	if w := get(local.String()); w.Code == http.StatusTooManyRequests {
+		t.Fatal("request for local content was limited")
+	}
+	// the block of the directory is local, but the DAG of the archive is not
+	if w := get(partial + "?format=raw"); w.Code == http.StatusTooManyRequests {
+		t.Fatal("request for a local block was limited")
+	}
+	if w := get(partial + "?format=tar"); w.Code == http.StatusTooManyRequests {
+		t.Fatal("first request for missing content was limited")
+	}
+	w := get(partial + "?format=car")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request for missing content was not limited: %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), rateLimitBudgetNetwork) {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+
+	// the request rejected by the network budget did not use the cached budget
+	if w := get(local.String()); w.Code == http.StatusTooManyRequests {
+		t.Fatalf("request for local content was limited by the network budget: %s", w.Body.String())
+	}
+	w = get(local.String())
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), rateLimitBudgetCached) {
+		t.Fatalf("expected the cached budget to be exhausted, got %d", w.Code)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -15,6 +17,7 @@ import (
 	version "github.com/ipfs/kubo"
 	core "github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/denylist"
 	repo "github.com/ipfs/kubo/repo"
 
 	datastore "github.com/ipfs/go-datastore"
@@ -681,3 +684,131 @@ func TestEtagMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestGatewayTrustlessOnly(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Gateway.PublicGateways = map[string]*config.GatewaySpec{
+		"trustless.example.com": {Paths: []string{"/ipfs", "/ipns"}, TrustlessOnly: true},
+		"example.com":           {Paths: []string{"/ipfs", "/ipns"}},
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := api.Unixfs().Add(n.Context(), files.NewBytesFile([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		host   string
+		query  string
+		status int
+	}{
+		{"trustless.example.com", "", http.StatusNotAcceptable},
+		{"trustless.example.com", "?format=tar", http.StatusNotAcceptable},
+		{"trustless.example.com", "?format=dag-json", http.StatusNotAcceptable},
+		{"trustless.example.com", "?format=raw", http.StatusOK},
+		{"trustless.example.com", "?format=car", http.StatusOK},
+		{"example.com", "", http.StatusOK},
+		{"127.0.0.1", "", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+p.String()+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = test.host
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("expected status %d for %s%s, got %d", test.status, test.host, test.query, res.StatusCode)
+		}
+	}
+}
+
+func TestGatewayDenylist(t *testing.T) {
+	n, err := newNodeWithMockNamesys(mockNamesys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := api.Unixfs().Add(n.Context(), files.NewMapDirectory(map[string]files.Node{
+		"secret": files.NewBytesFile([]byte("secret")),
+		"public": files.NewBytesFile([]byte("public")),
+		"bad":    files.NewBytesFile([]byte("bad")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := api.ResolvePath(n.Context(), ipath.Join(root, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := api.ResolvePath(n.Context(), ipath.Join(root, "bad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.deny"), []byte(root.String()+"/secret\n/ipfs/"+bad.Cid().String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n.Denylist = denylist.New(dir)
+	if _, err := n.Denylist.Reload(true); err != nil {
+		t.Fatal(err)
+	}
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+	t.Cleanup(func() { ts.Close() })
+	dh.Handler, err = makeHandler(n, ts.Listener, HostnameOption(), GatewayOption(false, "/ipfs", "/ipns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{root.String() + "/public", http.StatusOK},
+		{root.String() + "/secret", http.StatusGone},
+		{root.String() + "/secret?format=raw", http.StatusGone},
+		// entries for paths only block the path
+		{"/ipfs/" + secret.Cid().String(), http.StatusOK},
+		// entries for CIDs also block other paths to the content
+		{"/ipfs/" + bad.Cid().String(), http.StatusGone},
+		{root.String() + "/bad", http.StatusGone},
+	} {
+		res, err := http.Get(ts.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("%s: expected status %d, got %d: %s", test.path, test.status, res.StatusCode, body)
+		}
+	}
+}
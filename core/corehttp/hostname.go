@@ -104,7 +104,7 @@ func HostnameOption() ServeOption {
 
 					// Not a subdomain resource, continue with path processing
 					// Example: 127.0.0.1:8080/ipfs/{CID}, ipfs.io/ipfs/{CID} etc
-					childMux.ServeHTTP(w, r)
+					childMux.ServeHTTP(w, withGatewaySpecContext(r, gw))
 					return
 				}
 				// Not a whitelisted path
@@ -113,7 +113,7 @@ func HostnameOption() ServeOption {
 				if !gw.NoDNSLink && isDNSLinkName(r.Context(), coreAPI, host) {
 					// rewrite path and handle as DNSLink
 					r.URL.Path = "/ipns/" + stripPort(host) + r.URL.Path
-					childMux.ServeHTTP(w, withHostnameContext(r, host))
+					childMux.ServeHTTP(w, withGatewaySpecContext(withHostnameContext(r, host), gw))
 					return
 				}
 
@@ -209,7 +209,7 @@ func HostnameOption() ServeOption {
 				r.URL.Path = pathPrefix + r.URL.Path
 
 				// Serve path request
-				childMux.ServeHTTP(w, withHostnameContext(r, gwHostname))
+				childMux.ServeHTTP(w, withGatewaySpecContext(withHostnameContext(r, gwHostname), gw))
 				return
 			}
 			// We don't have a known gateway. Fallback on DNSLink lookup
@@ -254,6 +254,14 @@ func withHostnameContext(r *http.Request, hostname string) *http.Request {
 	return r.WithContext(ctx)
 }
 
+// Extends request context to include the spec of the known gateway which
+// matched the hostname, so the gateway handler can apply per-hostname settings
+func withGatewaySpecContext(r *http.Request, gw *config.GatewaySpec) *http.Request {
+	// nolint: staticcheck // same as gw-hostname
+	ctx := context.WithValue(r.Context(), "gw-spec", gw)
+	return r.WithContext(ctx)
+}
+
 func prepareKnownGateways(publicGateways map[string]*config.GatewaySpec) gatewayHosts {
 	var hosts gatewayHosts
 
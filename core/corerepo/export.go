@@ -0,0 +1,628 @@
+package corerepo
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/gc"
+	"github.com/ipfs/kubo/pinmeta"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipns "github.com/ipfs/go-ipns"
+	dag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-mfs"
+	uio "github.com/ipfs/go-unixfs/io"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	gocarv2 "github.com/ipld/go-car/v2"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ExportVersion is the version of the archives written by Export
+const ExportVersion = 1
+
+// Entries of the archives, in the order they are written
+const (
+	exportManifest       = "manifest.json"
+	exportConfig         = "config.json"
+	exportKeystorePrefix = "keystore/"
+	exportPins           = "pins.json"
+	exportIpnsPrefix     = "ipns/"
+	exportBlocks         = "blocks.car"
+)
+
+// ipnsPrefix is the namespace of the IPNS records in the datastore, see
+// namesys.IpnsDsKey
+var ipnsPrefix = ds.NewKey("/ipns")
+
+// importBatchSize is the number of blocks written at once by Import
+const importBatchSize = 256
+
+// ExportManifest describes an archive
+type ExportManifest struct {
+	Version int
+	// Redacted is set when the private keys were left out
+	Redacted bool
+	// FilesRoot is the root of the files API
+	FilesRoot string
+}
+
+// ExportedPin is a pin of an archive, with its info
+type ExportedPin struct {
+	Cid     string
+	Type    string
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+// ExportOptions selects what is written by Export
+type ExportOptions struct {
+	// Config is written to the archive, unless it is nil
+	Config map[string]interface{}
+	// Redacted leaves the keystore out of the archive, and is recorded in
+	// the manifest. The secrets of the config are removed by the caller.
+	Redacted bool
+}
+
+// Export writes a tar archive of the node: its config, keystore, pins with
+// their info, files root, IPNS records and the pinned blocks as a CARv2 file.
+func Export(ctx context.Context, n *core.IpfsNode, w io.Writer, opts ExportOptions) error {
+	// pins are not changed nor collected while they are exported
+	defer n.Blockstore.PinLock(ctx).Unlock(ctx)
+
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return err
+	}
+	pins, err := exportedPins(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	// the size of the CAR file is needed before it is added to the archive
+	dir, err := os.MkdirTemp("", "ipfs-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	carPath := filepath.Join(dir, exportBlocks)
+	if err := writeBlocks(ctx, n, dir, carPath, roots[0], pins); err != nil {
+		return fmt.Errorf("exporting the blocks: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	err = writeJSON(tw, exportManifest, ExportManifest{
+		Version:   ExportVersion,
+		Redacted:  opts.Redacted,
+		FilesRoot: roots[0].String(),
+	})
+	if err != nil {
+		return err
+	}
+	if opts.Config != nil {
+		if err := writeJSON(tw, exportConfig, opts.Config); err != nil {
+			return err
+		}
+	}
+	if !opts.Redacted {
+		if err := writeKeystore(n, tw); err != nil {
+			return err
+		}
+	}
+	if err := writeJSON(tw, exportPins, pins); err != nil {
+		return err
+	}
+	if err := writeIpnsRecords(ctx, n, tw); err != nil {
+		return err
+	}
+	if err := writeFile(tw, exportBlocks, carPath); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func exportedPins(ctx context.Context, n *core.IpfsNode) ([]ExportedPin, error) {
+	var pins []ExportedPin
+	for _, t := range []string{"recursive", "direct"} {
+		var keys []cid.Cid
+		var err error
+		if t == "recursive" {
+			keys, err = n.Pinning.RecursiveKeys(ctx)
+		} else {
+			keys, err = n.Pinning.DirectKeys(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range keys {
+			pin := ExportedPin{Cid: c.String(), Type: t}
+			if n.PinInfo != nil {
+				info, err := n.PinInfo.Get(ctx, c)
+				if err != nil {
+					return nil, err
+				}
+				pin.Name, pin.Meta = info.Name, info.Meta
+				if !info.Expires.IsZero() {
+					pin.Expires = &info.Expires
+				}
+			}
+			pins = append(pins, pin)
+		}
+	}
+	return pins, nil
+}
+
+// writeBlocks writes the blocks of the pins and of the files root to a CARv2
+// file, the roots of the file are the pins and the files root. A CARv1 file is
+// written to the temporary directory first, and then indexed.
+func writeBlocks(ctx context.Context, n *core.IpfsNode, tmpDir, carPath string, filesRoot cid.Cid, pins []ExportedPin) error {
+	roots := []cid.Cid{filesRoot}
+	var recursive, direct []cid.Cid
+	for _, p := range pins {
+		c, err := cid.Decode(p.Cid)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, c)
+		if p.Type == "recursive" {
+			recursive = append(recursive, c)
+		} else {
+			direct = append(direct, c)
+		}
+	}
+
+	// only the local blocks are exported
+	ng := dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+	getLinks := dag.GetLinksWithDAG(ng)
+	keys := cid.NewSet()
+	if err := gc.Descendants(ctx, getLinks, keys, append(recursive, filesRoot)); err != nil {
+		return err
+	}
+	for _, c := range direct {
+		keys.Add(c)
+	}
+
+	v1Path := filepath.Join(tmpDir, "blocks.v1.car")
+	f, err := os.Create(v1Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, w); err != nil {
+		return err
+	}
+	err = keys.ForEach(func(c cid.Cid) error {
+		block, err := n.Blockstore.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		return carutil.LdWrite(w, c.Bytes(), block.RawData())
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return gocarv2.WrapV1File(v1Path, carPath)
+}
+
+func writeKeystore(n *core.IpfsNode, tw *tar.Writer) error {
+	ks := n.Repo.Keystore()
+	names, err := ks.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		sk, err := ks.Get(name)
+		if err != nil {
+			return err
+		}
+		data, err := crypto.MarshalPrivateKey(sk)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, exportKeystorePrefix+name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeIpnsRecords(ctx context.Context, n *core.IpfsNode, tw *tar.Writer) error {
+	res, err := n.Repo.Datastore().Query(ctx, query.Query{Prefix: ipnsPrefix.String()})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		name := exportIpnsPrefix + strings.TrimPrefix(r.Key, ipnsPrefix.String()+"/")
+		if err := writeEntry(tw, name, r.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(tw *tar.Writer, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeEntry(tw, name, data)
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func writeFile(tw *tar.Writer, name, fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    st.Size(),
+		ModTime: st.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ImportOptions selects what is restored by Import
+type ImportOptions struct {
+	// Config restores the config of the archive. The datastore of the node
+	// is kept, as well as its identity when the archive is redacted.
+	Config bool
+}
+
+// ImportResult describes what was restored by Import
+type ImportResult struct {
+	Blocks      int
+	Pins        int
+	Keys        int
+	IpnsRecords int
+	// FilesRoot is the files root of the archive, when its entries were
+	// added to the files root of the node
+	FilesRoot string `json:",omitempty"`
+	Config    bool
+	// Errors are the parts of the archive which could not be restored
+	Errors []string `json:",omitempty"`
+}
+
+func (r *ImportResult) fail(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// Import restores an archive written by Export to the node, and verifies the
+// pins and the files are complete.
+func Import(ctx context.Context, n *core.IpfsNode, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	defer n.Blockstore.PinLock(ctx).Unlock(ctx)
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading the archive: %w", err)
+	}
+	if hdr.Name != exportManifest {
+		return nil, errors.New("not an archive of 'ipfs repo export', the manifest is missing")
+	}
+	var manifest ExportManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading the manifest: %w", err)
+	}
+	if manifest.Version != ExportVersion {
+		return nil, fmt.Errorf("unsupported archive version %d, expected %d", manifest.Version, ExportVersion)
+	}
+
+	res := &ImportResult{}
+	var cfg map[string]interface{}
+	var pins []ExportedPin
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading the archive: %w", err)
+		}
+
+		switch name := hdr.Name; {
+		case name == exportConfig:
+			if err := json.NewDecoder(tr).Decode(&cfg); err != nil {
+				return nil, fmt.Errorf("reading the config: %w", err)
+			}
+		case name == exportPins:
+			if err := json.NewDecoder(tr).Decode(&pins); err != nil {
+				return nil, fmt.Errorf("reading the pins: %w", err)
+			}
+		case strings.HasPrefix(name, exportKeystorePrefix):
+			imported, err := importKey(n, strings.TrimPrefix(name, exportKeystorePrefix), tr)
+			if err != nil {
+				res.fail(err)
+			} else if imported {
+				res.Keys++
+			}
+		case strings.HasPrefix(name, exportIpnsPrefix):
+			imported, err := importIpnsRecord(ctx, n, path.Base(name), tr)
+			if err != nil {
+				return nil, err
+			} else if imported {
+				res.IpnsRecords++
+			}
+		case name == exportBlocks:
+			res.Blocks, err = importBlocks(ctx, n, tr)
+			if err != nil {
+				return nil, fmt.Errorf("importing the blocks: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected entry %q in the archive", name)
+		}
+	}
+
+	// pinning recursively verifies the blocks of the pins are complete
+	for _, p := range pins {
+		if err := importPin(ctx, n, p); err != nil {
+			res.fail(fmt.Errorf("pinning %s: %w", p.Cid, err))
+			continue
+		}
+		res.Pins++
+	}
+	if err := n.Pinning.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	if manifest.FilesRoot != "" {
+		if err := importFilesRoot(ctx, n, manifest.FilesRoot); err != nil {
+			res.fail(fmt.Errorf("restoring the files root %s: %w", manifest.FilesRoot, err))
+		} else {
+			res.FilesRoot = manifest.FilesRoot
+		}
+	}
+
+	if opts.Config && cfg != nil {
+		if err := importConfig(n, cfg, manifest.Redacted); err != nil {
+			res.fail(fmt.Errorf("restoring the config: %w", err))
+		} else {
+			res.Config = true
+		}
+	}
+	return res, nil
+}
+
+// importKey adds a key of the keystore, a key with the same name must be the
+// same key
+func importKey(n *core.IpfsNode, name string, r io.Reader) (bool, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	sk, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return false, fmt.Errorf("reading key %s: %w", name, err)
+	}
+
+	ks := n.Repo.Keystore()
+	has, err := ks.Has(name)
+	if err != nil {
+		return false, err
+	}
+	if !has {
+		return true, ks.Put(name, sk)
+	}
+	existing, err := ks.Get(name)
+	if err != nil {
+		return false, err
+	}
+	if !existing.Equals(sk) {
+		return false, fmt.Errorf("a different key named %s is in the keystore", name)
+	}
+	return false, nil
+}
+
+// importIpnsRecord stores an IPNS record published by the node, unless it
+// has one, and puts it to the routing as the publisher does
+func importIpnsRecord(ctx context.Context, n *core.IpfsNode, name string, r io.Reader) (bool, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	id, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(name)
+	if err != nil {
+		return false, fmt.Errorf("invalid IPNS record name %q: %w", name, err)
+	}
+	d := n.Repo.Datastore()
+	key := ipnsPrefix.ChildString(name)
+	has, err := d.Has(ctx, key)
+	if err != nil || has {
+		return false, err
+	}
+	if err := d.Put(ctx, key, data); err != nil {
+		return false, err
+	}
+	if err := d.Sync(ctx, key); err != nil {
+		return false, err
+	}
+	// expired records are republished by the daemon when it has the key
+	if err := n.Routing.PutValue(ctx, ipns.RecordKey(peer.ID(id)), data); err != nil {
+		log.Warnf("putting the IPNS record of %s: %s", peer.ID(id), err)
+	}
+	return true, nil
+}
+
+func importBlocks(ctx context.Context, n *core.IpfsNode, r io.Reader) (int, error) {
+	car, err := gocarv2.NewBlockReader(r)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	batch := make([]blocks.Block, 0, importBatchSize)
+	for {
+		block, err := car.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		// blocks are not verified when they are read from CAR files
+		sum, err := block.Cid().Prefix().Sum(block.RawData())
+		if err != nil {
+			return count, err
+		}
+		if !sum.Equals(block.Cid()) {
+			return count, fmt.Errorf("block %s is corrupted", block.Cid())
+		}
+		batch = append(batch, block)
+		if len(batch) == importBatchSize {
+			if err := n.Blockstore.PutMany(ctx, batch); err != nil {
+				return count, err
+			}
+			count += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := n.Blockstore.PutMany(ctx, batch); err != nil {
+		return count, err
+	}
+	return count + len(batch), nil
+}
+
+func importPin(ctx context.Context, n *core.IpfsNode, p ExportedPin) error {
+	c, err := cid.Decode(p.Cid)
+	if err != nil {
+		return err
+	}
+	nd, err := n.DAG.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := n.Pinning.Pin(ctx, nd, p.Type == "recursive"); err != nil {
+		return err
+	}
+	if n.PinInfo == nil {
+		return nil
+	}
+	info := pinmeta.Info{Name: p.Name, Meta: p.Meta}
+	if p.Expires != nil {
+		info.Expires = *p.Expires
+	}
+	return n.PinInfo.Put(ctx, c, info)
+}
+
+// importFilesRoot adds the entries of the files root of the archive to the
+// files root of the node, after verifying they are complete
+func importFilesRoot(ctx context.Context, n *core.IpfsNode, root string) error {
+	c, err := cid.Decode(root)
+	if err != nil {
+		return err
+	}
+	ng := dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+	if err := gc.Descendants(ctx, dag.GetLinksWithDAG(ng), cid.NewSet(), []cid.Cid{c}); err != nil {
+		return err
+	}
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	dir, err := uio.NewDirectoryFromNode(ng, nd)
+	if err != nil {
+		return err
+	}
+	err = dir.ForEachLink(ctx, func(l *ipld.Link) error {
+		p := "/" + l.Name
+		if existing, err := mfs.Lookup(n.FilesRoot, p); err == nil {
+			nd, err := existing.GetNode()
+			if err != nil {
+				return err
+			}
+			// restored by a previous import
+			if nd.Cid().Equals(l.Cid) {
+				return nil
+			}
+			return fmt.Errorf("%s already exists", p)
+		}
+		child, err := ng.Get(ctx, l.Cid)
+		if err != nil {
+			return err
+		}
+		return mfs.PutNode(n.FilesRoot, p, child)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = mfs.FlushPath(ctx, n.FilesRoot, "/")
+	return err
+}
+
+const datastoreTag = "Datastore"
+
+// importConfig replaces the config of the node, keeping its datastore and,
+// when the secrets were redacted, its identity
+func importConfig(n *core.IpfsNode, imported map[string]interface{}, redacted bool) error {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+	current, err := config.ToMap(cfg)
+	if err != nil {
+		return err
+	}
+	imported[datastoreTag] = current[datastoreTag]
+	if redacted {
+		imported[config.IdentityTag] = current[config.IdentityTag]
+	}
+	updated, err := config.FromMap(imported)
+	if err != nil {
+		return err
+	}
+	return n.Repo.SetConfig(updated)
+}
@@ -0,0 +1,358 @@
+package corerepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/kubo/pinmeta"
+	"github.com/ipfs/kubo/repo"
+	"github.com/ipfs/kubo/repo/fsrepo"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-filestore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+// Checks run by 'ipfs repo fsck'
+const (
+	FsckDatastoreSpec = "datastore-spec"
+	FsckBlocks        = "blocks"
+	FsckPins          = "pins"
+	FsckFilestore     = "filestore"
+	FsckFilesRoot     = "files-root"
+	FsckProviderQueue = "provider-queue"
+)
+
+// specFile describes the datastore on disk, see fsrepo.DatastoreSpecError
+const specFile = "datastore_spec"
+
+// filesRootKey is where the root of the MFS is stored, see node.Files
+var filesRootKey = ds.NewKey("/local/filesroot")
+
+// providerQueuePrefix is the namespace of the queue of CIDs to provide, see
+// node.ProviderQueue
+var providerQueuePrefix = ds.NewKey("/provider-v1/queue")
+
+// FsckProblem is an inconsistency found by a check
+type FsckProblem struct {
+	// Key is the block, pin or datastore key with the problem
+	Key string
+	Err string
+	// Fixed is set when the problem was fixed
+	Fixed bool `json:",omitempty"`
+}
+
+// FsckCheck is the result of a check of the repo
+type FsckCheck struct {
+	Name string
+	// Checked is the number of entries checked
+	Checked  int
+	Problems []FsckProblem `json:",omitempty"`
+	// Skipped is the reason the check did not run
+	Skipped string `json:",omitempty"`
+}
+
+// Unfixed returns the number of problems which were not fixed
+func (c *FsckCheck) Unfixed() int {
+	unfixed := 0
+	for _, p := range c.Problems {
+		if !p.Fixed {
+			unfixed++
+		}
+	}
+	return unfixed
+}
+
+func (c *FsckCheck) add(key string, err error, fixed bool) {
+	c.Problems = append(c.Problems, FsckProblem{Key: key, Err: err.Error(), Fixed: fixed})
+}
+
+// Fsck checks the consistency of a repo which is not used by a node, fixing
+// the problems found when Fix is set.
+type Fsck struct {
+	Repo repo.Repo
+	Fix  bool
+}
+
+// OpenFsck opens the repo for the checks. The datastore_spec file is checked
+// first, as the repo can't be opened when it does not match the config: the
+// check is returned with a nil Fsck when it can't be fixed.
+func OpenFsck(repoPath, configFile string, fix bool) (*Fsck, FsckCheck, error) {
+	check := FsckCheck{Name: FsckDatastoreSpec, Checked: 1}
+
+	r, err := fsrepo.OpenWithUserConfig(repoPath, configFile)
+	var specErr *fsrepo.DatastoreSpecError
+	if !errors.As(err, &specErr) {
+		if err != nil {
+			return nil, check, err
+		}
+		return &Fsck{Repo: r, Fix: fix}, check, nil
+	}
+
+	// only a missing file can be fixed, a datastore which does not match
+	// the config must be converted
+	if specErr.Disk != "" || !fix {
+		check.add(specFile, specErr, false)
+		return nil, check, nil
+	}
+	if err := fsrepo.WriteDatastoreSpec(repoPath, configFile); err != nil {
+		return nil, check, err
+	}
+	check.add(specFile, specErr, true)
+
+	r, err = fsrepo.OpenWithUserConfig(repoPath, configFile)
+	if err != nil {
+		return nil, check, err
+	}
+	return &Fsck{Repo: r, Fix: fix}, check, nil
+}
+
+// Close closes the repo
+func (f *Fsck) Close() error {
+	return f.Repo.Close()
+}
+
+// blockstore returns the blockstore of the repo, with the filestore when it
+// is enabled
+func (f *Fsck) blockstore() bstore.Blockstore {
+	bs := bstore.NewBlockstore(f.Repo.Datastore())
+	if fm := f.Repo.FileManager(); fm != nil {
+		return filestore.NewFilestore(bs, fm)
+	}
+	return bs
+}
+
+// CheckBlocks verifies the hashes of the blocks, corrupt blocks are deleted
+// by the fix.
+func (f *Fsck) CheckBlocks(ctx context.Context) (FsckCheck, error) {
+	check := FsckCheck{Name: FsckBlocks}
+
+	bs := bstore.NewBlockstore(f.Repo.Datastore())
+	bs.HashOnRead(false)
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return check, err
+	}
+	for k := range keys {
+		check.Checked++
+		block, err := bs.Get(ctx, k)
+		if err != nil {
+			check.add(k.String(), err, false)
+			continue
+		}
+		sum, err := k.Prefix().Sum(block.RawData())
+		if err != nil {
+			check.add(k.String(), err, false)
+			continue
+		}
+		if sum.Equals(k) {
+			continue
+		}
+		if f.Fix {
+			if err := bs.DeleteBlock(ctx, k); err != nil {
+				return check, err
+			}
+		}
+		check.add(k.String(), bstore.ErrHashMismatch, f.Fix)
+	}
+	return check, ctx.Err()
+}
+
+// CheckPins checks the blocks of the pins are stored, and the info of the
+// pins is not left over from removed pins. The leftover info is deleted by
+// the fix, while missing blocks must be repaired with 'ipfs pin verify
+// --repair'.
+func (f *Fsck) CheckPins(ctx context.Context) (FsckCheck, error) {
+	check := FsckCheck{Name: FsckPins}
+
+	bs := f.blockstore()
+	pinner, err := dspinner.New(ctx, f.Repo.Datastore(), dag.NewDAGService(bserv.New(bs, offline.Exchange(bs))))
+	if err != nil {
+		return check, err
+	}
+	recursive, err := pinner.RecursiveKeys(ctx)
+	if err != nil {
+		return check, err
+	}
+	direct, err := pinner.DirectKeys(ctx)
+	if err != nil {
+		return check, err
+	}
+	internal, err := pinner.InternalPins(ctx)
+	if err != nil {
+		return check, err
+	}
+
+	pinned := cid.NewSet()
+	for _, keys := range [][]cid.Cid{recursive, direct, internal} {
+		for _, c := range keys {
+			check.Checked++
+			pinned.Add(c)
+			has, err := bs.Has(ctx, c)
+			if err != nil {
+				return check, err
+			}
+			if !has {
+				check.add(c.String(), errors.New("pinned block is missing, repair it with 'ipfs pin verify --repair'"), false)
+			}
+		}
+	}
+
+	store := pinmeta.NewStore(f.Repo.Datastore())
+	infos, err := store.Keys(ctx)
+	if err != nil {
+		return check, err
+	}
+	for _, c := range infos {
+		check.Checked++
+		if pinned.Has(c) {
+			continue
+		}
+		if f.Fix {
+			if err := store.Delete(ctx, c); err != nil {
+				return check, err
+			}
+		}
+		check.add(c.String(), errors.New("info of a removed pin"), f.Fix)
+	}
+	return check, nil
+}
+
+// CheckFilestore checks the files referenced by the filestore. The entries
+// of files which changed or were removed are deleted by the fix.
+func (f *Fsck) CheckFilestore(ctx context.Context) (FsckCheck, error) {
+	check := FsckCheck{Name: FsckFilestore}
+
+	fm := f.Repo.FileManager()
+	if fm == nil {
+		check.Skipped = "the filestore is not enabled"
+		return check, nil
+	}
+	next, err := filestore.VerifyAll(ctx, filestore.NewFilestore(bstore.NewBlockstore(f.Repo.Datastore()), fm), false)
+	if err != nil {
+		return check, err
+	}
+	for res := next(ctx); res != nil; res = next(ctx) {
+		check.Checked++
+		var fixable bool
+		switch res.Status {
+		case filestore.StatusOk:
+			continue
+		case filestore.StatusFileChanged, filestore.StatusFileNotFound, filestore.StatusOtherError:
+			fixable = true
+		}
+		err := fmt.Errorf("%s: %s", res.Status, res.ErrorMsg)
+		if f.Fix && fixable {
+			if err := fm.DeleteBlock(ctx, res.Key); err != nil && !ipld.IsNotFound(err) {
+				return check, err
+			}
+		}
+		check.add(res.Key.String(), err, f.Fix && fixable)
+	}
+	return check, ctx.Err()
+}
+
+// CheckFilesRoot checks the root of the MFS is a stored directory. The fix
+// replaces a broken root with an empty directory.
+func (f *Fsck) CheckFilesRoot(ctx context.Context) (FsckCheck, error) {
+	check := FsckCheck{Name: FsckFilesRoot}
+
+	d := f.Repo.Datastore()
+	val, err := d.Get(ctx, filesRootKey)
+	if err == ds.ErrNotFound {
+		return check, nil
+	} else if err != nil {
+		return check, err
+	}
+	check.Checked++
+
+	bs := f.blockstore()
+	key := filesRootKey.String()
+	rootErr := func() error {
+		c, err := cid.Cast(val)
+		if err != nil {
+			return fmt.Errorf("invalid root: %w", err)
+		}
+		key = c.String()
+		block, err := bs.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		nd, err := dag.DecodeProtobuf(block.RawData())
+		if err != nil {
+			return err
+		}
+		fsn, err := ft.FSNodeFromBytes(nd.Data())
+		if err != nil {
+			return err
+		}
+		switch fsn.Type() {
+		case ft.TDirectory, ft.THAMTShard:
+			return nil
+		default:
+			return errors.New("root is not a directory")
+		}
+	}()
+	if rootErr == nil {
+		return check, nil
+	}
+
+	if f.Fix {
+		empty := ft.EmptyDirNode()
+		if err := bs.Put(ctx, empty); err != nil {
+			return check, err
+		}
+		if err := d.Put(ctx, filesRootKey, empty.Cid().Bytes()); err != nil {
+			return check, err
+		}
+		if err := d.Sync(ctx, filesRootKey); err != nil {
+			return check, err
+		}
+	}
+	check.add(key, rootErr, f.Fix)
+	return check, nil
+}
+
+// CheckProviderQueue checks the entries of the queue of CIDs to provide,
+// invalid entries are deleted by the fix.
+func (f *Fsck) CheckProviderQueue(ctx context.Context) (FsckCheck, error) {
+	check := FsckCheck{Name: FsckProviderQueue}
+
+	d := f.Repo.Datastore()
+	res, err := d.Query(ctx, query.Query{Prefix: providerQueuePrefix.String()})
+	if err != nil {
+		return check, err
+	}
+	defer res.Close()
+
+	var invalid []string
+	for r := range res.Next() {
+		if r.Error != nil {
+			return check, r.Error
+		}
+		check.Checked++
+		if _, err := cid.Cast(r.Value); err != nil {
+			invalid = append(invalid, r.Key)
+			check.add(r.Key, fmt.Errorf("invalid queued CID: %w", err), f.Fix)
+		}
+	}
+	if !f.Fix {
+		return check, nil
+	}
+	// deleted once the query is done
+	for _, k := range invalid {
+		if err := d.Delete(ctx, ds.NewKey(k)); err != nil {
+			return check, err
+		}
+	}
+	return check, d.Sync(ctx, providerQueuePrefix)
+}
@@ -0,0 +1,96 @@
+package corerepo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/kubo/pinmeta"
+	"github.com/ipfs/kubo/repo"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestFsck(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(d)
+
+	good := dag.NodeWithData([]byte("good"))
+	corrupt, err := blocks.NewBlockWithCid([]byte("corrupt"), dag.NodeWithData([]byte("other")).Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.PutMany(ctx, []blocks.Block{good, corrupt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinmeta.NewStore(d).Put(ctx, good.Cid(), pinmeta.Info{Name: "removed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, providerQueuePrefix.ChildString("1/invalid"), []byte("invalid")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, providerQueuePrefix.ChildString("2/valid"), good.Cid().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, filesRootKey, good.Cid().Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(fix bool) []FsckCheck {
+		fsck := &Fsck{Repo: &repo.Mock{D: d}, Fix: fix}
+		var checks []FsckCheck
+		for _, run := range []func(context.Context) (FsckCheck, error){
+			fsck.CheckBlocks,
+			fsck.CheckPins,
+			fsck.CheckFilestore,
+			fsck.CheckFilesRoot,
+			fsck.CheckProviderQueue,
+		} {
+			check, err := run(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checks = append(checks, check)
+		}
+		return checks
+	}
+
+	expected := map[string]string{
+		// keys of the blockstore are raw CIDs
+		FsckBlocks:        cid.NewCidV1(cid.Raw, corrupt.Cid().Hash()).String(),
+		FsckPins:          good.Cid().String(),
+		FsckFilesRoot:     good.Cid().String(),
+		FsckProviderQueue: providerQueuePrefix.ChildString("1/invalid").String(),
+	}
+	for _, fix := range []bool{false, true} {
+		for _, check := range run(fix) {
+			key, ok := expected[check.Name]
+			if !ok {
+				if len(check.Problems) != 0 {
+					t.Errorf("unexpected %s problems %v", check.Name, check.Problems)
+				}
+				continue
+			}
+			if len(check.Problems) != 1 || check.Problems[0].Key != key || check.Problems[0].Fixed != fix {
+				t.Errorf("expected a %s problem with %s, fixed: %t, got %v", check.Name, key, fix, check.Problems)
+			}
+		}
+	}
+
+	for _, check := range run(false) {
+		if len(check.Problems) != 0 {
+			t.Errorf("unexpected %s problems after the fix %v", check.Name, check.Problems)
+		}
+	}
+	if has, _ := bs.Has(ctx, good.Cid()); !has {
+		t.Error("the good block was removed")
+	}
+	if has, _ := d.Has(ctx, providerQueuePrefix.ChildString("2/valid")); !has {
+		t.Error("the valid provider queue entry was removed")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ipfs/kubo/core"
@@ -11,8 +13,12 @@ import (
 	"github.com/ipfs/kubo/repo"
 
 	"github.com/dustin/go-humanize"
+	bserv "github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	logging "github.com/ipfs/go-log"
+	dag "github.com/ipfs/go-merkledag"
 	"github.com/ipfs/go-mfs"
 )
 
@@ -58,6 +64,10 @@ func NewGC(n *core.IpfsNode) (*GC, error) {
 	}
 	storageGC := storageMax * uint64(cfg.Datastore.StorageGCWatermark) / 100
 
+	if _, err := RetentionPolicy(n, 0); err != nil {
+		return nil, err
+	}
+
 	// calculate the slack space between StorageMax and StorageGCWatermark
 	// used to limit GC duration
 	slackGB := (storageMax - storageGC) / 10e9
@@ -83,12 +93,101 @@ func BestEffortRoots(filesRoot *mfs.Root) ([]cid.Cid, error) {
 	return []cid.Cid{rootDag.Cid()}, nil
 }
 
+// KeptSet returns the blocks which GC keeps: the pinned blocks, and those of
+// the MFS root, by their raw CIDs like the keys of the blockstore. The caller
+// should hold the GC lock while it uses the set.
+func KeptSet(ctx context.Context, n *core.IpfsNode) (*cid.Set, error) {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+	// the DAGs are walked below the layers recording the accesses to blocks
+	var bs blockstore.Blockstore = n.BaseBlocks
+	if n.Filestore != nil {
+		bs = n.Filestore
+	}
+	ng := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	output := make(chan gc.Result)
+	var firstErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for res := range output {
+			if firstErr == nil {
+				firstErr = res.Error
+			}
+		}
+	}()
+	kept, err := gc.ColoredSet(ctx, n.Pinning, ng, roots, output)
+	close(output)
+	<-done
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw := cid.NewSet()
+	_ = kept.ForEach(func(c cid.Cid) error {
+		raw.Add(cid.NewCidV1(cid.Raw, c.Hash()))
+		return nil
+	})
+	return raw, nil
+}
+
+// RetentionPolicy returns the GC policy configured with
+// Datastore.GCPolicies, or nil. LRU and LFU evictions stop once free bytes
+// are removed, unless free is 0.
+func RetentionPolicy(n *core.IpfsNode, free uint64) (gc.Policy, error) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Datastore.GCPolicies) == 0 {
+		return nil, nil
+	}
+
+	mounts := make([]gc.MountPolicy, 0, len(cfg.Datastore.GCPolicies))
+	for mountpoint, p := range cfg.Datastore.GCPolicies {
+		if !strings.HasPrefix(mountpoint, "/") {
+			return nil, fmt.Errorf("invalid Datastore.GCPolicies mountpoint %q, must start with /", mountpoint)
+		}
+		eviction := gc.Eviction(p.Eviction.WithDefault(string(gc.EvictAll)))
+		switch eviction {
+		case gc.EvictAll, gc.EvictLRU, gc.EvictLFU:
+		default:
+			return nil, fmt.Errorf("invalid Datastore.GCPolicies eviction %q for %s, must be one of all, lru, lfu", eviction, mountpoint)
+		}
+		mounts = append(mounts, gc.MountPolicy{
+			Mountpoint:         mountpoint,
+			KeepAccessedWithin: p.KeepAccessedWithin.WithDefault(0),
+			Eviction:           eviction,
+		})
+	}
+	return &gc.RetentionPolicy{
+		Mounts: mounts,
+		Access: n.GCAccess,
+		Free:   free,
+		Size:   n.Blockstore.GetSize,
+	}, nil
+}
+
 func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
+	return garbageCollect(n, ctx, 0)
+}
+
+func garbageCollect(n *core.IpfsNode, ctx context.Context, free uint64) error {
 	roots, err := BestEffortRoots(n.FilesRoot)
 	if err != nil {
 		return err
 	}
-	rmed := gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+	policy, err := RetentionPolicy(n, free)
+	if err != nil {
+		return err
+	}
+	rmed := gc.GCWithPolicy(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots, policy)
 
 	return CollectResult(ctx, rmed, nil)
 }
@@ -149,14 +248,70 @@ func (e *MultiError) Error() string {
 
 func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
 	roots, err := BestEffortRoots(n.FilesRoot)
+	var policy gc.Policy
+	if err == nil {
+		policy, err = RetentionPolicy(n, 0)
+	}
+	if err != nil {
+		out := make(chan gc.Result, 1)
+		out <- gc.Result{Error: err}
+		close(out)
+		return out
+	}
+
+	return gc.GCWithPolicy(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots, policy)
+}
+
+// ConcurrentGarbageCollectAsync runs a garbage collection which does not
+// block adds and pins while the blocks are marked and swept, see
+// gc.ConcurrentGC.
+func ConcurrentGarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
+	bs, ok := n.Blockstore.(*gc.BarrierBlockstore)
+	policy, err := RetentionPolicy(n, 0)
+	if !ok {
+		err = errors.New("concurrent garbage collection is not supported by the blockstore")
+	}
 	if err != nil {
-		out := make(chan gc.Result)
+		out := make(chan gc.Result, 1)
 		out <- gc.Result{Error: err}
 		close(out)
 		return out
 	}
 
-	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+	roots := func() ([]cid.Cid, error) {
+		return BestEffortRoots(n.FilesRoot)
+	}
+	return gc.ConcurrentGC(ctx, bs, n.Repo.Datastore(), n.Pinning, roots, policy)
+}
+
+// DryRunGarbageCollectAsync reports the blocks which GarbageCollectAsync
+// would remove, with their size, see gc.DryRun.
+func DryRunGarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	var policy gc.Policy
+	if err == nil {
+		policy, err = RetentionPolicy(n, 0)
+	}
+	if err != nil {
+		out := make(chan gc.Result, 1)
+		out <- gc.Result{Error: err}
+		close(out)
+		return out
+	}
+
+	return gc.DryRun(ctx, n.Blockstore, n.Pinning, roots, policy)
+}
+
+// PinStats reports the space used by the given recursive pins, or by all of
+// them, see gc.PinStats.
+func PinStats(n *core.IpfsNode, ctx context.Context, pins ...cid.Cid) ([]gc.PinStat, error) {
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return nil, err
+	}
+	// only the local blocks are accounted
+	ng := dag.NewDAGService(bserv.New(n.Blockstore, offline.Exchange(n.Blockstore)))
+	return gc.PinStats(ctx, n.Blockstore, n.Pinning, ng, roots, pins...)
 }
 
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
@@ -188,6 +343,10 @@ func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
 		case <-ctx.Done():
 			return nil
 		case <-time.After(period):
+			// the blocks of expired pins are collected in the same cycle
+			if _, err := RemoveExpiredPins(ctx, node); err != nil {
+				log.Errorf("removing expired pins: %s", err)
+			}
 			// the private func maybeGC doesn't compute storageMax, storageGC, slackGC so that they are not re-computed for every cycle
 			if err := gc.maybeGC(ctx, 0); err != nil {
 				log.Error(err)
@@ -218,7 +377,8 @@ func (gc *GC) maybeGC(ctx context.Context, offset uint64) error {
 		// Do GC here
 		log.Info("Watermark exceeded. Starting repo GC...")
 
-		if err := GarbageCollect(gc.Node, ctx); err != nil {
+		// retention policies only evict down to the watermark
+		if err := garbageCollect(gc.Node, ctx, storage+offset-gc.StorageGC); err != nil {
 			return err
 		}
 		log.Infof("Repo GC done. See `ipfs repo stat` to see how much space got freed.\n")
@@ -0,0 +1,61 @@
+package corerepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/kubo/core"
+
+	"github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+)
+
+// PinExpiryInterval is how often PeriodicPinExpiry removes the expired pins
+var PinExpiryInterval = time.Minute
+
+// RemoveExpiredPins removes the pins whose expiry has passed, and returns
+// them.
+func RemoveExpiredPins(ctx context.Context, n *core.IpfsNode) ([]cid.Cid, error) {
+	if n.PinInfo == nil {
+		return nil, nil
+	}
+
+	expired, err := n.PinInfo.Expired(ctx, time.Now())
+	if err != nil || len(expired) == 0 {
+		return nil, err
+	}
+
+	defer n.Blockstore.PinLock(ctx).Unlock(ctx)
+
+	removed := make([]cid.Cid, 0, len(expired))
+	for _, c := range expired {
+		// removes direct pins too
+		err := n.Pinning.Unpin(ctx, c, true)
+		if err == pin.ErrNotPinned {
+			// the info outlived the pin
+			err = n.PinInfo.Delete(ctx, c)
+		} else if err == nil {
+			removed = append(removed, c)
+			log.Infof("removed expired pin %s", c)
+		}
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, n.Pinning.Flush(ctx)
+}
+
+// PeriodicPinExpiry removes the expired pins every PinExpiryInterval, until
+// the context is done.
+func PeriodicPinExpiry(ctx context.Context, n *core.IpfsNode) error {
+	for {
+		if _, err := RemoveExpiredPins(ctx, n); err != nil {
+			log.Errorf("removing expired pins: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(PinExpiryInterval):
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package corerepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipfs/kubo/core"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	gocarv2 "github.com/ipld/go-car/v2"
+)
+
+// RepairFetchTimeout bounds the time spent fetching the repaired blocks from
+// the network
+var RepairFetchTimeout = 5 * time.Minute
+
+// Sources of repaired blocks
+const (
+	RepairSourceCar     = "car"
+	RepairSourceNetwork = "network"
+)
+
+// RepairResult describes the repair of a block
+type RepairResult struct {
+	Cid cid.Cid
+	// Source is where the block was repaired from, unless Err is set
+	Source string
+	Err    error
+}
+
+// Repair replaces missing or corrupted blocks, with the blocks of CAR files
+// given to ReadCar, and then with the blocks fetched from the network by
+// Fetch. A corrupted block is kept until its replacement is verified.
+type Repair struct {
+	// ImportMissing also writes the blocks of the CAR files which are not
+	// stored locally, such as the descendants of missing blocks
+	ImportMissing bool
+
+	n       *core.IpfsNode
+	keys    []cid.Cid
+	pending map[string]cid.Cid // by multihash
+	sources map[string]string
+}
+
+// NewRepair returns the repair of the given blocks, which are replaced by
+// ReadCar and Fetch.
+func NewRepair(n *core.IpfsNode, keys []cid.Cid) *Repair {
+	r := &Repair{
+		n:       n,
+		pending: make(map[string]cid.Cid, len(keys)),
+		sources: make(map[string]string, len(keys)),
+	}
+	for _, c := range keys {
+		if _, ok := r.pending[string(c.Hash())]; ok {
+			continue
+		}
+		r.keys = append(r.keys, c)
+		r.pending[string(c.Hash())] = c
+	}
+	return r
+}
+
+// ReadCar writes the blocks to repair found in the CAR file.
+func (r *Repair) ReadCar(ctx context.Context, rd io.Reader) error {
+	defer r.n.Blockstore.PinLock(ctx).Unlock(ctx)
+
+	car, err := gocarv2.NewBlockReader(rd)
+	if err != nil {
+		return err
+	}
+	for len(r.pending) > 0 || r.ImportMissing {
+		block, err := car.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		c := block.Cid()
+		_, repairing := r.pending[string(c.Hash())]
+		if !repairing {
+			if !r.ImportMissing {
+				continue
+			}
+			has, err := r.n.Blockstore.Has(ctx, c)
+			if err != nil {
+				return err
+			}
+			if has {
+				continue
+			}
+		}
+
+		// blocks are not verified when they are read from CAR files
+		if err := verifyBlock(block); err != nil {
+			return fmt.Errorf("block %s of the CAR file is corrupted: %w", c, err)
+		}
+		if !repairing {
+			if err := r.n.Blockstore.Put(ctx, block); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.replace(ctx, block, RepairSourceCar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch fetches the blocks which are still missing from the network, and
+// returns the results of the repair. The pin lock is only held to write the
+// fetched blocks.
+func (r *Repair) Fetch(ctx context.Context) []RepairResult {
+	if len(r.pending) > 0 && r.n.IsOnline {
+		if err := r.fetch(ctx); err != nil {
+			log.Errorf("fetching the repaired blocks: %s", err)
+		}
+	}
+
+	errNotFound := errors.New("block not found in the CAR files nor on the network")
+	if !r.n.IsOnline {
+		errNotFound = errors.New("block not found in the CAR files, and the node is offline")
+	}
+	results := make([]RepairResult, len(r.keys))
+	for i, c := range r.keys {
+		results[i] = RepairResult{Cid: c, Source: r.sources[string(c.Hash())]}
+		if results[i].Source == "" {
+			results[i].Err = errNotFound
+		}
+	}
+	return results
+}
+
+func (r *Repair) fetch(ctx context.Context) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, RepairFetchTimeout)
+	defer cancel()
+
+	missing := make([]cid.Cid, 0, len(r.pending))
+	for _, c := range r.pending {
+		missing = append(missing, c)
+	}
+	// the exchange is used directly, the block service would return the
+	// corrupted blocks which are still stored
+	blks, err := r.n.Exchange.GetBlocks(fetchCtx, missing)
+	if err != nil {
+		return err
+	}
+	var fetched []blocks.Block
+	for block := range blks {
+		if err := verifyBlock(block); err != nil {
+			log.Warnf("fetched block %s is corrupted: %s", block.Cid(), err)
+			continue
+		}
+		fetched = append(fetched, block)
+	}
+
+	defer r.n.Blockstore.PinLock(ctx).Unlock(ctx)
+	for _, block := range fetched {
+		if err := r.replace(ctx, block, RepairSourceNetwork); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace swaps the stored block for its verified replacement, the caller
+// holds the pin lock
+func (r *Repair) replace(ctx context.Context, block blocks.Block, source string) error {
+	if _, ok := r.pending[string(block.Cid().Hash())]; !ok {
+		return nil
+	}
+	// the blockstore doesn't overwrite the blocks it already has
+	if err := r.n.Blockstore.DeleteBlock(ctx, block.Cid()); err != nil && !ipld.IsNotFound(err) {
+		return fmt.Errorf("deleting block %s: %w", block.Cid(), err)
+	}
+	if err := r.n.Blockstore.Put(ctx, block); err != nil {
+		return err
+	}
+	r.repaired(block.Cid(), source)
+	return nil
+}
+
+// verifyBlock checks the hash of the block
+func verifyBlock(block blocks.Block) error {
+	sum, err := block.Cid().Prefix().Sum(block.RawData())
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(block.Cid()) {
+		return errors.New("hash mismatch")
+	}
+	return nil
+}
+
+func (r *Repair) repaired(c cid.Cid, source string) {
+	if _, ok := r.pending[string(c.Hash())]; !ok {
+		return
+	}
+	delete(r.pending, string(c.Hash()))
+	r.sources[string(c.Hash())] = source
+}
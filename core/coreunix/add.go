@@ -5,12 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	gopath "path"
 	"strconv"
 
 	"github.com/ipfs/go-cid"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
-	chunker "github.com/ipfs/go-ipfs-chunker"
 	files "github.com/ipfs/go-ipfs-files"
 	pin "github.com/ipfs/go-ipfs-pinner"
 	posinfo "github.com/ipfs/go-ipfs-posinfo"
@@ -43,6 +43,74 @@ type syncer interface {
 	Sync() error
 }
 
+// AddOptions are the settings of the Adder which the add options of the
+// CoreAPI do not have, see AddOption.
+type AddOptions struct {
+	PreserveMode  bool
+	PreserveMtime bool
+	// Resume journals the completed files in the datastore, an add
+	// interrupted before is resumed
+	Resume bool
+	// Concurrency is the number of files chunked and hashed in parallel
+	Concurrency int
+}
+
+// AddOption sets the AddOptions of an add through the CoreAPI of the node.
+type AddOption func(*AddOptions) error
+
+// AddOptionsFrom returns the settings set by the options.
+func AddOptionsFrom(opts ...AddOption) (*AddOptions, error) {
+	settings := &AddOptions{Concurrency: 1}
+	for _, opt := range opts {
+		if err := opt(settings); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+type addOpts struct{}
+
+// AddOpts builds the AddOptions, like options.Unixfs builds the add options
+// of the CoreAPI.
+var AddOpts addOpts
+
+// PreserveMode stores the mode of the added files in the UnixFS nodes.
+func (addOpts) PreserveMode(preserve bool) AddOption {
+	return func(settings *AddOptions) error {
+		settings.PreserveMode = preserve
+		return nil
+	}
+}
+
+// PreserveMtime stores the modification time of the added files in the
+// UnixFS nodes.
+func (addOpts) PreserveMtime(preserve bool) AddOption {
+	return func(settings *AddOptions) error {
+		settings.PreserveMtime = preserve
+		return nil
+	}
+}
+
+// Resume journals the completed files, so an interrupted add of the same
+// files skips them.
+func (addOpts) Resume(resume bool) AddOption {
+	return func(settings *AddOptions) error {
+		settings.Resume = resume
+		return nil
+	}
+}
+
+// Concurrency sets the number of files chunked and hashed in parallel, they
+// are added one by one below 2. Only the files of up to parallelFileSize are
+// hashed in parallel, the chunks of a larger file are hashed one at a time.
+func (addOpts) Concurrency(n int) AddOption {
+	return func(settings *AddOptions) error {
+		settings.Concurrency = n
+		return nil
+	}
+}
+
 // NewAdder Returns a new Adder used for a file add operation.
 func NewAdder(ctx context.Context, p pin.Pinner, bs bstore.GCLocker, ds ipld.DAGService) (*Adder, error) {
 	bufferedDS := ipld.NewBufferedDAG(ctx, ds)
@@ -80,6 +148,22 @@ type Adder struct {
 	tempRoot   cid.Cid
 	CidBuilder cid.Builder
 	liveNodes  uint64
+
+	// PreserveMode and PreserveMtime store the mode and the modification
+	// time of the files, directories and symlinks in the UnixFS nodes
+	PreserveMode  bool
+	PreserveMtime bool
+	// rootInfo is the info of the added directory, set on the root once
+	// it is complete
+	rootInfo os.FileInfo
+
+	// Journal records the completed files, files completed by an
+	// interrupted add are not added again
+	Journal *AddJournal
+	// Concurrency is the number of small files chunked and hashed in
+	// parallel, they are added one at a time when it is 1 or less
+	Concurrency int
+	pool        *filePool
 }
 
 func (adder *Adder) mfsRoot() (*mfs.Root, error) {
@@ -103,13 +187,23 @@ func (adder *Adder) SetMfsRoot(r *mfs.Root) {
 
 // Constructs a node from reader's data, and adds it. Doesn't pin.
 func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
-	chnk, err := chunker.FromString(reader, adder.Chunker)
+	nd, err := adder.layout(reader, adder.bufferedDS)
+	if err != nil {
+		return nil, err
+	}
+	return nd, adder.bufferedDS.Commit()
+}
+
+// layout chunks the reader's data into a DAG added to the DAG service, it
+// only reads the settings of the adder
+func (adder *Adder) layout(reader io.Reader, dserv ipld.DAGService) (ipld.Node, error) {
+	chnk, err := NewSplitter(reader, adder.Chunker)
 	if err != nil {
 		return nil, err
 	}
 
 	params := ihelper.DagBuilderParams{
-		Dagserv:    adder.bufferedDS,
+		Dagserv:    dserv,
 		RawLeaves:  adder.RawLeaves,
 		Maxlinks:   ihelper.DefaultLinksPerBlock,
 		NoCopy:     adder.NoCopy,
@@ -120,17 +214,10 @@ func (adder *Adder) add(reader io.Reader) (ipld.Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	var nd ipld.Node
 	if adder.Trickle {
-		nd, err = trickle.Layout(db)
-	} else {
-		nd, err = balanced.Layout(db)
+		return trickle.Layout(db)
 	}
-	if err != nil {
-		return nil, err
-	}
-
-	return nd, adder.bufferedDS.Commit()
+	return balanced.Layout(db)
 }
 
 // RootNode returns the mfs root node
@@ -271,7 +358,28 @@ func (adder *Adder) AddAllAndPin(ctx context.Context, file files.Node) (ipld.Nod
 		}
 	}()
 
-	if err := adder.addFileNode(ctx, "", file, true); err != nil {
+	if adder.Journal != nil {
+		adder.Journal.setOptions(adder.journalOptions())
+	}
+	if adder.Concurrency > 1 {
+		adder.pool = newFilePool(adder, adder.Concurrency)
+		defer func() {
+			adder.pool.close()
+			adder.pool = nil
+		}()
+	}
+	err := adder.addFileNode(ctx, "", file, true)
+	if err == nil && adder.pool != nil {
+		err = adder.pool.drain()
+	}
+	if err != nil {
+		if adder.Journal != nil {
+			// keep the files completed so far for the next add, the
+			// context may be canceled
+			if jerr := adder.flushJournal(context.Background()); jerr != nil {
+				log.Errorf("writing the add journal: %s", jerr)
+			}
+		}
 		return nil, err
 	}
 
@@ -321,6 +429,19 @@ func (adder *Adder) AddAllAndPin(ctx context.Context, file files.Node) (ipld.Nod
 		return nil, err
 	}
 
+	if dir && adder.rootInfo != nil {
+		nd, err = adder.withInfo(nd, adder.rootInfo)
+		if err != nil {
+			return nil, err
+		}
+		// the directories are listed from the root with its info
+		mr, err := mfs.NewRoot(adder.ctx, adder.dagService, nd.(*dag.ProtoNode), nil)
+		if err != nil {
+			return nil, err
+		}
+		root = mr.GetDirectory()
+	}
+
 	// output directory events
 	err = adder.outputDirs(name, root)
 	if err != nil {
@@ -334,10 +455,16 @@ func (adder *Adder) AddAllAndPin(ctx context.Context, file files.Node) (ipld.Nod
 		}
 	}
 
-	if !adder.Pin {
-		return nd, nil
+	if adder.Pin {
+		if err := adder.PinRoot(ctx, nd); err != nil {
+			return nd, err
+		}
+	}
+	// the add is complete, the next one adds all the files
+	if adder.Journal != nil {
+		return nd, adder.Journal.clear(ctx)
 	}
-	return nd, adder.PinRoot(ctx, nd)
+	return nd, nil
 }
 
 func (adder *Adder) addFileNode(ctx context.Context, path string, file files.Node, toplevel bool) error {
@@ -378,6 +505,13 @@ func (adder *Adder) addFileNode(ctx context.Context, path string, file files.Nod
 }
 
 func (adder *Adder) addSymlink(path string, l *files.Symlink) error {
+	// the files queued before are patched into the root first
+	if adder.pool != nil {
+		if err := adder.pool.drain(); err != nil {
+			return err
+		}
+	}
+
 	sdata, err := unixfs.SymlinkData(l.Target)
 	if err != nil {
 		return err
@@ -394,6 +528,33 @@ func (adder *Adder) addSymlink(path string, l *files.Symlink) error {
 }
 
 func (adder *Adder) addFile(path string, file files.File) error {
+	job := &fileJob{path: path, file: file}
+	if fi, ok := file.(files.FileInfo); ok {
+		job.abspath = fi.AbsPath()
+	}
+	if adder.preserveInfo() {
+		job.preserved = fileInfo(file)
+	}
+
+	// the info is read before the file, so a change while it is read is
+	// found by the next add
+	if adder.Journal != nil && job.abspath != "" {
+		job.info = fileInfo(file)
+	}
+	if job.info != nil {
+		dagnode, err := adder.resumeFile(path, job.abspath, job.info)
+		if err != nil {
+			return err
+		}
+		if dagnode != nil {
+			job.nd, job.resumed = dagnode, true
+			if adder.pool != nil {
+				return adder.pool.push(job)
+			}
+			return adder.finishFile(job)
+		}
+	}
+
 	// if the progress flag was specified, wrap the file so that we can send
 	// progress updates to the client (over the output channel)
 	var reader io.Reader = file
@@ -406,13 +567,103 @@ func (adder *Adder) addFile(path string, file files.File) error {
 		}
 	}
 
+	if adder.pool != nil {
+		if size, err := file.Size(); err == nil && size <= parallelFileSize {
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return err
+			}
+			job.data = data
+			return adder.pool.push(job)
+		}
+		// the files queued before are patched into the root first
+		if err := adder.pool.drain(); err != nil {
+			return err
+		}
+	}
+
 	dagnode, err := adder.add(reader)
 	if err != nil {
 		return err
 	}
+	job.nd = dagnode
+	return adder.finishFile(job)
+}
+
+// finishFile stores the nodes of a file chunked by a worker, then patches the
+// file into the root
+func (adder *Adder) finishFile(job *fileJob) error {
+	if job.resumed {
+		return adder.addNode(job.nd, job.path)
+	}
+	if job.nodes != nil {
+		if err := adder.bufferedDS.AddMany(adder.ctx, job.nodes); err != nil {
+			return err
+		}
+		if err := adder.bufferedDS.Commit(); err != nil {
+			return err
+		}
+	}
+
+	dagnode := job.nd
+	if job.preserved != nil {
+		var err error
+		dagnode, err = adder.withInfo(dagnode, job.preserved)
+		if err != nil {
+			return err
+		}
+	}
+
+	if job.info != nil {
+		adder.Journal.add(job.abspath, job.info, dagnode.Cid())
+		if adder.Journal.full() {
+			if err := adder.flushJournal(adder.ctx); err != nil {
+				return err
+			}
+		}
+	}
 
 	// patch it into the root
-	return adder.addNode(dagnode, path)
+	return adder.addNode(dagnode, job.path)
+}
+
+// resumeFile returns the node of a file completed by an interrupted add, nil
+// when the file was not completed or changed since
+func (adder *Adder) resumeFile(path, abspath string, info os.FileInfo) (ipld.Node, error) {
+	c, err := adder.Journal.lookup(adder.ctx, abspath, info)
+	if err != nil || !c.Defined() {
+		return nil, err
+	}
+	nd, err := adder.dagService.Get(adder.ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("%s was added by an interrupted add, skipping it", abspath)
+	if adder.Progress {
+		adder.Out <- &coreiface.AddEvent{
+			Name:  path,
+			Bytes: info.Size(),
+		}
+	}
+	return nd, nil
+}
+
+// flushJournal writes the completed files to the journal once their blocks
+// are synced
+func (adder *Adder) flushJournal(ctx context.Context) error {
+	if s, ok := adder.dagService.(syncer); ok {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	return adder.Journal.flush(ctx)
+}
+
+// journalOptions describes the options changing the nodes of the files, the
+// journal of an add is only used by the adds with the same options
+func (adder *Adder) journalOptions() string {
+	return fmt.Sprintf("%s %t %t %t %t %t %#v", adder.Chunker, adder.RawLeaves, adder.Trickle,
+		adder.NoCopy, adder.PreserveMode, adder.PreserveMtime, adder.CidBuilder)
 }
 
 func (adder *Adder) addDir(ctx context.Context, path string, dir files.Directory, toplevel bool) error {
@@ -441,8 +692,100 @@ func (adder *Adder) addDir(ctx context.Context, path string, dir files.Directory
 			return err
 		}
 	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	if !adder.preserveInfo() {
+		return nil
+	}
+	if fi := fileInfo(dir); fi != nil {
+		// the node of the directory is replaced with its children
+		if adder.pool != nil {
+			if err := adder.pool.drain(); err != nil {
+				return err
+			}
+		}
+		return adder.setInfo(path, fi)
+	}
+	return nil
+}
+
+func (adder *Adder) preserveInfo() bool {
+	return adder.PreserveMode || adder.PreserveMtime
+}
+
+// fileInfo returns the info of a file, nil when it was added without it. The
+// files sent to the daemon have the info read by the client, the daemon never
+// reads it from its own disk.
+func fileInfo(file files.Node) os.FileInfo {
+	if s, ok := file.(interface{ Stat() os.FileInfo }); ok {
+		return s.Stat()
+	}
+	return nil
+}
+
+// withInfo returns a copy of the node with the mode and the modification time
+// of the file.
+func (adder *Adder) withInfo(nd ipld.Node, fi os.FileInfo) (ipld.Node, error) {
+	if pi, ok := nd.(*posinfo.FilestoreNode); ok {
+		nd = pi.Node
+	}
+	pn, err := MetadataNode(nd, adder.CidBuilder)
+	if err != nil {
+		return nil, err
+	}
+
+	if adder.PreserveMode {
+		if err := SetMode(pn, fi.Mode()); err != nil {
+			return nil, err
+		}
+	}
+	if adder.PreserveMtime {
+		if err := SetModTime(pn, fi.ModTime()); err != nil {
+			return nil, err
+		}
+	}
+	return pn, adder.dagService.Add(adder.ctx, pn)
+}
+
+// setInfo sets the info of a file on its node in the mfs, the info of the
+// root is set once it is complete
+func (adder *Adder) setInfo(path string, fi os.FileInfo) error {
+	if path == "" {
+		adder.rootInfo = fi
+		return nil
+	}
 
-	return it.Err()
+	mr, err := adder.mfsRoot()
+	if err != nil {
+		return err
+	}
+	parent, name := gopath.Split(path)
+	pfsn, err := mfs.Lookup(mr, parent)
+	if err != nil {
+		return err
+	}
+	pdir, ok := pfsn.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", parent)
+	}
+	child, err := pdir.Child(name)
+	if err != nil {
+		return err
+	}
+	nd, err := child.GetNode()
+	if err != nil {
+		return err
+	}
+	nd, err = adder.withInfo(nd, fi)
+	if err != nil {
+		return err
+	}
+	if err := pdir.Unlink(name); err != nil {
+		return err
+	}
+	return pdir.AddChild(name, nd)
 }
 
 func (adder *Adder) maybePauseForGC(ctx context.Context) error {
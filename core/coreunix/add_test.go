@@ -3,10 +3,13 @@ package coreunix
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,6 +25,7 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	files "github.com/ipfs/go-ipfs-files"
 	pi "github.com/ipfs/go-ipfs-posinfo"
+	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	config "github.com/ipfs/kubo/config"
@@ -365,3 +369,337 @@ func (fi *dummyFileInfo) Mode() os.FileMode  { return 0 }
 func (fi *dummyFileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *dummyFileInfo) IsDir() bool        { return false }
 func (fi *dummyFileInfo) Sys() interface{}   { return nil }
+
+func TestAddResume(t *testing.T) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add := func(resume bool, wrap func(files.Directory) files.Directory) (cid.Cid, error) {
+		st, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sf, err := files.NewSerialFile(dir, false, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resume {
+			adder.Journal = NewAddJournal(node.Repo.Datastore(), node.Blockstore)
+		}
+		d := sf.(files.Directory)
+		if wrap != nil {
+			d = wrap(d)
+		}
+		nd, err := adder.AddAllAndPin(context.Background(), d)
+		if err != nil {
+			return cid.Undef, err
+		}
+		return nd.Cid(), nil
+	}
+
+	// the add is interrupted after a and b
+	_, err = add(true, func(d files.Directory) files.Directory {
+		return &interruptedDir{Directory: d, left: 2}
+	})
+	if err == nil {
+		t.Fatal("expected the add to be interrupted")
+	}
+
+	// b changes without a change of its size and mtime, the resumed add
+	// does not read it again
+	b := filepath.Join(dir, "b")
+	st, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("CONTENT OF B"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(b, st.ModTime(), st.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := add(true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := add(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed == plain {
+		t.Fatal("expected the resumed add to use the journaled b")
+	}
+
+	// the journal is cleared by the completed add
+	again, err := add(true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != plain {
+		t.Fatalf("expected %s once the journal is cleared, got %s", plain, again)
+	}
+}
+
+func TestAddJournal(t *testing.T) {
+	ctx := context.Background()
+	ds := syncds.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	dserv := dag.NewDAGService(blockservice.New(bs, nil))
+
+	leaf := dag.NewRawNode([]byte("leaf"))
+	root := dag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.AddMany(ctx, []ipld.Node{leaf, root}); err != nil {
+		t.Fatal(err)
+	}
+
+	journal := func() *AddJournal {
+		j := NewAddJournal(ds, bs)
+		j.setOptions("options")
+		return j
+	}
+	fi := &modeFileInfo{&dummyFileInfo{name: "a", size: 4, modTime: time.Unix(1000000000, 0)}, 0644}
+	j := journal()
+	j.add("/files/a", fi, root.Cid())
+	if err := j.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	other := journal()
+	other.add("/files/b", fi, root.Cid())
+	if err := other.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(j *AddJournal, fi os.FileInfo) cid.Cid {
+		t.Helper()
+		c, err := j.lookup(ctx, "/files/a", fi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	j = journal()
+	if c := lookup(j, fi); c != root.Cid() {
+		t.Fatalf("expected the journaled %s, got %s", root.Cid(), c)
+	}
+	// a chmod keeps the mtime
+	if c := lookup(j, &modeFileInfo{fi.dummyFileInfo, 0755}); c.Defined() {
+		t.Fatal("expected the file with another mode to be added again")
+	}
+	// the root is not enough, the whole DAG must be stored
+	if err := bs.DeleteBlock(ctx, leaf.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if c := lookup(j, fi); c.Defined() {
+		t.Fatal("expected the file with a missing block to be added again")
+	}
+
+	// the completed add only removes the entries of its files
+	if err := j.clear(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := ds.Has(ctx, j.key("/files/a")); err != nil || has {
+		t.Fatalf("expected the entry of the add to be removed: %v", err)
+	}
+	if has, err := ds.Has(ctx, j.key("/files/b")); err != nil || !has {
+		t.Fatalf("expected the entry of another add to be kept: %v", err)
+	}
+}
+
+type modeFileInfo struct {
+	*dummyFileInfo
+	mode os.FileMode
+}
+
+func (fi *modeFileInfo) Mode() os.FileMode { return fi.mode }
+
+// BenchmarkAddConcurrency adds directories of small files, which the workers
+// hash in parallel, and a large file, which is hashed one chunk at a time
+// with any concurrency
+func BenchmarkAddConcurrency(b *testing.B) {
+	const smallFiles = 256
+	small := make([][]byte, smallFiles)
+	rnd := rand.New(rand.NewSource(1))
+	for i := range small {
+		small[i] = make([]byte, 64<<10)
+		rnd.Read(small[i]) // Rand.Read never returns an error
+	}
+	large := make([]byte, smallFiles*64<<10)
+	rnd.Read(large)
+
+	for _, bench := range []struct {
+		name string
+		dir  func() files.Directory
+	}{
+		{"small-files", func() files.Directory {
+			entries := make(map[string]files.Node, len(small))
+			for i, data := range small {
+				entries[fmt.Sprint(i)] = files.NewBytesFile(data)
+			}
+			return files.NewMapDirectory(entries)
+		}},
+		{"large-file", func() files.Directory {
+			return files.NewMapDirectory(map[string]files.Node{"large": files.NewBytesFile(large)})
+		}},
+	} {
+		for _, concurrency := range []int{1, 4, 16} {
+			b.Run(fmt.Sprintf("%s/concurrency=%d", bench.name, concurrency), func(b *testing.B) {
+				b.SetBytes(int64(len(large)))
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(syncds.MutexWrap(datastore.NewMapDatastore())), blockstore.NewGCLocker())
+					dserv := dag.NewDAGService(blockservice.New(bs, nil))
+					adder, err := NewAdder(context.Background(), nil, bs, dserv)
+					if err != nil {
+						b.Fatal(err)
+					}
+					adder.Pin = false
+					adder.Concurrency = concurrency
+					dir := bench.dir()
+					b.StartTimer()
+					if _, err := adder.AddAllAndPin(context.Background(), dir); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// interruptedDir fails after listing some entries
+type interruptedDir struct {
+	files.Directory
+	left int
+}
+
+func (d *interruptedDir) Entries() files.DirIterator {
+	return &interruptedIterator{DirIterator: d.Directory.Entries(), left: d.left}
+}
+
+type interruptedIterator struct {
+	files.DirIterator
+	left int
+	err  error
+}
+
+func (it *interruptedIterator) Next() bool {
+	if it.left == 0 {
+		it.err = errors.New("interrupted")
+		return false
+	}
+	it.left--
+	return it.DirIterator.Next()
+}
+
+func (it *interruptedIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.DirIterator.Err()
+}
+
+func TestAddConcurrency(t *testing.T) {
+	r := &repo.Mock{
+		C: config.Config{
+			Identity: config.Identity{
+				PeerID: testPeerID, // required by offline node
+			},
+		},
+		D: syncds.MutexWrap(datastore.NewMapDatastore()),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{Repo: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	rnd := rand.New(rand.NewSource(3))
+	for i, size := range []int{0, 10, 1000, 300000, 2 * parallelFileSize, 5000, 70} {
+		sub := filepath.Join(dir, string(rune('a'+i%3)))
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, size)
+		rnd.Read(data)
+		if err := os.WriteFile(filepath.Join(sub, string(rune('k'+i))), data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a/k", filepath.Join(dir, "b", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(concurrency int, rawLeaves, preserve bool) (cid.Cid, []string) {
+		st, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sf, err := files.NewSerialFile(dir, false, st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder, err := NewAdder(context.Background(), node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(chan interface{})
+		adder.Out = out
+		adder.Concurrency = concurrency
+		adder.RawLeaves = rawLeaves
+		adder.PreserveMode = preserve
+		adder.PreserveMtime = preserve
+
+		var nd ipld.Node
+		go func() {
+			defer close(out)
+			nd, err = adder.AddAllAndPin(context.Background(), sf)
+		}()
+		var events []string
+		for o := range out {
+			ev := o.(*coreiface.AddEvent)
+			events = append(events, ev.Name+" "+ev.Path.Cid().String())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nd.Cid(), events
+	}
+
+	for _, rawLeaves := range []bool{false, true} {
+		for _, preserve := range []bool{false, true} {
+			want, wantEvents := add(1, rawLeaves, preserve)
+			for _, concurrency := range []int{2, 8} {
+				got, events := add(concurrency, rawLeaves, preserve)
+				if got != want {
+					t.Fatalf("expected %s with a concurrency of %d, got %s", want, concurrency, got)
+				}
+				if strings.Join(events, "\n") != strings.Join(wantEvents, "\n") {
+					t.Fatalf("expected the events\n%s\ngot\n%s", strings.Join(wantEvents, "\n"), strings.Join(events, "\n"))
+				}
+			}
+		}
+	}
+}
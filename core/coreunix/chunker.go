@@ -0,0 +1,59 @@
+package coreunix
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+)
+
+// NewSplitter returns the chunker of a chunker option. On top of the chunkers
+// of go-ipfs-chunker, it supports "fastcdc", "fastcdc-{min}-{avg}-{max}",
+// "tar" and "tar-{chunker}", where the content of the members of tar
+// archives is split by the other chunker, the default one for "tar".
+func NewSplitter(r io.Reader, spec string) (chunker.Splitter, error) {
+	switch {
+	case spec == "fastcdc" || strings.HasPrefix(spec, "fastcdc-"):
+		return parseFastCDCString(r, spec)
+
+	case spec == "tar" || strings.HasPrefix(spec, "tar-"):
+		inner := strings.TrimPrefix(strings.TrimPrefix(spec, "tar"), "-")
+		if strings.HasPrefix(inner, "tar") {
+			return nil, errors.New("the tar chunker can't split the members with itself")
+		}
+		newInner := func(r io.Reader) (chunker.Splitter, error) {
+			return NewSplitter(r, inner)
+		}
+		// check the other chunker before reading anything
+		if _, err := newInner(bytes.NewReader(nil)); err != nil {
+			return nil, err
+		}
+		return NewTarSplitter(r, newInner), nil
+
+	default:
+		return chunker.FromString(r, spec)
+	}
+}
+
+func parseFastCDCString(r io.Reader, spec string) (chunker.Splitter, error) {
+	parts := strings.Split(spec, "-")
+	switch len(parts) {
+	case 1:
+		return NewFastCDC(r, fastCDCMin, fastCDCAvg, fastCDCMax)
+	case 4:
+		var sizes [3]int
+		for i, p := range parts[1:] {
+			size, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, err
+			}
+			sizes[i] = size
+		}
+		return NewFastCDC(r, sizes[0], sizes[1], sizes[2])
+	default:
+		return nil, errors.New("incorrect format (expected 'fastcdc' or 'fastcdc-[min]-[avg]-[max]')")
+	}
+}
@@ -0,0 +1,164 @@
+package coreunix
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+)
+
+func splitAll(t *testing.T, data []byte, spec string) [][]byte {
+	t.Helper()
+	spl, err := NewSplitter(bytes.NewReader(data), spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks [][]byte
+	for {
+		b, err := spl.NextBytes()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) == 0 || len(b) > chunker.ChunkSizeLimit {
+			t.Fatalf("unexpected chunk of %d bytes", len(b))
+		}
+		chunks = append(chunks, b)
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("the chunks are not the data")
+	}
+	return chunks
+}
+
+func chunkSet(chunks [][]byte) map[string]bool {
+	set := make(map[string]bool)
+	for _, c := range chunks {
+		set[string(c)] = true
+	}
+	return set
+}
+
+func TestFastCDC(t *testing.T) {
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := splitAll(t, data, "fastcdc-4096-16384-65536")
+	for _, c := range chunks[:len(chunks)-1] {
+		if len(c) < 4096 || len(c) > 65536 {
+			t.Fatalf("chunk of %d bytes out of the bounds", len(c))
+		}
+	}
+	if avg := len(data) / len(chunks); avg < 8192 || avg > 32768 {
+		t.Fatalf("average chunk size %d too far from 16384", avg)
+	}
+
+	// the chunks after an insertion are the same
+	shifted := append([]byte("inserted bytes"), data...)
+	set := chunkSet(splitAll(t, shifted, "fastcdc-4096-16384-65536"))
+	same := 0
+	for _, c := range chunks {
+		if set[string(c)] {
+			same++
+		}
+	}
+	if same < len(chunks)-3 {
+		t.Fatalf("only %d of %d chunks unchanged by an insertion", same, len(chunks))
+	}
+
+	// the chunk boundaries must never change, they would change the CIDs
+	def := splitAll(t, data, "fastcdc")
+	if len(def) != 26 || len(def[0]) != 359558 {
+		t.Fatalf("the default fastcdc chunks changed: %d chunks, first of %d bytes", len(def), len(def[0]))
+	}
+
+	splitAll(t, nil, "fastcdc")
+	splitAll(t, data[:100], "fastcdc")
+}
+
+func TestNewSplitterErrors(t *testing.T) {
+	for _, spec := range []string{
+		"fastcdc-1",
+		"fastcdc-10-100-1000",
+		"fastcdc-4096-4096-65536",
+		"fastcdc-4096-16384-2097152",
+		"fastcdc-a-b-c",
+		"tar-tar",
+		"tar-foo",
+		"foo",
+	} {
+		if _, err := NewSplitter(bytes.NewReader(nil), spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func tarArchive(t *testing.T, members map[string][]byte, order ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range order {
+		// long names are stored in PAX records
+		hdr := &tar.Header{Name: "dir/" + name, Mode: 0644, Size: int64(len(members[name])), Format: tar.FormatPAX}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(members[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTarSplitter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	members := make(map[string][]byte)
+	for name, size := range map[string]int{"a": 1000, "b": 300000, "c": 0, "d": 12345} {
+		members[name] = make([]byte, size)
+		rnd.Read(members[name])
+	}
+	members["long-"+string(bytes.Repeat([]byte("x"), 200))] = []byte("long name")
+
+	one := splitAll(t, tarArchive(t, members, "a", "b", "c"), "tar-size-65536")
+	two := splitAll(t, tarArchive(t, members, "d", "c", "b", "long-"+string(bytes.Repeat([]byte("x"), 200))), "tar-size-65536")
+
+	// the content of b is split the same way in both archives
+	set := chunkSet(one)
+	for i := 0; i < len(members["b"]); i += 65536 {
+		end := i + 65536
+		if end > len(members["b"]) {
+			end = len(members["b"])
+		}
+		c := members["b"][i:end]
+		if !set[string(c)] || !chunkSet(two)[string(c)] {
+			t.Fatalf("the chunk at %d of b is not in both archives", i)
+		}
+	}
+	if !chunkSet(two)["long name"] {
+		t.Fatal("the member with a long name should be a chunk")
+	}
+
+	// data which is not an archive is split by the other chunker
+	data := make([]byte, 100000)
+	rnd.Read(data)
+	if chunks := splitAll(t, data, "tar-size-65536"); len(chunks) != 2 || len(chunks[0]) != 65536 {
+		t.Fatalf("unexpected chunks of data which is not an archive: %d", len(chunks))
+	}
+
+	// a truncated archive is not lost
+	archive := tarArchive(t, members, "a", "b")
+	splitAll(t, archive[:5000], "tar")
+	splitAll(t, archive[:1024+300], "tar")
+	splitAll(t, nil, "tar")
+}
@@ -0,0 +1,148 @@
+package coreunix
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+)
+
+// The default sizes of the FastCDC chunks, the average is the default block
+// size of the other chunkers.
+const (
+	fastCDCMin = 64 << 10
+	fastCDCAvg = 256 << 10
+	fastCDCMax = 1 << 20
+)
+
+// gearTable is the table of the gear hash of FastCDC. It is generated with
+// splitmix64 from a fixed seed and must never change, it would change the
+// chunks and the CIDs of the files.
+var gearTable = func() (t [256]uint64) {
+	x := uint64(0x6970667366617374) // "ipfsfast"
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// FastCDC is a content defined chunker, it finds the chunk boundaries with a
+// gear hash, which is faster than the rolling hashes of rabin and buzhash.
+// The chunks are normalized around the average size: a boundary is harder to
+// find before it and easier after it. See "FastCDC: a Fast and Efficient
+// Content-Defined Chunking Approach for Data Deduplication" (Xia et al.,
+// USENIX ATC 2016).
+type FastCDC struct {
+	r io.Reader
+
+	min, avg, max int
+	// maskS is used before the average size, maskL after it
+	maskS, maskL uint64
+
+	buf []byte
+	// buf[start:end] is read but not returned in a chunk yet
+	start, end int
+	err        error
+}
+
+// NewFastCDC returns a FastCDC chunker with chunks of min to max bytes, of
+// avg bytes on average.
+func NewFastCDC(r io.Reader, min, avg, max int) (*FastCDC, error) {
+	switch {
+	case min < 64:
+		return nil, errors.New("fastcdc min must be at least 64")
+	case min >= avg:
+		return nil, errors.New("incorrect format: fastcdc-min must be smaller than fastcdc-avg")
+	case avg >= max:
+		return nil, errors.New("incorrect format: fastcdc-avg must be smaller than fastcdc-max")
+	case max > chunker.ChunkSizeLimit:
+		return nil, chunker.ErrSizeMax
+	}
+	b := bits.Len(uint(avg)) - 1
+	return &FastCDC{
+		r:     r,
+		min:   min,
+		avg:   avg,
+		max:   max,
+		maskS: gearMask(b + 1),
+		maskL: gearMask(b - 1),
+		buf:   make([]byte, max),
+	}, nil
+}
+
+// gearMask returns a mask of the n high bits of the hash, which depend on
+// the last 64 bytes
+func gearMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Reader returns the io.Reader associated to this Splitter.
+func (f *FastCDC) Reader() io.Reader {
+	return f.r
+}
+
+// NextBytes returns the next chunk of data.
+func (f *FastCDC) NextBytes() ([]byte, error) {
+	if f.end-f.start < f.max && f.err == nil {
+		f.fill()
+	}
+	if f.start == f.end {
+		return nil, f.err
+	}
+
+	n := f.cut(f.buf[f.start:f.end])
+	chunk := make([]byte, n)
+	copy(chunk, f.buf[f.start:f.start+n])
+	f.start += n
+	return chunk, nil
+}
+
+// fill reads until the buffer holds max bytes, or the end of the data
+func (f *FastCDC) fill() {
+	if f.start > 0 {
+		copy(f.buf, f.buf[f.start:f.end])
+		f.end -= f.start
+		f.start = 0
+	}
+	for f.end < f.max && f.err == nil {
+		var n int
+		n, f.err = f.r.Read(f.buf[f.end:])
+		f.end += n
+	}
+}
+
+// cut returns the length of the chunk at the start of data
+func (f *FastCDC) cut(data []byte) int {
+	n := len(data)
+	if n <= f.min {
+		return n
+	}
+	if n > f.max {
+		n = f.max
+	}
+	normal := f.avg
+	if n < normal {
+		normal = n
+	}
+
+	var h uint64
+	i := f.min
+	for ; i < normal; i++ {
+		h = h<<1 + gearTable[data[i]]
+		if h&f.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = h<<1 + gearTable[data[i]]
+		if h&f.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,213 @@
+package coreunix
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+var journalPrefix = dstore.NewKey("/local/addjournal")
+
+// The completed files are written to the journal by batches of
+// journalBatchSize files, or after journalFlushInterval. At most that many
+// files are added again after a crash.
+const (
+	journalBatchSize     = 256
+	journalFlushInterval = 10 * time.Second
+)
+
+// journalEntry is stored for every file completed by a resumable add
+type journalEntry struct {
+	Size int64
+	// ModTime is the modification time of the file in Unix nanoseconds
+	ModTime int64
+	// Mode has the unix permission bits of the file, a chmod does not
+	// change the modification time
+	Mode uint32
+	Cid  cid.Cid
+}
+
+// AddJournal records the files completed by an add in the datastore. An
+// interrupted add of the same files with the same options resumes from it:
+// the files which did not change since, by size and modification time, are
+// not read and chunked again, their nodes are taken from the blockstore. The
+// entries of the files of an add are removed when it completes.
+type AddJournal struct {
+	ds   dstore.Batching
+	bs   bstore.Blockstore
+	dags ipld.DAGService
+
+	// prefix is the namespace of the options of the add, set on first use
+	prefix    dstore.Key
+	pending   map[dstore.Key]journalEntry
+	lastFlush time.Time
+	// keys are the entries of the files of the add, written by it or by the
+	// interrupted add it resumes
+	keys map[dstore.Key]struct{}
+}
+
+// NewAddJournal returns the journal stored in the datastore. The nodes of
+// the completed files are only used when all their blocks are in the
+// blockstore.
+func NewAddJournal(ds dstore.Batching, bs bstore.Blockstore) *AddJournal {
+	return &AddJournal{
+		ds:        ds,
+		bs:        bs,
+		dags:      dag.NewDAGService(bserv.New(bs, offline.Exchange(bs))),
+		pending:   make(map[dstore.Key]journalEntry),
+		lastFlush: time.Now(),
+		keys:      make(map[dstore.Key]struct{}),
+	}
+}
+
+// lookup returns the CID of the file at the path on the disk, when it was
+// completed by an add and is unchanged.
+func (j *AddJournal) lookup(ctx context.Context, abspath string, fi os.FileInfo) (cid.Cid, error) {
+	k := j.key(abspath)
+	e, ok := j.pending[k]
+	if !ok {
+		b, err := j.ds.Get(ctx, k)
+		if err == dstore.ErrNotFound {
+			return cid.Undef, nil
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := json.Unmarshal(b, &e); err != nil {
+			return cid.Undef, fmt.Errorf("reading the add journal: %w", err)
+		}
+	}
+	j.keys[k] = struct{}{}
+	if e.Size != fi.Size() || e.ModTime != fi.ModTime().UnixNano() || e.Mode != UnixMode(fi.Mode()) {
+		return cid.Undef, nil
+	}
+	// the blocks of an interrupted add are not pinned, some of them may have
+	// been garbage collected since
+	complete, err := j.complete(ctx, e.Cid)
+	if err != nil || !complete {
+		return cid.Undef, err
+	}
+	return e.Cid, nil
+}
+
+// complete tells if all the blocks of a DAG are in the blockstore, without
+// fetching them from the network
+func (j *AddJournal) complete(ctx context.Context, root cid.Cid) (bool, error) {
+	seen := cid.NewSet()
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !seen.Visit(c) {
+			continue
+		}
+		// the leaves have no links, they are not read
+		if c.Prefix().Codec == cid.Raw {
+			has, err := j.bs.Has(ctx, c)
+			if err != nil || !has {
+				return false, err
+			}
+			continue
+		}
+		nd, err := j.dags.Get(ctx, c)
+		if ipld.IsNotFound(err) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		for _, l := range nd.Links() {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return true, nil
+}
+
+// add records the file at the path on the disk, with its info from before
+// it was read, as completed once the journal is flushed.
+func (j *AddJournal) add(abspath string, fi os.FileInfo, c cid.Cid) {
+	k := j.key(abspath)
+	j.keys[k] = struct{}{}
+	j.pending[k] = journalEntry{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UnixNano(),
+		Mode:    UnixMode(fi.Mode()),
+		Cid:     c,
+	}
+}
+
+// full tells if the pending entries should be flushed
+func (j *AddJournal) full() bool {
+	return len(j.pending) >= journalBatchSize ||
+		len(j.pending) > 0 && time.Since(j.lastFlush) >= journalFlushInterval
+}
+
+// flush writes the pending entries to the datastore, the blocks of the files
+// must be synced first.
+func (j *AddJournal) flush(ctx context.Context) error {
+	if len(j.pending) == 0 {
+		return nil
+	}
+	batch, err := j.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for k, e := range j.pending {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(ctx, k, b); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	j.pending = make(map[dstore.Key]journalEntry)
+	j.lastFlush = time.Now()
+	return j.ds.Sync(ctx, j.prefix)
+}
+
+// clear removes the entries of the files of the add, the entries of the
+// other adds with the same options are kept.
+func (j *AddJournal) clear(ctx context.Context) error {
+	j.pending = make(map[dstore.Key]journalEntry)
+	batch, err := j.ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for k := range j.keys {
+		if err := batch.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return err
+	}
+	j.keys = make(map[dstore.Key]struct{})
+	return nil
+}
+
+// setOptions sets the namespace of the entries from a description of the
+// options changing the nodes of the files
+func (j *AddJournal) setOptions(opts string) {
+	sum := sha256.Sum256([]byte(opts))
+	j.prefix = journalPrefix.ChildString(hex.EncodeToString(sum[:16]))
+}
+
+func (j *AddJournal) key(abspath string) dstore.Key {
+	sum := sha256.Sum256([]byte(abspath))
+	return j.prefix.ChildString(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,229 @@
+package coreunix
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The mode and mtime fields of the Data message of UnixFS 1.5. go-unixfs does
+// not know them, they are kept as unrecognized fields when the data of a node
+// is decoded and encoded again.
+const (
+	modeField  protowire.Number = 7
+	mtimeField protowire.Number = 8
+
+	mtimeSecondsField protowire.Number = 1
+	mtimeNanosField   protowire.Number = 2
+)
+
+// permission bits stored in the mode field, the file type is not stored
+const unixModeMask = 07777
+
+var errInvalidData = errors.New("invalid unixfs data")
+
+// Mode returns the permissions of a UnixFS node, 0 when they are not set.
+func Mode(nd ipld.Node) (os.FileMode, error) {
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return 0, nil
+	}
+	var mode os.FileMode
+	err := scanFields(pn.Data(), func(num protowire.Number, typ protowire.Type, b []byte) error {
+		if num != modeField || typ != protowire.VarintType {
+			return nil
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return errInvalidData
+		}
+		mode = FileMode(uint32(v))
+		return nil
+	})
+	return mode, err
+}
+
+// ModTime returns the modification time of a UnixFS node, the zero time when
+// it is not set.
+func ModTime(nd ipld.Node) (time.Time, error) {
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return time.Time{}, nil
+	}
+	var mtime time.Time
+	err := scanFields(pn.Data(), func(num protowire.Number, typ protowire.Type, b []byte) error {
+		if num != mtimeField || typ != protowire.BytesType {
+			return nil
+		}
+		msg, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return errInvalidData
+		}
+		var secs int64
+		var nanos uint32
+		err := scanFields(msg, func(num protowire.Number, typ protowire.Type, b []byte) error {
+			switch {
+			case num == mtimeSecondsField && typ == protowire.VarintType:
+				v, n := protowire.ConsumeVarint(b)
+				if n < 0 {
+					return errInvalidData
+				}
+				secs = int64(v)
+			case num == mtimeNanosField && typ == protowire.Fixed32Type:
+				v, n := protowire.ConsumeFixed32(b)
+				if n < 0 || v > 999999999 {
+					return errInvalidData
+				}
+				nanos = v
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		mtime = time.Unix(secs, int64(nanos))
+		return nil
+	})
+	return mtime, err
+}
+
+// SetMode sets the permissions of a UnixFS node, 0 removes them.
+func SetMode(nd *dag.ProtoNode, mode os.FileMode) error {
+	data, err := removeField(nd.Data(), modeField)
+	if err != nil {
+		return err
+	}
+	if mode != 0 {
+		data = protowire.AppendTag(data, modeField, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(UnixMode(mode)))
+	}
+	nd.SetData(data)
+	return nil
+}
+
+// SetModTime sets the modification time of a UnixFS node, the zero time
+// removes it.
+func SetModTime(nd *dag.ProtoNode, mtime time.Time) error {
+	data, err := removeField(nd.Data(), mtimeField)
+	if err != nil {
+		return err
+	}
+	if !mtime.IsZero() {
+		var msg []byte
+		msg = protowire.AppendTag(msg, mtimeSecondsField, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(mtime.Unix()))
+		if nanos := mtime.Nanosecond(); nanos != 0 {
+			msg = protowire.AppendTag(msg, mtimeNanosField, protowire.Fixed32Type)
+			msg = protowire.AppendFixed32(msg, uint32(nanos))
+		}
+		data = protowire.AppendTag(data, mtimeField, protowire.BytesType)
+		data = protowire.AppendBytes(data, msg)
+	}
+	nd.SetData(data)
+	return nil
+}
+
+// MetadataNode returns a copy of a UnixFS node which can store the mode and
+// the modification time. Raw nodes can't, they are linked from a new file node
+// built with the CID builder.
+func MetadataNode(nd ipld.Node, builder cid.Builder) (*dag.ProtoNode, error) {
+	switch nd := nd.(type) {
+	case *dag.ProtoNode:
+		return nd.Copy().(*dag.ProtoNode), nil
+	case *dag.RawNode:
+		fsn := ft.NewFSNode(ft.TFile)
+		fsn.AddBlockSize(uint64(len(nd.RawData())))
+		data, err := fsn.GetBytes()
+		if err != nil {
+			return nil, err
+		}
+		pn := dag.NodeWithData(data)
+		pn.SetCidBuilder(builder)
+		if err := pn.AddNodeLink("", nd); err != nil {
+			return nil, err
+		}
+		return pn, nil
+	default:
+		return nil, fmt.Errorf("can't set the mode and mtime of a %T", nd)
+	}
+}
+
+// scanFields calls fn with the number, the type and the encoded value of each
+// field of a protobuf message
+func scanFields(data []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errInvalidData
+		}
+		data = data[n:]
+		m := protowire.ConsumeFieldValue(num, typ, data)
+		if m < 0 {
+			return errInvalidData
+		}
+		if err := fn(num, typ, data[:m]); err != nil {
+			return err
+		}
+		data = data[m:]
+	}
+	return nil
+}
+
+// removeField returns a copy of the protobuf message without the field
+func removeField(data []byte, field protowire.Number) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, errInvalidData
+		}
+		m := protowire.ConsumeFieldValue(num, typ, data[n:])
+		if m < 0 {
+			return nil, errInvalidData
+		}
+		if num != field {
+			out = append(out, data[:n+m]...)
+		}
+		data = data[n+m:]
+	}
+	return out, nil
+}
+
+// UnixMode returns the unix permission bits of a file mode, with the setuid,
+// setgid and sticky bits.
+func UnixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// FileMode returns the file mode of unix permission bits.
+func FileMode(m uint32) os.FileMode {
+	m &= unixModeMask
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
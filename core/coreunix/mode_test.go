@@ -0,0 +1,90 @@
+package coreunix
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	dag "github.com/ipfs/go-merkledag"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+func TestModeAndModTime(t *testing.T) {
+	nd := dag.NodeWithData(ft.FilePBData([]byte("hello"), 5))
+
+	mode, err := Mode(nd)
+	if err != nil || mode != 0 {
+		t.Fatalf("expected no mode, got %o, %v", mode, err)
+	}
+	mtime, err := ModTime(nd)
+	if err != nil || !mtime.IsZero() {
+		t.Fatalf("expected no mtime, got %s, %v", mtime, err)
+	}
+
+	wantMode := os.FileMode(0750) | os.ModeSetgid
+	wantMtime := time.Unix(1577934245, 123456789)
+	if err := SetMode(nd, wantMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetModTime(nd, wantMtime); err != nil {
+		t.Fatal(err)
+	}
+	// setting the fields again replaces them
+	if err := SetMode(nd, wantMode); err != nil {
+		t.Fatal(err)
+	}
+
+	if mode, err := Mode(nd); err != nil || mode != wantMode {
+		t.Fatalf("expected mode %o, got %o, %v", wantMode, mode, err)
+	}
+	if mtime, err := ModTime(nd); err != nil || !mtime.Equal(wantMtime) {
+		t.Fatalf("expected mtime %s, got %s, %v", wantMtime, mtime, err)
+	}
+
+	// go-unixfs keeps the fields it does not know
+	fsn, err := ft.FSNodeFromBytes(nd.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fsn.Data()) != "hello" {
+		t.Fatalf("unexpected file data %q", fsn.Data())
+	}
+	data, err := fsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode, err := Mode(dag.NodeWithData(data)); err != nil || mode != wantMode {
+		t.Fatalf("mode lost by go-unixfs: %o, %v", mode, err)
+	}
+
+	if err := SetMode(nd, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetModTime(nd, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if string(nd.Data()) != string(ft.FilePBData([]byte("hello"), 5)) {
+		t.Fatal("removing the mode and mtime should restore the data")
+	}
+}
+
+func TestMetadataNodeRaw(t *testing.T) {
+	raw := dag.NewRawNode([]byte("raw data"))
+	pn, err := MetadataNode(raw, dag.V1CidPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pn.Links()) != 1 || !pn.Links()[0].Cid.Equals(raw.Cid()) {
+		t.Fatal("the file node should link the raw node")
+	}
+	fsn, err := ft.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsn.Type() != ft.TFile || fsn.FileSize() != uint64(len(raw.RawData())) {
+		t.Fatalf("unexpected file node %s of %d bytes", fsn.Type(), fsn.FileSize())
+	}
+	if pn.Cid().Prefix().Version != 1 {
+		t.Fatal("the file node should use the CID builder")
+	}
+}
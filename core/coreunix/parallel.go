@@ -0,0 +1,176 @@
+package coreunix
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// parallelFileSize is the size of the largest file chunked and hashed by the
+// workers of a parallel add. The files are read in memory first, so they are
+// read one at a time like in any add, the larger files are added inline.
+const parallelFileSize = 1 << 20
+
+// fileJob is a file being added
+type fileJob struct {
+	path    string
+	abspath string
+	// info is the info of the file read before it, for the journal
+	info os.FileInfo
+	// preserved is the info stored in the node of the file
+	preserved os.FileInfo
+	// resumed is set when the node was completed by an interrupted add
+	resumed bool
+
+	// data is the content of the file chunked by a worker, file gives it
+	// its path on the disk for the filestore
+	data []byte
+	file files.File
+
+	nd ipld.Node
+	// nodes are the nodes of the file built by a worker, not stored yet
+	nodes []ipld.Node
+	err   error
+	done  chan struct{}
+}
+
+// filePool chunks and hashes the small files of an add on several workers.
+// The adder stores their nodes and patches them into the root in the order
+// of the files, so the CIDs and the output of an add do not depend on it.
+type filePool struct {
+	adder *Adder
+	jobs  chan *fileJob
+	wg    sync.WaitGroup
+
+	// queue holds the files in the order they were read, at most twice as
+	// many as the workers
+	queue []*fileJob
+}
+
+func newFilePool(adder *Adder, workers int) *filePool {
+	p := &filePool{
+		adder: adder,
+		jobs:  make(chan *fileJob, 2*workers),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+func (p *filePool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		var r io.Reader = bytes.NewReader(job.data)
+		if fi, ok := job.file.(files.FileInfo); ok {
+			r = &bufferedFile{FileInfo: fi, r: bytes.NewReader(job.data)}
+		}
+		nodes := &nodeCollector{}
+		job.nd, job.err = p.adder.layout(r, nodes)
+		job.nodes = nodes.nodes
+		job.data = nil
+		close(job.done)
+	}
+}
+
+// push queues a file read in memory, or completed already, finishing the
+// oldest files when the queue is full
+func (p *filePool) push(job *fileJob) error {
+	if job.done == nil {
+		job.done = make(chan struct{})
+		p.jobs <- job
+	}
+	p.queue = append(p.queue, job)
+	if len(p.queue) < cap(p.jobs) {
+		return nil
+	}
+	return p.finishOne()
+}
+
+// drain finishes the queued files
+func (p *filePool) drain() error {
+	for len(p.queue) > 0 {
+		if err := p.finishOne(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *filePool) finishOne() error {
+	job := p.queue[0]
+	p.queue = p.queue[1:]
+	<-job.done
+	if job.err != nil {
+		return job.err
+	}
+	return p.adder.finishFile(job)
+}
+
+// close stops the workers, the queued files are dropped
+func (p *filePool) close() {
+	close(p.jobs)
+	p.wg.Wait()
+	p.queue = nil
+}
+
+// bufferedFile is a file read in memory, with the info of the file for the
+// filestore
+type bufferedFile struct {
+	files.FileInfo
+	r *bytes.Reader
+}
+
+func (f *bufferedFile) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+// nodeCollector is the DAG service of the workers, it keeps the nodes of a
+// file until the adder stores them
+type nodeCollector struct {
+	nodes []ipld.Node
+}
+
+func (c *nodeCollector) Add(ctx context.Context, nd ipld.Node) error {
+	c.nodes = append(c.nodes, nd)
+	return nil
+}
+
+func (c *nodeCollector) AddMany(ctx context.Context, nds []ipld.Node) error {
+	c.nodes = append(c.nodes, nds...)
+	return nil
+}
+
+func (c *nodeCollector) Get(ctx context.Context, k cid.Cid) (ipld.Node, error) {
+	for _, nd := range c.nodes {
+		if nd.Cid().Equals(k) {
+			return nd, nil
+		}
+	}
+	return nil, ipld.ErrNotFound{Cid: k}
+}
+
+func (c *nodeCollector) GetMany(ctx context.Context, ks []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(ks))
+	for _, k := range ks {
+		nd, err := c.Get(ctx, k)
+		out <- &ipld.NodeOption{Node: nd, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (c *nodeCollector) Remove(ctx context.Context, k cid.Cid) error {
+	return nil
+}
+
+func (c *nodeCollector) RemoveMany(ctx context.Context, ks []cid.Cid) error {
+	return nil
+}
@@ -0,0 +1,208 @@
+package coreunix
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	gopath "path"
+	"strconv"
+	"strings"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	uio "github.com/ipfs/go-unixfs/io"
+)
+
+// PAX records of the mode and the modification time stored in UnixFS nodes.
+// They are only written for the nodes storing them, the header Mode and
+// ModTime of the other entries are defaults. Tools like GNU tar warn about
+// them, they are meant for the extraction by 'ipfs get'.
+const (
+	TarModeRecord  = "IPFS.mode"
+	TarMtimeRecord = "IPFS.mtime"
+)
+
+// TarWriter writes UnixFS DAGs to a tar archive like files.TarWriter, with the
+// mode and the modification time stored in the nodes.
+type TarWriter struct {
+	TarW *tar.Writer
+	// InfoRecords adds the PAX records of the mode and the mtime, read by
+	// TarHeaderInfo
+	InfoRecords bool
+	// ModTime is the modification time of the entries which do not store
+	// one, the time they are written at when it is zero
+	ModTime time.Time
+
+	ctx context.Context
+	dag ipld.DAGService
+}
+
+// NewTarWriter wraps the writer into a tar writer reading the nodes from dag.
+func NewTarWriter(ctx context.Context, dag ipld.DAGService, w io.Writer) *TarWriter {
+	return &TarWriter{
+		TarW: tar.NewWriter(w),
+		ctx:  ctx,
+		dag:  dag,
+	}
+}
+
+// WriteNode adds a node and its children to the archive.
+func (w *TarWriter) WriteNode(nd ipld.Node, fpath string) error {
+	f, err := unixfile.NewUnixfsFile(w.ctx, w.dag, nd)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch f := f.(type) {
+	case *files.Symlink:
+		hdr, err := w.header(nd, fpath, tar.TypeSymlink)
+		if err != nil {
+			return err
+		}
+		hdr.Linkname = f.Target
+		return w.TarW.WriteHeader(hdr)
+	case files.File:
+		size, err := f.Size()
+		if err != nil {
+			return err
+		}
+		hdr, err := w.header(nd, fpath, tar.TypeReg)
+		if err != nil {
+			return err
+		}
+		hdr.Size = size
+		if err := w.TarW.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w.TarW, f); err != nil {
+			return err
+		}
+		return w.TarW.Flush()
+	case files.Directory:
+		hdr, err := w.header(nd, fpath, tar.TypeDir)
+		if err != nil {
+			return err
+		}
+		if err := w.TarW.WriteHeader(hdr); err != nil {
+			return err
+		}
+		dir, err := uio.NewDirectoryFromNode(w.dag, nd)
+		if err != nil {
+			return err
+		}
+		// names come from untrusted DAGs, any name that could escape the
+		// directory it belongs to (or shadow a sibling) aborts the archive
+		seen := make(map[string]struct{})
+		return dir.ForEachLink(w.ctx, func(l *ipld.Link) error {
+			if err := validateTarEntryName(l.Name); err != nil {
+				return fmt.Errorf("%s: %w", fpath, err)
+			}
+			if _, ok := seen[l.Name]; ok {
+				return fmt.Errorf("%s: duplicate entry name %q", fpath, l.Name)
+			}
+			seen[l.Name] = struct{}{}
+			child, err := l.GetNode(w.ctx, w.dag)
+			if err != nil {
+				return err
+			}
+			return w.WriteNode(child, gopath.Join(fpath, l.Name))
+		})
+	default:
+		return fmt.Errorf("file type %T is not supported", f)
+	}
+}
+
+// validateTarEntryName ensures a directory entry name is a single path
+// segment that stays within its parent directory when extracted
+func validateTarEntryName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") || gopath.Clean(name) != name {
+		return fmt.Errorf("entry name %q is not a valid path segment", name)
+	}
+	return nil
+}
+
+// Close closes the tar writer.
+func (w *TarWriter) Close() error {
+	return w.TarW.Close()
+}
+
+// header returns the header of a node, with the defaults of files.TarWriter
+// when the node does not store its mode or mtime
+func (w *TarWriter) header(nd ipld.Node, fpath string, typ byte) (*tar.Header, error) {
+	mode, err := Mode(nd)
+	if err != nil {
+		return nil, err
+	}
+	mtime, err := ModTime(nd)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := &tar.Header{
+		Name:     fpath,
+		Typeflag: typ,
+		Mode:     0777,
+		ModTime:  w.ModTime,
+	}
+	if hdr.ModTime.IsZero() {
+		hdr.ModTime = time.Now().Truncate(time.Second)
+	}
+	if typ == tar.TypeReg {
+		hdr.Mode = 0644
+	}
+	if mode == 0 && mtime.IsZero() {
+		return hdr, nil
+	}
+
+	// PAX keeps the nanoseconds of the mtime
+	hdr.Format = tar.FormatPAX
+	records := map[string]string{}
+	if mode != 0 {
+		hdr.Mode = int64(UnixMode(mode))
+		records[TarModeRecord] = strconv.FormatUint(uint64(UnixMode(mode)), 8)
+	}
+	if !mtime.IsZero() {
+		hdr.ModTime = mtime
+		records[TarMtimeRecord] = fmt.Sprintf("%d.%09d", mtime.Unix(), mtime.Nanosecond())
+	}
+	if w.InfoRecords {
+		hdr.PAXRecords = records
+	}
+	return hdr, nil
+}
+
+// TarHeaderInfo returns the mode and the modification time stored in the
+// PAX records of a header written by TarWriter, 0 and the zero time when
+// they were not stored.
+func TarHeaderInfo(hdr *tar.Header) (os.FileMode, time.Time, error) {
+	var mode os.FileMode
+	var mtime time.Time
+	if s, ok := hdr.PAXRecords[TarModeRecord]; ok {
+		m, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			return 0, mtime, fmt.Errorf("invalid mode %q of %s", s, hdr.Name)
+		}
+		mode = FileMode(uint32(m))
+	}
+	if s, ok := hdr.PAXRecords[TarMtimeRecord]; ok {
+		secs, nsecs := s, "0"
+		if i := strings.IndexByte(s, '.'); i >= 0 {
+			secs, nsecs = s[:i], s[i+1:]
+		}
+		sec, err := strconv.ParseInt(secs, 10, 64)
+		if err != nil {
+			return 0, mtime, fmt.Errorf("invalid mtime %q of %s", s, hdr.Name)
+		}
+		nsec, err := strconv.ParseInt(nsecs, 10, 64)
+		if err != nil || nsec > 999999999 {
+			return 0, mtime, fmt.Errorf("invalid mtime %q of %s", s, hdr.Name)
+		}
+		mtime = time.Unix(sec, nsec)
+	}
+	return mode, mtime, nil
+}
@@ -0,0 +1,100 @@
+package coreunix
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	dag "github.com/ipfs/go-merkledag"
+	mdtest "github.com/ipfs/go-merkledag/test"
+	ft "github.com/ipfs/go-unixfs"
+)
+
+func TestTarWriterInfo(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdtest.Mock()
+
+	file := dag.NodeWithData(ft.FilePBData([]byte("hello"), 5))
+	mtime := time.Unix(1577934245, 123456789)
+	if err := SetMode(file, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetModTime(file, mtime); err != nil {
+		t.Fatal(err)
+	}
+	plain := dag.NodeWithData(ft.FilePBData([]byte("plain"), 5))
+	dir := ft.EmptyDirNode()
+	for name, nd := range map[string]*dag.ProtoNode{"file": file, "plain": plain} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddNodeLink(name, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, records := range []bool{true, false} {
+		var buf bytes.Buffer
+		w := NewTarWriter(ctx, dserv, &buf)
+		w.InfoRecords = records
+		if err := w.WriteNode(dir, "root"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		headers := map[string]*tar.Header{}
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers[hdr.Name] = hdr
+		}
+
+		hdr := headers["root/file"]
+		if hdr == nil || hdr.Mode != 0750 || !hdr.ModTime.Equal(mtime) {
+			t.Fatalf("unexpected header of the file: %+v", hdr)
+		}
+		mode, mt, err := TarHeaderInfo(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records && (mode != 0750 || !mt.Equal(mtime)) {
+			t.Fatalf("expected the records of the mode and mtime, got %o %s", mode, mt)
+		}
+		if !records && (mode != 0 || !mt.IsZero()) {
+			t.Fatal("expected no records")
+		}
+
+		hdr = headers["root/plain"]
+		if hdr == nil || hdr.Mode != 0644 {
+			t.Fatalf("expected the default mode, got %+v", hdr)
+		}
+		if mode, mt, err := TarHeaderInfo(hdr); err != nil || mode != os.FileMode(0) || !mt.IsZero() {
+			t.Fatalf("expected no mode and mtime, got %o %s %v", mode, mt, err)
+		}
+	}
+}
+
+func TestValidateTarEntryName(t *testing.T) {
+	for _, name := range []string{"a", "a.txt", "..a", "a..", "...", "a\\b"} {
+		if err := validateTarEntryName(name); err != nil {
+			t.Errorf("expected %q to be valid: %s", name, err)
+		}
+	}
+	for _, name := range []string{"", ".", "..", "../a", "a/..", "/a", "a/b", "a\x00b"} {
+		if err := validateTarEntryName(name); err == nil {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
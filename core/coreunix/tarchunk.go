@@ -0,0 +1,186 @@
+package coreunix
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	chunker "github.com/ipfs/go-ipfs-chunker"
+)
+
+const tarBlockSize = 512
+
+// TarSplitter splits a tar archive on the boundaries of its members: the
+// headers of a member are a chunk of their own, and its content is split by
+// another chunker from its first byte. The same files have the same chunks
+// in any archive, so they are deduplicated across archives. The chunks are
+// the bytes of the archive, the content of a file split by TarSplitter is
+// not changed.
+//
+// Data which is not a tar archive, and the end of an archive, is split by
+// the other chunker.
+type TarSplitter struct {
+	r        io.Reader
+	newInner func(io.Reader) (chunker.Splitter, error)
+
+	// member splits the content of the current member
+	member chunker.Splitter
+	// pad is the size of the padding after the content of the member
+	pad int64
+	// rest splits the data after the members
+	rest chunker.Splitter
+}
+
+// NewTarSplitter returns a TarSplitter splitting the content of the members
+// with the chunkers returned by newInner.
+func NewTarSplitter(r io.Reader, newInner func(io.Reader) (chunker.Splitter, error)) *TarSplitter {
+	return &TarSplitter{r: r, newInner: newInner}
+}
+
+// Reader returns the io.Reader associated to this Splitter.
+func (s *TarSplitter) Reader() io.Reader {
+	return s.r
+}
+
+// NextBytes returns the next chunk of data.
+func (s *TarSplitter) NextBytes() ([]byte, error) {
+	if s.member != nil {
+		b, err := s.member.NextBytes()
+		if err != io.EOF {
+			return b, err
+		}
+		s.member = nil
+	}
+	if s.rest != nil {
+		return s.rest.NextBytes()
+	}
+
+	// the padding of the previous member goes with the next headers
+	buf := make([]byte, s.pad, tarBlockSize)
+	n, err := io.ReadFull(s.r, buf)
+	buf = buf[:n]
+	s.pad = 0
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	for len(buf)+tarBlockSize <= chunker.ChunkSizeLimit {
+		block := make([]byte, tarBlockSize)
+		n, err := io.ReadFull(s.r, block)
+		if n == 0 && err == io.EOF {
+			if len(buf) == 0 {
+				return nil, io.EOF
+			}
+			return buf, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		size, ok := parseTarHeader(block[:n])
+		if !ok {
+			// the end of the archive, or not an archive
+			return s.splitRest(append(buf, block[:n]...))
+		}
+		buf = append(buf, block...)
+
+		switch block[156] {
+		case 'x', 'g', 'L', 'K':
+			// the PAX records and the long names are part of the headers
+			if int64(len(buf))+size+tarBlockSize > int64(chunker.ChunkSizeLimit) {
+				return s.splitRest(buf)
+			}
+			ext := make([]byte, blockPadded(size))
+			n, err := io.ReadFull(s.r, ext)
+			buf = append(buf, ext[:n]...)
+			if err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return buf, nil
+				}
+				return nil, err
+			}
+		case '1', '2', '3', '4', '5', '6':
+			// no content
+		default:
+			if size == 0 {
+				break
+			}
+			s.member, err = s.newInner(io.LimitReader(s.r, size))
+			if err != nil {
+				return nil, err
+			}
+			s.pad = blockPadded(size) - size
+			return buf, nil
+		}
+	}
+	return buf, nil
+}
+
+// splitRest splits the data read and the rest of the reader with the other
+// chunker
+func (s *TarSplitter) splitRest(read []byte) ([]byte, error) {
+	var err error
+	s.rest, err = s.newInner(io.MultiReader(bytes.NewReader(read), s.r))
+	if err != nil {
+		return nil, err
+	}
+	return s.rest.NextBytes()
+}
+
+func blockPadded(size int64) int64 {
+	return (size + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+}
+
+// parseTarHeader returns the size of the content of a tar header block, ok
+// is false when the block is not a valid header
+func parseTarHeader(block []byte) (size int64, ok bool) {
+	if len(block) != tarBlockSize {
+		return 0, false
+	}
+	chksum, err := parseTarOctal(block[148:156])
+	if err != nil {
+		return 0, false
+	}
+	// the checksum is the sum of the bytes, with spaces for the checksum,
+	// some old archives used signed bytes
+	var unsigned, signed int64
+	for i, c := range block {
+		if i >= 148 && i < 156 {
+			c = ' '
+		}
+		unsigned += int64(c)
+		signed += int64(int8(c))
+	}
+	if chksum != unsigned && chksum != signed {
+		return 0, false
+	}
+
+	field := block[124:136]
+	if field[0]&0x80 != 0 {
+		// base-256
+		if field[0]&0x40 != 0 {
+			return 0, false
+		}
+		size = int64(field[0] & 0x3f)
+		for _, c := range field[1:] {
+			if size > 1<<55 {
+				return 0, false
+			}
+			size = size<<8 | int64(c)
+		}
+		return size, true
+	}
+	size, err = parseTarOctal(field)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+func parseTarOctal(field []byte) (int64, error) {
+	s := strings.Trim(string(field), " \x00")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 8, 64)
+}
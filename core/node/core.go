@@ -3,6 +3,7 @@ package node
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
@@ -12,6 +13,7 @@ import (
 	"github.com/ipfs/go-filestore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	exchange "github.com/ipfs/go-ipfs-exchange-interface"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	pin "github.com/ipfs/go-ipfs-pinner"
 	"github.com/ipfs/go-ipfs-pinner/dspinner"
 	format "github.com/ipfs/go-ipld-format"
@@ -26,6 +28,8 @@ import (
 	"go.uber.org/fx"
 
 	"github.com/ipfs/kubo/core/node/helpers"
+	"github.com/ipfs/kubo/gc"
+	"github.com/ipfs/kubo/pinmeta"
 	"github.com/ipfs/kubo/repo"
 )
 
@@ -42,8 +46,21 @@ func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interf
 	return bsvc
 }
 
+// GCIndex provides the index of the blocks reachable from the pins and the
+// MFS root, used by GC and 'pin ls'
+func GCIndex(bstore blockstore.Blockstore, repo repo.Repo) *gc.Index {
+	// links of new blocks are read locally only
+	offlineDag := merkledag.NewDAGService(blockservice.New(bstore, offline.Exchange(bstore)))
+	return gc.NewIndex(repo.Datastore(), offlineDag)
+}
+
+// PinInfo provides the store of the names and metadata of the pins
+func PinInfo(repo repo.Repo) *pinmeta.Store {
+	return pinmeta.NewStore(repo.Datastore())
+}
+
 // Pinning creates new pinner which tells GC which blocks should be kept
-func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
+func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo, idx *gc.Index, info *pinmeta.Store) (pin.Pinner, error) {
 	rootDS := repo.Datastore()
 
 	syncFn := func(ctx context.Context) error {
@@ -61,7 +78,7 @@ func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo)
 		return nil, err
 	}
 
-	return pinning, nil
+	return gc.NewIndexedPinner(pinmeta.NewPinner(pinning, info), idx), nil
 }
 
 var (
@@ -109,8 +126,11 @@ func Dag(bs blockservice.BlockService) format.DAGService {
 }
 
 // Files loads persisted MFS root
-func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.DAGService) (*mfs.Root, error) {
+func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.DAGService, idx *gc.Index) (*mfs.Root, error) {
 	dsk := datastore.NewKey("/local/filesroot")
+	// the last root added to the gc index
+	var indexedLk sync.Mutex
+	var indexed cid.Cid
 	pf := func(ctx context.Context, c cid.Cid) error {
 		rootDS := repo.Datastore()
 		if err := rootDS.Sync(ctx, blockstore.BlockPrefix); err != nil {
@@ -123,7 +143,27 @@ func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.
 		if err := rootDS.Put(ctx, dsk, c.Bytes()); err != nil {
 			return err
 		}
-		return rootDS.Sync(ctx, dsk)
+		if err := rootDS.Sync(ctx, dsk); err != nil {
+			return err
+		}
+
+		// only the change of the root is indexed, GC syncs the index with
+		// the current root again if this fails
+		indexedLk.Lock()
+		defer indexedLk.Unlock()
+		if c.Equals(indexed) {
+			return nil
+		}
+		var removed []cid.Cid
+		if indexed.Defined() {
+			removed = []cid.Cid{indexed}
+		}
+		if err := idx.Update(ctx, gc.IndexFiles, []cid.Cid{c}, removed); err != nil {
+			logger.Errorf("updating gc index: %s", err)
+			return nil
+		}
+		indexed = c
+		return nil
 	}
 
 	var nd *merkledag.ProtoNode
@@ -158,6 +198,7 @@ func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.
 		return nil, err
 	}
 
+	indexed = nd.Cid()
 	root, err := mfs.NewRoot(ctx, dag, nd, pf)
 
 	lc.Append(fx.Hook{
@@ -0,0 +1,33 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/ipfs/kubo/denylist"
+	"github.com/ipfs/kubo/repo"
+	"go.uber.org/fx"
+)
+
+// Denylist loads the content denylists from the repo and reloads them when
+// the files change. Repos without a directory on disk get an empty denylist.
+func Denylist(lc fx.Lifecycle, r repo.Repo) *denylist.Denylist {
+	var dir string
+	if r.Path() != "" {
+		dir = filepath.Join(r.Path(), denylist.DirName)
+	}
+	dl := denylist.New(dir)
+	if _, err := dl.Reload(true); err != nil {
+		// (other lists are still enforced)
+		logger.Error(err)
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return dl.Start()
+		},
+		OnStop: func(context.Context) error {
+			return dl.Stop()
+		},
+	})
+	return dl
+}
@@ -201,6 +201,7 @@ func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		fx.Provide(RepoConfig),
 		fx.Provide(Datastore),
 		fx.Provide(BaseBlockstoreCtor(cacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
+		fx.Provide(GCAccessTracker),
 		finalBstore,
 	)
 }
@@ -327,8 +328,11 @@ var Core = fx.Options(
 	fx.Provide(BlockService),
 	fx.Provide(Dag),
 	fx.Provide(FetcherConfig),
+	fx.Provide(GCIndex),
+	fx.Provide(PinInfo),
 	fx.Provide(Pinning),
 	fx.Provide(Files),
+	fx.Provide(Denylist),
 )
 
 func Networked(bcfg *BuildCfg, cfg *config.Config) fx.Option {
@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+
 	"github.com/ipfs/go-datastore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	config "github.com/ipfs/kubo/config"
@@ -8,6 +10,7 @@ import (
 
 	"github.com/ipfs/go-filestore"
 	"github.com/ipfs/kubo/core/node/helpers"
+	"github.com/ipfs/kubo/gc"
 	"github.com/ipfs/kubo/repo"
 	"github.com/ipfs/kubo/thirdparty/verifbs"
 )
@@ -49,23 +52,53 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 	}
 }
 
+// GCAccessTracker records the use of blocks when GC retention policies are
+// configured, it is nil otherwise
+func GCAccessTracker(lc fx.Lifecycle, repo repo.Repo, cfg *config.Config) *gc.AccessTracker {
+	if len(cfg.Datastore.GCPolicies) == 0 {
+		return nil
+	}
+	tracker := gc.NewAccessTracker(repo.Datastore())
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return tracker.Start()
+		},
+		OnStop: func(context.Context) error {
+			return tracker.Stop()
+		},
+	})
+	return tracker
+}
+
+// trackAccess wraps the blockstore to record the use of blocks, if enabled
+func trackAccess(gcbs blockstore.GCBlockstore, tracker *gc.AccessTracker) blockstore.GCBlockstore {
+	if tracker == nil {
+		return gcbs
+	}
+	return gc.NewTrackingBlockstore(gcbs, tracker)
+}
+
 // GcBlockstoreCtor wraps the base blockstore with GC and Filestore layers
-func GcBlockstoreCtor(bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore) {
+func GcBlockstoreCtor(bb BaseBlocks, tracker *gc.AccessTracker) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore) {
 	gclocker = blockstore.NewGCLocker()
 	gcbs = blockstore.NewGCBlockstore(bb, gclocker)
+	gcbs = trackAccess(gcbs, tracker)
+	gcbs = gc.NewBarrierBlockstore(gcbs)
 
 	bs = gcbs
 	return
 }
 
 // GcBlockstoreCtor wraps GcBlockstore and adds Filestore support
-func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
+func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks, tracker *gc.AccessTracker) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
 	gclocker = blockstore.NewGCLocker()
 
 	// hash security
 	fstore = filestore.NewFilestore(bb, repo.FileManager())
 	gcbs = blockstore.NewGCBlockstore(fstore, gclocker)
 	gcbs = &verifbs.VerifBSGC{GCBlockstore: gcbs}
+	gcbs = trackAccess(gcbs, tracker)
+	gcbs = gc.NewBarrierBlockstore(gcbs)
 
 	bs = gcbs
 	return
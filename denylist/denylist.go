@@ -0,0 +1,405 @@
+// Package denylist implements lists of content which must not be served by
+// the node, such as the "bad bits" list used by public gateways.
+//
+// Lists are files in the denylists directory of the repo. Entries are stored
+// hashed, so the lists can be shared without spreading the content they block:
+//
+//	# comment
+//	//<sha256 hex>              hashed entry
+//	/ipfs/<cid>[/path]          plain entry, hashed when the list is loaded
+//
+// An entry is the SHA2-256 (hex) of "<CIDv1 base32>/<path>", for example the
+// hash of "bafy.../" blocks the CID, and the hash of "bafy.../foo" blocks
+// the /foo path under the CID and everything below it. Files with the .json
+// extension are read in the format of https://badbits.dwebops.pub/, a JSON
+// array of objects with hashed entries in the "anchor" field.
+package denylist
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("denylist")
+
+const (
+	// DirName is the name of the directory with the lists in the repo
+	DirName = "denylists"
+
+	// ReloadInterval is how often the directory is checked for changes
+	ReloadInterval = 5 * time.Second
+
+	hashedEntryPrefix = "//"
+)
+
+// ErrDenied is returned for content matching an entry of a denylist
+var ErrDenied = errors.New("content is blocked by a denylist")
+
+// Match describes the entry which blocks a path
+type Match struct {
+	// Path is the blocked part of the checked path, /ipfs/<cid>[/path]
+	Path string
+	// Entry is the hashed entry which matched
+	Entry string
+	// File is the name of the list the entry was loaded from
+	File string
+}
+
+// File describes a list loaded from the denylists directory
+type File struct {
+	Name    string
+	Entries int
+	Error   string `json:",omitempty"`
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Denylist is the set of entries of all lists in a directory
+type Denylist struct {
+	dir string
+
+	mu      sync.RWMutex
+	entries map[string]string // hashed entry -> file name
+	files   []File
+	state   map[string]fileState
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a denylist of the files in dir. Lists are loaded with Reload.
+// An empty dir results in an empty denylist.
+func New(dir string) *Denylist {
+	return &Denylist{
+		dir:     dir,
+		entries: make(map[string]string),
+	}
+}
+
+// Dir returns the directory the lists are loaded from
+func (d *Denylist) Dir() string {
+	return d.dir
+}
+
+// Start reloads the lists when the files in the directory change
+func (d *Denylist) Start() error {
+	if d.dir == "" {
+		return nil
+	}
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := d.Reload(false); err != nil {
+					log.Error(err)
+				}
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops reloading the lists
+func (d *Denylist) Stop() error {
+	if d.stop == nil {
+		return nil
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+	return nil
+}
+
+// Reload loads the lists if the files in the directory changed since the
+// last load, or unconditionally with force. It returns true if the lists were
+// loaded. Lists with errors are skipped, and the first error is returned.
+func (d *Denylist) Reload(force bool) (bool, error) {
+	if d.dir == "" {
+		return false, nil
+	}
+	state, err := readDirState(d.dir)
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.RLock()
+	changed := force || !sameState(d.state, state)
+	d.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+
+	names := make([]string, 0, len(state))
+	for name := range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var firstErr error
+	entries := make(map[string]string)
+	loaded := make([]File, 0, len(names))
+	for _, name := range names {
+		hashes, err := loadFile(filepath.Join(d.dir, name))
+		if err != nil {
+			err = fmt.Errorf("denylist %s: %w", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			loaded = append(loaded, File{Name: name, Error: err.Error()})
+			continue
+		}
+		for _, h := range hashes {
+			if _, ok := entries[h]; !ok {
+				entries[h] = name
+			}
+		}
+		loaded = append(loaded, File{Name: name, Entries: len(hashes)})
+	}
+
+	d.mu.Lock()
+	d.entries = entries
+	d.files = loaded
+	d.state = state
+	d.mu.Unlock()
+
+	log.Infof("loaded %d entries from %d denylists", len(entries), len(loaded))
+	return true, firstErr
+}
+
+// Files returns the lists loaded from the directory
+func (d *Denylist) Files() []File {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]File(nil), d.files...)
+}
+
+// Len returns the number of distinct entries of all lists
+func (d *Denylist) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.entries)
+}
+
+// CheckCid returns the match if the CID is blocked, or nil
+func (d *Denylist) CheckCid(c cid.Cid) *Match {
+	return d.check(c, nil)
+}
+
+// CheckPath returns the match if an /ipfs/<cid>[/path] path, or any of its
+// parent paths, is blocked. Paths in other namespaces are not checked.
+func (d *Denylist) CheckPath(p string) (*Match, error) {
+	root, segments, ok, err := splitIpfsPath(p)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return d.check(root, segments), nil
+}
+
+func (d *Denylist) check(root cid.Cid, segments []string) *Match {
+	if d == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if len(d.entries) == 0 {
+		return nil
+	}
+	for idx := 0; idx <= len(segments); idx++ {
+		subpath := strings.Join(segments[:idx], "/")
+		h := HashEntry(root, subpath)
+		if file, ok := d.entries[h]; ok {
+			p := "/ipfs/" + normalizeCid(root)
+			if subpath != "" {
+				p += "/" + subpath
+			}
+			return &Match{Path: p, Entry: hashedEntryPrefix + h, File: file}
+		}
+	}
+	return nil
+}
+
+// HashEntry returns the hashed entry for the path under the CID. The CID is
+// normalized to CIDv1, so entries match regardless of the CID version.
+func HashEntry(c cid.Cid, subpath string) string {
+	sum := sha256.Sum256([]byte(normalizeCid(c) + "/" + strings.Trim(subpath, "/")))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashPath returns the hashed entry line for an /ipfs/<cid>[/path] path
+func HashPath(p string) (string, error) {
+	root, segments, ok, err := splitIpfsPath(p)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("%q is not an /ipfs/ path", p)
+	}
+	return hashedEntryPrefix + HashEntry(root, strings.Join(segments, "/")), nil
+}
+
+func normalizeCid(c cid.Cid) string {
+	return cid.NewCidV1(c.Type(), c.Hash()).String()
+}
+
+// splitIpfsPath splits /ipfs/<cid>/a/b (or <cid>/a/b) into the CID and the
+// path segments. ok is false for paths in other namespaces.
+func splitIpfsPath(p string) (cid.Cid, []string, bool, error) {
+	var segments []string
+	for _, seg := range strings.Split(p, "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	if len(segments) == 0 {
+		return cid.Undef, nil, false, fmt.Errorf("invalid path %q", p)
+	}
+	if strings.HasPrefix(p, "/") {
+		if segments[0] != "ipfs" {
+			return cid.Undef, nil, false, nil
+		}
+		segments = segments[1:]
+		if len(segments) == 0 {
+			return cid.Undef, nil, false, fmt.Errorf("invalid path %q", p)
+		}
+	}
+	c, err := cid.Decode(segments[0])
+	if err != nil {
+		return cid.Undef, nil, false, fmt.Errorf("invalid path %q: %w", p, err)
+	}
+	return c, segments[1:], true, nil
+}
+
+func readDirState(dir string) (map[string]fileState, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]fileState{}, nil
+		}
+		return nil, err
+	}
+	state := make(map[string]fileState, len(dirEntries))
+	for _, e := range dirEntries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		ext := filepath.Ext(e.Name())
+		if ext != ".deny" && ext != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		state[e.Name()] = fileState{size: info.Size(), modTime: info.ModTime()}
+	}
+	return state, nil
+}
+
+func sameState(a, b map[string]fileState) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for name, s := range a {
+		if other, ok := b[name]; !ok || other != s {
+			return false
+		}
+	}
+	return true
+}
+
+func loadFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if filepath.Ext(name) == ".json" {
+		return parseJSON(f)
+	}
+	return Parse(f)
+}
+
+// Parse reads the entries of a list, returning them hashed
+func Parse(r io.Reader) ([]string, error) {
+	var hashes []string
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h, err := parseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		hashes = append(hashes, h)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+func parseEntry(line string) (string, error) {
+	if strings.HasPrefix(line, hashedEntryPrefix) {
+		return parseHash(strings.TrimPrefix(line, hashedEntryPrefix))
+	}
+	h, err := HashPath(line)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(h, hashedEntryPrefix), nil
+}
+
+func parseHash(h string) (string, error) {
+	h = strings.ToLower(h)
+	if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid hashed entry %q, expected sha256 in hex", h)
+	}
+	return h, nil
+}
+
+func parseJSON(r io.Reader) ([]string, error) {
+	var anchors []struct {
+		Anchor string `json:"anchor"`
+	}
+	if err := json.NewDecoder(r).Decode(&anchors); err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(anchors))
+	for idx, a := range anchors {
+		h, err := parseHash(a.Anchor)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", idx, err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,149 @@
+package denylist
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	testCidV0 = "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+	testCidV1 = "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354"
+)
+
+func TestHashEntry(t *testing.T) {
+	v0, err := cid.Decode(testCidV0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := cid.Decode(testCidV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HashEntry(v0, "") != HashEntry(v1, "") {
+		t.Error("entries should not depend on the CID version")
+	}
+	if HashEntry(v1, "/a/b/") != HashEntry(v1, "a/b") {
+		t.Error("entries should not depend on leading and trailing slashes")
+	}
+	// sha256 of "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354/"
+	if h, _ := HashPath("/ipfs/" + testCidV0); h != "//6fe4a9f9ee915120a76ac47bf396198b02a1457dd1234ab75b2c394ca8ef5779" {
+		t.Errorf("unexpected hashed entry %s", h)
+	}
+	if _, err := HashPath("/ipns/example.net"); err == nil {
+		t.Error("expected error for /ipns/ path")
+	}
+}
+
+func TestParse(t *testing.T) {
+	hashed, err := HashPath("/ipfs/" + testCidV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := Parse(strings.NewReader(`
+# comment
+` + hashed + `
+/ipfs/` + testCidV0 + `/secret
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 {
+		t.Fatalf("expected 2 entries, got %v", hashes)
+	}
+
+	for _, malformed := range []string{
+		"//abc",
+		"/ipns/example.net",
+		"/ipfs/not-a-cid",
+	} {
+		if _, err := Parse(strings.NewReader(malformed)); err == nil {
+			t.Errorf("expected error for %q", malformed)
+		} else if !strings.HasPrefix(err.Error(), "line 1:") {
+			t.Errorf("expected error with line number, got %s", err)
+		}
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	dir := t.TempDir()
+	dl := New(dir)
+	if ok, err := dl.Reload(false); err != nil || !ok {
+		t.Fatalf("expected initial load, got %t %v", ok, err)
+	}
+	if m, _ := dl.CheckPath("/ipfs/" + testCidV0); m != nil {
+		t.Fatal("empty denylist should not block")
+	}
+
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret, _ := HashPath("/ipfs/" + testCidV1 + "/secret")
+	write("local.deny", secret+"\n")
+	write("ignored.txt", "/ipfs/"+testCidV1+"\n")
+	if ok, err := dl.Reload(false); err != nil || !ok {
+		t.Fatalf("expected reload after change, got %t %v", ok, err)
+	}
+	if ok, _ := dl.Reload(false); ok {
+		t.Error("unchanged lists should not be reloaded")
+	}
+
+	for p, blocked := range map[string]bool{
+		"/ipfs/" + testCidV1:                   false,
+		"/ipfs/" + testCidV1 + "/other":        false,
+		"/ipfs/" + testCidV1 + "/secret":       true,
+		"/ipfs/" + testCidV0 + "/secret/a/b":   true,
+		"/ipfs/" + testCidV1 + "//secret/":     true,
+		"/ipns/example.net/secret":             false,
+		"/ipfs/" + testCidV1 + "/secretive":    false,
+		"/ipfs/" + testCidV1 + "/other/secret": false,
+	} {
+		m, err := dl.CheckPath(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (m != nil) != blocked {
+			t.Errorf("%s: expected blocked %t, got %v", p, blocked, m)
+		}
+		if m != nil && (m.File != "local.deny" || m.Entry != secret) {
+			t.Errorf("%s: unexpected match %v", p, m)
+		}
+	}
+
+	// badbits format, a broken list doesn't prevent others from loading
+	root, _ := cid.Decode(testCidV0)
+	write("badbits.json", `[{"anchor": "`+HashEntry(root, "")+`"}]`)
+	write("broken.deny", "//not-a-hash\n")
+	// (make sure the size or the modification time changes)
+	future := time.Now().Add(time.Hour)
+	_ = os.Chtimes(filepath.Join(dir, "local.deny"), future, future)
+	if _, err := dl.Reload(false); err == nil || !strings.Contains(err.Error(), "broken.deny") {
+		t.Fatalf("expected error for broken list, got %v", err)
+	}
+	if m := dl.CheckCid(root); m == nil || m.File != "badbits.json" {
+		t.Errorf("expected CID to be blocked by badbits.json, got %v", m)
+	}
+	if dl.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", dl.Len())
+	}
+	files := dl.Files()
+	if len(files) != 3 || files[1].Name != "broken.deny" || files[1].Error == "" {
+		t.Errorf("unexpected files %v", files)
+	}
+
+	// removed lists are unloaded
+	if err := os.Remove(filepath.Join(dir, "badbits.json")); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = dl.Reload(false)
+	if m := dl.CheckCid(root); m != nil {
+		t.Errorf("entry of removed list is still enforced: %v", m)
+	}
+}
@@ -0,0 +1,354 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// SweepBatchSize is the number of blocks deleted at once by ConcurrentGC.
+// Reads and writes to the blockstore wait while a batch is deleted.
+var SweepBatchSize = 1024
+
+// ErrConcurrentGCRunning is returned when a concurrent garbage collection is
+// started while another one is running on the same blockstore.
+var ErrConcurrentGCRunning = errors.New("concurrent garbage collection is already running")
+
+// BarrierBlockstore is a GCBlockstore which records the blocks that are
+// read or written while a concurrent garbage collection is running. This is
+// the write barrier of the marking done by ConcurrentGC: any block which is
+// accessed during the collection, and everything it links to, is kept.
+type BarrierBlockstore struct {
+	bstore.GCBlockstore
+	*barrier
+}
+
+type barrier struct {
+	// held for writing while ConcurrentGC deletes a batch of blocks
+	mu       sync.RWMutex
+	tracking int32
+
+	touchedLk sync.Mutex
+	touched   *cid.Set
+}
+
+var _ bstore.GCBlockstore = (*BarrierBlockstore)(nil)
+
+// NewBarrierBlockstore wraps the blockstore with the write barrier needed
+// by ConcurrentGC
+func NewBarrierBlockstore(bs bstore.GCBlockstore) *BarrierBlockstore {
+	return &BarrierBlockstore{GCBlockstore: bs, barrier: new(barrier)}
+}
+
+// Wrap returns a blockstore sharing the barrier, for blockstores bypassing
+// this one to write to the same storage, such as the one of the adder
+func (b *BarrierBlockstore) Wrap(bs bstore.GCBlockstore) *BarrierBlockstore {
+	return &BarrierBlockstore{GCBlockstore: bs, barrier: b.barrier}
+}
+
+// record records the access to the block while a collection is running.
+// Accesses made by the walks of the collection itself are not recorded. The
+// returned function must be called once the access is done.
+func (b *BarrierBlockstore) record(ctx context.Context, keys ...cid.Cid) func() {
+	if atomic.LoadInt32(&b.tracking) == 0 {
+		return func() {}
+	}
+	b.mu.RLock()
+	if ctx.Value(untrackedKey{}) == nil {
+		b.touchedLk.Lock()
+		if b.touched == nil {
+			b.touched = cid.NewSet()
+		}
+		for _, k := range keys {
+			b.touched.Add(k)
+		}
+		b.touchedLk.Unlock()
+	}
+	return b.mu.RUnlock
+}
+
+func (b *BarrierBlockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	defer b.record(ctx, c)()
+	return b.GCBlockstore.Has(ctx, c)
+}
+
+func (b *BarrierBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	defer b.record(ctx, c)()
+	return b.GCBlockstore.Get(ctx, c)
+}
+
+func (b *BarrierBlockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	defer b.record(ctx, c)()
+	return b.GCBlockstore.GetSize(ctx, c)
+}
+
+func (b *BarrierBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	defer b.record(ctx, blk.Cid())()
+	return b.GCBlockstore.Put(ctx, blk)
+}
+
+func (b *BarrierBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	keys := make([]cid.Cid, len(blks))
+	for idx, blk := range blks {
+		keys[idx] = blk.Cid()
+	}
+	defer b.record(ctx, keys...)()
+	return b.GCBlockstore.PutMany(ctx, blks)
+}
+
+// start enables the barrier, it fails if a collection is already running
+func (b *BarrierBlockstore) start() bool {
+	if !atomic.CompareAndSwapInt32(&b.tracking, 0, 1) {
+		return false
+	}
+	b.drain()
+	return true
+}
+
+func (b *BarrierBlockstore) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	atomic.StoreInt32(&b.tracking, 0)
+	b.touchedLk.Lock()
+	b.touched = nil
+	b.touchedLk.Unlock()
+}
+
+// drain returns the blocks accessed since the last call, each one once
+func (b *BarrierBlockstore) drain() []cid.Cid {
+	b.touchedLk.Lock()
+	defer b.touchedLk.Unlock()
+	if b.touched == nil {
+		return nil
+	}
+	touched := b.touched.Keys()
+	b.touched = nil
+	return touched
+}
+
+// ConcurrentGC performs a garbage collection like GC, without holding the
+// GC lock of the blockstore for the whole run:
+//
+//   - the GC lock is taken briefly to enable the write barrier of the
+//     blockstore, so operations holding the pin lock finish first
+//   - the marked set is computed without any lock, blocks accessed in the
+//     meantime are recorded by the barrier
+//   - the GC lock is taken briefly again to reconcile the marked set with
+//     pins and best-effort roots which changed since
+//   - blocks are deleted in batches of SweepBatchSize. Before every batch,
+//     the blocks recorded by the barrier and their descendants are marked.
+//
+// Only the blocks selected by the policy are deleted, unless it is nil.
+// Removed blocks and errors are reported through the returned channel, as
+// they are deleted.
+func ConcurrentGC(ctx context.Context, bs *BarrierBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error), policy Policy) <-chan Result {
+	ctx, cancel := context.WithCancel(untracked(ctx))
+	output := make(chan Result, 128)
+
+	if !bs.start() {
+		defer cancel()
+		output <- Result{Error: ErrConcurrentGCRunning}
+		close(output)
+		return output
+	}
+
+	go func() {
+		defer cancel()
+		defer close(output)
+		defer bs.stop()
+
+		// all reads go around the barrier, walking the DAGs does not keep
+		// anything alive
+		base := bs.GCBlockstore
+		ds := dag.NewDAGService(bserv.New(base, offline.Exchange(base)))
+
+		sendErr := func(err error) {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+
+		// wait for in-flight adds and pins, operations started from now on
+		// are recorded by the barrier
+		bs.GCLock(ctx).Unlock(ctx)
+
+		roots, err := bestEffortRoots()
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		marked, err := ColoredSet(ctx, pn, ds, roots, output)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		// reconcile: only new pins and roots are walked, as the set already
+		// contains the descendants of the old ones
+		unlocker := bs.GCLock(ctx)
+		var added *cid.Set
+		if roots, err = bestEffortRoots(); err == nil {
+			added, err = ColoredSet(ctx, pn, &skipMarked{ds, marked}, roots, output)
+		}
+		unlocker.Unlock(ctx)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		_ = added.ForEach(func(c cid.Cid) error {
+			marked.Add(c)
+			return nil
+		})
+
+		// The blockstore reports raw blocks. We need to remove the codecs from the CIDs.
+		marked, err = toRawCids(marked)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		keychan, err := base.AllKeysChan(ctx)
+		if err == nil && policy != nil {
+			keychan, err = selectKeys(ctx, policy, keychan, marked)
+		}
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		errors := false
+		batch := make([]cid.Cid, 0, SweepBatchSize)
+		sweep := func() bool {
+			removed, failed, err := sweepBatch(ctx, bs, ds, marked, batch)
+			batch = batch[:0]
+			if err != nil {
+				sendErr(err)
+				return false
+			}
+			for _, k := range removed {
+				if policy != nil {
+					policy.Removed(ctx, k)
+				}
+				select {
+				case output <- Result{KeyRemoved: k}:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			for _, e := range failed {
+				errors = true
+				select {
+				case output <- Result{Error: e}:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}
+
+	loop:
+		for ctx.Err() == nil {
+			select {
+			case k, ok := <-keychan:
+				if !ok {
+					break loop
+				}
+				if marked.Has(k) {
+					continue
+				}
+				batch = append(batch, k)
+				if len(batch) >= SweepBatchSize && !sweep() {
+					return
+				}
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		if ctx.Err() != nil || (len(batch) > 0 && !sweep()) {
+			return
+		}
+		if errors {
+			sendErr(ErrCannotDeleteSomeBlocks)
+			return
+		}
+
+		if gds, ok := dstor.(dstore.GCDatastore); ok {
+			if err := gds.CollectGarbage(ctx); err != nil {
+				sendErr(err)
+			}
+		}
+	}()
+
+	return output
+}
+
+// sweepBatch deletes the blocks of the batch which are still unmarked after
+// marking the blocks recorded by the barrier
+func sweepBatch(ctx context.Context, bs *BarrierBlockstore, ng ipld.NodeGetter, marked *cid.Set, batch []cid.Cid) (removed []cid.Cid, failed []error, err error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if err := markTouched(ctx, ng, marked, bs.drain()); err != nil {
+		return nil, nil, err
+	}
+	for _, k := range batch {
+		if marked.Has(k) {
+			continue
+		}
+		if err := bs.GCBlockstore.DeleteBlock(ctx, k); err != nil {
+			failed = append(failed, &CannotDeleteBlockError{k, err})
+			continue
+		}
+		removed = append(removed, k)
+	}
+	return removed, failed, nil
+}
+
+// markTouched adds the blocks and everything they link to (when available
+// locally) to the set of raw CIDs
+func markTouched(ctx context.Context, ng ipld.NodeGetter, marked *cid.Set, touched []cid.Cid) error {
+	for len(touched) > 0 {
+		c := touched[len(touched)-1]
+		touched = touched[:len(touched)-1]
+		if !marked.Visit(cid.NewCidV1(cid.Raw, c.Hash())) {
+			continue
+		}
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err != nil {
+			if ipld.IsNotFound(err) {
+				continue
+			}
+			return &CannotFetchLinksError{c, err}
+		}
+		for _, l := range links {
+			touched = append(touched, l.Cid)
+		}
+	}
+	return nil
+}
+
+// skipMarked is a NodeGetter which returns nodes in the marked set without
+// links, so walking the DAGs again stops at the already marked parts
+type skipMarked struct {
+	ipld.NodeGetter
+	marked *cid.Set
+}
+
+func (s *skipMarked) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if s.marked.Has(toCidV1(c)) {
+		return &dag.ProtoNode{}, nil
+	}
+	return s.NodeGetter.Get(ctx, c)
+}
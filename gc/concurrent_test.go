@@ -0,0 +1,146 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestConcurrentGC(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := NewBarrierBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker()))
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(data string, links ...*dag.ProtoNode) *dag.ProtoNode {
+		t.Helper()
+		nd := dag.NodeWithData([]byte(data))
+		for _, l := range links {
+			if err := nd.AddNodeLink(l.Cid().String(), l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+
+	pinnedChild := add("pinned child")
+	pinned := add("pinned", pinnedChild)
+	if err := pinner.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+	garbage := add("garbage")
+	// linked by a block written during the collection
+	revived := add("revived")
+
+	SweepBatchSize = 1
+	defer func() { SweepBatchSize = 1024 }()
+
+	var written, writtenAround, pinnedLate *dag.ProtoNode
+	calls := 0
+	roots := func() ([]cid.Cid, error) {
+		calls++
+		if calls == 1 {
+			// while marking
+			written = add("written", revived)
+			pinnedLate = add("pinned late")
+			// blockstores sharing the barrier are seen too
+			wrapped := bs.Wrap(bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bs))
+			writtenAround = dag.NodeWithData([]byte("written around"))
+			if err := dag.NewDAGService(bserv.New(wrapped, offline.Exchange(wrapped))).Add(ctx, writtenAround); err != nil {
+				return nil, err
+			}
+			if err := pinner.Pin(ctx, pinnedLate, true); err != nil {
+				return nil, err
+			}
+			for res := range ConcurrentGC(ctx, bs, dstore, pinner, nil, nil) {
+				if !errors.Is(res.Error, ErrConcurrentGCRunning) {
+					t.Errorf("expected %s, got %v", ErrConcurrentGCRunning, res.Error)
+				}
+			}
+		}
+		return nil, nil
+	}
+
+	removed := cid.NewSet()
+	for res := range ConcurrentGC(ctx, bs, dstore, pinner, roots, nil) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed.Add(res.KeyRemoved)
+	}
+
+	if removed.Len() != 1 || !removed.Has(cid.NewCidV1(cid.Raw, garbage.Cid().Hash())) {
+		t.Fatalf("expected only the garbage block to be removed, got %v", removed.Keys())
+	}
+	for _, nd := range []*dag.ProtoNode{pinned, pinnedChild, written, writtenAround, revived, pinnedLate} {
+		if has, err := bs.Has(ctx, nd.Cid()); err != nil || !has {
+			t.Errorf("block %s was removed", nd.Cid())
+		}
+	}
+
+	// the next collection removes the blocks which are not referenced anymore
+	removed = cid.NewSet()
+	noRoots := func() ([]cid.Cid, error) { return nil, nil }
+	for res := range ConcurrentGC(ctx, bs, dstore, pinner, noRoots, nil) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed.Add(res.KeyRemoved)
+	}
+	if removed.Len() != 3 {
+		t.Fatalf("expected the written blocks to be removed, got %v", removed.Keys())
+	}
+}
+
+func TestBarrierRecordsOnce(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := NewBarrierBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker()))
+	nd := dag.NodeWithData([]byte("read"))
+	other := dag.NodeWithData([]byte("read by the collection"))
+	for _, n := range []*dag.ProtoNode{nd, other} {
+		if err := bs.Put(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bs.start() {
+		t.Fatal("could not start the barrier")
+	}
+	defer bs.stop()
+	for i := 0; i < 3; i++ {
+		if _, err := bs.Get(ctx, nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bs.Has(ctx, nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bs.Get(untracked(ctx), other.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if touched := bs.drain(); len(touched) != 1 || !touched[0].Equals(nd.Cid()) {
+		t.Fatalf("expected only %s to be recorded, got %v", nd.Cid(), touched)
+	}
+	if touched := bs.drain(); len(touched) != 0 {
+		t.Fatalf("expected nothing after draining, got %v", touched)
+	}
+}
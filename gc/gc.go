@@ -26,6 +26,8 @@ var log = logging.Logger("gc")
 type Result struct {
 	KeyRemoved cid.Cid
 	Error      error
+	// Size of the block, only reported by DryRun
+	Size int
 }
 
 // converts a set of CIDs with different codecs to a set of CIDs with the raw codec.
@@ -48,7 +50,14 @@ func toRawCids(set *cid.Set) (*cid.Set, error) {
 // The routine then iterates over every block in the blockstore and
 // deletes any block that is not found in the marked set.
 func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid) <-chan Result {
-	ctx, cancel := context.WithCancel(ctx)
+	return GCWithPolicy(ctx, bs, dstor, pn, bestEffortRoots, nil)
+}
+
+// GCWithPolicy performs a garbage collection like GC, only deleting the
+// blocks which are not in the marked set and are selected by the policy. A
+// nil policy deletes all of them.
+func GCWithPolicy(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid, policy Policy) <-chan Result {
+	ctx, cancel := context.WithCancel(untracked(ctx))
 
 	unlocker := bs.GCLock(ctx)
 
@@ -82,6 +91,9 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 		}
 
 		keychan, err := bs.AllKeysChan(ctx)
+		if err == nil && policy != nil {
+			keychan, err = selectKeys(ctx, policy, keychan, gcs)
+		}
 		if err != nil {
 			select {
 			case output <- Result{Error: err}:
@@ -115,6 +127,9 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 						// continue as error is non-fatal
 						continue loop
 					}
+					if policy != nil {
+						policy.Removed(ctx, k)
+					}
 					select {
 					case output <- Result{KeyRemoved: k}:
 					case <-ctx.Done():
@@ -221,6 +236,21 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 	if err != nil {
 		return nil, err
 	}
+
+	// With an index, the walks below stop at the indexed blocks, and only
+	// visit what was not indexed.
+	var missingPins, missingFiles []cid.Cid
+	if idx := PinnerIndex(pn); idx != nil {
+		missingPins, missingFiles, err = idx.Seed(ctx, gcs, rkeys, bestEffortRoots)
+		if err != nil {
+			log.Errorf("gc index unavailable, walking all pins: %s", err)
+			gcs = cid.NewSet()
+			missingPins, missingFiles = nil, nil
+		}
+	}
+	rkeys = append(rkeys, missingPins...)
+	bestEffortRoots = append(bestEffortRoots[:len(bestEffortRoots):len(bestEffortRoots)], missingFiles...)
+
 	err = Descendants(ctx, getLinks, gcs, rkeys)
 	if err != nil {
 		errors = true
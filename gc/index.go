@@ -0,0 +1,763 @@
+package gc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// IndexSpace identifies the kind of roots a block is reachable from
+type IndexSpace string
+
+const (
+	// IndexPins is the space of the recursive pins
+	IndexPins IndexSpace = "pins"
+	// IndexFiles is the space of the MFS root
+	IndexFiles IndexSpace = "files"
+)
+
+var (
+	indexPrefix        = dstore.NewKey("/local/gcindex")
+	indexRefsPrefix    = indexPrefix.ChildString("refs")
+	indexLinksPrefix   = indexPrefix.ChildString("links")
+	indexMissingPrefix = indexPrefix.ChildString("missing")
+	indexRootPrefix    = indexPrefix.ChildString("roots")
+	indexDirtyKey      = indexPrefix.ChildString("dirty")
+	indexVersionKey    = indexPrefix.ChildString("version")
+)
+
+// indexVersion is the version of the layout of the index, an index with
+// another version is rebuilt
+const indexVersion = "2"
+
+// indexBatchSize is the number of records an update keeps in memory, and
+// the number of writes in a datastore batch. Larger updates are written in
+// several batches while the index is marked as dirty, so an update that is
+// interrupted halfway causes a rebuild.
+var indexBatchSize = 1 << 14
+
+// indexSpaces are the spaces stored in the index
+var indexSpaces = []IndexSpace{IndexPins, IndexFiles}
+
+// indexRecord is the state of an indexed block. It is stored under several
+// keys, so listing the blocks of a space only reads keys:
+//
+//   - refs/<space>/<cid>: the number of references in the space, as a varint
+//   - links/<cid>: the children of the block, loaded when it is first
+//     referenced
+//   - missing/<cid>: set when the block was not stored locally when indexed,
+//     its descendants are not indexed
+type indexRecord struct {
+	Refs    map[IndexSpace]int
+	Links   []cid.Cid
+	Missing bool
+}
+
+func (r *indexRecord) total() int {
+	total := 0
+	for _, n := range r.Refs {
+		total += n
+	}
+	return total
+}
+
+// Index is a persistent index, in the datastore, of the blocks reachable from
+// the recursive pins and the MFS root. Garbage collection and 'pin ls' use it
+// instead of walking the DAGs of all pins every time.
+//
+// Every indexed block has a count of references per space: the roots and
+// indexed parents linking to it. The links of a block are followed when it
+// is first referenced and released with its last reference, so updating the
+// index after a change of the roots costs in the size of the change, not in
+// the size of the pinned DAGs.
+//
+// The index is only ever used to skip walks: blocks which are not indexed
+// are found by walking the roots as usual.
+type Index struct {
+	ds dstore.Datastore
+	ng ipld.NodeGetter
+
+	mu sync.Mutex
+}
+
+// NewIndex returns the index stored in the datastore. Links of new blocks
+// are read with the node getter, which should not fetch blocks from the
+// network.
+func NewIndex(ds dstore.Datastore, ng ipld.NodeGetter) *Index {
+	return &Index{ds: ds, ng: ng}
+}
+
+// Sync updates the roots of the space, indexing the blocks reachable from new
+// roots and releasing the blocks which are not reachable anymore.
+func (idx *Index) Sync(ctx context.Context, space IndexSpace, roots []cid.Cid) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.check(ctx); err != nil {
+		return err
+	}
+
+	indexed, err := idx.keys(ctx, indexRootPrefix.ChildString(string(space)))
+	if err != nil {
+		return err
+	}
+	want := cid.NewSet()
+	var added, removed []cid.Cid
+	for _, c := range roots {
+		if want.Visit(c) && !indexed.Has(c) {
+			added = append(added, c)
+		}
+	}
+	_ = indexed.ForEach(func(c cid.Cid) error {
+		if !want.Has(c) {
+			removed = append(removed, c)
+		}
+		return nil
+	})
+	return idx.update(ctx, space, added, removed)
+}
+
+// Update adds and removes roots of the space, without listing the other
+// roots. Roots which are already indexed are not added again, and roots which
+// are not indexed are not removed.
+func (idx *Index) Update(ctx context.Context, space IndexSpace, add, remove []cid.Cid) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.check(ctx); err != nil {
+		return err
+	}
+
+	rootPrefix := indexRootPrefix.ChildString(string(space))
+	adding := cid.NewSet()
+	var added, removed []cid.Cid
+	for _, c := range add {
+		if !adding.Visit(c) {
+			continue
+		}
+		has, err := idx.ds.Has(ctx, rootPrefix.ChildString(c.String()))
+		if err != nil {
+			return err
+		}
+		if !has {
+			added = append(added, c)
+		}
+	}
+	removing := cid.NewSet()
+	for _, c := range remove {
+		if adding.Has(c) || !removing.Visit(c) {
+			continue
+		}
+		has, err := idx.ds.Has(ctx, rootPrefix.ChildString(c.String()))
+		if err != nil {
+			return err
+		}
+		if has {
+			removed = append(removed, c)
+		}
+	}
+	return idx.update(ctx, space, added, removed)
+}
+
+func (idx *Index) update(ctx context.Context, space IndexSpace, added, removed []cid.Cid) error {
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	u := &indexUpdate{idx: idx, records: make(map[cid.Cid]*indexRecord)}
+	// add first, so blocks shared with the removed roots are not released
+	for _, c := range added {
+		if err := u.inc(ctx, space, c); err != nil {
+			return err
+		}
+	}
+	for _, c := range removed {
+		if _, err := u.dec(ctx, space, c); err != nil {
+			return err
+		}
+	}
+	return u.commit(ctx, space, added, removed)
+}
+
+// Seed syncs the index with the recursive pins and the MFS roots, and adds
+// the indexed blocks to the set of CIDv1s. It returns the blocks which were
+// not stored locally when indexed: the set does not contain them nor their
+// descendants, so they must be walked.
+func (idx *Index) Seed(ctx context.Context, set *cid.Set, pins, files []cid.Cid) (missingPins, missingFiles []cid.Cid, err error) {
+	if err := idx.Sync(ctx, IndexPins, pins); err != nil {
+		return nil, nil, err
+	}
+	if err := idx.Sync(ctx, IndexFiles, files); err != nil {
+		return nil, nil, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	missing, err := idx.keys(ctx, indexMissingPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	missingSpace := func(space IndexSpace, other *cid.Set) ([]cid.Cid, *cid.Set, error) {
+		var out []cid.Cid
+		indexed, err := idx.keys(ctx, indexRefsPrefix.ChildString(string(space)))
+		if err != nil {
+			return nil, nil, err
+		}
+		_ = indexed.ForEach(func(c cid.Cid) error {
+			switch {
+			case !missing.Has(c):
+				set.Add(toCidV1(c))
+			case other == nil || !other.Has(c):
+				out = append(out, c)
+			}
+			return nil
+		})
+		return out, indexed, nil
+	}
+	missingPins, pinned, err := missingSpace(IndexPins, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	missingFiles, _, err = missingSpace(IndexFiles, pinned)
+	if err != nil {
+		return nil, nil, err
+	}
+	return missingPins, missingFiles, nil
+}
+
+// Pinned syncs the index with the recursive pins and returns the blocks
+// reachable from them, the pins included. As with Seed, descendants of the
+// missing blocks are not included.
+func (idx *Index) Pinned(ctx context.Context, pins []cid.Cid) (indexed, missing []cid.Cid, err error) {
+	if err := idx.Sync(ctx, IndexPins, pins); err != nil {
+		return nil, nil, err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	missingSet, err := idx.keys(ctx, indexMissingPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	pinned, err := idx.keys(ctx, indexRefsPrefix.ChildString(string(IndexPins)))
+	if err != nil {
+		return nil, nil, err
+	}
+	indexed = pinned.Keys()
+	for _, c := range indexed {
+		if missingSet.Has(c) {
+			missing = append(missing, c)
+		}
+	}
+	return indexed, missing, nil
+}
+
+// Exclusive returns the blocks, as CIDv1s, which are only reachable from the
+// root of the space: removing the root would release them, and no other space
+// references them. The index is not changed, it should be synced with the
+// roots of all the spaces first. It returns false when the index can't tell,
+// if the root is not indexed or blocks were not stored locally when indexed.
+func (idx *Index) Exclusive(ctx context.Context, space IndexSpace, root cid.Cid) (*cid.Set, bool, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	missing, err := idx.keys(ctx, indexMissingPrefix)
+	if err != nil || missing.Len() > 0 {
+		return nil, false, err
+	}
+	indexed, err := idx.ds.Has(ctx, indexRootPrefix.ChildString(string(space)).ChildString(root.String()))
+	if err != nil || !indexed {
+		return nil, false, err
+	}
+
+	// the records are never written, they are all kept in memory
+	u := &indexUpdate{idx: idx, records: make(map[cid.Cid]*indexRecord), readOnly: true}
+	released, err := u.dec(ctx, space, root)
+	if err != nil {
+		return nil, false, err
+	}
+	exclusive := cid.NewSet()
+	for _, c := range released {
+		if u.records[c].total() == 0 {
+			exclusive.Add(toCidV1(c))
+		}
+	}
+	return exclusive, true, nil
+}
+
+// keys returns the CIDs of the keys under the prefix, without reading the
+// values
+func (idx *Index) keys(ctx context.Context, prefix dstore.Key) (*cid.Set, error) {
+	res, err := idx.ds.Query(ctx, dsq.Query{
+		Prefix:   prefix.String(),
+		KeysOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	keys := cid.NewSet()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(path.Base(r.Key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gc index key %s: %w", r.Key, err)
+		}
+		keys.Add(c)
+	}
+	return keys, nil
+}
+
+// check clears the index if an update was interrupted, or if it was written
+// with another layout. It is then rebuilt as the spaces are synced.
+func (idx *Index) check(ctx context.Context) error {
+	dirty, err := idx.ds.Has(ctx, indexDirtyKey)
+	if err != nil {
+		return err
+	}
+	version, err := idx.ds.Get(ctx, indexVersionKey)
+	switch {
+	case err == dstore.ErrNotFound:
+	case err != nil:
+		return err
+	case !dirty && string(version) == indexVersion:
+		return nil
+	}
+	if dirty {
+		log.Warn("gc index update was interrupted, rebuilding the index")
+	}
+
+	// the dirty marker is kept until the index is cleared
+	if err := idx.ds.Put(ctx, indexDirtyKey, []byte{}); err != nil {
+		return err
+	}
+	for {
+		res, err := idx.ds.Query(ctx, dsq.Query{
+			Prefix:   indexPrefix.String(),
+			KeysOnly: true,
+			Filters:  []dsq.Filter{dsq.FilterKeyCompare{Op: dsq.NotEqual, Key: indexDirtyKey.String()}},
+			Limit:    indexBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		b, err := newIndexBatch(ctx, idx.ds)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := b.Delete(ctx, dstore.NewKey(e.Key)); err != nil {
+				return err
+			}
+		}
+		if err := b.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	if err := idx.ds.Put(ctx, indexVersionKey, []byte(indexVersion)); err != nil {
+		return err
+	}
+	if err := idx.ds.Delete(ctx, indexDirtyKey); err != nil {
+		return err
+	}
+	return idx.ds.Sync(ctx, indexPrefix)
+}
+
+// indexUpdate holds the records changed by a sync until they are written.
+// Once it holds indexBatchSize records, they are written to the datastore
+// and read back from it when needed again.
+type indexUpdate struct {
+	idx     *Index
+	records map[cid.Cid]*indexRecord
+	// readOnly updates are never written
+	readOnly bool
+	// dirty is set once the index is marked as dirty
+	dirty bool
+}
+
+func (u *indexUpdate) get(ctx context.Context, c cid.Cid) (*indexRecord, error) {
+	if rec, ok := u.records[c]; ok {
+		return rec, nil
+	}
+	ds := u.idx.ds
+	rec := &indexRecord{Refs: make(map[IndexSpace]int)}
+	for _, space := range indexSpaces {
+		val, err := ds.Get(ctx, indexRefsPrefix.ChildString(string(space)).ChildString(c.String()))
+		switch err {
+		case nil:
+			n, read := binary.Uvarint(val)
+			if read <= 0 {
+				return nil, fmt.Errorf("invalid gc index refs of %s", c)
+			}
+			rec.Refs[space] = int(n)
+		case dstore.ErrNotFound:
+		default:
+			return nil, err
+		}
+	}
+	if rec.total() > 0 {
+		val, err := ds.Get(ctx, indexLinksPrefix.ChildString(c.String()))
+		switch err {
+		case nil:
+			for len(val) > 0 {
+				n, l, err := cid.CidFromBytes(val)
+				if err != nil {
+					return nil, fmt.Errorf("invalid gc index links of %s: %w", c, err)
+				}
+				rec.Links = append(rec.Links, l)
+				val = val[n:]
+			}
+		case dstore.ErrNotFound:
+		default:
+			return nil, err
+		}
+		if rec.Missing, err = ds.Has(ctx, indexMissingPrefix.ChildString(c.String())); err != nil {
+			return nil, err
+		}
+	}
+	u.records[c] = rec
+	return rec, nil
+}
+
+// inc adds a reference to the block in the space, and to its descendants if
+// it was not referenced in the space yet
+func (u *indexUpdate) inc(ctx context.Context, space IndexSpace, root cid.Cid) error {
+	type ref struct {
+		c     cid.Cid
+		space IndexSpace
+	}
+	stack := []ref{{root, space}}
+	for len(stack) > 0 {
+		if err := u.spill(ctx); err != nil {
+			return err
+		}
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		rec, err := u.get(ctx, r.c)
+		if err != nil {
+			return err
+		}
+		rec.Refs[r.space]++
+		first := rec.Refs[r.space] == 1
+		if !first && !rec.Missing {
+			continue
+		}
+
+		var spaces []IndexSpace
+		if first {
+			spaces = append(spaces, r.space)
+		}
+		if rec.total() == 1 || rec.Missing {
+			links, err := ipld.GetLinks(ctx, u.idx.ng, r.c)
+			switch {
+			case err == nil:
+				if rec.Missing {
+					// the block is now available, its descendants are
+					// referenced in all the spaces of the block
+					rec.Missing = false
+					spaces = spaces[:0]
+					for s, n := range rec.Refs {
+						if n > 0 {
+							spaces = append(spaces, s)
+						}
+					}
+				}
+				rec.Links = make([]cid.Cid, len(links))
+				for i, l := range links {
+					rec.Links[i] = l.Cid
+				}
+			case ipld.IsNotFound(err):
+				rec.Missing = true
+				continue
+			default:
+				return &CannotFetchLinksError{r.c, err}
+			}
+		}
+		for _, s := range spaces {
+			for _, l := range rec.Links {
+				stack = append(stack, ref{l, s})
+			}
+		}
+	}
+	return nil
+}
+
+// dec removes a reference to the block in the space, and from its
+// descendants if it is not referenced in the space anymore. It returns the
+// blocks which are not referenced in the space anymore.
+func (u *indexUpdate) dec(ctx context.Context, space IndexSpace, root cid.Cid) ([]cid.Cid, error) {
+	var released []cid.Cid
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		if err := u.spill(ctx); err != nil {
+			return nil, err
+		}
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		rec, err := u.get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if rec.Refs[space] == 0 {
+			log.Warnf("gc index: releasing unreferenced block %s", c)
+			continue
+		}
+		rec.Refs[space]--
+		if rec.Refs[space] > 0 {
+			continue
+		}
+		delete(rec.Refs, space)
+		released = append(released, c)
+		stack = append(stack, rec.Links...)
+	}
+	return released, nil
+}
+
+// spill writes the records once the update holds indexBatchSize of them
+func (u *indexUpdate) spill(ctx context.Context) error {
+	if u.readOnly || len(u.records) < indexBatchSize {
+		return nil
+	}
+	return u.flush(ctx)
+}
+
+// flush marks the index as dirty and writes the records of the update
+func (u *indexUpdate) flush(ctx context.Context) error {
+	ds := u.idx.ds
+	if !u.dirty {
+		if err := ds.Put(ctx, indexDirtyKey, []byte{}); err != nil {
+			return err
+		}
+		if err := ds.Sync(ctx, indexDirtyKey); err != nil {
+			return err
+		}
+		u.dirty = true
+	}
+
+	b, err := newIndexBatch(ctx, ds)
+	if err != nil {
+		return err
+	}
+	for c, rec := range u.records {
+		if err := putRecord(ctx, b, c, rec); err != nil {
+			return err
+		}
+	}
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+	u.records = make(map[cid.Cid]*indexRecord)
+	return nil
+}
+
+func (u *indexUpdate) commit(ctx context.Context, space IndexSpace, added, removed []cid.Cid) error {
+	if err := u.flush(ctx); err != nil {
+		return err
+	}
+
+	ds := u.idx.ds
+	b, err := newIndexBatch(ctx, ds)
+	if err != nil {
+		return err
+	}
+	rootPrefix := indexRootPrefix.ChildString(string(space))
+	for _, c := range added {
+		if err := b.Put(ctx, rootPrefix.ChildString(c.String()), []byte{}); err != nil {
+			return err
+		}
+	}
+	for _, c := range removed {
+		if err := b.Delete(ctx, rootPrefix.ChildString(c.String())); err != nil {
+			return err
+		}
+	}
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+	if err := ds.Sync(ctx, indexPrefix); err != nil {
+		return err
+	}
+	if err := ds.Delete(ctx, indexDirtyKey); err != nil {
+		return err
+	}
+	return ds.Sync(ctx, indexDirtyKey)
+}
+
+// indexBatch writes to the datastore in batches of at most indexBatchSize
+// writes, or directly if the datastore doesn't support batching
+type indexBatch struct {
+	ds     dstore.Datastore
+	batch  dstore.Write
+	done   func(context.Context) error
+	writes int
+}
+
+func newIndexBatch(ctx context.Context, ds dstore.Datastore) (*indexBatch, error) {
+	b := &indexBatch{ds: ds}
+	return b, b.reset(ctx)
+}
+
+func (b *indexBatch) reset(ctx context.Context) error {
+	b.writes = 0
+	bds, ok := b.ds.(dstore.Batching)
+	if !ok {
+		b.batch, b.done = b.ds, nil
+		return nil
+	}
+	batch, err := bds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	b.batch, b.done = batch, batch.Commit
+	return nil
+}
+
+func (b *indexBatch) Put(ctx context.Context, key dstore.Key, value []byte) error {
+	if err := b.batch.Put(ctx, key, value); err != nil {
+		return err
+	}
+	return b.wrote(ctx)
+}
+
+func (b *indexBatch) Delete(ctx context.Context, key dstore.Key) error {
+	if err := b.batch.Delete(ctx, key); err != nil {
+		return err
+	}
+	return b.wrote(ctx)
+}
+
+// wrote commits the batch once it is full, and starts the next one
+func (b *indexBatch) wrote(ctx context.Context) error {
+	b.writes++
+	if b.writes < indexBatchSize {
+		return nil
+	}
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+	return b.reset(ctx)
+}
+
+// Commit writes the pending writes of the batch
+func (b *indexBatch) Commit(ctx context.Context) error {
+	if b.done == nil || b.writes == 0 {
+		return nil
+	}
+	b.writes = 0
+	return b.done(ctx)
+}
+
+// putRecord writes the keys of the record, or deletes them once the block is
+// not referenced anymore
+func putRecord(ctx context.Context, batch dstore.Write, c cid.Cid, rec *indexRecord) error {
+	key := c.String()
+	put := func(k dstore.Key, val []byte, keep bool) error {
+		if keep {
+			return batch.Put(ctx, k, val)
+		}
+		return batch.Delete(ctx, k)
+	}
+
+	for _, space := range indexSpaces {
+		n := rec.Refs[space]
+		buf := make([]byte, binary.MaxVarintLen64)
+		buf = buf[:binary.PutUvarint(buf, uint64(n))]
+		if err := put(indexRefsPrefix.ChildString(string(space)).ChildString(key), buf, n > 0); err != nil {
+			return err
+		}
+	}
+	referenced := rec.total() > 0
+	var links []byte
+	for _, l := range rec.Links {
+		links = append(links, l.Bytes()...)
+	}
+	if err := put(indexLinksPrefix.ChildString(key), links, referenced && len(links) > 0); err != nil {
+		return err
+	}
+	return put(indexMissingPrefix.ChildString(key), []byte{}, referenced && rec.Missing)
+}
+
+// IndexedPinner is a pinner which keeps the index of the recursive pins up
+// to date
+type IndexedPinner struct {
+	pin.Pinner
+	index *Index
+}
+
+// NewIndexedPinner wraps the pinner, updating the index after the recursive
+// pins change
+func NewIndexedPinner(pn pin.Pinner, index *Index) *IndexedPinner {
+	return &IndexedPinner{Pinner: pn, index: index}
+}
+
+// Index returns the index of the pinner
+func (p *IndexedPinner) Index() *Index {
+	return p.index
+}
+
+func (p *IndexedPinner) Pin(ctx context.Context, node ipld.Node, recursive bool) error {
+	if err := p.Pinner.Pin(ctx, node, recursive); err != nil {
+		return err
+	}
+	if recursive {
+		p.update(ctx, []cid.Cid{node.Cid()}, nil)
+	}
+	return nil
+}
+
+func (p *IndexedPinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	if recursive {
+		p.update(ctx, nil, []cid.Cid{c})
+	}
+	return nil
+}
+
+func (p *IndexedPinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+	var removed []cid.Cid
+	if unpin {
+		removed = []cid.Cid{from}
+	}
+	p.update(ctx, []cid.Cid{to}, removed)
+	return nil
+}
+
+// update adds and removes the changed pins from the index, failures are only
+// logged as the index is synced with all the pins before it is used
+func (p *IndexedPinner) update(ctx context.Context, added, removed []cid.Cid) {
+	if err := p.index.Update(ctx, IndexPins, added, removed); err != nil {
+		log.Errorf("updating gc index: %s", err)
+	}
+}
+
+// PinnerIndex returns the index of the pinner, or nil if it doesn't keep one
+func PinnerIndex(pn pin.Pinner) *Index {
+	if ip, ok := pn.(interface{ Index() *Index }); ok {
+		return ip.Index()
+	}
+	return nil
+}
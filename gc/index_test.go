@@ -0,0 +1,289 @@
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func sortedKeys(keys []cid.Cid) string {
+	s := make([]string, len(keys))
+	for i, c := range keys {
+		s[i] = c.String()
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	idx := NewIndex(dstore, dserv)
+
+	node := func(data string, links ...*dag.ProtoNode) *dag.ProtoNode {
+		t.Helper()
+		nd := dag.NodeWithData([]byte(data))
+		for _, l := range links {
+			if err := nd.AddNodeLink(l.Cid().String(), l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nd
+	}
+	add := func(data string, links ...*dag.ProtoNode) *dag.ProtoNode {
+		t.Helper()
+		nd := node(data, links...)
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	check := func(pins []*dag.ProtoNode, expected []*dag.ProtoNode, expectedMissing ...*dag.ProtoNode) {
+		t.Helper()
+		var pinKeys, want, wantMissing []cid.Cid
+		for _, nd := range pins {
+			pinKeys = append(pinKeys, nd.Cid())
+		}
+		for _, nd := range expected {
+			want = append(want, nd.Cid())
+		}
+		for _, nd := range expectedMissing {
+			wantMissing = append(wantMissing, nd.Cid())
+		}
+		indexed, missing, err := idx.Pinned(ctx, pinKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sortedKeys(indexed) != sortedKeys(want) {
+			t.Errorf("expected indexed blocks %s, got %s", sortedKeys(want), sortedKeys(indexed))
+		}
+		if sortedKeys(missing) != sortedKeys(wantMissing) {
+			t.Errorf("expected missing blocks %s, got %s", sortedKeys(wantMissing), sortedKeys(missing))
+		}
+	}
+	nodes := func(nds ...*dag.ProtoNode) []*dag.ProtoNode { return nds }
+
+	shared := add("shared")
+	a := add("a", shared)
+	b := add("b", shared)
+	c := add("c", a)
+
+	check(nodes(a, b), nodes(a, b, shared))
+	// shared blocks are kept until their last reference is released
+	check(nodes(b), nodes(b, shared))
+	check(nodes(c), nodes(c, a, shared))
+	check(nodes(a, c), nodes(c, a, shared))
+	check(nodes(a), nodes(a, shared))
+
+	// the MFS root is indexed separately, GC is seeded with both
+	set := cid.NewSet()
+	if _, _, err := idx.Seed(ctx, set, []cid.Cid{a.Cid()}, []cid.Cid{b.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 || !set.Has(toCidV1(b.Cid())) || !set.Has(toCidV1(shared.Cid())) {
+		t.Fatalf("unexpected seeded set %s", sortedKeys(set.Keys()))
+	}
+	check(nodes(a), nodes(a, shared))
+
+	// blocks which are not stored are reported, and indexed once available
+	later := node("later", node("later child"))
+	d := add("d", later)
+	check(nodes(a, d), nodes(a, shared, d, later), later)
+	set = cid.NewSet()
+	missingPins, missingFiles, err := idx.Seed(ctx, set, []cid.Cid{a.Cid(), d.Cid()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sortedKeys(missingPins) != later.Cid().String() || len(missingFiles) != 0 || set.Has(toCidV1(later.Cid())) {
+		t.Errorf("unexpected missing blocks %s %s", sortedKeys(missingPins), sortedKeys(missingFiles))
+	}
+	laterChild := add("later child")
+	if err := dserv.Add(ctx, later); err != nil {
+		t.Fatal(err)
+	}
+	e := add("e", later)
+	check(nodes(a, d, e), nodes(a, shared, d, e, later, laterChild))
+	check(nodes(a), nodes(a, shared))
+
+	// interrupted updates are discarded, and the index rebuilt
+	if err := dstore.Put(ctx, indexDirtyKey, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	check(nodes(c), nodes(c, a, shared))
+	set = cid.NewSet()
+	if _, _, err := idx.Seed(ctx, set, []cid.Cid{c.Cid()}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Fatalf("unexpected seeded set after rebuild %s", sortedKeys(set.Keys()))
+	}
+
+	// roots are added and removed without listing the others
+	update := func(add, remove []*dag.ProtoNode, expected ...*dag.ProtoNode) {
+		t.Helper()
+		var addKeys, removeKeys, want []cid.Cid
+		for _, nd := range add {
+			addKeys = append(addKeys, nd.Cid())
+		}
+		for _, nd := range remove {
+			removeKeys = append(removeKeys, nd.Cid())
+		}
+		for _, nd := range expected {
+			want = append(want, nd.Cid())
+		}
+		if err := idx.Update(ctx, IndexPins, addKeys, removeKeys); err != nil {
+			t.Fatal(err)
+		}
+		indexed, err := idx.keys(ctx, indexRefsPrefix.ChildString(string(IndexPins)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sortedKeys(indexed.Keys()) != sortedKeys(want) {
+			t.Errorf("expected indexed blocks %s, got %s", sortedKeys(want), sortedKeys(indexed.Keys()))
+		}
+	}
+	update(nodes(b), nil, c, a, shared, b)
+	update(nodes(b), nil, c, a, shared, b)
+	update(nil, nodes(c), b, shared)
+	// a is not a root, it is not released
+	update(nil, nodes(a), b, shared)
+
+	// an index with another layout is rebuilt
+	oldKey := indexPrefix.ChildString("blocks").ChildString(a.Cid().String())
+	if err := dstore.Put(ctx, oldKey, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dstore.Delete(ctx, indexVersionKey); err != nil {
+		t.Fatal(err)
+	}
+	check(nodes(a), nodes(a, shared))
+	if has, err := dstore.Has(ctx, oldKey); err != nil || has {
+		t.Fatalf("the index with another layout was not cleared: %v", err)
+	}
+}
+
+// batchingDatastore records the size of the committed batches, and fails the
+// commits after the first failAfter ones when it is set
+type batchingDatastore struct {
+	ds.Batching
+	maxWrites int
+	commits   int
+	failAfter int
+}
+
+func (d *batchingDatastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := d.Batching.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &countingBatch{Batch: b, d: d}, nil
+}
+
+type countingBatch struct {
+	ds.Batch
+	d      *batchingDatastore
+	writes int
+}
+
+func (b *countingBatch) Put(ctx context.Context, key ds.Key, value []byte) error {
+	b.writes++
+	return b.Batch.Put(ctx, key, value)
+}
+
+func (b *countingBatch) Delete(ctx context.Context, key ds.Key) error {
+	b.writes++
+	return b.Batch.Delete(ctx, key)
+}
+
+func (b *countingBatch) Commit(ctx context.Context) error {
+	if b.d.failAfter > 0 && b.d.commits >= b.d.failAfter {
+		return errors.New("commit failed")
+	}
+	b.d.commits++
+	if b.writes > b.d.maxWrites {
+		b.d.maxWrites = b.writes
+	}
+	return b.Batch.Commit(ctx)
+}
+
+func TestIndexBatches(t *testing.T) {
+	ctx := context.Background()
+
+	defer func(size int) { indexBatchSize = size }(indexBatchSize)
+	indexBatchSize = 4
+
+	dstore := &batchingDatastore{Batching: dssync.MutexWrap(ds.NewMapDatastore())}
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	idx := NewIndex(dstore, dserv)
+
+	// a root with 20 children of 2 blocks each
+	root := dag.NodeWithData([]byte("root"))
+	var want []cid.Cid
+	for i := 0; i < 20; i++ {
+		leaf := dag.NodeWithData([]byte(fmt.Sprintf("leaf %d", i)))
+		child := dag.NodeWithData([]byte(fmt.Sprintf("child %d", i)))
+		if err := child.AddNodeLink("leaf", leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(fmt.Sprint(i), child); err != nil {
+			t.Fatal(err)
+		}
+		if err := dserv.AddMany(ctx, []ipld.Node{leaf, child}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, child.Cid(), leaf.Cid())
+	}
+	want = append(want, root.Cid())
+	if err := dserv.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := func() string {
+		t.Helper()
+		indexed, _, err := idx.Pinned(ctx, []cid.Cid{root.Cid()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sortedKeys(indexed)
+	}
+
+	dstore.maxWrites, dstore.commits = 0, 0
+	if got := pinned(); got != sortedKeys(want) {
+		t.Fatalf("expected indexed blocks %s, got %s", sortedKeys(want), got)
+	}
+	if dstore.maxWrites > indexBatchSize || dstore.commits < 41/indexBatchSize {
+		t.Errorf("expected batches of at most %d writes, got %d commits of up to %d writes", indexBatchSize, dstore.commits, dstore.maxWrites)
+	}
+
+	// an update which fails after some batches were committed causes a rebuild
+	if err := idx.Update(ctx, IndexPins, nil, []cid.Cid{root.Cid()}); err != nil {
+		t.Fatal(err)
+	}
+	dstore.commits, dstore.failAfter = 0, 2
+	if err := idx.Update(ctx, IndexPins, []cid.Cid{root.Cid()}, nil); err == nil {
+		t.Fatal("expected the update to fail")
+	}
+	if has, err := dstore.Has(ctx, indexDirtyKey); err != nil || !has {
+		t.Fatalf("expected the index to be marked as dirty: %v", err)
+	}
+	dstore.failAfter = 0
+	if got := pinned(); got != sortedKeys(want) {
+		t.Fatalf("expected indexed blocks %s after rebuild, got %s", sortedKeys(want), got)
+	}
+}
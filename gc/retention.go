@@ -0,0 +1,447 @@
+package gc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+)
+
+// Policy selects the blocks removed by a garbage collection, among the
+// blocks which are not marked
+type Policy interface {
+	// Select returns the blocks to remove
+	Select(ctx context.Context, unmarked []cid.Cid) ([]cid.Cid, error)
+	// Removed is called for every removed block
+	Removed(ctx context.Context, c cid.Cid)
+}
+
+// selectKeys reads all the unmarked keys and returns the keys selected by
+// the policy
+func selectKeys(ctx context.Context, policy Policy, keychan <-chan cid.Cid, marked *cid.Set) (<-chan cid.Cid, error) {
+	var unmarked []cid.Cid
+	for k := range keychan {
+		if !marked.Has(k) {
+			unmarked = append(unmarked, k)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	selected, err := policy.Select(ctx, unmarked)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan cid.Cid, len(selected))
+	for _, k := range selected {
+		out <- k
+	}
+	close(out)
+	return out, nil
+}
+
+// Eviction is the order in which a retention policy removes blocks
+type Eviction string
+
+const (
+	// EvictAll removes all the blocks which are not kept
+	EvictAll Eviction = "all"
+	// EvictLRU removes the least recently used blocks first
+	EvictLRU Eviction = "lru"
+	// EvictLFU removes the least frequently used blocks first
+	EvictLFU Eviction = "lfu"
+)
+
+// MountPolicy is the retention policy of the blocks stored under a
+// datastore mountpoint
+type MountPolicy struct {
+	// Mountpoint is the datastore key prefix of the blocks, such as /blocks
+	Mountpoint string
+	// KeepAccessedWithin keeps the blocks used within the duration
+	KeepAccessedWithin time.Duration
+	// Eviction is the order blocks are removed in
+	Eviction Eviction
+}
+
+// RetentionPolicy is a Policy keeping blocks by their recorded use. Each
+// block gets the policy of the longest mountpoint containing its datastore
+// key, blocks which don't match any mountpoint are all removed.
+type RetentionPolicy struct {
+	Mounts []MountPolicy
+	Access *AccessTracker
+	// Free is the number of bytes after which LRU and LFU evictions stop, all
+	// the blocks which are not kept are removed when 0
+	Free uint64
+	// Size returns the size of a block
+	Size func(context.Context, cid.Cid) (int, error)
+}
+
+var _ Policy = (*RetentionPolicy)(nil)
+
+type evictionCandidate struct {
+	key    cid.Cid
+	access Access
+}
+
+// Select implements Policy
+func (p *RetentionPolicy) Select(ctx context.Context, unmarked []cid.Cid) ([]cid.Cid, error) {
+	ctx = untracked(ctx)
+	now := time.Now()
+
+	var selected []cid.Cid
+	queues := make([][]evictionCandidate, len(p.Mounts))
+	for _, k := range unmarked {
+		mp := p.mountOf(k)
+		if mp < 0 {
+			selected = append(selected, k)
+			continue
+		}
+		policy := p.Mounts[mp]
+
+		var access Access
+		if p.Access != nil {
+			var err error
+			if access, err = p.Access.Get(ctx, k); err != nil {
+				return nil, err
+			}
+		}
+		if policy.KeepAccessedWithin > 0 && now.Sub(access.Last) < policy.KeepAccessedWithin {
+			continue
+		}
+		if policy.Eviction == EvictLRU || policy.Eviction == EvictLFU {
+			queues[mp] = append(queues[mp], evictionCandidate{k, access})
+			continue
+		}
+		selected = append(selected, k)
+	}
+
+	var freed uint64
+	if p.Free > 0 {
+		for _, k := range selected {
+			size, err := p.Size(ctx, k)
+			if err != nil {
+				return nil, err
+			}
+			freed += uint64(size)
+		}
+	}
+
+	for mp, queue := range queues {
+		lfu := p.Mounts[mp].Eviction == EvictLFU
+		sort.SliceStable(queue, func(i, j int) bool {
+			a, b := queue[i].access, queue[j].access
+			if lfu && a.Count != b.Count {
+				return a.Count < b.Count
+			}
+			return a.Last.Before(b.Last)
+		})
+	}
+	// evictions are spread evenly across mounts
+	for pos := 0; ; pos++ {
+		left := false
+		for _, queue := range queues {
+			if pos >= len(queue) {
+				continue
+			}
+			left = true
+			if p.Free > 0 && freed >= p.Free {
+				return selected, nil
+			}
+			k := queue[pos].key
+			if p.Free > 0 {
+				size, err := p.Size(ctx, k)
+				if err != nil {
+					return nil, err
+				}
+				freed += uint64(size)
+			}
+			selected = append(selected, k)
+		}
+		if !left {
+			return selected, nil
+		}
+	}
+}
+
+// Removed implements Policy
+func (p *RetentionPolicy) Removed(ctx context.Context, c cid.Cid) {
+	if p.Access != nil {
+		p.Access.Forget(c)
+	}
+}
+
+// mountOf returns the index of the policy of the block, or -1
+func (p *RetentionPolicy) mountOf(c cid.Cid) int {
+	key := bstore.BlockPrefix.Child(dshelp.MultihashToDsKey(c.Hash())).String()
+	match, matchLen := -1, -1
+	for i, mp := range p.Mounts {
+		prefix := strings.TrimSuffix(mp.Mountpoint, "/")
+		if (prefix == "" || key == prefix || strings.HasPrefix(key, prefix+"/")) && len(prefix) > matchLen {
+			match, matchLen = i, len(prefix)
+		}
+	}
+	return match
+}
+
+// AccessSampleRate is the rate reads are sampled at by access trackers: one
+// read out of AccessSampleRate is recorded, and counts for AccessSampleRate
+// reads. Writes are always recorded.
+var AccessSampleRate = 8
+
+// AccessFlushInterval is how often recorded accesses are written to the
+// datastore
+var AccessFlushInterval = time.Minute
+
+var accessPrefix = dstore.NewKey("/local/gcaccess")
+
+// Access is what is known about the use of a block
+type Access struct {
+	// Last is the time of the last recorded use
+	Last time.Time
+	// Count is the estimated number of uses
+	Count uint64
+}
+
+func (a *Access) merge(other Access) {
+	if other.Last.After(a.Last) {
+		a.Last = other.Last
+	}
+	a.Count += other.Count
+}
+
+type untrackedKey struct{}
+
+// untracked returns a context for blockstore operations which are not
+// recorded by the access tracker, such as the walks of the collection
+func untracked(ctx context.Context) context.Context {
+	return context.WithValue(ctx, untrackedKey{}, true)
+}
+
+// AccessTracker records when blocks are used, for retention policies.
+// Accesses are kept in memory and written to the datastore periodically.
+type AccessTracker struct {
+	ds dstore.Datastore
+
+	mu        sync.Mutex
+	pending   map[string]Access
+	forgotten map[string]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewAccessTracker returns a tracker storing accesses in the datastore
+func NewAccessTracker(ds dstore.Datastore) *AccessTracker {
+	return &AccessTracker{
+		ds:        ds,
+		pending:   make(map[string]Access),
+		forgotten: make(map[string]struct{}),
+	}
+}
+
+func (t *AccessTracker) record(ctx context.Context, c cid.Cid, count uint64) {
+	if ctx.Value(untrackedKey{}) != nil {
+		return
+	}
+	key := string(c.Hash())
+	now := time.Now()
+	t.mu.Lock()
+	a := t.pending[key]
+	a.merge(Access{Last: now, Count: count})
+	t.pending[key] = a
+	delete(t.forgotten, key)
+	t.mu.Unlock()
+}
+
+// Read records a sampled read of the block
+func (t *AccessTracker) Read(ctx context.Context, c cid.Cid) {
+	if AccessSampleRate > 1 && rand.Intn(AccessSampleRate) != 0 {
+		return
+	}
+	t.record(ctx, c, uint64(AccessSampleRate))
+}
+
+// Write records a write of the block
+func (t *AccessTracker) Write(ctx context.Context, c cid.Cid) {
+	t.record(ctx, c, 1)
+}
+
+// Get returns the recorded use of the block
+func (t *AccessTracker) Get(ctx context.Context, c cid.Cid) (Access, error) {
+	key := string(c.Hash())
+	t.mu.Lock()
+	pending, isPending := t.pending[key]
+	_, isForgotten := t.forgotten[key]
+	t.mu.Unlock()
+
+	var a Access
+	if !isForgotten {
+		var err error
+		if a, err = t.load(ctx, key); err != nil {
+			return Access{}, err
+		}
+	}
+	if isPending {
+		a.merge(pending)
+	}
+	return a, nil
+}
+
+// Forget removes what is recorded for the block
+func (t *AccessTracker) Forget(c cid.Cid) {
+	key := string(c.Hash())
+	t.mu.Lock()
+	delete(t.pending, key)
+	t.forgotten[key] = struct{}{}
+	t.mu.Unlock()
+}
+
+func (t *AccessTracker) dsKey(key string) dstore.Key {
+	return accessPrefix.Child(dshelp.MultihashToDsKey([]byte(key)))
+}
+
+func (t *AccessTracker) load(ctx context.Context, key string) (Access, error) {
+	val, err := t.ds.Get(ctx, t.dsKey(key))
+	if err == dstore.ErrNotFound {
+		return Access{}, nil
+	}
+	if err != nil {
+		return Access{}, err
+	}
+	last, n := binary.Varint(val)
+	if n <= 0 {
+		return Access{}, fmt.Errorf("invalid access record for %s", t.dsKey(key))
+	}
+	count, m := binary.Uvarint(val[n:])
+	if m <= 0 {
+		return Access{}, fmt.Errorf("invalid access record for %s", t.dsKey(key))
+	}
+	return Access{Last: time.Unix(last, 0), Count: count}, nil
+}
+
+// Flush writes the recorded accesses to the datastore
+func (t *AccessTracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	pending, forgotten := t.pending, t.forgotten
+	t.pending = make(map[string]Access)
+	t.forgotten = make(map[string]struct{})
+	t.mu.Unlock()
+
+	if len(pending) == 0 && len(forgotten) == 0 {
+		return nil
+	}
+
+	var batch dstore.Write = t.ds
+	if bds, ok := t.ds.(dstore.Batching); ok {
+		b, err := bds.Batch(ctx)
+		if err != nil {
+			return err
+		}
+		batch = b
+	}
+	for key := range forgotten {
+		if err := batch.Delete(ctx, t.dsKey(key)); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	for key, a := range pending {
+		stored, err := t.load(ctx, key)
+		if err != nil {
+			return err
+		}
+		stored.merge(a)
+		n := binary.PutVarint(buf, stored.Last.Unix())
+		n += binary.PutUvarint(buf[n:], stored.Count)
+		if err := batch.Put(ctx, t.dsKey(key), append([]byte(nil), buf[:n]...)); err != nil {
+			return err
+		}
+	}
+	if b, ok := batch.(dstore.Batch); ok {
+		if err := b.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	return t.ds.Sync(ctx, accessPrefix)
+}
+
+// Start writes the recorded accesses periodically
+func (t *AccessTracker) Start() error {
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(AccessFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.Flush(context.Background()); err != nil {
+					log.Errorf("writing block accesses: %s", err)
+				}
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops writing accesses periodically, and writes the pending ones
+func (t *AccessTracker) Stop() error {
+	if t.stop != nil {
+		close(t.stop)
+		<-t.done
+		t.stop = nil
+	}
+	return t.Flush(context.Background())
+}
+
+// TrackingBlockstore is a GCBlockstore recording the reads and writes of
+// blocks with an AccessTracker
+type TrackingBlockstore struct {
+	bstore.GCBlockstore
+	tracker *AccessTracker
+}
+
+// NewTrackingBlockstore wraps the blockstore, recording accesses with the
+// tracker
+func NewTrackingBlockstore(bs bstore.GCBlockstore, tracker *AccessTracker) *TrackingBlockstore {
+	return &TrackingBlockstore{GCBlockstore: bs, tracker: tracker}
+}
+
+func (b *TrackingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := b.GCBlockstore.Get(ctx, c)
+	if err == nil {
+		b.tracker.Read(ctx, c)
+	}
+	return blk, err
+}
+
+func (b *TrackingBlockstore) Put(ctx context.Context, blk blocks.Block) error {
+	if err := b.GCBlockstore.Put(ctx, blk); err != nil {
+		return err
+	}
+	b.tracker.Write(ctx, blk.Cid())
+	return nil
+}
+
+func (b *TrackingBlockstore) PutMany(ctx context.Context, blks []blocks.Block) error {
+	if err := b.GCBlockstore.PutMany(ctx, blks); err != nil {
+		return err
+	}
+	for _, blk := range blks {
+		b.tracker.Write(ctx, blk.Cid())
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestAccessTracker(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	tracker := NewAccessTracker(dstore)
+
+	sampleRate := AccessSampleRate
+	AccessSampleRate = 1
+	defer func() { AccessSampleRate = sampleRate }()
+
+	a := blocks.NewBlock([]byte("a")).Cid()
+	b := blocks.NewBlock([]byte("b")).Cid()
+
+	tracker.Write(ctx, a)
+	tracker.Read(ctx, a)
+	tracker.Read(untracked(ctx), b)
+	if acc, _ := tracker.Get(ctx, a); acc.Count != 2 || time.Since(acc.Last) > time.Minute {
+		t.Fatalf("unexpected access %v", acc)
+	}
+	if acc, _ := tracker.Get(ctx, b); acc.Count != 0 {
+		t.Fatalf("untracked read was recorded: %v", acc)
+	}
+
+	// accesses are merged with the stored ones
+	if err := tracker.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Read(ctx, a)
+	if err := tracker.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if acc, _ := NewAccessTracker(dstore).Get(ctx, a); acc.Count != 3 {
+		t.Fatalf("expected 3 accesses, got %v", acc)
+	}
+
+	tracker.Forget(a)
+	if acc, _ := tracker.Get(ctx, a); acc.Count != 0 {
+		t.Fatalf("forgotten access is still reported: %v", acc)
+	}
+	if err := tracker.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if acc, _ := NewAccessTracker(dstore).Get(ctx, a); acc.Count != 0 {
+		t.Fatalf("forgotten access is still stored: %v", acc)
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	tracker := NewAccessTracker(dstore)
+
+	now := time.Now()
+	var keys []cid.Cid
+	for i, access := range []Access{
+		{Last: now.Add(-time.Minute), Count: 1},    // recent
+		{Last: now.Add(-48 * time.Hour), Count: 9}, // old, frequent
+		{Last: now.Add(-72 * time.Hour), Count: 5}, // oldest
+		{Last: now.Add(-50 * time.Hour), Count: 1}, // old, rare
+	} {
+		c := blocks.NewBlock([]byte{byte(i)}).Cid()
+		keys = append(keys, c)
+		tracker.pending[string(c.Hash())] = access
+	}
+	size := func(context.Context, cid.Cid) (int, error) { return 10, nil }
+
+	for _, test := range []struct {
+		name     string
+		policy   MountPolicy
+		free     uint64
+		expected []int
+	}{
+		{"all", MountPolicy{Mountpoint: "/blocks", Eviction: EvictAll}, 0, []int{0, 1, 2, 3}},
+		{"keep recent", MountPolicy{Mountpoint: "/", KeepAccessedWithin: 24 * time.Hour}, 0, []int{1, 2, 3}},
+		{"other mount", MountPolicy{Mountpoint: "/other", KeepAccessedWithin: 24 * time.Hour}, 0, []int{0, 1, 2, 3}},
+		{"lru", MountPolicy{Mountpoint: "/blocks", Eviction: EvictLRU}, 15, []int{2, 3}},
+		{"lru without target", MountPolicy{Mountpoint: "/blocks", Eviction: EvictLRU}, 0, []int{2, 3, 1, 0}},
+		{"lfu", MountPolicy{Mountpoint: "/blocks", Eviction: EvictLFU}, 20, []int{3, 0}},
+		{"lfu keep recent", MountPolicy{Mountpoint: "/blocks", Eviction: EvictLFU, KeepAccessedWithin: time.Hour}, 20, []int{3, 2}},
+	} {
+		p := &RetentionPolicy{
+			Mounts: []MountPolicy{test.policy},
+			Access: tracker,
+			Free:   test.free,
+			Size:   size,
+		}
+		selected, err := p.Select(ctx, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, c := range selected {
+			for i, k := range keys {
+				if k == c {
+					got = append(got, i)
+				}
+			}
+		}
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestGCWithPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	tracker := NewAccessTracker(dstore)
+	bs := NewTrackingBlockstore(bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker()), tracker)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recent := dag.NodeWithData([]byte("recent"))
+	if err := dserv.Add(ctx, recent); err != nil {
+		t.Fatal(err)
+	}
+	old := dag.NodeWithData([]byte("old"))
+	if err := dserv.Add(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	tracker.Forget(old.Cid())
+
+	policy := &RetentionPolicy{
+		Mounts: []MountPolicy{{Mountpoint: "/blocks", KeepAccessedWithin: time.Hour}},
+		Access: tracker,
+	}
+	removed := cid.NewSet()
+	for res := range GCWithPolicy(ctx, bs, dstore, pinner, nil, policy) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed.Add(res.KeyRemoved)
+	}
+	if removed.Len() != 1 || !removed.Has(cid.NewCidV1(cid.Raw, old.Cid().Hash())) {
+		t.Fatalf("expected only the old block to be removed, got %v", removed.Keys())
+	}
+	// accesses of removed blocks are forgotten
+	if acc, _ := tracker.Get(ctx, old.Cid()); acc.Count != 0 {
+		t.Errorf("access of removed block is still recorded: %v", acc)
+	}
+}
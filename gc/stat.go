@@ -0,0 +1,248 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// DryRun reports the blocks which GCWithPolicy would remove, with their
+// size, without removing them. The GC lock is held until the output is
+// drained, so that the reported blocks match the state of the blockstore.
+func DryRun(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []cid.Cid, policy Policy) <-chan Result {
+	ctx, cancel := context.WithCancel(untracked(ctx))
+
+	unlocker := bs.GCLock(ctx)
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+		defer unlocker.Unlock(ctx)
+
+		sendErr := func(err error) {
+			select {
+			case output <- Result{Error: err}:
+			case <-ctx.Done():
+			}
+		}
+
+		gcs, err := ColoredSet(ctx, pn, ds, bestEffortRoots, output)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+		gcs, err = toRawCids(gcs)
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		keychan, err := bs.AllKeysChan(ctx)
+		if err == nil && policy != nil {
+			keychan, err = selectKeys(ctx, policy, keychan, gcs)
+		}
+		if err != nil {
+			sendErr(err)
+			return
+		}
+
+		for k := range keychan {
+			if gcs.Has(k) {
+				continue
+			}
+			size, err := bs.GetSize(ctx, k)
+			if err != nil {
+				sendErr(err)
+				return
+			}
+			select {
+			case output <- Result{KeyRemoved: k, Size: size}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return output
+}
+
+// PinStat is the space used by a recursive pin.
+type PinStat struct {
+	Cid    cid.Cid
+	Blocks int
+	// Size is the size of all the blocks of the pin
+	Size uint64
+	// Exclusive is the size of the blocks only kept by this pin, which GC
+	// removes once it is unpinned
+	Exclusive uint64
+	// Shared is the size of the blocks also kept by other pins or by the
+	// best effort roots
+	Shared uint64
+}
+
+// PinStats computes the space used by the given recursive pins, or by all
+// of them when none is given. Blocks which are not stored locally are not
+// counted. The pin lock is only held while the pins are listed.
+//
+// When the pinner keeps an index, the blocks only kept by a pin are found in
+// the index. Otherwise, the DAGs of all the recursive pins are walked.
+func PinStats(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, pins ...cid.Cid) ([]PinStat, error) {
+	ctx = untracked(ctx)
+
+	rkeys, dkeys, ikeys, idx, err := listPins(ctx, bs, pn, bestEffortRoots)
+	if err != nil {
+		return nil, err
+	}
+	if len(pins) == 0 {
+		pins = rkeys
+	}
+	recursive := cid.NewSet()
+	for _, k := range rkeys {
+		recursive.Add(toCidV1(k))
+	}
+	for _, p := range pins {
+		if !recursive.Has(toCidV1(p)) {
+			return nil, fmt.Errorf("%s is not pinned recursively", p)
+		}
+	}
+
+	// pins may change and blocks be collected while walking
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, err := ipld.GetLinks(ctx, ng, c)
+		if err != nil && !ipld.IsNotFound(err) {
+			return nil, err
+		}
+		return links, nil
+	}
+
+	// blocks kept regardless of the recursive pins
+	kept := cid.NewSet()
+	for _, k := range dkeys {
+		kept.Add(toCidV1(k))
+	}
+	if err := Descendants(ctx, getLinks, kept, ikeys); err != nil {
+		return nil, err
+	}
+
+	// with the index, the blocks only reachable from each pin
+	var exclusive map[cid.Cid]*cid.Set
+	if idx != nil {
+		exclusive = make(map[cid.Cid]*cid.Set, len(pins))
+		for _, p := range pins {
+			set, ok, err := idx.Exclusive(ctx, IndexPins, p)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				exclusive = nil
+				break
+			}
+			exclusive[p] = set
+		}
+	}
+
+	// otherwise, the number of recursive pins reaching each block, by
+	// multihash, and the blocks of the best effort roots
+	var refs map[string]int
+	if exclusive == nil {
+		refs = make(map[string]int)
+		for _, k := range rkeys {
+			set := cid.NewSet()
+			if err := Descendants(ctx, getLinks, set, []cid.Cid{k}); err != nil {
+				return nil, err
+			}
+			_ = set.ForEach(func(c cid.Cid) error {
+				refs[string(c.Hash())]++
+				return nil
+			})
+		}
+		if err := Descendants(ctx, getLinks, kept, bestEffortRoots); err != nil {
+			return nil, err
+		}
+	}
+	kept, err = toRawCids(kept)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]PinStat, 0, len(pins))
+	for _, p := range pins {
+		isExclusive := func(c cid.Cid) bool {
+			if kept.Has(cid.NewCidV1(cid.Raw, c.Hash())) {
+				return false
+			}
+			if exclusive != nil {
+				return exclusive[p].Has(c)
+			}
+			return refs[string(c.Hash())] == 1
+		}
+
+		set := cid.NewSet()
+		if err := Descendants(ctx, getLinks, set, []cid.Cid{p}); err != nil {
+			return nil, err
+		}
+		stat := PinStat{Cid: p}
+		err := set.ForEach(func(c cid.Cid) error {
+			n, err := bs.GetSize(ctx, c)
+			if ipld.IsNotFound(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			stat.Blocks++
+			stat.Size += uint64(n)
+			if isExclusive(c) {
+				stat.Exclusive += uint64(n)
+			} else {
+				stat.Shared += uint64(n)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// listPins lists the pins while holding the pin lock, and syncs the index of
+// the pinner, if any, with them. The index is not returned if it can't be
+// synced.
+func listPins(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, bestEffortRoots []cid.Cid) (rkeys, dkeys, ikeys []cid.Cid, idx *Index, err error) {
+	defer bs.PinLock(ctx).Unlock(ctx)
+
+	if rkeys, err = pn.RecursiveKeys(ctx); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if dkeys, err = pn.DirectKeys(ctx); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if ikeys, err = pn.InternalPins(ctx); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if idx = PinnerIndex(pn); idx != nil {
+		err := idx.Sync(ctx, IndexPins, rkeys)
+		if err == nil {
+			err = idx.Sync(ctx, IndexFiles, bestEffortRoots)
+		}
+		if err != nil {
+			log.Errorf("gc index unavailable, walking all pins: %s", err)
+			idx = nil
+		}
+	}
+	return rkeys, dkeys, ikeys, idx, nil
+}
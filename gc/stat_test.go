@@ -0,0 +1,111 @@
+package gc
+
+import (
+	"context"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestDryRunAndPinStats(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(data string, links ...*dag.ProtoNode) *dag.ProtoNode {
+		t.Helper()
+		nd := dag.NodeWithData([]byte(data))
+		for _, l := range links {
+			if err := nd.AddNodeLink(l.Cid().String(), l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	size := func(nds ...*dag.ProtoNode) uint64 {
+		var n uint64
+		for _, nd := range nds {
+			n += uint64(len(nd.RawData()))
+		}
+		return n
+	}
+
+	shared := add("shared")
+	inFiles := add("in files")
+	a := add("a", shared, inFiles)
+	b := add("b", shared)
+	garbage := add("garbage")
+	for _, nd := range []*dag.ProtoNode{a, b} {
+		if err := pinner.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots := []cid.Cid{inFiles.Cid()}
+
+	var total int
+	removed := cid.NewSet()
+	for res := range DryRun(ctx, bs, pinner, roots, nil) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		removed.Add(res.KeyRemoved)
+		total += res.Size
+	}
+	if removed.Len() != 1 || !removed.Has(cid.NewCidV1(cid.Raw, garbage.Cid().Hash())) || uint64(total) != size(garbage) {
+		t.Fatalf("expected only the garbage block to be reported, got %v (%d bytes)", removed.Keys(), total)
+	}
+	if has, err := bs.Has(ctx, garbage.Cid()); err != nil || !has {
+		t.Fatal("dry run removed a block")
+	}
+
+	// the stats are the same with the index of the pins
+	indexed := NewIndexedPinner(pinner, NewIndex(dstore, dserv))
+	for _, pn := range []pin.Pinner{pinner, indexed} {
+		stats, err := PinStats(ctx, bs, pn, dserv, roots, a.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 1 {
+			t.Fatalf("expected one stat, got %v", stats)
+		}
+		stat := stats[0]
+		if stat.Blocks != 3 || stat.Size != size(a, shared, inFiles) ||
+			stat.Exclusive != size(a) || stat.Shared != size(shared, inFiles) {
+			t.Errorf("unexpected stat %+v", stat)
+		}
+
+		stats, err = PinStats(ctx, bs, pn, dserv, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 2 {
+			t.Fatalf("expected the stats of all pins, got %v", stats)
+		}
+		for _, stat := range stats {
+			if stat.Cid.Equals(a.Cid()) && stat.Exclusive != size(a, inFiles) {
+				t.Errorf("unexpected stat without best effort roots %+v", stat)
+			}
+		}
+
+		if _, err := PinStats(ctx, bs, pn, dserv, nil, garbage.Cid()); err == nil {
+			t.Error("expected an error for a block which is not pinned")
+		}
+	}
+}
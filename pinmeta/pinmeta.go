@@ -0,0 +1,273 @@
+// Package pinmeta stores the names, metadata and expiry of local pins, next
+// to the state of the pinner in the datastore.
+package pinmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	pin "github.com/ipfs/go-ipfs-pinner"
+)
+
+// MaxNameLength is the maximum length of pin names, as for remote pins
+const MaxNameLength = 255
+
+var infoPrefix = ds.NewKey("/pins/meta")
+
+// Info is the name, metadata and expiry of a pin
+type Info struct {
+	Name string
+	Meta map[string]string
+	// Expires is when the pin is removed by the daemon, unless it is zero
+	Expires time.Time
+}
+
+// IsEmpty returns whether the pin has no name, metadata nor expiry
+func (i Info) IsEmpty() bool {
+	return i.Name == "" && len(i.Meta) == 0 && i.Expires.IsZero()
+}
+
+// Repin returns the info of a pin which is added again with the info i. The
+// name and metadata of the stored info are kept unless i has new ones, while
+// the expiry is always the one of i: pinning again without an expiry makes
+// the pin permanent.
+func (i Info) Repin(stored Info) Info {
+	if i.Name == "" {
+		i.Name = stored.Name
+	}
+	if len(i.Meta) == 0 {
+		i.Meta = stored.Meta
+	}
+	return i
+}
+
+// storedInfo is the representation of Info in the datastore
+type storedInfo struct {
+	Name    string            `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+// Validate checks the name and metadata can be stored
+func (i Info) Validate() error {
+	if len(i.Name) > MaxNameLength {
+		return fmt.Errorf("pin name is longer than %d bytes", MaxNameLength)
+	}
+	for k := range i.Meta {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("invalid pin metadata key %q", k)
+		}
+	}
+	return nil
+}
+
+// ParseMeta parses metadata given as key=value strings, or as keys with an
+// empty value
+func ParseMeta(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	meta := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid pin metadata %q, expected key=value", pair)
+		}
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		meta[kv[0]] = kv[1]
+	}
+	return meta, nil
+}
+
+// Query selects pins by their name and metadata. The zero Query selects all
+// the pins.
+type Query struct {
+	// Name is contained in the names of the selected pins
+	Name string
+	// Meta are the metadata of the selected pins. An empty value selects the
+	// pins with the key, whatever its value.
+	Meta map[string]string
+}
+
+// IsEmpty returns whether the query selects all the pins
+func (q Query) IsEmpty() bool {
+	return q.Name == "" && len(q.Meta) == 0
+}
+
+// Matches returns whether the pin with the given info is selected
+func (q Query) Matches(info Info) bool {
+	if !strings.Contains(info.Name, q.Name) {
+		return false
+	}
+	for k, v := range q.Meta {
+		value, ok := info.Meta[k]
+		if !ok || (v != "" && v != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Store persists the info of the pins in the datastore
+type Store struct {
+	ds ds.Datastore
+}
+
+// NewStore returns a store of pin info kept in the datastore
+func NewStore(d ds.Datastore) *Store {
+	return &Store{ds: d}
+}
+
+func infoKey(c cid.Cid) ds.Key {
+	return infoPrefix.ChildString(c.String())
+}
+
+// Get returns the info of the pin, which is empty when none was stored
+func (s *Store) Get(ctx context.Context, c cid.Cid) (Info, error) {
+	data, err := s.ds.Get(ctx, infoKey(c))
+	if err == ds.ErrNotFound {
+		return Info{}, nil
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return decodeInfo(data)
+}
+
+func decodeInfo(data []byte) (Info, error) {
+	var stored storedInfo
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return Info{}, err
+	}
+	info := Info{Name: stored.Name, Meta: stored.Meta}
+	if stored.Expires != nil {
+		info.Expires = *stored.Expires
+	}
+	return info, nil
+}
+
+// Put stores the info of the pin, an empty info is deleted
+func (s *Store) Put(ctx context.Context, c cid.Cid, info Info) error {
+	if info.IsEmpty() {
+		return s.Delete(ctx, c)
+	}
+	if err := info.Validate(); err != nil {
+		return err
+	}
+	stored := storedInfo{Name: info.Name, Meta: info.Meta}
+	if !info.Expires.IsZero() {
+		stored.Expires = &info.Expires
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if err := s.ds.Put(ctx, infoKey(c), data); err != nil {
+		return err
+	}
+	return s.ds.Sync(ctx, infoKey(c))
+}
+
+// Expired returns the pins which expire before the given time
+func (s *Store) Expired(ctx context.Context, now time.Time) ([]cid.Cid, error) {
+	res, err := s.ds.Query(ctx, query.Query{Prefix: infoPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var expired []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		info, err := decodeInfo(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		if info.Expires.IsZero() || info.Expires.After(now) {
+			continue
+		}
+		c, err := cid.Decode(ds.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, c)
+	}
+	return expired, nil
+}
+
+// Keys returns the pins with stored info
+func (s *Store) Keys(ctx context.Context) ([]cid.Cid, error) {
+	res, err := s.ds.Query(ctx, query.Query{Prefix: infoPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var keys []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(ds.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, c)
+	}
+	return keys, nil
+}
+
+// Delete removes the info of the pin
+func (s *Store) Delete(ctx context.Context, c cid.Cid) error {
+	if err := s.ds.Delete(ctx, infoKey(c)); err != nil {
+		return err
+	}
+	return s.ds.Sync(ctx, infoKey(c))
+}
+
+// Pinner is a pinner which keeps the info of the pins: it is removed with
+// the pin, and moved by updates
+type Pinner struct {
+	pin.Pinner
+	store *Store
+}
+
+// NewPinner wraps the pinner, keeping the info of the store consistent with
+// the pins
+func NewPinner(pn pin.Pinner, store *Store) *Pinner {
+	return &Pinner{Pinner: pn, store: store}
+}
+
+func (p *Pinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := p.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	return p.store.Delete(ctx, c)
+}
+
+func (p *Pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := p.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+	info, err := p.store.Get(ctx, from)
+	if err != nil || info.IsEmpty() {
+		return err
+	}
+	if err := p.store.Put(ctx, to, info); err != nil {
+		return err
+	}
+	if unpin {
+		return p.store.Delete(ctx, from)
+	}
+	return nil
+}
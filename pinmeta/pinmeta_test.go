@@ -0,0 +1,166 @@
+package pinmeta
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestQuery(t *testing.T) {
+	info := Info{Name: "holiday photos", Meta: map[string]string{"owner": "alice", "year": "2022"}}
+	for _, test := range []struct {
+		query   Query
+		matches bool
+	}{
+		{Query{}, true},
+		{Query{Name: "photos"}, true},
+		{Query{Name: "videos"}, false},
+		{Query{Meta: map[string]string{"owner": "alice"}}, true},
+		{Query{Meta: map[string]string{"owner": ""}}, true},
+		{Query{Meta: map[string]string{"owner": "bob"}}, false},
+		{Query{Name: "photos", Meta: map[string]string{"owner": "alice", "tag": ""}}, false},
+	} {
+		if test.query.Matches(info) != test.matches {
+			t.Errorf("query %+v: expected match %t", test.query, test.matches)
+		}
+	}
+	if (Query{Name: "x"}).Matches(Info{}) {
+		t.Error("pin without info matches a query")
+	}
+}
+
+func TestParseMeta(t *testing.T) {
+	meta, err := ParseMeta([]string{"owner=alice", "expr=a=b", "tag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta) != 3 || meta["owner"] != "alice" || meta["expr"] != "a=b" || meta["tag"] != "" {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+	if _, err := ParseMeta([]string{"=value"}); err == nil {
+		t.Error("expected an error for an empty key")
+	}
+}
+
+func TestRepin(t *testing.T) {
+	expires := time.Unix(1666346400, 0)
+	stored := Info{Name: "build", Meta: map[string]string{"owner": "alice"}, Expires: expires}
+
+	// pinning again without an expiry makes the pin permanent
+	info := Info{}.Repin(stored)
+	if info.Name != "build" || info.Meta["owner"] != "alice" || !info.Expires.IsZero() {
+		t.Errorf("unexpected info %+v", info)
+	}
+	info = Info{Name: "release"}.Repin(stored)
+	if info.Name != "release" || info.Meta["owner"] != "alice" || !info.Expires.IsZero() {
+		t.Errorf("unexpected info %+v", info)
+	}
+	later := expires.Add(time.Hour)
+	info = Info{Meta: map[string]string{"owner": "bob"}, Expires: later}.Repin(stored)
+	if info.Name != "build" || info.Meta["owner"] != "bob" || !info.Expires.Equal(later) {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestPinner(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	dspin, err := dspinner.New(ctx, dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(dstore)
+	pinner := NewPinner(dspin, store)
+
+	a := dag.NodeWithData([]byte("a"))
+	b := dag.NodeWithData([]byte("b"))
+	for _, nd := range []*dag.ProtoNode{a, b} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pinner.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	info := Info{Name: "a", Meta: map[string]string{"k": "v"}}
+	if err := store.Put(ctx, a.Cid(), info); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := NewStore(dstore).Get(ctx, a.Cid()); err != nil || got.Name != "a" || got.Meta["k"] != "v" {
+		t.Fatalf("unexpected stored info %+v (%v)", got, err)
+	}
+
+	// updates move the info to the new pin
+	if err := pinner.Update(ctx, a.Cid(), b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get(ctx, b.Cid()); got.Name != "a" {
+		t.Errorf("info was not moved: %+v", got)
+	}
+	if got, _ := store.Get(ctx, a.Cid()); !got.IsEmpty() {
+		t.Errorf("info of the unpinned cid is kept: %+v", got)
+	}
+
+	// removed with the pin
+	if err := pinner.Unpin(ctx, b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.Get(ctx, b.Cid()); !got.IsEmpty() {
+		t.Errorf("info of the removed pin is kept: %+v", got)
+	}
+
+	if err := store.Put(ctx, a.Cid(), Info{Meta: map[string]string{"a=b": ""}}); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(dssync.MutexWrap(ds.NewMapDatastore()))
+
+	now := time.Now().Truncate(time.Second)
+	expired := dag.NodeWithData([]byte("expired")).Cid()
+	later := dag.NodeWithData([]byte("later")).Cid()
+	named := dag.NodeWithData([]byte("named")).Cid()
+	for c, info := range map[cid.Cid]Info{
+		expired: {Expires: now.Add(-time.Minute)},
+		later:   {Expires: now.Add(time.Hour)},
+		named:   {Name: "named"},
+	} {
+		if err := store.Put(ctx, c, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, _ := store.Get(ctx, later); !info.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected expiry %s", info.Expires)
+	}
+	if info, _ := store.Get(ctx, named); !info.Expires.IsZero() {
+		t.Errorf("unexpected expiry %s", info.Expires)
+	}
+
+	keys, err := store.Expired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].Equals(expired) {
+		t.Errorf("expected only the expired pin, got %v", keys)
+	}
+	if keys, _ := store.Expired(ctx, now.Add(2*time.Hour)); len(keys) != 2 {
+		t.Errorf("expected both pins to be expired, got %v", keys)
+	}
+	if keys, _ := store.Keys(ctx); len(keys) != 3 {
+		t.Errorf("expected the keys of all the pins, got %v", keys)
+	}
+}
@@ -0,0 +1,401 @@
+package fsrepo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	repo "github.com/ipfs/kubo/repo"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	lockfile "github.com/ipfs/go-fs-lock"
+	util "github.com/ipfs/go-ipfs-util"
+	config "github.com/ipfs/kubo/config"
+	serialize "github.com/ipfs/kubo/config/serialize"
+	"github.com/ipfs/kubo/repo/fsrepo/migrations"
+)
+
+// ConvertDir is the directory of a datastore conversion, relative to the repo.
+// It holds the new datastore until it replaces the old one.
+const ConvertDir = "datastore_convert"
+
+const convertStateFn = "state.json"
+
+// convertBatchSize is the number of keys copied per batch
+const convertBatchSize = 1024
+
+// ErrSameDatastore is returned by ConvertDatastore when the repo already uses
+// the datastore.
+var ErrSameDatastore = errors.New("the repo already uses this datastore")
+
+// Phases of a conversion
+const (
+	convertCopy = "copy"
+	convertSwap = "swap"
+)
+
+// convertState is written in the ConvertDir to resume an interrupted
+// conversion.
+type convertState struct {
+	// Spec is the config of the new datastore
+	Spec map[string]interface{}
+	// Old is the disk spec of the datastore being replaced
+	Old   DiskSpec
+	Phase string
+}
+
+// ConvertDatastore copies all the keys of the datastore of the repo to a new
+// datastore built from spec, verifies their values, then replaces the old
+// datastore, the datastore_spec file and the Datastore.Spec of the config. The
+// repo is locked during the conversion, which can't run while the daemon is
+// running. An interrupted conversion to the same datastore is
+// resumed, without copying the keys already copied. Progress is called with
+// the number of keys copied so far, which is returned.
+func ConvertDatastore(ctx context.Context, repoPath, userConfigFilePath string, spec map[string]interface{}, progress func(copied int)) (int, error) {
+	r, err := newFSRepo(repoPath, userConfigFilePath)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkInitialized(r.path); err != nil {
+		return 0, err
+	}
+	lock, err := lockfile.Lock(r.path, LockFile)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Close()
+
+	ver, err := migrations.RepoVersion(r.path)
+	if err != nil {
+		return 0, err
+	}
+	if ver != RepoVersion {
+		return 0, fmt.Errorf("repo version %d does not match the version %d of this program, migrate it first", ver, RepoVersion)
+	}
+	if err := r.openConfig(); err != nil {
+		return 0, err
+	}
+
+	newDsc, err := AnyDatastoreConfig(spec)
+	if err != nil {
+		return 0, err
+	}
+	newDisk := newDsc.DiskSpec()
+	convertDir := filepath.Join(r.path, ConvertDir)
+
+	state, err := readConvertState(convertDir)
+	if err != nil {
+		return 0, err
+	}
+	resume := state != nil
+	if resume {
+		stateDsc, err := AnyDatastoreConfig(state.Spec)
+		if err != nil {
+			return 0, err
+		}
+		if stateDsc.DiskSpec().String() != newDisk.String() {
+			return 0, fmt.Errorf("the conversion to '%s' was interrupted, resume it with the same datastore or remove %s to cancel it",
+				stateDsc.DiskSpec(), convertDir)
+		}
+		if state.Phase == convertSwap {
+			return 0, r.swapDatastore(convertDir, state)
+		}
+	}
+
+	oldDsc, err := AnyDatastoreConfig(r.config.Datastore.Spec)
+	if err != nil {
+		return 0, err
+	}
+	oldDisk := oldDsc.DiskSpec()
+	diskSpec, err := r.readSpec()
+	if os.IsNotExist(err) {
+		return 0, &DatastoreSpecError{Config: oldDisk.String()}
+	} else if err != nil {
+		return 0, err
+	}
+	if diskSpec != oldDisk.String() {
+		return 0, &DatastoreSpecError{Config: oldDisk.String(), Disk: diskSpec}
+	}
+	if oldDisk.String() == newDisk.String() {
+		return 0, ErrSameDatastore
+	}
+	// the new datastore is created in the ConvertDir, relative paths only
+	// can be moved to the repo
+	for _, p := range append(diskPaths(oldDisk), diskPaths(newDisk)...) {
+		if filepath.IsAbs(p) {
+			return 0, fmt.Errorf("can't convert a datastore with the absolute path %s", p)
+		}
+	}
+
+	if !resume {
+		state = &convertState{Spec: spec, Old: oldDisk, Phase: convertCopy}
+		if err := os.MkdirAll(filepath.Join(convertDir, "new"), 0700); err != nil {
+			return 0, err
+		}
+		if err := writeConvertState(convertDir, state); err != nil {
+			return 0, err
+		}
+	}
+
+	copied, err := convertKeys(ctx, oldDsc, newDsc, r.path, filepath.Join(convertDir, "new"), resume, progress)
+	if err != nil {
+		return copied, err
+	}
+
+	state.Phase = convertSwap
+	if err := writeConvertState(convertDir, state); err != nil {
+		return copied, err
+	}
+	return copied, r.swapDatastore(convertDir, state)
+}
+
+// convertKeys copies the keys of the old datastore to the new one, and
+// verifies the new one has the same keys and values
+func convertKeys(ctx context.Context, oldDsc, newDsc DatastoreConfig, oldPath, newPath string, resume bool, progress func(int)) (int, error) {
+	from, err := oldDsc.Create(oldPath)
+	if err != nil {
+		return 0, err
+	}
+	defer from.Close()
+	to, err := newDsc.Create(newPath)
+	if err != nil {
+		return 0, err
+	}
+	defer to.Close()
+
+	copied, err := copyKeys(ctx, from, to, resume, progress)
+	if err != nil {
+		return copied, err
+	}
+	return copied, verifyKeys(ctx, from, to)
+}
+
+func copyKeys(ctx context.Context, from, to repo.Datastore, resume bool, progress func(int)) (int, error) {
+	res, err := from.Query(ctx, query.Query{})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	batch, err := to.Batch(ctx)
+	if err != nil {
+		return 0, err
+	}
+	copied, pending := 0, 0
+	for r := range res.Next() {
+		if r.Error != nil {
+			return copied, r.Error
+		}
+		k := ds.RawKey(r.Key)
+		if resume {
+			has, err := to.Has(ctx, k)
+			if err != nil {
+				return copied, err
+			}
+			if has {
+				continue
+			}
+		}
+		if err := batch.Put(ctx, k, r.Value); err != nil {
+			return copied, err
+		}
+		copied++
+		pending++
+		if pending < convertBatchSize {
+			continue
+		}
+		if err := batch.Commit(ctx); err != nil {
+			return copied, err
+		}
+		if batch, err = to.Batch(ctx); err != nil {
+			return copied, err
+		}
+		pending = 0
+		if progress != nil {
+			progress(copied)
+		}
+	}
+	if err := batch.Commit(ctx); err != nil {
+		return copied, err
+	}
+	if progress != nil {
+		progress(copied)
+	}
+	return copied, to.Sync(ctx, ds.NewKey("/"))
+}
+
+// verifyKeys checks that every key of the old datastore has the same value in
+// the new one, and that the new one has no other keys
+func verifyKeys(ctx context.Context, from, to repo.Datastore) error {
+	res, err := from.Query(ctx, query.Query{})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	oldCount := 0
+	for r := range res.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		v, err := to.Get(ctx, ds.RawKey(r.Key))
+		if err == ds.ErrNotFound {
+			return fmt.Errorf("the key %s is missing in the new datastore", r.Key)
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(v, r.Value) {
+			return fmt.Errorf("the key %s has another value in the new datastore", r.Key)
+		}
+		oldCount++
+	}
+
+	newCount, err := countKeys(ctx, to)
+	if err != nil {
+		return err
+	}
+	if oldCount != newCount {
+		return fmt.Errorf("the new datastore has %d keys, the old one %d", newCount, oldCount)
+	}
+	return nil
+}
+
+func countKeys(ctx context.Context, d repo.Datastore) (int, error) {
+	res, err := d.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	n := 0
+	for r := range res.Next() {
+		if r.Error != nil {
+			return n, r.Error
+		}
+		n++
+	}
+	return n, nil
+}
+
+// swapDatastore moves the old datastore out of the repo and the new one in,
+// then writes the config and the datastore_spec. Every step can be repeated
+// when the swap was interrupted.
+func (r *FSRepo) swapDatastore(convertDir string, state *convertState) error {
+	newDsc, err := AnyDatastoreConfig(state.Spec)
+	if err != nil {
+		return err
+	}
+
+	oldDir := filepath.Join(convertDir, "old")
+	for _, p := range diskPaths(state.Old) {
+		src, dst := filepath.Join(r.path, p), filepath.Join(oldDir, p)
+		if !util.FileExists(src) || util.FileExists(dst) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+	for _, p := range diskPaths(newDsc.DiskSpec()) {
+		src, dst := filepath.Join(convertDir, "new", p), filepath.Join(r.path, p)
+		if !util.FileExists(src) {
+			continue
+		}
+		if util.FileExists(dst) {
+			return fmt.Errorf("can't move the new datastore to %s, the path exists", dst)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+	}
+
+	// the repo opens again once both the config and the datastore_spec
+	// describe the new datastore
+	var mapconf map[string]interface{}
+	if err := serialize.ReadConfigFile(r.configFilePath, &mapconf); err != nil {
+		return err
+	}
+	dsconf, ok := mapconf["Datastore"].(map[string]interface{})
+	if !ok {
+		return errors.New("the config has no Datastore section")
+	}
+	dsconf["Spec"] = state.Spec
+	if err := serialize.WriteConfigFile(r.configFilePath, mapconf); err != nil {
+		return err
+	}
+	if err := writeSpec(r.path, newDsc.DiskSpec()); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(convertDir)
+}
+
+// writeSpec replaces the datastore_spec file atomically
+func writeSpec(path string, spec DiskSpec) error {
+	fn, err := config.Path(path, specFn)
+	if err != nil {
+		return err
+	}
+	tmp := fn + ".tmp"
+	if err := os.WriteFile(tmp, spec.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fn)
+}
+
+func readConvertState(convertDir string) (*convertState, error) {
+	b, err := os.ReadFile(filepath.Join(convertDir, convertStateFn))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state convertState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("reading the state of the conversion: %w", err)
+	}
+	return &state, nil
+}
+
+func writeConvertState(convertDir string, state *convertState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(convertDir, convertStateFn)
+	if err := os.WriteFile(fn+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(fn+".tmp", fn)
+}
+
+// diskPaths returns the paths of the datastores of a disk spec
+func diskPaths(spec interface{}) []string {
+	var paths []string
+	switch spec := spec.(type) {
+	case DiskSpec:
+		return diskPaths(map[string]interface{}(spec))
+	case map[string]interface{}:
+		for k, v := range spec {
+			if p, ok := v.(string); ok && k == "path" {
+				paths = append(paths, p)
+				continue
+			}
+			paths = append(paths, diskPaths(v)...)
+		}
+	case []interface{}:
+		for _, child := range spec {
+			paths = append(paths, diskPaths(child)...)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,145 @@
+package fsrepo_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/plugin/loader"
+	"github.com/ipfs/kubo/repo/fsrepo"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+// injectPlugins injects the datastore plugins, unless another test of the
+// package already did
+func injectPlugins(t *testing.T) {
+	if _, err := fsrepo.AnyDatastoreConfig(config.DefaultDatastoreConfig().Spec); err == nil {
+		return
+	}
+	loader, err := loader.NewPluginLoader("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Inject(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertDatastore(t *testing.T) {
+	injectPlugins(t)
+	ctx := context.Background()
+
+	dir, err := os.MkdirTemp("", "ipfs-datastore-convert-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &config.Config{Datastore: config.DefaultDatastoreConfig()}
+	if err := fsrepo.Init(dir, conf); err != nil {
+		t.Fatal(err)
+	}
+	keys := []ds.Key{
+		ds.NewKey("/blocks/CIQBED3K6YA5I3QQWLJOCHWXDRK5EXZQILBCKAPEDUJENZ5B5HJ5R3A"),
+		ds.NewKey("/local/filesroot"),
+	}
+	r, err := fsrepo.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if err := r.Datastore().Put(ctx, k, []byte(k.String())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Profiles["badgerds"].Transform(conf); err != nil {
+		t.Fatal(err)
+	}
+	// a resumed conversion whose copy has another value is not swapped
+	convertDir := filepath.Join(dir, fsrepo.ConvertDir)
+	newDsc, err := fsrepo.AnyDatastoreConfig(conf.Datastore.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, err := newDsc.Create(filepath.Join(convertDir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := partial.Put(ctx, keys[0], []byte("corrupted")); err != nil {
+		t.Fatal(err)
+	}
+	if err := partial.Close(); err != nil {
+		t.Fatal(err)
+	}
+	oldSpec, err := os.ReadFile(filepath.Join(dir, "datastore_spec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := json.Marshal(map[string]interface{}{"Spec": conf.Datastore.Spec, "Old": json.RawMessage(oldSpec), "Phase": "copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(convertDir, "state.json"), state, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsrepo.ConvertDatastore(ctx, dir, "", conf.Datastore.Spec, nil); err == nil || !strings.Contains(err.Error(), "another value") {
+		t.Fatalf("expected the values to be verified, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blocks")); err != nil {
+		t.Fatalf("the old datastore was replaced: %v", err)
+	}
+	if err := os.RemoveAll(convertDir); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress int
+	copied, err := fsrepo.ConvertDatastore(ctx, dir, "", conf.Datastore.Spec, func(n int) { progress = n })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != len(keys) || progress != copied {
+		t.Fatalf("expected %d keys copied, got %d, progress %d", len(keys), copied, progress)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fsrepo.ConvertDir)); !os.IsNotExist(err) {
+		t.Fatalf("the conversion directory was not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blocks")); !os.IsNotExist(err) {
+		t.Fatalf("the old datastore was not removed: %v", err)
+	}
+
+	r, err = fsrepo.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		v, err := r.Datastore().Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v) != k.String() {
+			t.Fatalf("expected %s, got %s", k, v)
+		}
+	}
+
+	if _, err := fsrepo.ConvertDatastore(ctx, dir, "", conf.Datastore.Spec, nil); err == nil {
+		t.Fatal("expected the repo to be locked")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsrepo.ConvertDatastore(ctx, dir, "", conf.Datastore.Spec, nil); err != fsrepo.ErrSameDatastore {
+		t.Fatalf("expected ErrSameDatastore, got %v", err)
+	}
+}
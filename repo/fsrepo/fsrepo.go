@@ -467,12 +467,13 @@ func (r *FSRepo) openDatastore() error {
 	spec := dsc.DiskSpec()
 
 	oldSpec, err := r.readSpec()
-	if err != nil {
+	if os.IsNotExist(err) {
+		return &DatastoreSpecError{Config: spec.String()}
+	} else if err != nil {
 		return err
 	}
 	if oldSpec != spec.String() {
-		return fmt.Errorf("datastore configuration of '%s' does not match what is on disk '%s'",
-			oldSpec, spec.String())
+		return &DatastoreSpecError{Config: spec.String(), Disk: oldSpec}
 	}
 
 	d, err := dsc.Create(r.path)
@@ -488,6 +489,38 @@ func (r *FSRepo) openDatastore() error {
 	return nil
 }
 
+// DatastoreSpecError is returned by Open when the datastore configured in
+// the config file does not match the datastore_spec file, which describes the
+// datastore on disk.
+type DatastoreSpecError struct {
+	// Config is the spec of the configured datastore
+	Config string
+	// Disk is the spec of the datastore on disk, empty when the
+	// datastore_spec file is missing
+	Disk string
+}
+
+func (e *DatastoreSpecError) Error() string {
+	if e.Disk == "" {
+		return fmt.Sprintf("%s file is missing, the configured datastore is '%s'", specFn, e.Config)
+	}
+	return fmt.Sprintf("datastore configuration of '%s' does not match what is on disk '%s'",
+		e.Disk, e.Config)
+}
+
+// WriteDatastoreSpec writes the missing datastore_spec file of the repo, for
+// the datastore configured in the config file. An existing file is kept.
+func WriteDatastoreSpec(repoPath string, userConfigFilePath string) error {
+	r, err := newFSRepo(repoPath, userConfigFilePath)
+	if err != nil {
+		return err
+	}
+	if err := r.openConfig(); err != nil {
+		return err
+	}
+	return initSpec(r.path, r.config.Datastore.Spec)
+}
+
 func (r *FSRepo) readSpec() (string, error) {
 	fn, err := config.Path(r.path, specFn)
 	if err != nil {
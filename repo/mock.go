@@ -55,6 +55,8 @@ func (m *Mock) SetGatewayAddr(addr net.Addr) error { return errTODO }
 
 func (m *Mock) Keystore() keystore.Keystore { return m.K }
 
+func (m *Mock) Path() string { return "" }
+
 func (m *Mock) SwarmKey() ([]byte, error) {
 	return nil, nil
 }
@@ -58,6 +58,10 @@ type Repo interface {
 	// SwarmKey returns the configured shared symmetric key for the private networks feature.
 	SwarmKey() ([]byte, error)
 
+	// Path returns the directory of the repo, or an empty string for repos
+	// which are not stored on disk.
+	Path() string
+
 	io.Closer
 }
 
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/kubo/thirdparty/unit"
+
+	config "github.com/ipfs/kubo/config"
+	random "github.com/jbenet/go-random"
+)
+
+const (
+	fileCount = 1000
+	fileSize  = 64 * unit.KB
+)
+
+func main() {
+	if err := compareResults(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func compareResults() error {
+	for _, concurrency := range []int{1, 2, 4, 8, 16} {
+		if results, err := benchmarkAdd(concurrency); err != nil {
+			return err
+		} else {
+			log.Println("concurrency", concurrency, "\t", results)
+		}
+	}
+	return nil
+}
+
+func benchmarkAdd(concurrency int) (*testing.BenchmarkResult, error) {
+	results := testing.Benchmark(func(b *testing.B) {
+		b.SetBytes(int64(fileCount * fileSize))
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			tmpDir, err := os.MkdirTemp("", "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			env := append(os.Environ(), fmt.Sprintf("%s=%s", config.EnvDir, path.Join(tmpDir, config.DefaultPathName)))
+			setupCmd := func(cmd *exec.Cmd) {
+				cmd.Env = env
+			}
+
+			cmd := exec.Command("ipfs", "init", "-b=2048")
+			setupCmd(cmd)
+			if err := cmd.Run(); err != nil {
+				b.Fatal(err)
+			}
+
+			dataDir := filepath.Join(tmpDir, "data")
+			if err := os.Mkdir(dataDir, 0755); err != nil {
+				b.Fatal(err)
+			}
+			for j := 0; j < fileCount; j++ {
+				f, err := os.Create(filepath.Join(dataDir, fmt.Sprint(j)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = random.WritePseudoRandomBytes(int64(fileSize), f, int64(j))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := f.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.StartTimer()
+			cmd = exec.Command("ipfs", "add", "-r", "-Q", fmt.Sprintf("--concurrency=%d", concurrency), dataDir)
+			setupCmd(cmd)
+			if err := cmd.Run(); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+		}
+	})
+	return &results, nil
+}
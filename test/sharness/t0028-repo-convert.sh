@@ -0,0 +1,70 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs repo convert"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "add content" '
+  random 1000000 42 > bigfile &&
+  HASH=$(ipfs add -Q --pin=false bigfile) &&
+  ipfs pin add --name=big $HASH &&
+  ipfs files mkdir /dir &&
+  ipfs config Datastore.Spec > flatfs_spec
+'
+
+test_expect_success "converting to the same datastore fails" '
+  test_expect_code 1 ipfs repo convert --profile=flatfs 2> convert_err &&
+  grep "already uses the datastore of the flatfs profile" convert_err
+'
+
+test_expect_success "convert to badgerds" '
+  ipfs repo convert --profile=badgerds > convert_out &&
+  grep "converted the repo to the badgerds datastore" convert_out &&
+  test_path_is_missing "$IPFS_PATH/blocks" &&
+  test_path_is_missing "$IPFS_PATH/datastore_convert" &&
+  grep badgerds "$IPFS_PATH/datastore_spec" &&
+  ipfs config Datastore.Spec | grep badgerds
+'
+
+test_expect_success "the content is kept" '
+  ipfs cat $HASH > converted &&
+  test_cmp bigfile converted &&
+  ipfs pin ls --type=recursive | grep "$HASH recursive big" &&
+  ipfs files ls / | grep dir &&
+  ipfs repo fsck
+'
+
+test_expect_success "interrupt a conversion to flatfs" '
+  mkdir -p "$IPFS_PATH/datastore_convert/new" &&
+  echo "{\"Spec\":$(cat flatfs_spec),\"Old\":$(cat "$IPFS_PATH/datastore_spec"),\"Phase\":\"copy\"}" > "$IPFS_PATH/datastore_convert/state.json"
+'
+
+test_expect_success "another conversion fails while one is interrupted" '
+  test_expect_code 1 ipfs repo convert --profile=badgerds 2> convert_err &&
+  grep "was interrupted" convert_err
+'
+
+test_expect_success "the interrupted conversion is resumed" '
+  ipfs repo convert --profile=flatfs > convert_out &&
+  grep "converted the repo to the flatfs datastore" convert_out &&
+  test_path_is_missing "$IPFS_PATH/badgerds" &&
+  test_path_is_missing "$IPFS_PATH/datastore_convert" &&
+  ipfs cat $HASH > converted &&
+  test_cmp bigfile converted
+'
+
+test_launch_ipfs_daemon_without_network
+
+test_expect_success "convert does not run with the daemon" '
+  test_expect_code 1 ipfs repo convert --profile=badgerds 2> convert_err &&
+  grep "someone else has the lock" convert_err
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,113 @@
+#!/usr/bin/env bash
+#
+# Copyright (c) 2022 Protocol Labs
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test add, get and files with the mode and mtime of files"
+
+. lib/test-lib.sh
+
+test_add_mode_mtime() {
+
+  test_expect_success "creating files succeeds" '
+    rm -rf tree out* ref* &&
+    mkdir -p tree/sub &&
+    echo "run" >tree/run.sh &&
+    echo "data" >tree/sub/data &&
+    chmod 0750 tree/run.sh &&
+    chmod 0600 tree/sub/data &&
+    chmod 0755 tree tree/sub &&
+    TZ=UTC touch -t 202001020304.05 tree/run.sh tree/sub/data tree/sub ref &&
+    TZ=UTC touch -t 201805050000.00 tree
+  '
+
+  test_expect_success "ipfs add without --preserve-mode and --preserve-mtime succeeds" '
+    HASH_PLAIN=$(ipfs add -Q -r tree)
+  '
+
+  test_expect_success "ipfs add --preserve-mode --preserve-mtime succeeds" '
+    HASH=$(ipfs add -Q -r --preserve-mode --preserve-mtime tree)
+  '
+
+  test_expect_success "the mode and mtime change the hash" '
+    test "$HASH" != "$HASH_PLAIN"
+  '
+
+  test_expect_success "ipfs get restores the mode" '
+    ipfs get -o out "$HASH" &&
+    test "$(generic_stat out/run.sh)" = "-rwxr-x---" &&
+    test "$(generic_stat out/sub/data)" = "-rw-------"
+  '
+
+  test_expect_success "ipfs get restores the mtime" '
+    ! test out/run.sh -nt ref && ! test ref -nt out/run.sh &&
+    ! test out/sub -nt ref && ! test ref -nt out/sub &&
+    test ref -nt out
+  '
+
+  test_expect_success "ipfs get -a stores the mode in the archive" '
+    ipfs get -a -o out.tar "$HASH" &&
+    tar -tvf out.tar >out_tar &&
+    grep -- "-rwxr-x---.*/run.sh" out_tar
+  '
+
+  test_expect_success "ipfs files stat shows the mode and mtime" '
+    ipfs files cp "/ipfs/$HASH" /tree &&
+    ipfs files stat --format="<mode> <mtime>" /tree/run.sh >actual &&
+    echo "0750 2020-01-02T03:04:05Z" >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs files stat omits them when they are not stored" '
+    ipfs files cp "/ipfs/$HASH_PLAIN" /plain &&
+    ipfs files stat /plain/run.sh >actual &&
+    test_must_fail grep -E "^(Mode|Mtime):" actual
+  '
+
+  test_expect_success "ipfs files chmod succeeds" '
+    ipfs files chmod 0640 /plain/run.sh &&
+    ipfs files stat --format="<mode>" /plain/run.sh >actual &&
+    echo "0640" >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs files chmod fails on an invalid mode" '
+    test_must_fail ipfs files chmod 0999 /plain/run.sh 2>err &&
+    grep "invalid mode" err
+  '
+
+  test_expect_success "ipfs files touch --mtime succeeds" '
+    ipfs files touch --mtime=1000000000 /plain/sub &&
+    ipfs files stat --format="<mtime>" /plain/sub >actual &&
+    echo "2001-09-09T01:46:40Z" >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs files touch fails on a missing file" '
+    test_must_fail ipfs files touch /plain/missing
+  '
+
+  test_expect_success "ipfs get applies the changes" '
+    ipfs get -o out_plain "$(ipfs files stat --hash /plain)" &&
+    test "$(generic_stat out_plain/run.sh)" = "-rw-r-----" &&
+    TZ=UTC touch -t 200109090146.40 ref_sub &&
+    ! test out_plain/sub -nt ref_sub && ! test ref_sub -nt out_plain/sub
+  '
+
+  test_expect_success "cleaning up the files succeeds" '
+    ipfs files rm -r /tree /plain
+  '
+}
+
+test_init_ipfs
+
+test_add_mode_mtime
+
+test_launch_ipfs_daemon
+
+test_add_mode_mtime
+
+test_kill_ipfs_daemon
+
+test_done
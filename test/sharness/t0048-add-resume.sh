@@ -0,0 +1,59 @@
+#!/usr/bin/env bash
+#
+# Copyright (c) 2022 Protocol Labs
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs add --resume"
+
+. lib/test-lib.sh
+
+test_add_resume() {
+
+  test_expect_success "creating files succeeds" '
+    rm -rf files &&
+    mkdir -p files/sub &&
+    random 100000 1 >files/a &&
+    random 200000 2 >files/sub/b &&
+    echo "c" >files/sub/c
+  '
+
+  test_expect_success "ipfs add -r succeeds" '
+    HASH=$(ipfs add -Q -r files)
+  '
+
+  test_expect_success "ipfs add -r --resume gives the same hash" '
+    ipfs add -Q -r --resume files >actual &&
+    echo "$HASH" >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs add -r --resume gives the same hash again" '
+    ipfs add -Q -r --resume files >actual &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs add --resume of a changed file succeeds" '
+    echo "changed" >files/sub/c &&
+    ipfs add -Q -r --resume files >actual &&
+    ipfs add -Q -r -n files >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs add --resume --only-hash fails" '
+    test_must_fail ipfs add -r --resume --only-hash files 2>err &&
+    grep "only hashes the files" err
+  '
+}
+
+test_init_ipfs
+
+test_add_resume
+
+test_launch_ipfs_daemon
+
+test_add_resume
+
+test_kill_ipfs_daemon
+
+test_done
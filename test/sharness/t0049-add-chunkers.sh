@@ -0,0 +1,94 @@
+#!/usr/bin/env bash
+#
+# Copyright (c) 2022 Protocol Labs
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs add with the fastcdc and tar chunkers and the import profiles"
+
+. lib/test-lib.sh
+
+test_add_chunkers() {
+
+  test_expect_success "creating files succeeds" '
+    rm -rf files one.tar two.tar &&
+    mkdir -p files &&
+    random 3000000 1 >files/big &&
+    echo "small" >files/small &&
+    tar -cf one.tar files &&
+    random 100000 2 >files/other &&
+    tar -cf two.tar files
+  '
+
+  test_expect_success "ipfs add --chunker=fastcdc succeeds" '
+    HASH=$(ipfs add -Q --chunker=fastcdc files/big) &&
+    ipfs cat "$HASH" >actual &&
+    test_cmp files/big actual
+  '
+
+  test_expect_success "the fastcdc chunks are within the bounds" '
+    HASH=$(ipfs add -Q --raw-leaves --chunker=fastcdc-65536-131072-262144 files/big) &&
+    ipfs refs "$HASH" >refs &&
+    for ref in $(cat refs); do
+      size=$(ipfs block stat "$ref" | sed -n "s/^Size: //p") &&
+      test "$size" -le 262144 || return 1
+    done
+  '
+
+  test_expect_success "ipfs add --chunker=fastcdc fails on invalid sizes" '
+    test_must_fail ipfs add --chunker=fastcdc-100-50-1000 files/big 2>err &&
+    grep "fastcdc-min must be smaller than fastcdc-avg" err
+  '
+
+  test_expect_success "ipfs add --chunker=tar-fastcdc succeeds" '
+    ONE=$(ipfs add -Q --raw-leaves --chunker=tar-fastcdc one.tar) &&
+    TWO=$(ipfs add -Q --raw-leaves --chunker=tar-fastcdc two.tar) &&
+    ipfs cat "$TWO" >actual &&
+    test_cmp two.tar actual
+  '
+
+  test_expect_success "the same members share their blocks" '
+    ipfs refs "$ONE" | sort >refs_one &&
+    ipfs refs "$TWO" | sort >refs_two &&
+    BIG=$(ipfs add -Q --raw-leaves --chunker=fastcdc files/big) &&
+    ipfs refs "$BIG" | sort >refs_big &&
+    comm -12 refs_one refs_two >shared &&
+    comm -23 refs_big shared >missing &&
+    test_must_be_empty missing
+  '
+
+  test_expect_success "ipfs add --profile=large-files sets the options" '
+    ipfs add -Q --profile=large-files files/big >actual &&
+    ipfs add -Q --chunker=fastcdc --raw-leaves --cid-version=1 files/big >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs add --profile=small-web sets the options" '
+    ipfs add -Q --profile=small-web files/big >actual &&
+    ipfs add -Q --chunker=fastcdc-16384-65536-262144 --raw-leaves --cid-version=1 --trickle files/big >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "the options passed explicitly override the profile" '
+    ipfs add -Q --profile=large-files --cid-version=0 --chunker=size-262144 --raw-leaves=false files/big >actual &&
+    ipfs add -Q files/big >expected &&
+    test_cmp expected actual
+  '
+
+  test_expect_success "ipfs add fails on an unknown profile" '
+    test_must_fail ipfs add --profile=unknown files/big 2>err &&
+    grep "unrecognized add profile: unknown" err
+  '
+}
+
+test_init_ipfs
+
+test_add_chunkers
+
+test_launch_ipfs_daemon
+
+test_add_chunkers
+
+test_kill_ipfs_daemon
+
+test_done
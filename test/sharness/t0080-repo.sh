@@ -125,6 +125,61 @@ test_expect_success "'ipfs repo gc' removes file" '
   test_must_fail ipfs block stat $HASH
 '
 
+test_expect_success "'ipfs repo gc --concurrent' keeps pinned file" '
+  echo "concurrent gc" >cfile &&
+  HASH3=`ipfs add -q cfile` &&
+  ipfs repo gc --concurrent &&
+  ipfs cat "$HASH3" >actual_concurrent &&
+  test_cmp cfile actual_concurrent
+'
+
+test_expect_success "'ipfs repo gc --concurrent' removes unpinned file" '
+  ipfs pin rm -r "$HASH3" &&
+  ipfs repo gc --concurrent &&
+  test_must_fail ipfs block stat "$HASH3"
+'
+
+test_expect_success "'ipfs repo gc --dry-run' lists unpinned file without removing it" '
+  echo "dry run gc" >dfile &&
+  HASH4=`ipfs add -q --pin=false dfile` &&
+  HASH4_RAW=`ipfs cid format -v 1 --mc raw -b base32 "$HASH4"` &&
+  ipfs repo gc --dry-run -q >dry_run_out &&
+  grep "^$HASH4_RAW\$" dry_run_out &&
+  ipfs block stat "$HASH4"
+'
+
+test_expect_success "'ipfs repo gc --dry-run' reports the reclaimed size" '
+  SIZE4=`ipfs block stat "$HASH4" | sed -n "s/^Size: //p"` &&
+  ipfs repo gc --dry-run >dry_run_out &&
+  grep "^would remove $HASH4_RAW ($SIZE4 bytes)\$" dry_run_out &&
+  tail -n 1 dry_run_out | grep "^[0-9]* bytes would be reclaimed\$"
+'
+
+test_expect_success "'ipfs repo gc --dry-run' rejects --concurrent" '
+  test_must_fail ipfs repo gc --dry-run --concurrent
+'
+
+test_expect_success "'ipfs pin stat' reports exclusive size" '
+  ipfs pin add "$HASH4" &&
+  echo "$HASH4 1 blocks, size $SIZE4, exclusive $SIZE4, shared 0" >expected_stat &&
+  ipfs pin stat "$HASH4" >actual_stat &&
+  test_cmp expected_stat actual_stat
+'
+
+test_expect_success "'ipfs repo du' reports size shared with MFS" '
+  ipfs files cp "/ipfs/$HASH4" /dfile &&
+  ipfs repo du >actual_du &&
+  grep "^$HASH4 1 blocks, size $SIZE4, exclusive 0, shared $SIZE4\$" actual_du &&
+  ipfs files rm /dfile
+'
+
+test_expect_success "'ipfs pin stat' fails on unpinned object" '
+  ipfs pin rm "$HASH4" &&
+  test_must_fail ipfs pin stat "$HASH4" &&
+  ipfs repo gc &&
+  test_must_fail ipfs block stat "$HASH4"
+'
+
 # Convert all to a base32-multihash as refs local outputs cidv1 raw
 # Technically converting refs local output would suffice, but this is more
 # future proof if we ever switch to adding the files with cid-version 1.
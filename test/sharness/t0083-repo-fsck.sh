@@ -0,0 +1,101 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs repo fsck"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+BS_BLOCK1="XZ/CIQPDDQH5PDJTF4QSNMPFC45FQZH5MBSWCX2W254P7L7HGNHW5MQXZA.data"
+BS_BLOCK2="CK/CIQNYWBOKHY7TCY7FUOBXKVJ66YRMARDT3KC7PPY6UWWPZR4YA67CKQ.data"
+
+test_expect_success "enable the filestore" '
+  ipfs config --json Experimental.FilestoreEnabled true
+'
+
+test_expect_success "add content" '
+  H_BLOCK1=$(echo "Block 1" | ipfs add -q) &&
+  H_BLOCK2=$(echo "Block 2" | ipfs add -q) &&
+  random 300000 41 > somefile &&
+  ipfs add -q --nocopy somefile &&
+  ipfs files mkdir /dir &&
+  FILES_ROOT=$(ipfs files stat --hash /)
+'
+
+test_expect_success "fsck of a consistent repo" '
+  ipfs repo fsck > fsck_out &&
+  test $(grep -c ", ok$" fsck_out) = 6
+'
+
+test_expect_success "break the repo" '
+  cp -f "$IPFS_PATH/blocks/$BS_BLOCK1" "$IPFS_PATH/blocks/$BS_BLOCK2" &&
+  printf "broken" | dd of=somefile bs=1 seek=100 conv=notrunc &&
+  ROOT_BLOCK=$(cid-fmt -b base32 "%M" $FILES_ROOT | tr a-z A-Z) &&
+  find "$IPFS_PATH/blocks" -name "$ROOT_BLOCK.data" -delete
+'
+
+test_expect_success "fsck reports the problems" '
+  test_expect_code 1 ipfs repo fsck > fsck_out 2> fsck_err &&
+  grep "blocks: .* checked, 1 problems" fsck_out &&
+  grep "block in storage has different hash than requested$" fsck_out &&
+  grep "filestore: 2 checked, 1 problems" fsck_out &&
+  grep "changed: data in file did not match" fsck_out &&
+  grep "files-root: 1 checked, 1 problems" fsck_out &&
+  grep "$FILES_ROOT: " fsck_out &&
+  grep "fsck found 3 problems" fsck_err
+'
+
+test_expect_success "fsck reports the problems in JSON" '
+  test_expect_code 1 ipfs repo fsck --enc=json > fsck_json &&
+  grep "\"Name\":\"files-root\",\"Checked\":1,\"Problems\":\[{\"Key\":\"$FILES_ROOT\"" fsck_json
+'
+
+test_expect_success "fsck --fix fixes the problems" '
+  test_expect_code 1 ipfs repo fsck --fix > fsck_out 2> fsck_err &&
+  test $(grep -c "(fixed)$" fsck_out) = 3 &&
+  grep "$H_BLOCK2: pinned block is missing" fsck_out &&
+  grep "fsck found 4 problems, 1 could not be fixed" fsck_err
+'
+
+test_expect_success "the files root is an empty directory" '
+  ipfs files ls / > files_out &&
+  test_must_be_empty files_out
+'
+
+test_expect_success "fsck of the fixed repo" '
+  ipfs pin rm $H_BLOCK2 &&
+  ipfs repo fsck > fsck_out &&
+  test $(grep -c ", ok$" fsck_out) = 6
+'
+
+test_expect_success "fsck --fix writes a missing datastore_spec" '
+  mv "$IPFS_PATH/datastore_spec" spec_backup &&
+  test_expect_code 1 ipfs repo fsck > fsck_out 2> fsck_err &&
+  grep "datastore_spec file is missing" fsck_out &&
+  grep "fsck aborted" fsck_err &&
+  ipfs repo fsck --fix > fsck_out &&
+  grep "datastore_spec file is missing.*(fixed)$" fsck_out &&
+  test_cmp spec_backup "$IPFS_PATH/datastore_spec"
+'
+
+test_expect_success "fsck reports a datastore_spec mismatch" '
+  echo "{\"mounts\":[],\"type\":\"mount\"}" > "$IPFS_PATH/datastore_spec" &&
+  test_expect_code 1 ipfs repo fsck --fix > fsck_out &&
+  grep "does not match what is on disk" fsck_out &&
+  test_must_fail grep "(fixed)" fsck_out &&
+  cp spec_backup "$IPFS_PATH/datastore_spec"
+'
+
+test_launch_ipfs_daemon_without_network
+
+test_expect_success "fsck does not run with the daemon" '
+  test_expect_code 1 ipfs repo fsck 2> fsck_err &&
+  grep "someone else has the lock" fsck_err
+'
+
+test_kill_ipfs_daemon
+
+test_done
@@ -19,6 +19,9 @@ H_BLOCK2=$(echo "Block 2" | ipfs add -q)
 BS_BLOCK1="XZ/CIQPDDQH5PDJTF4QSNMPFC45FQZH5MBSWCX2W254P7L7HGNHW5MQXZA.data"
 BS_BLOCK2="CK/CIQNYWBOKHY7TCY7FUOBXKVJ66YRMARDT3KC7PPY6UWWPZR4YA67CKQ.data"
 
+test_expect_success 'export block 2' '
+  ipfs dag export $H_BLOCK2 > block2.car
+'
 
 test_expect_success 'blocks are swapped' '
   ipfs cat $H_BLOCK2 > noswap &&
@@ -49,8 +52,63 @@ test_expect_success "can add and cat a raw-leaf file" '
   ipfs cat $HASH > /dev/null
 '
 
+test_expect_success "repo verify --repair fails without the block" '
+  test_expect_code 1 ipfs repo verify --repair > repair_out &&
+  grep "could not be repaired (block not found in the CAR files, and the node is offline)" repair_out
+'
+
+test_expect_success "the corrupt block is kept when it is not repaired" '
+  test_cmp "$IPFS_PATH/blocks/$BS_BLOCK1" "$IPFS_PATH/blocks/$BS_BLOCK2"
+'
+
+test_check_bad_blocks
+
+test_expect_success "pin verify --repair repairs the pin from a CAR file" '
+  ipfs pin verify --repair block2.car > verify_out &&
+  grep "$H_BLOCK2 broken" verify_out &&
+  grep "$H_BLOCK2 repaired" verify_out &&
+  ipfs pin verify > verify_out &&
+  test_must_be_empty verify_out &&
+  ipfs cat $H_BLOCK2 > repaired &&
+  test_cmp noswap repaired
+'
+
+test_expect_success "CAR files require --repair" '
+  test_expect_code 1 ipfs pin verify block2.car &&
+  test_expect_code 1 ipfs repo verify block2.car
+'
+
+test_expect_success "repo verify --repair repairs blocks from a CAR file" '
+  cp -f "$IPFS_PATH/blocks/$BS_BLOCK1" "$IPFS_PATH/blocks/$BS_BLOCK2" &&
+  ipfs repo verify --repair block2.car > repair_out &&
+  grep "was repaired from the car" repair_out &&
+  grep "verify complete, 1 corrupt blocks were repaired." repair_out &&
+  ipfs repo verify
+'
+
+test_expect_success "pin verify --repair reports pins still broken" '
+  cp -f "$IPFS_PATH/blocks/$BS_BLOCK1" "$IPFS_PATH/blocks/$BS_BLOCK2" &&
+  ipfs pin verify --repair > verify_out &&
+  grep "$H_BLOCK2 still broken" verify_out &&
+  grep "block not found in the CAR files, and the node is offline" verify_out &&
+  ipfs pin verify --repair -q block2.car > verify_out &&
+  test_must_be_empty verify_out
+'
+
+test_expect_success 'blocks are swapped again' '
+  cp -f "$IPFS_PATH/blocks/$BS_BLOCK1" "$IPFS_PATH/blocks/$BS_BLOCK2"
+'
+
 test_launch_ipfs_daemon
 test_check_bad_blocks
+
+test_expect_success "pin verify --repair repairs the pin from a CAR file with the daemon" '
+  ipfs pin verify --repair block2.car > verify_out &&
+  grep "$H_BLOCK2 repaired" verify_out &&
+  ipfs cat $H_BLOCK2 > repaired &&
+  test_cmp noswap repaired
+'
+
 test_kill_ipfs_daemon
 
 test_done
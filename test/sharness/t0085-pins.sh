@@ -164,6 +164,126 @@ test_pin_progress() {
   '
 }
 
+test_pin_names() {
+  test_expect_success "'ipfs pin add --name --meta' works" '
+    NAMED_A=`echo "named a" | ipfs add -q --pin=false` &&
+    NAMED_B=`echo "named b" | ipfs add -q --pin=false` &&
+    ipfs pin add --name="photos 2022" --meta owner=alice --meta tag "$NAMED_A" &&
+    ipfs pin add -r=false --name=videos --meta owner=bob "$NAMED_B"
+  '
+
+  test_expect_success "'ipfs pin ls' shows names" '
+    ipfs pin ls >actual_names &&
+    grep "^$NAMED_A recursive photos 2022\$" actual_names &&
+    grep "^$NAMED_B direct videos\$" actual_names &&
+    ipfs pin ls --stream "$NAMED_A" >actual_names &&
+    grep "^$NAMED_A recursive photos 2022\$" actual_names
+  '
+
+  test_expect_success "'ipfs pin ls --enc=json' shows metadata" '
+    ipfs pin ls --enc=json "$NAMED_A" >actual_json &&
+    grep "\"Meta\":{\"owner\":\"alice\",\"tag\":\"\"}" actual_json
+  '
+
+  test_expect_success "'ipfs pin ls --name' filters pins" '
+    ipfs pin ls -q --name=photo >actual_filtered &&
+    echo "$NAMED_A" >expected_filtered &&
+    test_cmp expected_filtered actual_filtered &&
+    ipfs pin ls -q --name=videos "$NAMED_A" "$NAMED_B" >actual_filtered &&
+    echo "$NAMED_B" >expected_filtered &&
+    test_cmp expected_filtered actual_filtered
+  '
+
+  test_expect_success "'ipfs pin ls --meta' filters pins" '
+    ipfs pin ls -q --meta owner=bob >actual_filtered &&
+    echo "$NAMED_B" >expected_filtered &&
+    test_cmp expected_filtered actual_filtered &&
+    ipfs pin ls -q --meta owner --meta tag >actual_filtered &&
+    echo "$NAMED_A" >expected_filtered &&
+    test_cmp expected_filtered actual_filtered &&
+    ipfs pin ls -q --meta owner=carol >actual_filtered &&
+    test_must_be_empty actual_filtered
+  '
+
+  test_expect_success "'ipfs pin update' keeps the name" '
+    ipfs pin update "$NAMED_A" "$NAMED_B" &&
+    ipfs pin ls -q --name=photos >actual_filtered &&
+    echo "$NAMED_B" >expected_filtered &&
+    test_cmp expected_filtered actual_filtered
+  '
+
+  test_expect_success "'ipfs pin rm' removes the name" '
+    ipfs pin rm "$NAMED_B" &&
+    ipfs pin add -r=false "$NAMED_B" &&
+    ipfs pin ls --stream "$NAMED_B" >actual_names &&
+    echo "$NAMED_B direct" >expected_names &&
+    test_cmp expected_names actual_names &&
+    ipfs pin rm "$NAMED_B"
+  '
+
+  test_expect_success "'ipfs pin add --meta' rejects empty keys" '
+    test_must_fail ipfs pin add --meta =value "$NAMED_B"
+  '
+}
+
+test_pin_expiry_init() {
+  test_expect_success "'ipfs pin add --expires-in' works" '
+    EXPIRING=`echo "expiring" | ipfs add -q --pin=false` &&
+    LASTING=`echo "lasting" | ipfs add -q --pin=false` &&
+    ipfs pin add --name=short --expires-in=1s "$EXPIRING" &&
+    ipfs pin add -r=false --expires-at=2100-01-01T00:00:00Z "$LASTING"
+  '
+
+  test_expect_success "'ipfs pin ls' shows the expiry" '
+    ipfs pin ls --stream "$LASTING" >actual_expiry &&
+    echo "$LASTING direct (expires 2100-01-01T00:00:00Z)" >expected_expiry &&
+    test_cmp expected_expiry actual_expiry &&
+    ipfs pin ls --stream "$EXPIRING" | grep "^$EXPIRING recursive short (expires .*)\$"
+  '
+
+  test_expect_success "'ipfs pin add' again without an expiry makes the pin permanent" '
+    REPINNED=`echo "repinned" | ipfs add -q --pin=false` &&
+    ipfs pin add --name=kept --expires-at=2100-01-01T00:00:00Z "$REPINNED" &&
+    ipfs pin add "$REPINNED" &&
+    ipfs pin ls --stream "$REPINNED" >actual_expiry &&
+    echo "$REPINNED recursive kept" >expected_expiry &&
+    test_cmp expected_expiry actual_expiry &&
+    ipfs pin add --expires-at=2100-01-01T00:00:00Z "$REPINNED" &&
+    ipfs pin add --name=renamed "$REPINNED" &&
+    ipfs pin ls --stream "$REPINNED" >actual_expiry &&
+    echo "$REPINNED recursive renamed" >expected_expiry &&
+    test_cmp expected_expiry actual_expiry &&
+    ipfs pin rm "$REPINNED"
+  '
+
+  test_expect_success "'ipfs pin add' rejects invalid expiries" '
+    test_must_fail ipfs pin add --expires-in=-1h "$LASTING" &&
+    test_must_fail ipfs pin add --expires-at=2000-01-01T00:00:00Z "$LASTING" &&
+    test_must_fail ipfs pin add --expires-in=1h --expires-at=2100-01-01T00:00:00Z "$LASTING"
+  '
+}
+
+test_pin_expiry() {
+  test_expect_success "the daemon removes expired pins" '
+    for i in 1 2 3 4 5 6 7 8 9 10; do
+      ipfs pin ls "$EXPIRING" >/dev/null 2>&1 || break
+      sleep 1
+    done &&
+    test_must_fail ipfs pin ls "$EXPIRING" &&
+    ipfs pin ls "$LASTING"
+  '
+
+  test_expect_success "'ipfs pin update' keeps the expiry" '
+    ipfs pin rm "$LASTING" &&
+    ipfs pin add --expires-at=2100-01-01T00:00:00Z "$LASTING" &&
+    ipfs pin update "$LASTING" "$EXPIRING" &&
+    ipfs pin ls --stream "$EXPIRING" >actual_expiry &&
+    echo "$EXPIRING recursive (expires 2100-01-01T00:00:00Z)" >expected_expiry &&
+    test_cmp expected_expiry actual_expiry &&
+    ipfs pin rm "$EXPIRING"
+  '
+}
+
 test_init_ipfs
 
 test_pins '' '' ''
@@ -180,8 +300,14 @@ test_pin_dag --raw-leaves
 
 test_pin_progress
 
+test_pin_names
+
+test_pin_expiry_init
+
 test_launch_ipfs_daemon_without_network
 
+test_pin_expiry
+
 test_pins '' '' ''
 test_pins --progress '' ''
 test_pins --progress --stream ''
@@ -196,6 +322,8 @@ test_pin_dag --raw-leaves
 
 test_pin_progress
 
+test_pin_names
+
 test_kill_ipfs_daemon
 
 test_done
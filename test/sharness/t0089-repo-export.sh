@@ -0,0 +1,108 @@
+#!/usr/bin/env bash
+#
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs repo export and import"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "add content to the node" '
+  random 500000 42 > bigfile &&
+  HASH=$(ipfs add -Q --pin=false bigfile) &&
+  ipfs pin add --name=big --meta=kind=file $HASH &&
+  DIRECT=$(echo "direct" | ipfs add -Q --pin=false) &&
+  ipfs pin add --recursive=false $DIRECT &&
+  ipfs files mkdir -p /docs/sub &&
+  echo "hello" | ipfs files write --create /docs/hello.txt &&
+  ipfs key gen exported > /dev/null &&
+  ipfs name publish --allow-offline --key=exported /ipfs/$HASH &&
+  ipfs name publish --allow-offline /ipfs/$DIRECT &&
+  PEERID=$(ipfs config Identity.PeerID) &&
+  KEYID=$(ipfs key list -l | grep exported | cut -d" " -f1)
+'
+
+test_expect_success "export the node" '
+  ipfs repo export > backup.tar &&
+  tar tf backup.tar > entries &&
+  test $(head -1 entries) = manifest.json &&
+  grep "^keystore/exported$" entries &&
+  grep "^ipns/" entries &&
+  test $(tail -1 entries) = blocks.car
+'
+
+test_expect_success "export with redacted secrets" '
+  ipfs repo export --redact-secrets > redacted.tar &&
+  tar xOf redacted.tar config.json > redacted_config &&
+  test_must_fail grep PrivKey redacted_config &&
+  test_must_fail tar tf redacted.tar keystore/exported
+'
+
+test_expect_success "import the node in a badger repo" '
+  export IPFS_PATH="$(pwd)/.ipfs-badger" &&
+  ipfs init --profile=badgerds,test > /dev/null &&
+  ipfs repo import backup.tar > import_out &&
+  grep "4 pins, 1 keys and 2 IPNS records" import_out &&
+  grep "restored the config" import_out
+'
+
+test_expect_success "the node is restored" '
+  ipfs pin ls --type=recursive > pins &&
+  grep "$HASH recursive big" pins &&
+  ipfs pin ls --type=direct > pins &&
+  grep "$DIRECT direct" pins &&
+  ipfs cat $HASH > restored &&
+  test_cmp bigfile restored &&
+  ipfs files read /docs/hello.txt > hello &&
+  echo "hello" > expected &&
+  test_cmp expected hello &&
+  ipfs key list -l | grep "$KEYID exported" &&
+  test $(ipfs config Identity.PeerID) = $PEERID &&
+  ipfs config Datastore.Spec | grep badgerds &&
+  test $(ipfs name resolve --offline) = /ipfs/$DIRECT &&
+  test $(ipfs name resolve --offline $KEYID) = /ipfs/$HASH &&
+  ipfs pin verify &&
+  ipfs repo fsck
+'
+
+test_expect_success "importing again is a no-op" '
+  ipfs repo import backup.tar > import_out &&
+  grep "0 keys and 0 IPNS records" import_out
+'
+
+test_expect_success "a redacted archive keeps the identity" '
+  export IPFS_PATH="$(pwd)/.ipfs-redacted" &&
+  ipfs init --profile=test > /dev/null &&
+  NEWID=$(ipfs config Identity.PeerID) &&
+  ipfs repo import redacted.tar > import_out &&
+  grep "0 keys" import_out &&
+  test $(ipfs config Identity.PeerID) = $NEWID
+'
+
+test_expect_success "--skip-config keeps the config" '
+  export IPFS_PATH="$(pwd)/.ipfs-skip" &&
+  ipfs init --profile=test > /dev/null &&
+  NEWID=$(ipfs config Identity.PeerID) &&
+  ipfs repo import --skip-config backup.tar > import_out &&
+  test_must_fail grep "restored the config" import_out &&
+  test $(ipfs config Identity.PeerID) = $NEWID
+'
+
+test_expect_success "pins with missing blocks are reported" '
+  export IPFS_PATH="$(pwd)/.ipfs-broken" &&
+  ipfs init --profile=test > /dev/null &&
+  tar xf backup.tar manifest.json pins.json &&
+  tar cf broken.tar manifest.json pins.json &&
+  test_expect_code 1 ipfs repo import broken.tar > import_out 2> import_err &&
+  grep "error: pinning $HASH" import_out &&
+  grep "import incomplete" import_err
+'
+
+test_expect_success "other files are not imported" '
+  test_expect_code 1 ipfs repo import bigfile 2> import_err &&
+  grep "reading the archive" import_err
+'
+
+test_done
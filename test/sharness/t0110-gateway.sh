@@ -295,6 +295,61 @@ test_expect_success "Verify gateway file" '
 
 test_kill_ipfs_daemon
 
+test_expect_success "set Gateway.APICommands allowlist" '
+  ipfs config --json Gateway.APICommands "[\"cat\", \"dag/get\"]"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "GET for allowed API command on gateway port succeeds" '
+  curl -sf "http://127.0.0.1:$port/api/v0/cat?arg=$HASH" > api_cat_actual &&
+  test_cmp expected api_cat_actual
+'
+
+test_expect_success "GET for API command not in Gateway.APICommands returns 404" '
+  curl -svX GET "http://127.0.0.1:$port/api/v0/refs?arg=$HASH" >/dev/null 2>curl_output &&
+  grep "< HTTP/1.1 404 Not Found" curl_output
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "invalid Gateway.APICommands prevents daemon from starting" '
+  ipfs config --json Gateway.APICommands "[\"foo/bar\"]" &&
+  test_expect_code 1 ipfs daemon --offline > daemon_output 2>&1 &&
+  grep "invalid Gateway.APICommands: unknown command \"foo\"" daemon_output &&
+  ipfs config --json Gateway.APICommands "[]"
+'
+
+test_expect_success "mutating command in Gateway.APICommands prevents daemon from starting" '
+  ipfs config --json Gateway.APICommands "[\"pin/add\"]" &&
+  test_expect_code 1 ipfs daemon --offline > daemon_output 2>&1 &&
+  grep "invalid Gateway.APICommands: command \"pin\" is not read-only, only the commands of the read-only RPC API can be exposed on the gateway" daemon_output &&
+  ipfs config --json Gateway.APICommands "[]"
+'
+
+test_expect_success "set Gateway.RateLimit" '
+  ipfs config --json Gateway.RateLimit "{\"CachedRequestsPerMinute\": 1, \"CachedBurst\": 1}"
+'
+
+test_launch_ipfs_daemon
+
+test_expect_success "GET within Gateway.RateLimit succeeds" '
+  curl -sf "http://127.0.0.1:$port/ipfs/$HASH" > rate_limit_actual &&
+  test_cmp expected rate_limit_actual
+'
+
+test_expect_success "GET over Gateway.RateLimit returns 429 with Retry-After" '
+  curl -svX GET "http://127.0.0.1:$port/ipfs/$HASH" >/dev/null 2>curl_output &&
+  grep "< HTTP/1.1 429 Too Many Requests" curl_output &&
+  grep "< Retry-After: 60" curl_output
+'
+
+test_kill_ipfs_daemon
+
+test_expect_success "reset Gateway.RateLimit" '
+  ipfs config --json Gateway.RateLimit "{}"
+'
+
 
 GWPORT=32563
 
@@ -63,13 +63,40 @@ test_launch_ipfs_daemon_without_network
     '
 
     # explicit version=2
-    test_expect_success "GET for application/vnd.ipld.raw version=2 returns HTTP 400 Bad Request error" '
-    curl -svX GET -H "Accept: application/vnd.ipld.car;version=2" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" > curl_output 2>&1 &&
+    test_expect_success "GET for application/vnd.ipld.car version=2 returns a CARv2" '
+    ipfs dag import test-dag.car &&
+    curl -svX GET -H "Accept: application/vnd.ipld.car;version=2" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID" -o gateway-v2.car 2>curl_output_v2 &&
+    cat curl_output_v2 &&
+    grep "< Content-Type: application/vnd.ipld.car; version=2; order=dfs" curl_output_v2 &&
+    printf "\x0a\xa1\x67version\x02" > expected-pragma &&
+    head -c 11 gateway-v2.car > actual-pragma &&
+    test_cmp expected-pragma actual-pragma
+    '
+
+    # unsupported version
+    test_expect_success "GET for application/vnd.ipld.car version=3 returns HTTP 400 Bad Request error" '
+    curl -svX GET -H "Accept: application/vnd.ipld.car;version=3" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt" > curl_output 2>&1 &&
     cat curl_output &&
     grep "400 Bad Request" curl_output &&
     grep "unsupported CAR version" curl_output
     '
 
+# GET CAR in deterministic order
+
+    test_expect_success "GET for application/vnd.ipld.car with order=dfs returns a strong Etag" '
+    ipfs dag import test-dag.car &&
+    curl -svX GET -H "Accept: application/vnd.ipld.car; order=dfs" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID" -o gateway-dfs.car 2>curl_output_dfs &&
+    cat curl_output_dfs &&
+    grep "< Etag: \"${ROOT_DIR_CID}.car.dfs\"" curl_output_dfs &&
+    grep "< Accept-Ranges: bytes" curl_output_dfs
+    '
+
+    test_expect_success "GET for application/vnd.ipld.car with order=dfs supports range requests" '
+    curl -sX GET -H "Accept: application/vnd.ipld.car; order=dfs" -H "Range: bytes=10-" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID" -o gateway-dfs-range.car &&
+    tail -c +11 gateway-dfs.car > expected-dfs-range.car &&
+    test_cmp expected-dfs-range.car gateway-dfs-range.car
+    '
+
 # GET unixfs directory as a CAR with DAG and some selector
 
     # TODO: this is basic test for "full" selector, we will add support for custom ones in https://github.com/ipfs/go-ipfs/issues/8769
@@ -81,6 +108,30 @@ test_launch_ipfs_daemon_without_network
     ipfs dag stat --offline $ROOT_DIR_CID
     '
 
+# GET CAR scoped with dag-scope and entity-bytes
+
+    test_expect_success "GET with dag-scope=block returns only blocks of the content path" '
+    ipfs dag import test-dag.car &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt?format=car&dag-scope=block" -o gateway-scope-block.car &&
+    purge_blockstore &&
+    ipfs dag import gateway-scope-block.car &&
+    ipfs cat --offline /ipfs/$ROOT_DIR_CID/subdir/ascii.txt > scope-block-output &&
+    test_cmp subdir/ascii.txt scope-block-output
+    '
+
+    test_expect_success "GET with entity-bytes returns a CAR with the root of the content path" '
+    ipfs dag import test-dag.car &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt?format=car&entity-bytes=0:*" -o gateway-scope-bytes.car &&
+    purge_blockstore &&
+    ipfs dag import gateway-scope-bytes.car | grep "$ROOT_DIR_CID"
+    '
+
+    test_expect_success "GET with unsupported dag-scope returns HTTP 400 Bad Request error" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID/subdir/ascii.txt?format=car&dag-scope=foo" > curl_output_scope 2>&1 &&
+    grep "400 Bad Request" curl_output_scope &&
+    grep "unsupported dag-scope" curl_output_scope
+    '
+
 # Make sure expected HTTP headers are returned with the CAR bytes
 
     test_expect_success "GET response for application/vnd.ipld.car has expected Content-Type" '
@@ -0,0 +1,51 @@
+#!/usr/bin/env bash
+
+test_description="Test HTTP Gateway TAR (application/x-tar) Support"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+test_launch_ipfs_daemon_without_network
+
+test_expect_success "Create text fixtures" '
+  mkdir -p dir/sub &&
+  echo "hello application/x-tar" > dir/ascii.txt &&
+  echo "nested" > dir/sub/nested.txt &&
+  ROOT_DIR_CID=$(ipfs add -Qr --cid-version 1 dir)
+'
+
+# GET unixfs dir as TAR and compare extracted files
+
+    test_expect_success "GET with format=tar param returns a TAR archive" '
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID?format=tar" -o curl_param.tar &&
+    mkdir -p param_out &&
+    tar -xf curl_param.tar -C param_out &&
+    test_cmp dir/ascii.txt param_out/$ROOT_DIR_CID/ascii.txt &&
+    test_cmp dir/sub/nested.txt param_out/$ROOT_DIR_CID/sub/nested.txt
+    '
+
+    test_expect_success "GET for application/x-tar returns a TAR archive" '
+    curl -sX GET -H "Accept: application/x-tar" "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID" -o curl_accept.tar &&
+    test_cmp curl_param.tar curl_accept.tar
+    '
+
+# Make sure expected HTTP headers are returned with the TAR bytes
+
+    test_expect_success "GET response for application/x-tar has expected HTTP headers" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID?format=tar" >/dev/null 2>curl_output &&
+    cat curl_output &&
+    grep "< Content-Type: application/x-tar" curl_output &&
+    grep "< Content-Disposition: attachment\; filename=\"${ROOT_DIR_CID}.tar\"" curl_output &&
+    grep "< Etag: W/\"${ROOT_DIR_CID}.x-tar\"" curl_output &&
+    grep "< Accept-Ranges: none" curl_output &&
+    grep "< X-Content-Type-Options: nosniff" curl_output
+    '
+
+    test_expect_success "GET for application/x-tar with query filename includes Content-Disposition with custom filename" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_DIR_CID?format=tar&filename=foobar.tar" >/dev/null 2>curl_output_filename &&
+    grep "< Content-Disposition: attachment\; filename=\"foobar.tar\"" curl_output_filename
+    '
+
+test_kill_ipfs_daemon
+
+test_done
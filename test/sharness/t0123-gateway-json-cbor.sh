@@ -0,0 +1,78 @@
+#!/usr/bin/env bash
+
+test_description="Test HTTP Gateway DAG-JSON (application/vnd.ipld.dag-json) and DAG-CBOR (application/vnd.ipld.dag-cbor) Support"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+test_launch_ipfs_daemon_without_network
+
+test_expect_success "Create text fixtures" '
+  echo "hello dag-json" > file.txt &&
+  FILE_CID=$(ipfs add -Q --cid-version 1 file.txt) &&
+  echo "{\"file\":{\"/\":\"$FILE_CID\"},\"name\":\"foo\"}" > node.json &&
+  DAG_JSON_CID=$(ipfs dag put --input-codec dag-json --store-codec dag-json node.json) &&
+  DAG_CBOR_CID=$(ipfs dag put --input-codec dag-json --store-codec dag-cbor node.json)
+'
+
+# GET nodes in their own codec and re-encoded with a different codec
+
+    test_expect_success "GET dag-json CID without explicit format returns the dag-json block" '
+    ipfs block get $DAG_JSON_CID > expected &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_JSON_CID" -o curl_output &&
+    test_cmp expected curl_output
+    '
+
+    test_expect_success "GET dag-cbor CID with format=dag-json returns the same node as dag get" '
+    ipfs dag get --output-codec dag-json $DAG_CBOR_CID > expected &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_CBOR_CID?format=dag-json" -o curl_output &&
+    test_cmp expected curl_output
+    '
+
+    test_expect_success "GET dag-json CID for application/vnd.ipld.dag-cbor returns the dag-cbor block" '
+    ipfs block get $DAG_CBOR_CID > expected &&
+    curl -sX GET -H "Accept: application/vnd.ipld.dag-cbor" "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_JSON_CID" -o curl_output &&
+    test_cmp expected curl_output
+    '
+
+    test_expect_success "GET with path traversing fields returns the field" '
+    ipfs dag get $DAG_CBOR_CID/name > expected &&
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_CBOR_CID/name?format=dag-json" -o curl_output &&
+    test_cmp expected curl_output
+    '
+
+    test_expect_success "GET with path traversing links returns the UnixFS file" '
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_CBOR_CID/file" -o curl_output &&
+    test_cmp file.txt curl_output
+    '
+
+# Make sure expected HTTP headers are returned
+
+    test_expect_success "GET response for application/vnd.ipld.dag-json has expected HTTP headers" '
+    curl -svX GET -H "Accept: application/vnd.ipld.dag-json" "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_CBOR_CID" >/dev/null 2>curl_output &&
+    cat curl_output &&
+    grep "< Content-Type: application/vnd.ipld.dag-json" curl_output &&
+    grep "< Content-Disposition: inline\; filename=\"${DAG_CBOR_CID}.json\"" curl_output &&
+    grep "< Etag: \"${DAG_CBOR_CID}.dag-json\"" curl_output &&
+    grep "< Cache-Control: public, max-age=29030400, immutable" curl_output
+    '
+
+    test_expect_success "GET response for application/vnd.ipld.dag-cbor has expected HTTP headers" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_JSON_CID?format=dag-cbor" >/dev/null 2>curl_output &&
+    cat curl_output &&
+    grep "< Content-Type: application/vnd.ipld.dag-cbor" curl_output &&
+    grep "< Content-Disposition: attachment\; filename=\"${DAG_JSON_CID}.cbor\"" curl_output &&
+    grep "< Etag: \"${DAG_JSON_CID}.dag-cbor\"" curl_output
+    '
+
+# HTML view for web browsers
+
+    test_expect_success "GET for text/html returns HTML with links" '
+    curl -sX GET -H "Accept: text/html" "http://127.0.0.1:$GWAY_PORT/ipfs/$DAG_CBOR_CID" -o curl_output &&
+    grep "<a href=\"/ipfs/$FILE_CID\">" curl_output &&
+    grep "<a href=\"/ipfs/$DAG_CBOR_CID/name\">" curl_output
+    '
+
+test_kill_ipfs_daemon
+
+test_done
@@ -0,0 +1,42 @@
+#!/usr/bin/env bash
+
+test_description="Test HTTP Gateway IPNS Record (application/vnd.ipfs.ipns-record) Support"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+test_launch_ipfs_daemon --offline
+
+test_expect_success "Create and Publish IPNS Key" '
+  echo "hello application/vnd.ipfs.ipns-record" > file.txt &&
+  FILE_CID=$(ipfs add -Q file.txt) &&
+  IPNS_KEY=$(ipfs key gen --ipns-base=b58mh ipns-record-key) &&
+  ipfs name publish --allow-offline --key=ipns-record-key $FILE_CID
+'
+
+    test_expect_success "GET with format=ipns-record param returns the signed record" '
+    curl -sX GET "http://127.0.0.1:$GWAY_PORT/ipns/$IPNS_KEY?format=ipns-record" -o curl_param_output &&
+    grep -a "/ipfs/$FILE_CID" curl_param_output
+    '
+
+    test_expect_success "GET for application/vnd.ipfs.ipns-record returns the signed record" '
+    curl -sX GET -H "Accept: application/vnd.ipfs.ipns-record" "http://127.0.0.1:$GWAY_PORT/ipns/$IPNS_KEY" -o curl_accept_output &&
+    test_cmp curl_param_output curl_accept_output
+    '
+
+    test_expect_success "GET response for application/vnd.ipfs.ipns-record has expected HTTP headers" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipns/$IPNS_KEY?format=ipns-record" >/dev/null 2>curl_output &&
+    cat curl_output &&
+    grep "< Content-Type: application/vnd.ipfs.ipns-record" curl_output &&
+    grep "< Content-Disposition: attachment\; filename=\"${IPNS_KEY}.ipns-record\"" curl_output &&
+    grep "< Cache-Control: " curl_output
+    '
+
+    test_expect_success "GET for ipns-record with DNSLink name returns HTTP 400 Bad Request error" '
+    curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipns/example.com?format=ipns-record" >/dev/null 2>curl_output_dnslink &&
+    grep "400 Bad Request" curl_output_dnslink
+    '
+
+test_kill_ipfs_daemon
+
+test_done
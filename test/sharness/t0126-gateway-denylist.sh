@@ -0,0 +1,73 @@
+#!/usr/bin/env bash
+
+test_description="Test content denylists on the HTTP Gateway and in ipfs cat/get"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "Add test content and a denylist" '
+  mkdir -p site &&
+  echo "public content" > site/public.txt &&
+  echo "blocked content" > site/blocked.txt &&
+  ROOT_CID=$(ipfs add -Qr --cid-version=1 site) &&
+  BLOCKED_CID=$(ipfs add -Q --cid-version=1 site/blocked.txt) &&
+  mkdir -p "$IPFS_PATH/denylists" &&
+  ipfs denylist hash /ipfs/$BLOCKED_CID > "$IPFS_PATH/denylists/test.deny"
+'
+
+test_expect_success "ipfs denylist ls lists the loaded lists" '
+  echo "test.deny 1" > expected_ls &&
+  ipfs denylist ls > actual_ls &&
+  test_cmp expected_ls actual_ls
+'
+
+test_expect_success "ipfs denylist check reports blocked and allowed content" '
+  ipfs denylist check /ipfs/$ROOT_CID/blocked.txt > actual_check &&
+  grep "/ipfs/$ROOT_CID/blocked.txt blocked by //" actual_check &&
+  grep "in test.deny (/ipfs/$BLOCKED_CID)" actual_check &&
+  ipfs denylist check /ipfs/$ROOT_CID/public.txt > actual_check_public &&
+  echo "/ipfs/$ROOT_CID/public.txt allowed" > expected_check_public &&
+  test_cmp expected_check_public actual_check_public
+'
+
+test_expect_success "ipfs cat only refuses blocked content with --enforce-denylist" '
+  ipfs cat /ipfs/$ROOT_CID/blocked.txt > actual_cat &&
+  test_cmp site/blocked.txt actual_cat &&
+  test_expect_code 1 ipfs cat --enforce-denylist /ipfs/$ROOT_CID/blocked.txt 2> cat_err &&
+  grep "content is blocked by a denylist" cat_err
+'
+
+test_expect_success "ipfs get refuses blocked content with --enforce-denylist" '
+  test_expect_code 1 ipfs get --enforce-denylist -o got.txt /ipfs/$BLOCKED_CID 2> get_err &&
+  grep "content is blocked by a denylist" get_err &&
+  test_path_is_missing got.txt
+'
+
+test_launch_ipfs_daemon --offline
+
+test_expect_success "GET for blocked content returns 410 Gone" '
+  curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$BLOCKED_CID" >/dev/null 2>curl_output &&
+  grep "< HTTP/1.1 410 Gone" curl_output
+'
+
+test_expect_success "GET for a path to blocked content returns 410 Gone" '
+  curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_CID/blocked.txt" >/dev/null 2>curl_output &&
+  grep "< HTTP/1.1 410 Gone" curl_output
+'
+
+test_expect_success "GET for other content succeeds" '
+  curl -sf "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_CID/public.txt" > actual_public &&
+  test_cmp site/public.txt actual_public
+'
+
+test_expect_success "changes to the lists are applied without restart" '
+  echo "/ipfs/$ROOT_CID/public.txt" > "$IPFS_PATH/denylists/more.deny" &&
+  ipfs denylist reload &&
+  curl -svX GET "http://127.0.0.1:$GWAY_PORT/ipfs/$ROOT_CID/public.txt" >/dev/null 2>curl_output &&
+  grep "< HTTP/1.1 410 Gone" curl_output
+'
+
+test_kill_ipfs_daemon
+
+test_done
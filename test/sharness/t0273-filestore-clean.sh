@@ -0,0 +1,111 @@
+#!/usr/bin/env bash
+#
+# Copyright (c) 2022 Protocol Labs
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="Test ipfs filestore clean and filestore ls --path-prefix"
+
+. lib/test-lib.sh
+
+test_init_ipfs
+
+test_expect_success "enable filestore config setting" '
+  ipfs config --json Experimental.FilestoreEnabled true
+'
+
+test_filestore_clean() {
+
+  test_expect_success "create a dataset" '
+    rm -rf somedir &&
+    mkdir -p somedir/sub somedir/sub2 &&
+    random  300000 1 >somedir/pinned &&
+    random  300000 2 >somedir/unpinned &&
+    random  300000 3 >somedir/sub/removed &&
+    random  300000 4 >somedir/sub/kept &&
+    random  300000 7 >somedir/sub2/other
+  '
+
+  test_expect_success "nocopy add succeeds" '
+    PINNED=$(ipfs add -Q --nocopy somedir/pinned) &&
+    ipfs add -Q --nocopy --pin=false somedir/unpinned somedir/sub/removed somedir/sub/kept somedir/sub2/other
+  '
+
+  test_expect_success "filestore ls --path-prefix lists the files under the prefix" '
+    ipfs filestore ls --path-prefix=somedir/sub | cut -d" " -f3- | sort -u >actual &&
+    ipfs filestore ls --path-prefix="$(pwd)/somedir/sub" | cut -d" " -f3- | sort -u >actual_abs &&
+    test_cmp actual actual_abs &&
+    test $(wc -l <actual) -eq 4 &&
+    test_must_fail grep -v -e "somedir/sub/removed" -e "somedir/sub/kept" actual &&
+    ipfs filestore ls --path-prefix=somedir/sub/ | cut -d" " -f3- | sort -u >actual_slash &&
+    test_cmp actual actual_slash
+  '
+
+  test_expect_success "filestore ls --path-prefix fails outside the filestore root" '
+    test_must_fail ipfs filestore ls --path-prefix=/nonexistent/dir 2>err &&
+    grep "is not under the filestore root" err
+  '
+
+  test_expect_success "change the files" '
+    random 300000 5 >somedir/pinned &&
+    random 300000 6 >somedir/unpinned &&
+    rm somedir/sub/removed somedir/sub2/other
+  '
+
+  test_expect_success "filestore clean fails without valid objects to remove" '
+    test_must_fail ipfs filestore clean bogus 2>err &&
+    grep "invalid objects to remove" err &&
+    test_must_fail ipfs filestore clean no-file --readd 2>err &&
+    grep "it needs changed" err
+  '
+
+  test_expect_success "filestore clean --unpinned keeps the pinned files" '
+    ipfs filestore clean --unpinned changed >actual &&
+    grep "^removed changed .* somedir/unpinned " actual &&
+    test_must_fail grep "somedir/pinned" actual &&
+    ipfs filestore verify | grep "somedir/pinned" | grep "^changed"
+  '
+
+  test_expect_success "filestore clean --path-prefix only removes the files under the prefix" '
+    ipfs filestore clean --path-prefix=somedir/sub no-file >actual &&
+    grep "^removed no-file .* somedir/sub/removed " actual &&
+    ipfs filestore verify >verify_actual &&
+    test_must_fail grep "somedir/sub/removed" verify_actual &&
+    grep "^ok .* somedir/sub/kept " verify_actual &&
+    grep "^no-file .* somedir/sub2/other " verify_actual
+  '
+
+  test_expect_success "filestore clean --readd adds the changed files again" '
+    ipfs filestore clean --readd changed >actual &&
+    grep "^removed changed .* somedir/pinned " actual &&
+    NEW=$(sed -n "s/^added \([^ ]*\) somedir\/pinned$/\1/p" actual) &&
+    ipfs cat "$NEW" >cat_actual &&
+    test_cmp somedir/pinned cat_actual &&
+    ipfs pin ls --type=recursive "$NEW"
+  '
+
+  test_expect_success "filestore clean removes the other missing file" '
+    ipfs filestore clean no-file >actual &&
+    grep "^removed no-file .* somedir/sub2/other " actual
+  '
+
+  test_expect_success "the filestore is consistent" '
+    ipfs filestore verify >actual &&
+    test_must_fail grep -v "^ok" actual
+  '
+
+  test_expect_success "clean up" '
+    ipfs pin rm "$PINNED" "$NEW" &&
+    ipfs repo gc >/dev/null
+  '
+}
+
+test_filestore_clean
+
+test_launch_ipfs_daemon
+
+test_filestore_clean
+
+test_kill_ipfs_daemon
+
+test_done